@@ -0,0 +1,279 @@
+// Copyright 2020-2021 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file  except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the  License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/couchbase/service-broker/pkg/broker"
+	"github.com/couchbase/service-broker/pkg/config"
+)
+
+const (
+	// credentialReloadPeriod is how often to check credential files for changes.
+	credentialReloadPeriod = 10 * time.Second
+
+	// jwksTimeout is how long to wait for an issuer to return its key set.
+	jwksTimeout = 10 * time.Second
+)
+
+// authenticationType is the type of authentication the broker should use.
+type authenticationType string
+
+const (
+	// bearerToken authentication just does a string match.
+	bearerToken authenticationType = "token"
+
+	// basic authentication alos does a string match, or username and password.
+	// Note Cloud Foundry expects basic auth.
+	basic authenticationType = "basic"
+
+	// mtls authentication requires clients to present a certificate signed by
+	// a trusted CA.
+	mtls authenticationType = "mtls"
+
+	// jwt authentication requires clients to present a bearer token signed by
+	// a trusted issuer.
+	jwt authenticationType = "jwt"
+
+	// tokenReview authentication requires clients to present a bearer token that
+	// Kubernetes can authenticate, and that is allowed by the configuration.
+	tokenReview authenticationType = "tokenreview"
+)
+
+// Set sets the authentication type from CLI parameters.
+func (a *authenticationType) Set(s string) error {
+	switch t := authenticationType(s); t {
+	case bearerToken, basic, mtls, jwt, tokenReview:
+		*a = t
+	default:
+		return fmt.Errorf("%w: unexpected authentication type %s", ErrFatal, s)
+	}
+
+	return nil
+}
+
+// Type returns the type of flag to display.
+func (a *authenticationType) Type() string {
+	return "string"
+}
+
+// String returns the default authentication type.
+func (a *authenticationType) String() string {
+	return string(*a)
+}
+
+// stringList is a list of strings.  The flag may be specified multiple times e.g.
+// so that more than one credential is valid while rotating them.
+type stringList struct {
+	// values is the list of values.
+	values []string
+
+	// set records whether the default values have been overridden.
+	set bool
+}
+
+// Set adds a value from CLI parameters.  The first value replaces any default.
+func (l *stringList) Set(s string) error {
+	if !l.set {
+		l.values = nil
+		l.set = true
+	}
+
+	l.values = append(l.values, s)
+
+	return nil
+}
+
+// String returns the list values.
+func (l *stringList) String() string {
+	return strings.Join(l.values, ",")
+}
+
+// authenticationOptions defines the type of authentication to use and its options.
+type authenticationOptions struct {
+	// authentication is the type of authentication to use.
+	authentication authenticationType
+
+	// tokenPaths are the locations of the files containing the bearer tokens for authentication.
+	tokenPaths stringList
+
+	// usernamePaths are the locations of the files containing the usernames for authentication.
+	usernamePaths stringList
+
+	// passwordPaths are the locations of the files containing the passwords for authentication.
+	passwordPaths stringList
+
+	// clientCAPath is the location of the CA bundle used to verify client certificates.
+	clientCAPath string
+
+	// clientAllowedNames are the client certificate names allowed to use the API.
+	clientAllowedNames stringList
+
+	// jwtIssuer is the issuer that must have signed JWTs.
+	jwtIssuer string
+
+	// jwtAudience is the audience JWTs must be intended for.
+	jwtAudience string
+
+	// jwtJWKSPath is the location of the file containing the JWT signing keys.
+	// If not set, keys are discovered from the issuer.
+	jwtJWKSPath string
+
+	// jwtGroupsClaim is the JWT claim containing the client's groups.
+	jwtGroupsClaim string
+
+	// jwtAllowInsecureJWKS allows discovered JWT signing keys to be fetched without TLS.
+	jwtAllowInsecureJWKS bool
+
+	// tokenReviewAudiences are the audiences tokens must be valid for.
+	tokenReviewAudiences stringList
+}
+
+// newAuthenticationOptions returns the default authentication options.
+func newAuthenticationOptions() *authenticationOptions {
+	return &authenticationOptions{
+		authentication: basic,
+		tokenPaths:     stringList{values: []string{"/var/run/secrets/service-broker/token"}},
+		usernamePaths:  stringList{values: []string{"/var/run/secrets/service-broker/username"}},
+		passwordPaths:  stringList{values: []string{"/var/run/secrets/service-broker/password"}},
+	}
+}
+
+// addFlags registers authentication CLI flags.
+func (o *authenticationOptions) addFlags() {
+	flag.Var(&o.authentication, "authentication", "Authentication type to use, either 'basic', 'token', 'mtls', 'jwt' or 'tokenreview'")
+	flag.Var(&o.tokenPaths, "token", "Bearer token for API authentication, may be specified multiple times")
+	flag.Var(&o.usernamePaths, "username", "Username for basic authentication, may be specified multiple times")
+	flag.Var(&o.passwordPaths, "password", "Password for basic authentication, may be specified multiple times")
+	flag.StringVar(&o.clientCAPath, "client-ca", "/var/run/secrets/service-broker/client-ca", "CA bundle used to verify client certificates for mtls authentication")
+	flag.Var(&o.clientAllowedNames, "client-allowed-name", "Client certificate subject common name or alternative name allowed for mtls authentication, may be specified multiple times")
+	flag.StringVar(&o.jwtIssuer, "jwt-issuer", "", "Issuer URL that must have signed tokens for jwt authentication")
+	flag.StringVar(&o.jwtAudience, "jwt-audience", "", "Audience tokens must be intended for with jwt authentication")
+	flag.StringVar(&o.jwtJWKSPath, "jwt-jwks", "", "JSON web key set used to verify tokens for jwt authentication, by default discovered from the issuer")
+	flag.StringVar(&o.jwtGroupsClaim, "jwt-groups-claim", broker.DefaultJWTGroupsClaim, "Token claim containing the client's groups for jwt authentication")
+	flag.BoolVar(&o.jwtAllowInsecureJWKS, "jwt-allow-insecure-jwks", false, "Allow the key set discovered from the issuer to be fetched over plain http for jwt authentication")
+	flag.Var(&o.tokenReviewAudiences, "token-review-audience", "Audience tokens must be valid for with tokenreview authentication, may be specified multiple times")
+}
+
+// loadCredentials loads credentials from a set of files.
+func loadCredentials(paths []string) ([]*broker.FileCredential, error) {
+	credentials := make([]*broker.FileCredential, len(paths))
+
+	for i, path := range paths {
+		credential, err := broker.NewFileCredential(path)
+		if err != nil {
+			return nil, err
+		}
+
+		credentials[i] = credential
+	}
+
+	return credentials, nil
+}
+
+// newAuthenticator creates an authenticator for the requested authentication type.
+// Any credential files that need to be watched for changes are also returned.
+func (o *authenticationOptions) newAuthenticator() (broker.Authenticator, []*broker.FileCredential, error) {
+	switch o.authentication {
+	case bearerToken:
+		tokens, err := loadCredentials(o.tokenPaths.values)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		credentials := make([]broker.Credential, len(tokens))
+		for i := range tokens {
+			credentials[i] = tokens[i]
+		}
+
+		return broker.NewBearerTokenAuthenticator(credentials...), tokens, nil
+
+	case basic:
+		if len(o.usernamePaths.values) != len(o.passwordPaths.values) {
+			return nil, nil, fmt.Errorf("%w: each username must have a corresponding password", ErrFatal)
+		}
+
+		usernames, err := loadCredentials(o.usernamePaths.values)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		passwords, err := loadCredentials(o.passwordPaths.values)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		credentials := make([]broker.BasicAuthCredential, len(usernames))
+		for i := range usernames {
+			credentials[i] = broker.BasicAuthCredential{
+				Username: usernames[i],
+				Password: passwords[i],
+			}
+		}
+
+		return broker.NewBasicAuthenticator(credentials...), append(usernames, passwords...), nil
+
+	case mtls:
+		bundle, err := ioutil.ReadFile(o.clientCAPath)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		clientCAs := x509.NewCertPool()
+
+		if !clientCAs.AppendCertsFromPEM(bundle) {
+			return nil, nil, fmt.Errorf("%w: no valid certificates found in client CA bundle %s", ErrFatal, o.clientCAPath)
+		}
+
+		return broker.NewClientCertificateAuthenticator(clientCAs, o.clientAllowedNames.values...), nil, nil
+
+	case jwt:
+		if o.jwtIssuer == "" || o.jwtAudience == "" {
+			return nil, nil, fmt.Errorf("%w: jwt authentication requires an issuer and audience", ErrFatal)
+		}
+
+		if o.jwtJWKSPath == "" {
+			keys := broker.NewRemoteKeySet(o.jwtIssuer, &http.Client{Timeout: jwksTimeout}, o.jwtAllowInsecureJWKS)
+
+			return broker.NewJWTAuthenticator(keys, o.jwtIssuer, o.jwtAudience, o.jwtGroupsClaim), nil, nil
+		}
+
+		jwks, err := broker.NewFileCredential(o.jwtJWKSPath)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		keys, err := broker.NewCredentialKeySet(jwks)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return broker.NewJWTAuthenticator(keys, o.jwtIssuer, o.jwtAudience, o.jwtGroupsClaim), []*broker.FileCredential{jwks}, nil
+
+	case tokenReview:
+		config.TokenReviewRequired = true
+
+		return broker.NewTokenReviewAuthenticator(o.tokenReviewAudiences.values...), nil, nil
+	}
+
+	return nil, nil, fmt.Errorf("%w: unexpected authentication type %s", ErrFatal, o.authentication)
+}
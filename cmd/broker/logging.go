@@ -0,0 +1,164 @@
+// Copyright 2020-2021 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file  except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the  License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/couchbase/service-broker/pkg/log"
+)
+
+// logLevel is the minimum level of log messages to output.
+type logLevel log.Level
+
+// Set sets the log level from CLI parameters.
+func (l *logLevel) Set(s string) error {
+	level, err := log.ParseLevel(s)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrFatal, err)
+	}
+
+	*l = logLevel(level)
+
+	return nil
+}
+
+// Type returns the type of flag to display.
+func (l *logLevel) Type() string {
+	return "string"
+}
+
+// String returns the default log level.
+func (l *logLevel) String() string {
+	return log.Level(*l).String()
+}
+
+// isSubsystem returns whether the subsystem exists.
+func isSubsystem(subsystem string) bool {
+	for _, s := range log.Subsystems {
+		if s == subsystem {
+			return true
+		}
+	}
+
+	return false
+}
+
+// subsystemLevels maps from subsystem to the minimum level of log messages to output.
+type subsystemLevels map[string]log.Level
+
+// Set sets the subsystem log levels from CLI parameters, e.g. "api=debug,config=warning".
+func (l subsystemLevels) Set(s string) error {
+	for _, pair := range strings.Split(s, ",") {
+		fields := strings.Split(pair, "=")
+
+		expectedFields := 2
+		if len(fields) != expectedFields {
+			return fmt.Errorf("%w: subsystem log level %s not of the form subsystem=level", ErrFatal, pair)
+		}
+
+		subsystem := fields[0]
+
+		if !isSubsystem(subsystem) {
+			return fmt.Errorf("%w: unexpected log subsystem %s, must be one of %s", ErrFatal, subsystem, strings.Join(log.Subsystems, ", "))
+		}
+
+		level, err := log.ParseLevel(fields[1])
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrFatal, err)
+		}
+
+		l[subsystem] = level
+	}
+
+	return nil
+}
+
+// Type returns the type of flag to display.
+func (l subsystemLevels) Type() string {
+	return "string"
+}
+
+// String returns the default subsystem log levels.
+func (l subsystemLevels) String() string {
+	pairs := make([]string, 0, len(l))
+
+	for subsystem, level := range l {
+		pairs = append(pairs, subsystem+"="+level.String())
+	}
+
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, ",")
+}
+
+// loggingOptions defines which log messages the broker outputs.
+type loggingOptions struct {
+	// level is the minimum level to output for all subsystems.
+	level logLevel
+
+	// subsystemLevels overrides the level for individual subsystems.
+	subsystemLevels subsystemLevels
+
+	// verbosity is deprecated, a value greater than zero enables debug messages.
+	verbosity int
+
+	// logToStderr is deprecated and ignored, log messages are always written to
+	// standard error.
+	logToStderr bool
+}
+
+// newLoggingOptions returns the default logging options.
+func newLoggingOptions() *loggingOptions {
+	return &loggingOptions{
+		level:           logLevel(log.LevelInfo),
+		subsystemLevels: subsystemLevels{},
+	}
+}
+
+// addFlags registers logging CLI flags.
+func (o *loggingOptions) addFlags() {
+	flag.Var(&o.level, "log-level", "Minimum level of log messages to output, either 'debug', 'info', 'warning' or 'error'")
+	flag.Var(o.subsystemLevels, "log-subsystem-levels", fmt.Sprintf("Comma separated list of subsystem=level pairs that override the log level, subsystems are %s", strings.Join(log.Subsystems, ", ")))
+	flag.IntVar(&o.verbosity, "v", 0, "Deprecated, use -log-level=debug")
+	flag.BoolVar(&o.logToStderr, "logtostderr", true, "Deprecated and ignored, log messages are always written to standard error")
+}
+
+// configure sets the log levels.
+func (o *loggingOptions) configure() {
+	level := log.Level(o.level)
+
+	// Honor the deprecated verbosity flag, unless overridden.
+	explicit := false
+
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "log-level" {
+			explicit = true
+		}
+	})
+
+	if !explicit && o.verbosity > 0 {
+		level = log.LevelDebug
+	}
+
+	log.SetLevel(level)
+
+	for subsystem, l := range o.subsystemLevels {
+		log.SetSubsystemLevel(subsystem, l)
+	}
+}
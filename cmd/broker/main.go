@@ -15,19 +15,18 @@
 package main
 
 import (
-	"crypto/tls"
 	"errors"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"os"
+	"time"
 
 	"github.com/couchbase/service-broker/pkg/broker"
 	"github.com/couchbase/service-broker/pkg/client"
 	"github.com/couchbase/service-broker/pkg/config"
+	"github.com/couchbase/service-broker/pkg/log"
+	"github.com/couchbase/service-broker/pkg/operation"
 	"github.com/couchbase/service-broker/pkg/version"
-
-	"github.com/golang/glog"
 )
 
 const (
@@ -38,139 +37,141 @@ const (
 // ErrFatal is raised when the broker is unable to start.
 var ErrFatal = errors.New("fatal error")
 
-// authenticationType is the type of authentication the broker should use.
-type authenticationType string
+func main() {
+	// authentication defines the type of authentication to use, and its options.
+	authentication := newAuthenticationOptions()
 
-const (
-	// bearerToken authentication just does a string match.
-	bearerToken authenticationType = "token"
+	// server defines how to serve the API.
+	server := newServerOptions()
 
-	// basic authentication alos does a string match, or username and password.
-	// Note Cloud Foundry expects basic auth.
-	basic authenticationType = "basic"
-)
+	// logging defines which log messages to output.
+	logging := newLoggingOptions()
 
-// Set sets the authentication type from CLI parameters.
-func (a *authenticationType) Set(s string) error {
-	switch t := authenticationType(s); t {
-	case bearerToken, basic:
-		*a = t
-	default:
-		return fmt.Errorf("%w: unexpected authentication type %s", ErrFatal, s)
-	}
+	// traces defines where to export traces to.
+	traces := newTracingOptions()
 
-	return nil
-}
+	// operationWorkers is the number of asynchronous operations that may run concurrently.
+	var operationWorkers int
 
-// Type returns the type of flag to display.
-func (a *authenticationType) Type() string {
-	return "string"
-}
+	// operationQueueSize is the number of asynchronous operations that may be waiting to run.
+	var operationQueueSize int
 
-// String returns the default authentication type.
-func (a *authenticationType) String() string {
-	return string(*a)
-}
+	// orphanMitigationPeriod is how often to look for orphaned service instances.
+	var orphanMitigationPeriod time.Duration
 
-func main() {
-	// authenticationType is the type of authentication to use.
-	authentication := basic
+	// orphanMitigationGracePeriod is how long to wait before cleaning up orphaned service instances.
+	var orphanMitigationGracePeriod time.Duration
 
-	// tokenPath is the location of the file containing the bearer token for authentication.
-	var tokenPath string
-
-	// usernamePath is the location of the file containing the username for authentication.
-	var usernamePath string
-
-	// passwordPath is the location of the file containing the password for authentication.
-	var passwordPath string
+	// rateLimits are the per-client request rates allowed for each endpoint class.
+	rateLimits := map[broker.EndpointClass]*float64{
+		broker.EndpointClassCatalog:        new(float64),
+		broker.EndpointClassProvision:      new(float64),
+		broker.EndpointClassPoll:           new(float64),
+		broker.EndpointClassBind:           new(float64),
+		broker.EndpointClassAuthentication: new(float64),
+	}
 
-	// tlsCertificatePath is the location of the file containing the TLS server certifcate.
-	var tlsCertificatePath string
+	// rateLimitBurst is the number of requests a client may make at once.
+	var rateLimitBurst int
 
-	// tlsPrivateKeyPath is the location of the file containing the TLS private key.
-	var tlsPrivateKeyPath string
+	// maxMutatingRequests is the number of requests that may modify service instances
+	// or bindings concurrently.
+	var maxMutatingRequests int
 
-	flag.Var(&authentication, "authentication", "Authentication type to use, either 'basic' or 'token'")
-	flag.StringVar(&tokenPath, "token", "/var/run/secrets/service-broker/token", "Bearer token for API authentication")
-	flag.StringVar(&usernamePath, "username", "/var/run/secrets/service-broker/username", "Username for basic authentication")
-	flag.StringVar(&passwordPath, "password", "/var/run/secrets/service-broker/password", "Password for basic authentication")
-	flag.StringVar(&tlsCertificatePath, "tls-certificate", "/var/run/secrets/service-broker/tls-certificate", "Path to the server TLS certificate")
-	flag.StringVar(&tlsPrivateKeyPath, "tls-private-key", "/var/run/secrets/service-broker/tls-private-key", "Path to the server TLS key")
+	authentication.addFlags()
+	server.addFlags()
+	logging.addFlags()
+	traces.addFlags()
 	flag.StringVar(&config.ConfigurationName, "config", config.ConfigurationNameDefault, "Configuration resource name")
+	flag.IntVar(&operationWorkers, "operation-workers", operation.DefaultWorkers, "Maximum number of asynchronous operations to run concurrently")
+	flag.IntVar(&operationQueueSize, "operation-queue-size", operation.DefaultQueueSize, "Maximum number of asynchronous operations waiting to run before requests are rejected")
+	flag.DurationVar(&orphanMitigationPeriod, "orphan-mitigation-period", 0, "How often to look for orphaned service instances, disabled if zero")
+	flag.DurationVar(&orphanMitigationGracePeriod, "orphan-mitigation-grace-period", broker.DefaultOrphanMitigationGracePeriod, "How long to wait for clients to clean up failed or abandoned service instances before deprovisioning them")
+	for class, rate := range rateLimits {
+		flag.Float64Var(rate, "rate-limit-"+string(class), 0, fmt.Sprintf("Average %s requests per second allowed for each client, unlimited if zero", class))
+	}
+
+	flag.IntVar(&rateLimitBurst, "rate-limit-burst", broker.DefaultRateLimitBurst, "Number of requests each client may make at once before being rate limited")
+	flag.IntVar(&maxMutatingRequests, "max-mutating-requests", 0, "Maximum number of requests that create, update or delete service instances and bindings to handle concurrently, unlimited if zero")
 	flag.Parse()
 
+	logging.configure()
+
+	logger := log.New(log.SubsystemMain)
+
 	// Start the server.
-	glog.Infof("%s %s (git commit %s)", version.Application, version.Version, version.GitCommit)
+	logger.Infof("%s %s (git commit %s)", version.Application, version.Version, version.GitCommit)
 
 	c := broker.ServerConfiguration{}
 
 	// Parse implicit configuration.
 	namespace, ok := os.LookupEnv("NAMESPACE")
 	if !ok {
-		glog.Fatal(fmt.Errorf("%w: NAMESPACE environment variable must be set", ErrFatal))
+		logger.Errorf("%v", fmt.Errorf("%w: NAMESPACE environment variable must be set", ErrFatal))
 		os.Exit(errorCode)
 	}
 
 	c.Namespace = namespace
+	c.Scheduler = operation.NewScheduler(operationWorkers, operationQueueSize)
+	c.OrphanMitigationPeriod = orphanMitigationPeriod
+	c.OrphanMitigationGracePeriod = orphanMitigationGracePeriod
 
-	// Load up explicit configuration.
-	switch authentication {
-	case bearerToken:
-		token, err := ioutil.ReadFile(tokenPath)
-		if err != nil {
-			glog.Fatal(err)
-			os.Exit(errorCode)
-		}
-
-		stringToken := string(token)
-		c.Token = &stringToken
-
-	case basic:
-		username, err := ioutil.ReadFile(usernamePath)
-		if err != nil {
-			glog.Fatal(err)
-			os.Exit(errorCode)
-		}
+	limits := map[broker.EndpointClass]broker.RateLimit{}
 
-		password, err := ioutil.ReadFile(passwordPath)
-		if err != nil {
-			glog.Fatal(err)
-			os.Exit(errorCode)
+	for class, rate := range rateLimits {
+		limits[class] = broker.RateLimit{
+			Rate:  *rate,
+			Burst: rateLimitBurst,
 		}
+	}
 
-		stringUsername := string(username)
-		stringPassword := string(password)
+	c.RateLimiter = broker.NewRateLimiter(limits, maxMutatingRequests)
 
-		c.BasicAuth = &broker.ServerConfigurationBasicAuth{
-			Username: stringUsername,
-			Password: stringPassword,
-		}
+	// Load up explicit configuration.
+	authenticator, credentials, err := authentication.newAuthenticator()
+	if err != nil {
+		logger.Errorf("%v", err)
+		os.Exit(errorCode)
 	}
 
-	cert, err := tls.LoadX509KeyPair(tlsCertificatePath, tlsPrivateKeyPath)
+	c.Authenticator = authenticator
+
+	serverCredentials, err := server.configure(&c)
 	if err != nil {
-		glog.Fatal(err)
+		logger.Errorf("%v", err)
 		os.Exit(errorCode)
 	}
 
-	c.Certificate = cert
+	traces.configure()
+
+	// Pick up rotated credentials and certificates without a restart.
+	go broker.WatchCredentials(credentialReloadPeriod, append(credentials, serverCredentials...)...)
 
 	// Initialize the clients.
 	clients, err := client.New()
 	if err != nil {
-		glog.Fatal(err)
+		logger.Errorf("%v", err)
 		os.Exit(errorCode)
 	}
 
 	// Start the server.
 	if err := broker.ConfigureServer(clients, &c); err != nil {
-		glog.Fatal(err)
+		logger.Errorf("%v", err)
+		os.Exit(errorCode)
+	}
+
+	// Pick up any operations that were in flight when the broker last stopped.
+	if err := broker.ResumeOperations(&c); err != nil {
+		logger.Errorf("%v", err)
 		os.Exit(errorCode)
 	}
 
+	if c.OrphanMitigationPeriod > 0 {
+		go broker.RunOrphanMitigation(&c)
+	}
+
 	if err := broker.RunServer(&c); err != nil {
-		glog.Fatal(err)
+		logger.Errorf("%v", err)
 		os.Exit(errorCode)
 	}
 }
@@ -0,0 +1,201 @@
+// Copyright 2020-2021 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file  except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the  License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/couchbase/service-broker/pkg/broker"
+)
+
+// tlsVersions maps from CLI parameters to TLS versions.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// tlsVersion is the minimum TLS version the broker should accept.
+type tlsVersion string
+
+// Set sets the TLS version from CLI parameters.
+func (v *tlsVersion) Set(s string) error {
+	if _, ok := tlsVersions[s]; !ok {
+		return fmt.Errorf("%w: unexpected TLS version %s", ErrFatal, s)
+	}
+
+	*v = tlsVersion(s)
+
+	return nil
+}
+
+// Type returns the type of flag to display.
+func (v *tlsVersion) Type() string {
+	return "string"
+}
+
+// String returns the default TLS version.
+func (v *tlsVersion) String() string {
+	return string(*v)
+}
+
+// cipherSuites is a list of TLS cipher suites the broker should accept.
+type cipherSuites []uint16
+
+// Set sets the cipher suites from a comma separated list of Go cipher suite names
+// e.g. TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256.
+func (c *cipherSuites) Set(s string) error {
+	ids := map[string]uint16{}
+
+	for _, suite := range tls.CipherSuites() {
+		ids[suite.Name] = suite.ID
+	}
+
+	for _, suite := range tls.InsecureCipherSuites() {
+		ids[suite.Name] = suite.ID
+	}
+
+	*c = nil
+
+	for _, name := range strings.Split(s, ",") {
+		id, ok := ids[strings.TrimSpace(name)]
+		if !ok {
+			return fmt.Errorf("%w: unexpected TLS cipher suite %s", ErrFatal, name)
+		}
+
+		*c = append(*c, id)
+	}
+
+	return nil
+}
+
+// String returns the cipher suite names.
+func (c *cipherSuites) String() string {
+	names := make([]string, len(*c))
+
+	for i, id := range *c {
+		names[i] = tls.CipherSuiteName(id)
+	}
+
+	return strings.Join(names, ",")
+}
+
+// serverOptions defines how the broker serves the API.
+type serverOptions struct {
+	// tlsCertificatePath is the location of the file containing the TLS server certifcate.
+	tlsCertificatePath string
+
+	// tlsPrivateKeyPath is the location of the file containing the TLS private key.
+	tlsPrivateKeyPath string
+
+	// listenAddress is the address to serve the API over TLS on.
+	listenAddress string
+
+	// plaintextListenAddress is the address to serve the API without TLS on.
+	plaintextListenAddress string
+
+	// tlsMinVersion is the minimum TLS version to accept.
+	tlsMinVersion tlsVersion
+
+	// tlsCipherSuites are the TLS cipher suites to accept.
+	tlsCipherSuites cipherSuites
+
+	// readTimeout is how long to wait for a request to be read.
+	readTimeout time.Duration
+
+	// writeTimeout is how long to wait for a response to be written.
+	writeTimeout time.Duration
+
+	// idleTimeout is how long to keep idle connections open.
+	idleTimeout time.Duration
+
+	// opsListenAddress is the address to serve the operations API on.
+	opsListenAddress string
+
+	// opsTokenPaths are the locations of the files containing the bearer tokens for
+	// operations API authentication.
+	opsTokenPaths stringList
+}
+
+// newServerOptions returns the default server options.
+func newServerOptions() *serverOptions {
+	return &serverOptions{
+		tlsCertificatePath: "/var/run/secrets/service-broker/tls-certificate",
+		tlsPrivateKeyPath:  "/var/run/secrets/service-broker/tls-private-key",
+		listenAddress:      broker.DefaultListenAddress,
+		tlsMinVersion:      "1.2",
+		opsTokenPaths:      stringList{values: []string{"/var/run/secrets/service-broker/ops-token"}},
+	}
+}
+
+// addFlags registers server CLI flags.
+func (o *serverOptions) addFlags() {
+	flag.StringVar(&o.tlsCertificatePath, "tls-certificate", o.tlsCertificatePath, "Path to the server TLS certificate")
+	flag.StringVar(&o.tlsPrivateKeyPath, "tls-private-key", o.tlsPrivateKeyPath, "Path to the server TLS key")
+	flag.StringVar(&o.listenAddress, "listen-address", o.listenAddress, "Address to serve the API over TLS on")
+	flag.StringVar(&o.plaintextListenAddress, "plaintext-listen-address", "", "Address to also serve the API without TLS on e.g. for a service mesh sidecar, disabled if empty")
+	flag.Var(&o.tlsMinVersion, "tls-min-version", "Minimum TLS version to accept, either '1.0', '1.1', '1.2' or '1.3'")
+	flag.Var(&o.tlsCipherSuites, "tls-cipher-suites", "Comma separated list of TLS cipher suites to accept for TLS 1.2 and below, by default the Go defaults")
+	flag.DurationVar(&o.readTimeout, "http-read-timeout", 0, "How long to wait for a request to be read, unlimited if zero")
+	flag.DurationVar(&o.writeTimeout, "http-write-timeout", 0, "How long to wait for a response to be written, unlimited if zero")
+	flag.DurationVar(&o.idleTimeout, "http-idle-timeout", 0, "How long to keep idle connections open, the read timeout is used if zero")
+	flag.StringVar(&o.opsListenAddress, "ops-listen-address", "", "Address to serve the read-only operations API over TLS on, disabled if empty")
+	flag.Var(&o.opsTokenPaths, "ops-token", "Bearer token for operations API authentication, may be specified multiple times")
+}
+
+// configure loads the TLS key pair and operations API credentials, and applies the
+// options to the server configuration.  Any files that need to be watched for changes
+// are also returned.
+func (o *serverOptions) configure(c *broker.ServerConfiguration) ([]*broker.FileCredential, error) {
+	keyPair, err := broker.NewKeyPair(o.tlsCertificatePath, o.tlsPrivateKeyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	c.KeyPair = keyPair
+	c.ListenAddress = o.listenAddress
+	c.PlaintextListenAddress = o.plaintextListenAddress
+	c.TLSMinVersion = tlsVersions[string(o.tlsMinVersion)]
+	c.TLSCipherSuites = o.tlsCipherSuites
+	c.ReadTimeout = o.readTimeout
+	c.WriteTimeout = o.writeTimeout
+	c.IdleTimeout = o.idleTimeout
+
+	credentials := keyPair.Credentials()
+
+	if o.opsListenAddress == "" {
+		return credentials, nil
+	}
+
+	tokens, err := loadCredentials(o.opsTokenPaths.values)
+	if err != nil {
+		return nil, err
+	}
+
+	opsCredentials := make([]broker.Credential, len(tokens))
+	for i := range tokens {
+		opsCredentials[i] = tokens[i]
+	}
+
+	c.OpsListenAddress = o.opsListenAddress
+	c.OpsAuthenticator = broker.NewBearerTokenAuthenticator(opsCredentials...)
+
+	return append(credentials, tokens...), nil
+}
@@ -0,0 +1,92 @@
+// Copyright 2020-2021 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file  except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the  License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/couchbase/service-broker/pkg/tracing"
+)
+
+const (
+	// traceExporterNone disables tracing.
+	traceExporterNone = "none"
+
+	// traceExporterOTLP sends spans to an OpenTelemetry collector.
+	traceExporterOTLP = "otlp"
+
+	// traceExporterStdout writes spans to standard output.
+	traceExporterStdout = "stdout"
+)
+
+// traceExporter is where the broker should export spans to.
+type traceExporter string
+
+// Set sets the trace exporter from CLI parameters.
+func (e *traceExporter) Set(s string) error {
+	switch s {
+	case traceExporterNone, traceExporterOTLP, traceExporterStdout:
+	default:
+		return fmt.Errorf("%w: unexpected trace exporter %s", ErrFatal, s)
+	}
+
+	*e = traceExporter(s)
+
+	return nil
+}
+
+// Type returns the type of flag to display.
+func (e *traceExporter) Type() string {
+	return "string"
+}
+
+// String returns the default trace exporter.
+func (e *traceExporter) String() string {
+	return string(*e)
+}
+
+// tracingOptions defines how the broker exports traces.
+type tracingOptions struct {
+	// exporter is where to export spans to.
+	exporter traceExporter
+
+	// otlpEndpoint is the OTLP/HTTP traces endpoint to send spans to.
+	otlpEndpoint string
+}
+
+// newTracingOptions returns the default tracing options.
+func newTracingOptions() *tracingOptions {
+	return &tracingOptions{
+		exporter:     traceExporterNone,
+		otlpEndpoint: tracing.DefaultOTLPEndpoint,
+	}
+}
+
+// addFlags registers tracing CLI flags.
+func (o *tracingOptions) addFlags() {
+	flag.Var(&o.exporter, "trace-exporter", "Where to export traces to, either 'none', 'otlp' or 'stdout'")
+	flag.StringVar(&o.otlpEndpoint, "otlp-endpoint", o.otlpEndpoint, "OTLP/HTTP traces endpoint to export traces to when using the 'otlp' exporter")
+}
+
+// configure enables tracing if requested.
+func (o *tracingOptions) configure() {
+	switch o.exporter {
+	case traceExporterOTLP:
+		tracing.SetExporter(tracing.NewOTLPExporter(o.otlpEndpoint))
+	case traceExporterStdout:
+		tracing.SetExporter(tracing.NewStdoutExporter())
+	}
+}
@@ -21,16 +21,18 @@ type ServiceCatalog struct {
 
 // ServiceOffering must be provided by a service catalog.
 type ServiceOffering struct {
-	Name            string           `json:"name"`
-	ID              string           `json:"id"`
-	Description     string           `json:"description"`
-	Tags            []string         `json:"tags,omitempty"`
-	Requires        []string         `json:"requires,omitempty"`
-	Bindable        bool             `json:"bindable"`
-	Metadata        interface{}      `json:"metadata,omitempty"`
-	DashboardClient *DashboardClient `json:"dashboard_client,omitempty"`
-	PlanUpdatable   bool             `json:"plan_updatable,omitempty"`
-	Plans           []ServicePlan    `json:"plans"`
+	Name                 string           `json:"name"`
+	ID                   string           `json:"id"`
+	Description          string           `json:"description"`
+	Tags                 []string         `json:"tags,omitempty"`
+	Requires             []string         `json:"requires,omitempty"`
+	Bindable             bool             `json:"bindable"`
+	InstancesRetrievable bool             `json:"instances_retrievable,omitempty"`
+	BindingsRetrievable  bool             `json:"bindings_retrievable,omitempty"`
+	Metadata             interface{}      `json:"metadata,omitempty"`
+	DashboardClient      *DashboardClient `json:"dashboard_client,omitempty"`
+	PlanUpdatable        bool             `json:"plan_updatable,omitempty"`
+	Plans                []ServicePlan    `json:"plans"`
 }
 
 // DashboardClient may be provided by a service offering.
@@ -42,13 +44,18 @@ type DashboardClient struct {
 
 // ServicePlan must be provided by a service offering.
 type ServicePlan struct {
-	ID          string      `json:"id"`
-	Name        string      `json:"name"`
-	Description string      `json:"description"`
-	Metadata    interface{} `json:"metadata,omitempty"`
-	Free        bool        `json:"free,omitempty"`
-	Bindable    *bool       `json:"bindable,omitempty"`
-	Schemas     *Schemas    `json:"schemas,omitempty"`
+	ID              string           `json:"id"`
+	Name            string           `json:"name"`
+	Description     string           `json:"description"`
+	Metadata        interface{}      `json:"metadata,omitempty"`
+	Free            bool             `json:"free,omitempty"`
+	Bindable        *bool            `json:"bindable,omitempty"`
+	Schemas         *Schemas         `json:"schemas,omitempty"`
+	MaintenanceInfo *MaintenanceInfo `json:"maintenance_info,omitempty"`
+
+	// MaximumPollingDuration is the maximum amount of time in seconds that the
+	// platform should poll an asynchronous operation before giving up.
+	MaximumPollingDuration *int `json:"maximum_polling_duration,omitempty"`
 }
 
 // Schemas may be provided for a service plan.
@@ -0,0 +1,88 @@
+// Copyright 2020-2021 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file  except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the  License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"time"
+)
+
+// ResourceReference identifies a Kubernetes resource created by the service broker.
+type ResourceReference struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name"`
+}
+
+// OperationStatus describes the current asynchronous operation on a service instance
+// or binding.
+type OperationStatus struct {
+	// Type is the type of operation e.g. "provision".
+	Type string `json:"type"`
+
+	// ID is the operation ID returned to the client.
+	ID string `json:"id"`
+
+	// State is whether the operation is in progress, succeeded or failed.
+	State PollState `json:"state"`
+
+	// Description is the reason the operation failed.
+	Description string `json:"description,omitempty"`
+
+	// Step is the last step the operation started.
+	Step string `json:"step,omitempty"`
+
+	// StartTime is when the operation started.
+	StartTime *time.Time `json:"start_time,omitempty"`
+
+	// RequestID is the API request that started the operation.
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// ServiceInstanceStatus describes a service instance managed by the service broker.
+type ServiceInstanceStatus struct {
+	ID          string              `json:"id"`
+	Namespace   string              `json:"namespace"`
+	ServiceID   string              `json:"service_id"`
+	ServiceName string              `json:"service_name,omitempty"`
+	PlanID      string              `json:"plan_id"`
+	PlanName    string              `json:"plan_name,omitempty"`
+	Operation   *OperationStatus    `json:"operation,omitempty"`
+	Resources   []ResourceReference `json:"resources"`
+	Bindings    []string            `json:"bindings"`
+}
+
+// ServiceBindingStatus describes a service binding managed by the service broker.
+type ServiceBindingStatus struct {
+	ID          string              `json:"id"`
+	InstanceID  string              `json:"instance_id"`
+	Namespace   string              `json:"namespace"`
+	ServiceID   string              `json:"service_id"`
+	ServiceName string              `json:"service_name,omitempty"`
+	PlanID      string              `json:"plan_id"`
+	PlanName    string              `json:"plan_name,omitempty"`
+	Operation   *OperationStatus    `json:"operation,omitempty"`
+	Resources   []ResourceReference `json:"resources"`
+}
+
+// ListServiceInstancesResponse is returned when service instances are listed.
+type ListServiceInstancesResponse struct {
+	ServiceInstances []ServiceInstanceStatus `json:"service_instances"`
+}
+
+// ListServiceBindingsResponse is returned when service bindings are listed.
+type ListServiceBindingsResponse struct {
+	ServiceBindings []ServiceBindingStatus `json:"service_bindings"`
+}
@@ -66,6 +66,18 @@ const (
 	// ErrorResourceGone means that a delete request has failed because the
 	// requested resource does not exist.
 	ErrorResourceGone ErrorType = "ResourceGone"
+
+	// ErrorBusy means that the service broker is unable to accept the request
+	// at present, and it should be retried later.
+	ErrorBusy ErrorType = "Busy"
+
+	// ErrorForbidden means that the client is not allowed to use the requested
+	// service plan.
+	ErrorForbidden ErrorType = "Forbidden"
+
+	// ErrorRateLimited means that the client has made too many requests, and it
+	// should be retried later.
+	ErrorRateLimited ErrorType = "RateLimited"
 )
 
 // PollState is returned when an asynchronous request is polled.
@@ -101,7 +113,18 @@ type Error struct {
 
 // MaintenanceInfo is submitted by the client to provide versioning information.
 type MaintenanceInfo struct {
-	Version string `json:"version,omitempty"`
+	Version     string `json:"version,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// OriginatingIdentity is submitted by the client in the X-Broker-API-Originating-Identity
+// header to identify the end user that initiated a request.
+type OriginatingIdentity struct {
+	// Platform is the platform the user belongs to e.g. "cloudfoundry" or "kubernetes".
+	Platform string `json:"platform"`
+
+	// Value is a platform specific JSON object that identifies the user.
+	Value *runtime.RawExtension `json:"value"`
 }
 
 // CreateServiceInstanceRequest is submitted by the client when creating a service instance.
@@ -129,10 +152,11 @@ type PollServiceInstanceResponse struct {
 
 // GetServiceInstanceResponse is returned by the server when a service instance is read.
 type GetServiceInstanceResponse struct {
-	ServiceID    string                `json:"service_id,omitempty"`
-	PlanID       string                `json:"plan_id,omitempty"`
-	DashboardURL string                `json:"dashboard_url,omitempty"`
-	Parameters   *runtime.RawExtension `json:"parameters,omitempty"`
+	ServiceID       string                `json:"service_id,omitempty"`
+	PlanID          string                `json:"plan_id,omitempty"`
+	DashboardURL    string                `json:"dashboard_url,omitempty"`
+	Parameters      *runtime.RawExtension `json:"parameters,omitempty"`
+	MaintenanceInfo *MaintenanceInfo      `json:"maintenance_info,omitempty"`
 }
 
 // UpdateServiceInstanceRequest is submitted by the client when updating a service instance.
@@ -178,6 +202,12 @@ type CreateServiceBindingResponse struct {
 	Operation string `json:"operation"`
 }
 
+// PollServiceBindingResponse is returned by the server when a binding operation is being polled.
+type PollServiceBindingResponse struct {
+	State       PollState `json:"state"`
+	Description string    `json:"description,omitempty"`
+}
+
 // GetServiceBindingResponse is returned by the server when a service binding is read.
 type GetServiceBindingResponse struct {
 	Metadata        *BindingMetadata      `json:"metadata,omitempty"`
@@ -218,4 +248,5 @@ type Endpoint struct {
 
 // DeleteServiceBindingResponse is returned when a binding is deleted.
 type DeleteServiceBindingResponse struct {
+	Operation string `json:"operation,omitempty"`
 }
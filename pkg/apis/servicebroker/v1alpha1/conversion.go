@@ -18,6 +18,33 @@ import (
 	"github.com/couchbase/service-broker/pkg/api"
 )
 
+// ConvertCatalog reformats the Kubernetes catalog object as an Open Service Broker object.
+// Service plans are augmented with attributes defined by their configuration bindings.
+func (in ServiceBrokerConfigSpec) ConvertCatalog() api.ServiceCatalog {
+	out := in.Catalog.Convert()
+
+	for i := range out.Services {
+		service := &out.Services[i]
+
+		for j := range service.Plans {
+			plan := &service.Plans[j]
+
+			for _, binding := range in.Bindings {
+				if binding.Service != service.Name || binding.Plan != plan.Name {
+					continue
+				}
+
+				if binding.MaximumPollingDuration != nil {
+					maximumPollingDuration := int(binding.MaximumPollingDuration.Seconds())
+					plan.MaximumPollingDuration = &maximumPollingDuration
+				}
+			}
+		}
+	}
+
+	return out
+}
+
 // Convert reformats a Kubernetes catalog object as an Open Service Broker object.
 func (in ServiceCatalog) Convert() api.ServiceCatalog {
 	out := api.ServiceCatalog{}
@@ -31,16 +58,20 @@ func (in ServiceCatalog) Convert() api.ServiceCatalog {
 }
 
 // Convert reformats a Kubernetes catalog object as an Open Service Broker object.
+// Service instances and bindings are always retrievable, so this is advertised
+// unconditionally.
 func (in ServiceOffering) Convert() api.ServiceOffering {
 	out := api.ServiceOffering{
-		Name:          in.Name,
-		ID:            in.ID,
-		Description:   in.Description,
-		Tags:          in.Tags,
-		Requires:      in.Requires,
-		Bindable:      in.Bindable,
-		Metadata:      in.Metadata,
-		PlanUpdatable: in.PlanUpdatable,
+		Name:                 in.Name,
+		ID:                   in.ID,
+		Description:          in.Description,
+		Tags:                 in.Tags,
+		Requires:             in.Requires,
+		Bindable:             in.Bindable,
+		InstancesRetrievable: true,
+		BindingsRetrievable:  true,
+		Metadata:             in.Metadata,
+		PlanUpdatable:        in.PlanUpdatable,
 	}
 
 	if in.DashboardClient != nil {
@@ -81,9 +112,22 @@ func (in ServicePlan) Convert() api.ServicePlan {
 		out.Schemas = &schemas
 	}
 
+	if in.MaintenanceInfo != nil {
+		maintenanceInfo := in.MaintenanceInfo.Convert()
+		out.MaintenanceInfo = &maintenanceInfo
+	}
+
 	return out
 }
 
+// Convert reformats a Kubernetes catalog object as an Open Service Broker object.
+func (in MaintenanceInfo) Convert() api.MaintenanceInfo {
+	return api.MaintenanceInfo{
+		Version:     in.Version,
+		Description: in.Description,
+	}
+}
+
 // Convert reformats a Kubernetes catalog object as an Open Service Broker object.
 func (in Schemas) Convert() api.Schemas {
 	out := api.Schemas{}
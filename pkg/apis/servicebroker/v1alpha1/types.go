@@ -29,6 +29,10 @@ const (
 
 	// ResourceAnnotation records the resource for updates.
 	ResourceAnnotation = labelBase + "/resource"
+
+	// OriginatingIdentityAnnotation records the end user that created or last updated
+	// the resource.
+	OriginatingIdentityAnnotation = labelBase + "/originating-identity"
 )
 
 // +genclient
@@ -63,6 +67,71 @@ type ServiceBrokerConfigSpec struct {
 	// +listType=map
 	// +listMapKey=name
 	Bindings []ConfigurationBinding `json:"bindings"`
+
+	// TokenReview defines which clients may use the service broker API when
+	// using Kubernetes TokenReview authentication.  More info:
+	// https://github.com/couchbase/service-broker/tree/master/documentation/modules/ROOT/pages/reference/container.adoc
+	TokenReview *TokenReviewAuthorization `json:"tokenReview,omitempty"`
+
+	// AccessRules restrict which clients may see and use service plans.  Service
+	// plans not selected by any rule are available to all clients.  More info:
+	// https://github.com/couchbase/service-broker/tree/master/documentation/modules/ROOT/pages/concepts/catalog.adoc
+	AccessRules []AccessRule `json:"accessRules,omitempty"`
+}
+
+// AccessRule allows clients to see and use service plans.  A selected service plan is
+// available to a client if any rule that selects it matches.  A rule matches when every
+// criterion specified is satisfied.
+type AccessRule struct {
+	// Service is the name of the service offering the rule applies to.
+	// +kubebuilder:validation:MinLength=1
+	Service string `json:"service"`
+
+	// Plans are the names of the service plans the rule applies to.  If not
+	// specified the rule applies to all plans of the service offering.
+	Plans []string `json:"plans,omitempty"`
+
+	// Subjects, if specified, matches authenticated clients with any of these
+	// identities.  When used with groups, either may match.
+	Subjects []string `json:"subjects,omitempty"`
+
+	// Groups, if specified, matches authenticated clients that are members of
+	// any of these groups.  When used with subjects, either may match.
+	Groups []string `json:"groups,omitempty"`
+
+	// Organizations, if specified, matches requests with any of these Cloud
+	// Foundry organization GUIDs in the request context.
+	Organizations []string `json:"organizations,omitempty"`
+
+	// Spaces, if specified, matches requests with any of these Cloud Foundry
+	// space GUIDs in the request context.
+	Spaces []string `json:"spaces,omitempty"`
+
+	// Namespaces, if specified, matches requests with any of these Kubernetes
+	// namespaces in the request context.
+	Namespaces []string `json:"namespaces,omitempty"`
+}
+
+// TokenReviewAuthorization defines which Kubernetes identities are allowed to use
+// the service broker API.  A client is allowed if it is any of the service accounts
+// or a member of any of the groups.
+type TokenReviewAuthorization struct {
+	// ServiceAccounts is a list of service accounts allowed to use the API.
+	ServiceAccounts []ServiceAccountReference `json:"serviceAccounts,omitempty"`
+
+	// Groups is a list of groups allowed to use the API.
+	Groups []string `json:"groups,omitempty"`
+}
+
+// ServiceAccountReference refers to a Kubernetes service account.
+type ServiceAccountReference struct {
+	// Namespace is the namespace the service account is in.
+	// +kubebuilder:validation:MinLength=1
+	Namespace string `json:"namespace"`
+
+	// Name is the name of the service account.
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
 }
 
 // ServiceCatalog is defined by:
@@ -192,6 +261,11 @@ type ServicePlan struct {
 	// Plan. More info:
 	// https://github.com/couchbase/service-broker/tree/master/documentation/modules/ROOT/pages/concepts/catalog.adoc#json-schemas
 	Schemas *Schemas `json:"schemas,omitempty"`
+
+	// MaintenanceInfo describes the version of the Service Plan.  When the version is
+	// changed, existing Service Instances may be upgraded to the new version by the
+	// platform, re-rendering the plan's templates.
+	MaintenanceInfo *MaintenanceInfo `json:"maintenanceInfo,omitempty"`
 }
 
 // Schemas is defined by:
@@ -234,7 +308,13 @@ type InputParamtersSchema struct {
 // MaintenanceInfo is defined by:
 // https://github.com/openservicebrokerapi/servicebroker/blob/master/spec.md#body
 type MaintenanceInfo struct {
-	Version string `json:"version,omitempty"`
+	// Version is the version of the Service Plan.  This MUST be a semantic version
+	// string e.g. 1.0.0.
+	// +kubebuilder:validation:MinLength=1
+	Version string `json:"version"`
+
+	// Description is a description of the changes made by this version.
+	Description string `json:"description,omitempty"`
 }
 
 // ConfigurationTemplate defines a resource template for use when either
@@ -254,6 +334,11 @@ type ConfigurationTemplate struct {
 	// doesn't already exist.  Singleton resources will first check to see
 	// whether they exist before attempting creation.
 	Singleton bool `json:"singleton,omitempty"`
+
+	// SensitiveFields are JSON pointers to fields in the template that contain
+	// sensitive data e.g. passwords.  These are masked when the template is
+	// logged.  A "*" matches any attribute or array element.
+	SensitiveFields []string `json:"sensitiveFields,omitempty"`
 }
 
 // RegistryValue sets a registry key using a template.
@@ -340,6 +425,20 @@ type ConfigurationBinding struct {
 	// a new service binding is created.  This attribute is optional based on
 	// whether the service plan allows binding.
 	ServiceBinding *ServiceBrokerTemplateList `json:"serviceBinding,omitempty"`
+
+	// MaximumPollingDuration is the maximum time an asynchronous operation on a
+	// service instance or binding may take.  Operations that take longer are
+	// cancelled and reported as failed.  This is advertised to clients as the
+	// service plan's maximum polling duration.  If not specified, operations
+	// are not time limited.
+	MaximumPollingDuration *metav1.Duration `json:"maximumPollingDuration,omitempty"`
+
+	// RollbackOnFailure, when set, deletes all resources created by a failed
+	// provisioning operation, in the reverse order they were created in.
+	// Singleton resources are not deleted, only their owner reference to the
+	// service instance or binding is removed.  This leaves nothing behind for
+	// the platform to clean up before it retries.
+	RollbackOnFailure bool `json:"rollbackOnFailure,omitempty"`
 }
 
 // ServiceBrokerTemplateList is an ordered list of templates to use
@@ -371,6 +470,13 @@ type ServiceBrokerTemplateList struct {
 	// +listType=map
 	// +listMapKey=name
 	Steps []ServiceBrokerTemplateListStep `json:"steps,omitempty"`
+
+	// Teardown allows templates to be created, in steps, when a service instance or
+	// binding is deprovisioned e.g. to run a backup job.  Teardown steps are run before
+	// any resources are deleted, and the resources they create are deleted last.
+	// +listType=map
+	// +listMapKey=name
+	Teardown []ServiceBrokerTemplateListStep `json:"teardown,omitempty"`
 }
 
 // ServiceBrokerTemplateListStep allows a service instance to be provisioned in steps
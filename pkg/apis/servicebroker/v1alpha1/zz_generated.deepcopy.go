@@ -24,6 +24,52 @@ import (
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AccessRule) DeepCopyInto(out *AccessRule) {
+	*out = *in
+	if in.Plans != nil {
+		in, out := &in.Plans, &out.Plans
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Subjects != nil {
+		in, out := &in.Subjects, &out.Subjects
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Groups != nil {
+		in, out := &in.Groups, &out.Groups
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Organizations != nil {
+		in, out := &in.Organizations, &out.Organizations
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Spaces != nil {
+		in, out := &in.Spaces, &out.Spaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Namespaces != nil {
+		in, out := &in.Namespaces, &out.Namespaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AccessRule.
+func (in *AccessRule) DeepCopy() *AccessRule {
+	if in == nil {
+		return nil
+	}
+	out := new(AccessRule)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ConfigurationBinding) DeepCopyInto(out *ConfigurationBinding) {
 	*out = *in
@@ -33,6 +79,11 @@ func (in *ConfigurationBinding) DeepCopyInto(out *ConfigurationBinding) {
 		*out = new(ServiceBrokerTemplateList)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.MaximumPollingDuration != nil {
+		in, out := &in.MaximumPollingDuration, &out.MaximumPollingDuration
+		*out = new(v1.Duration)
+		**out = **in
+	}
 	return
 }
 
@@ -96,6 +147,11 @@ func (in *ConfigurationTemplate) DeepCopyInto(out *ConfigurationTemplate) {
 		*out = new(runtime.RawExtension)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.SensitiveFields != nil {
+		in, out := &in.SensitiveFields, &out.SensitiveFields
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 	return
 }
 
@@ -204,6 +260,22 @@ func (in *Schemas) DeepCopy() *Schemas {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ServiceAccountReference) DeepCopyInto(out *ServiceAccountReference) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ServiceAccountReference.
+func (in *ServiceAccountReference) DeepCopy() *ServiceAccountReference {
+	if in == nil {
+		return nil
+	}
+	out := new(ServiceAccountReference)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ServiceBindingSchema) DeepCopyInto(out *ServiceBindingSchema) {
 	*out = *in
@@ -321,6 +393,18 @@ func (in *ServiceBrokerConfigSpec) DeepCopyInto(out *ServiceBrokerConfigSpec) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.TokenReview != nil {
+		in, out := &in.TokenReview, &out.TokenReview
+		*out = new(TokenReviewAuthorization)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.AccessRules != nil {
+		in, out := &in.AccessRules, &out.AccessRules
+		*out = make([]AccessRule, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	return
 }
 
@@ -384,6 +468,13 @@ func (in *ServiceBrokerTemplateList) DeepCopyInto(out *ServiceBrokerTemplateList
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.Teardown != nil {
+		in, out := &in.Teardown, &out.Teardown
+		*out = make([]ServiceBrokerTemplateListStep, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	return
 }
 
@@ -535,6 +626,11 @@ func (in *ServicePlan) DeepCopyInto(out *ServicePlan) {
 		*out = new(Schemas)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.MaintenanceInfo != nil {
+		in, out := &in.MaintenanceInfo, &out.MaintenanceInfo
+		*out = new(MaintenanceInfo)
+		**out = **in
+	}
 	return
 }
 
@@ -547,3 +643,29 @@ func (in *ServicePlan) DeepCopy() *ServicePlan {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TokenReviewAuthorization) DeepCopyInto(out *TokenReviewAuthorization) {
+	*out = *in
+	if in.ServiceAccounts != nil {
+		in, out := &in.ServiceAccounts, &out.ServiceAccounts
+		*out = make([]ServiceAccountReference, len(*in))
+		copy(*out, *in)
+	}
+	if in.Groups != nil {
+		in, out := &in.Groups, &out.Groups
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TokenReviewAuthorization.
+func (in *TokenReviewAuthorization) DeepCopy() *TokenReviewAuthorization {
+	if in == nil {
+		return nil
+	}
+	out := new(TokenReviewAuthorization)
+	in.DeepCopyInto(out)
+	return out
+}
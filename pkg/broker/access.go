@@ -0,0 +1,209 @@
+// Copyright 2020-2021 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file  except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the  License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/couchbase/service-broker/pkg/api"
+	v1 "github.com/couchbase/service-broker/pkg/apis/servicebroker/v1alpha1"
+	"github.com/couchbase/service-broker/pkg/errors"
+
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// accessRequest describes who is requesting access to a service plan, and from where.
+type accessRequest struct {
+	// identity is the authenticated client identity.
+	identity *Identity
+
+	// platform is set when the platform context is known.  The catalog is
+	// requested without one, so rules depending on it cannot be evaluated.
+	platform bool
+
+	// organization is the Cloud Foundry organization GUID.
+	organization string
+
+	// space is the Cloud Foundry space GUID.
+	space string
+
+	// namespace is the Kubernetes namespace.
+	namespace string
+}
+
+// newCatalogAccessRequest creates an access request for reading the catalog.  There
+// is no platform context, so only the authenticated identity is known.
+func newCatalogAccessRequest(r *http.Request) *accessRequest {
+	access := &accessRequest{}
+
+	if identity, ok := IdentityFromContext(r.Context()); ok {
+		access.identity = identity
+	}
+
+	return access
+}
+
+// newAccessRequest creates an access request from the authenticated identity and
+// platform context.  The context is optional, in which case rules depending on it
+// will not match.
+func newAccessRequest(r *http.Request, context *runtime.RawExtension) (*accessRequest, error) {
+	access := newCatalogAccessRequest(r)
+	access.platform = true
+
+	if context == nil || len(context.Raw) == 0 {
+		return access, nil
+	}
+
+	var platform struct {
+		OrganizationGUID string `json:"organization_guid"`
+		SpaceGUID        string `json:"space_guid"`
+		Namespace        string `json:"namespace"`
+	}
+
+	if err := json.Unmarshal(context.Raw, &platform); err != nil {
+		return nil, errors.NewParameterError("malformed request context: %v", err)
+	}
+
+	access.organization = platform.OrganizationGUID
+	access.space = platform.SpaceGUID
+	access.namespace = platform.Namespace
+
+	return access, nil
+}
+
+// contains returns whether the value is in the list.
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// selects returns whether the rule applies to a service plan.
+func selects(rule *v1.AccessRule, serviceName, planName string) bool {
+	if rule.Service != serviceName {
+		return false
+	}
+
+	return len(rule.Plans) == 0 || contains(rule.Plans, planName)
+}
+
+// matches returns whether the rule allows access.
+func (a *accessRequest) matches(rule *v1.AccessRule) bool {
+	if len(rule.Subjects) != 0 || len(rule.Groups) != 0 {
+		if a.identity == nil {
+			return false
+		}
+
+		matched := a.identity.Subject != "" && contains(rule.Subjects, a.identity.Subject)
+
+		for _, group := range a.identity.Groups {
+			matched = matched || contains(rule.Groups, group)
+		}
+
+		if !matched {
+			return false
+		}
+	}
+
+	if !a.platform {
+		return true
+	}
+
+	if len(rule.Organizations) != 0 && !contains(rule.Organizations, a.organization) {
+		return false
+	}
+
+	if len(rule.Spaces) != 0 && !contains(rule.Spaces, a.space) {
+		return false
+	}
+
+	if len(rule.Namespaces) != 0 && !contains(rule.Namespaces, a.namespace) {
+		return false
+	}
+
+	return true
+}
+
+// planAccessible returns whether a service plan is available to the client.
+func planAccessible(config *v1.ServiceBrokerConfig, access *accessRequest, serviceName, planName string) bool {
+	selected := false
+
+	for i := range config.Spec.AccessRules {
+		rule := &config.Spec.AccessRules[i]
+
+		if !selects(rule, serviceName, planName) {
+			continue
+		}
+
+		if access.matches(rule) {
+			return true
+		}
+
+		selected = true
+	}
+
+	return !selected
+}
+
+// filterCatalog removes any service plans from the catalog that are not available to
+// the client.  Service offerings with no plans left are also removed.
+func filterCatalog(config *v1.ServiceBrokerConfig, access *accessRequest, catalog api.ServiceCatalog) api.ServiceCatalog {
+	services := []api.ServiceOffering{}
+
+	for _, service := range catalog.Services {
+		plans := []api.ServicePlan{}
+
+		for _, plan := range service.Plans {
+			if planAccessible(config, access, service.Name, plan.Name) {
+				plans = append(plans, plan)
+			}
+		}
+
+		if len(plans) == 0 && len(service.Plans) != 0 {
+			continue
+		}
+
+		service.Plans = plans
+		services = append(services, service)
+	}
+
+	catalog.Services = services
+
+	return catalog
+}
+
+// authorizeServicePlan checks that a service plan is available to the client.
+func authorizeServicePlan(config *v1.ServiceBrokerConfig, access *accessRequest, serviceID, planID string) error {
+	service, err := getServiceOffering(config, serviceID)
+	if err != nil {
+		return err
+	}
+
+	plan, err := getServicePlan(config, serviceID, planID)
+	if err != nil {
+		return err
+	}
+
+	if !planAccessible(config, access, service.Name, plan.Name) {
+		return errors.NewForbiddenError("service plan %s of service offering %s is not available to this client", plan.Name, service.Name)
+	}
+
+	return nil
+}
@@ -0,0 +1,563 @@
+// Copyright 2020-2021 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file  except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the  License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "github.com/couchbase/service-broker/pkg/apis/servicebroker/v1alpha1"
+	"github.com/couchbase/service-broker/pkg/config"
+	"github.com/couchbase/service-broker/pkg/log"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Identity is the authenticated identity of a client.
+type Identity struct {
+	// Subject uniquely identifies the client e.g. a user name.  This may be
+	// empty if the authentication mechanism cannot identify the client.
+	Subject string
+
+	// Groups are any groups the client belongs to.
+	Groups []string
+}
+
+// identityKey is used to store the client identity in a request context.
+type identityKey struct{}
+
+// contextWithIdentity returns a new context containing the client identity.
+func contextWithIdentity(ctx context.Context, identity *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// IdentityFromContext returns the authenticated client identity from a request context.
+func IdentityFromContext(ctx context.Context) (*Identity, bool) {
+	identity, ok := ctx.Value(identityKey{}).(*Identity)
+
+	return identity, ok
+}
+
+// Authenticator authenticates API requests.
+type Authenticator interface {
+	// Authenticate returns the client identity if the request is authenticated,
+	// and an error otherwise.
+	Authenticate(r *http.Request) (*Identity, error)
+}
+
+// Credential is a source of secret data e.g. a password.
+type Credential interface {
+	// Value returns the current credential value.
+	Value() []byte
+}
+
+// StaticCredential is a credential that never changes.
+type StaticCredential []byte
+
+// Value returns the credential value.
+func (c StaticCredential) Value() []byte {
+	return c
+}
+
+// ErrCredentialEmpty is raised when a credential file contains no data.
+var ErrCredentialEmpty = errors.New("credential empty")
+
+// FileCredential is a credential loaded from a file.  It is reloaded when the
+// file is modified, so credentials can be rotated without restarting.
+type FileCredential struct {
+	// path is the file to load the credential from.
+	path string
+
+	// lock protects the fields below.
+	lock sync.RWMutex
+
+	// value is the current credential value.
+	value []byte
+
+	// modTime and size are used to detect when the file has changed.
+	modTime time.Time
+	size    int64
+}
+
+// NewFileCredential loads a credential from a file.
+func NewFileCredential(path string) (*FileCredential, error) {
+	c := &FileCredential{
+		path: path,
+	}
+
+	if err := c.Reload(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Value returns the credential value.
+func (c *FileCredential) Value() []byte {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.value
+}
+
+// Reload reloads the credential if the file has changed.  Kubernetes secrets
+// are updated by swapping symbolic links, so the file is always stat'd by name.
+// Should the file be empty, the last good credential is retained, as an empty
+// credential would either lock out or let in everyone.
+func (c *FileCredential) Reload() error {
+	info, err := os.Stat(c.path)
+	if err != nil {
+		return err
+	}
+
+	c.lock.RLock()
+	changed := !info.ModTime().Equal(c.modTime) || info.Size() != c.size
+	c.lock.RUnlock()
+
+	if !changed {
+		return nil
+	}
+
+	value, err := ioutil.ReadFile(c.path)
+	if err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if len(bytes.TrimSpace(value)) == 0 {
+		if c.value == nil {
+			return fmt.Errorf("%w: %s", ErrCredentialEmpty, c.path)
+		}
+
+		log.New(log.SubsystemAuth).Warningf("credential %s is empty, retaining previous value", c.path)
+
+		c.modTime = info.ModTime()
+		c.size = info.Size()
+
+		return nil
+	}
+
+	if c.value != nil && !bytes.Equal(c.value, value) {
+		log.New(log.SubsystemAuth).Infof("credential %s reloaded", c.path)
+	}
+
+	c.value = value
+	c.modTime = info.ModTime()
+	c.size = info.Size()
+
+	return nil
+}
+
+// WatchCredentials periodically reloads credentials from files.  This is intended
+// to be run in a go routine, and never returns.  Should a reload fail, the last
+// good credential is retained.
+func WatchCredentials(period time.Duration, credentials ...*FileCredential) {
+	tick := time.NewTicker(period)
+	defer tick.Stop()
+
+	for range tick.C {
+		for _, credential := range credentials {
+			if err := credential.Reload(); err != nil {
+				log.New(log.SubsystemAuth).Infof("failed to reload credential %s: %v", credential.path, err)
+			}
+		}
+	}
+}
+
+// matchCredential checks whether the presented value matches a credential.  The
+// comparison is constant time, to prevent timing attacks.
+func matchCredential(presented []byte, credential Credential) int {
+	return subtle.ConstantTimeCompare(presented, credential.Value())
+}
+
+// getAuthorization returns the authorization header credentials for a scheme.
+func getAuthorization(r *http.Request, scheme string) (string, error) {
+	header, err := getHeaderSingle(r, "Authorization")
+	if err != nil {
+		return "", err
+	}
+
+	prefix := scheme + " "
+
+	if !strings.HasPrefix(header, prefix) {
+		return "", fmt.Errorf("%w: authorization scheme is not %s", ErrUnauthorized, scheme)
+	}
+
+	return strings.TrimPrefix(header, prefix), nil
+}
+
+// BearerTokenAuthenticator implements RFC-6750.
+type BearerTokenAuthenticator struct {
+	// tokens is the set of valid tokens.  Multiple tokens may be valid at
+	// the same time so they can be rotated.
+	tokens []Credential
+}
+
+// NewBearerTokenAuthenticator returns an authenticator that accepts any of the tokens.
+func NewBearerTokenAuthenticator(tokens ...Credential) *BearerTokenAuthenticator {
+	return &BearerTokenAuthenticator{
+		tokens: tokens,
+	}
+}
+
+// bearerTokenSubject returns a stable subject for a bearer token, so clients using
+// different tokens can be told apart, e.g. by rate limiting, without revealing the
+// token itself.
+func bearerTokenSubject(token string) string {
+	digest := sha256.Sum256([]byte(token))
+
+	return "token:" + hex.EncodeToString(digest[:8])
+}
+
+// Authenticate checks the request has a valid bearer token.  Tokens are shared
+// secrets, so the client is identified by the token it presented.
+func (a *BearerTokenAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	token, err := getAuthorization(r, "Bearer")
+	if err != nil {
+		return nil, err
+	}
+
+	// Check every token, so the time taken doesn't leak which one matched.
+	match := 0
+
+	for _, t := range a.tokens {
+		match |= matchCredential([]byte(token), t)
+	}
+
+	if match != 1 {
+		return nil, fmt.Errorf("%w: authorization failed", ErrUnauthorized)
+	}
+
+	identity := &Identity{
+		Subject: bearerTokenSubject(token),
+	}
+
+	return identity, nil
+}
+
+// BasicAuthCredential is a username and password pair.
+type BasicAuthCredential struct {
+	// Username is the user name.
+	Username Credential
+
+	// Password is the user's password.
+	Password Credential
+}
+
+// BasicAuthenticator implements RFC-7617.
+type BasicAuthenticator struct {
+	// credentials is the set of valid credentials.  Multiple credentials may be
+	// valid at the same time so they can be rotated.
+	credentials []BasicAuthCredential
+}
+
+// NewBasicAuthenticator returns an authenticator that accepts any of the credentials.
+func NewBasicAuthenticator(credentials ...BasicAuthCredential) *BasicAuthenticator {
+	return &BasicAuthenticator{
+		credentials: credentials,
+	}
+}
+
+// Authenticate checks the request has a valid username and password.
+func (a *BasicAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	encoded, err := getAuthorization(r, "Basic")
+	if err != nil {
+		return nil, err
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed basic authorization: %v", ErrUnauthorized, err)
+	}
+
+	index := bytes.IndexByte(decoded, ':')
+	if index < 0 {
+		return nil, fmt.Errorf("%w: malformed basic authorization", ErrUnauthorized)
+	}
+
+	username := decoded[:index]
+	password := decoded[index+1:]
+
+	// Check every credential, so the time taken doesn't leak which one matched.
+	match := 0
+
+	for _, credential := range a.credentials {
+		match |= matchCredential(username, credential.Username) & matchCredential(password, credential.Password)
+	}
+
+	if match != 1 {
+		return nil, fmt.Errorf("%w: authorization failed", ErrUnauthorized)
+	}
+
+	return &Identity{Subject: string(username)}, nil
+}
+
+// TLSAuthenticator is implemented by authenticators that need to modify the server
+// TLS configuration e.g. to request client certificates.
+type TLSAuthenticator interface {
+	// ConfigureTLS modifies the server TLS configuration.
+	ConfigureTLS(config *tls.Config)
+}
+
+// ClientCertificateAuthenticator implements mutual TLS authentication.
+type ClientCertificateAuthenticator struct {
+	// clientCAs is the set of CAs client certificates must be signed by.
+	clientCAs *x509.CertPool
+
+	// allowedNames, if set, is the set of subject common names or subject
+	// alternative names that are permitted to access the API.
+	allowedNames []string
+}
+
+// NewClientCertificateAuthenticator returns an authenticator that accepts any client
+// certificate signed by one of the CAs.  If any allowed names are specified, the
+// certificate's subject common name or a subject alternative name must match one.
+func NewClientCertificateAuthenticator(clientCAs *x509.CertPool, allowedNames ...string) *ClientCertificateAuthenticator {
+	return &ClientCertificateAuthenticator{
+		clientCAs:    clientCAs,
+		allowedNames: allowedNames,
+	}
+}
+
+// ConfigureTLS requires client certificates and verifies them against the CAs, so
+// unauthenticated clients are rejected by the TLS handshake before any request is
+// processed.
+func (a *ClientCertificateAuthenticator) ConfigureTLS(config *tls.Config) {
+	config.ClientCAs = a.clientCAs
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+}
+
+// Authenticate checks the request was made with a valid client certificate.  The
+// client is identified by the certificate's subject common name.
+func (a *ClientCertificateAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil, fmt.Errorf("%w: client certificate required", ErrUnauthorized)
+	}
+
+	certificate := r.TLS.VerifiedChains[0][0]
+
+	identity := &Identity{
+		Subject: certificate.Subject.CommonName,
+	}
+
+	if len(a.allowedNames) == 0 {
+		return identity, nil
+	}
+
+	names := []string{
+		certificate.Subject.CommonName,
+	}
+
+	names = append(names, certificate.DNSNames...)
+	names = append(names, certificate.EmailAddresses...)
+
+	for _, ip := range certificate.IPAddresses {
+		names = append(names, ip.String())
+	}
+
+	for _, uri := range certificate.URIs {
+		names = append(names, uri.String())
+	}
+
+	for _, name := range names {
+		for _, allowedName := range a.allowedNames {
+			if name != "" && name == allowedName {
+				return identity, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("%w: client certificate %s not allowed", ErrUnauthorized, certificate.Subject.CommonName)
+}
+
+const (
+	// tokenReviewCacheTTL is how long token review results are cached for, so
+	// every request does not result in a call to the Kubernetes API.
+	tokenReviewCacheTTL = 10 * time.Second
+
+	// tokenReviewCacheSize is the maximum number of cached token review results,
+	// so random tokens cannot be used to exhaust memory.
+	tokenReviewCacheSize = 1024
+)
+
+// tokenReviewResult is a cached token review result.
+type tokenReviewResult struct {
+	// status is the result of the token review.
+	status authenticationv1.TokenReviewStatus
+
+	// expiry is when the result must be reviewed again.
+	expiry time.Time
+}
+
+// TokenReviewAuthenticator implements Kubernetes service account authentication.  Bearer
+// tokens are verified with the TokenReview API, and the resulting identity must be
+// allowed by the service broker configuration.
+type TokenReviewAuthenticator struct {
+	// audiences, if set, are the audiences the token must be valid for.
+	audiences []string
+
+	// lock protects the fields below.
+	lock sync.Mutex
+
+	// cache maps from a token hash to its token review result.
+	cache map[string]tokenReviewResult
+}
+
+// NewTokenReviewAuthenticator returns an authenticator that accepts any token the
+// Kubernetes API server can authenticate.  If any audiences are specified, the token
+// must be valid for at least one of them.
+func NewTokenReviewAuthenticator(audiences ...string) *TokenReviewAuthenticator {
+	return &TokenReviewAuthenticator{
+		audiences: audiences,
+		cache:     map[string]tokenReviewResult{},
+	}
+}
+
+// cached returns a cached token review result.
+func (a *TokenReviewAuthenticator) cached(key string) (*authenticationv1.TokenReviewStatus, bool) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	result, ok := a.cache[key]
+	if !ok || time.Now().After(result.expiry) {
+		return nil, false
+	}
+
+	return &result.status, true
+}
+
+// cacheResult records a token review result.  If the cache is full, expired results are
+// pruned, and if it is still full the result is not cached.
+func (a *TokenReviewAuthenticator) cacheResult(key string, status *authenticationv1.TokenReviewStatus) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	now := time.Now()
+
+	if len(a.cache) >= tokenReviewCacheSize {
+		for k, result := range a.cache {
+			if now.After(result.expiry) {
+				delete(a.cache, k)
+			}
+		}
+
+		if len(a.cache) >= tokenReviewCacheSize {
+			return
+		}
+	}
+
+	a.cache[key] = tokenReviewResult{
+		status: *status,
+		expiry: now.Add(tokenReviewCacheTTL),
+	}
+}
+
+// review returns the token review status for a token, either cached or from the
+// Kubernetes API.  Both authenticated and unauthenticated results are cached, API
+// errors are not.
+func (a *TokenReviewAuthenticator) review(r *http.Request, token string) (*authenticationv1.TokenReviewStatus, error) {
+	sum := sha256.Sum256([]byte(token))
+	key := hex.EncodeToString(sum[:])
+
+	if status, ok := a.cached(key); ok {
+		return status, nil
+	}
+
+	review := &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{
+			Token:     token,
+			Audiences: a.audiences,
+		},
+	}
+
+	result, err := config.Clients().Kubernetes().AuthenticationV1().TokenReviews().Create(r.Context(), review, metav1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	a.cacheResult(key, &result.Status)
+
+	return &result.Status, nil
+}
+
+// serviceAccountUsername returns the user name Kubernetes assigns to a service account.
+func serviceAccountUsername(serviceAccount v1.ServiceAccountReference) string {
+	return "system:serviceaccount:" + serviceAccount.Namespace + ":" + serviceAccount.Name
+}
+
+// Authenticate checks the request has a valid bearer token, and that the token's
+// identity is allowed to use the API.
+func (a *TokenReviewAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	token, err := getAuthorization(r, "Bearer")
+	if err != nil {
+		return nil, err
+	}
+
+	status, err := a.review(r, token)
+	if err != nil {
+		log.New(log.SubsystemAuth).Infof("token review failed: %v", err)
+		return nil, fmt.Errorf("%w: token review failed: %v", ErrUnauthorized, err)
+	}
+
+	if !status.Authenticated {
+		return nil, fmt.Errorf("%w: token not authenticated: %s", ErrUnauthorized, status.Error)
+	}
+
+	identity := &Identity{
+		Subject: status.User.Username,
+		Groups:  status.User.Groups,
+	}
+
+	// With no explicit configuration, nobody is allowed, rather than everyone
+	// who happens to have a service account in the cluster.  Configuration
+	// validation should prevent this.
+	authorization := config.Config().Spec.TokenReview
+	if authorization == nil {
+		return nil, fmt.Errorf("%w: %s not allowed, no token review authorization configured", ErrUnauthorized, identity.Subject)
+	}
+
+	for _, serviceAccount := range authorization.ServiceAccounts {
+		if identity.Subject == serviceAccountUsername(serviceAccount) {
+			return identity, nil
+		}
+	}
+
+	for _, group := range identity.Groups {
+		for _, allowedGroup := range authorization.Groups {
+			if group == allowedGroup {
+				return identity, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %s not allowed", ErrUnauthorized, identity.Subject)
+}
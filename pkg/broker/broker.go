@@ -16,7 +16,6 @@ package broker
 
 import (
 	"crypto/tls"
-	"encoding/base64"
 	"errors"
 	"fmt"
 	"net/http"
@@ -28,9 +27,7 @@ import (
 	"github.com/couchbase/service-broker/pkg/client"
 	"github.com/couchbase/service-broker/pkg/config"
 	"github.com/couchbase/service-broker/pkg/log"
-
-	"github.com/golang/glog"
-	"github.com/julienschmidt/httprouter"
+	"github.com/couchbase/service-broker/pkg/operation"
 
 	"k8s.io/client-go/kubernetes/scheme"
 )
@@ -87,38 +84,6 @@ func handleReadiness(w http.ResponseWriter) error {
 	return nil
 }
 
-// handleBrokerBearerToken implements RFC-6750.
-func handleBrokerBearerToken(c *ServerConfiguration, w http.ResponseWriter, r *http.Request) error {
-	header, err := getHeaderSingle(r, "Authorization")
-	if err != nil {
-		httpResponse(w, http.StatusUnauthorized)
-		return err
-	}
-
-	if header != "Bearer "+*c.Token {
-		httpResponse(w, http.StatusUnauthorized)
-		return fmt.Errorf("%w: authorization failed", ErrUnauthorized)
-	}
-
-	return nil
-}
-
-// handleBrokerBasicAuth implements RFC-7617.
-func handleBrokerBasicAuth(c *ServerConfiguration, w http.ResponseWriter, r *http.Request) error {
-	header, err := getHeaderSingle(r, "Authorization")
-	if err != nil {
-		httpResponse(w, http.StatusUnauthorized)
-		return err
-	}
-
-	if header != "Basic "+base64.StdEncoding.EncodeToString([]byte(c.BasicAuth.Username+":"+c.BasicAuth.Password)) {
-		httpResponse(w, http.StatusUnauthorized)
-		return fmt.Errorf("%w: authorization failed", ErrUnauthorized)
-	}
-
-	return nil
-}
-
 // handleBrokerAPIHeader looks for and verifies the X-Broker-API-Version header.
 func handleBrokerAPIHeader(w http.ResponseWriter, r *http.Request) error {
 	header, err := getHeaderSingle(r, "X-Broker-API-Version")
@@ -163,31 +128,40 @@ func handleContentTypeHeader(w http.ResponseWriter, r *http.Request) error {
 }
 
 // handleRequestHeaders checks that required headers are sent and are
-// valid, and that content encodings are correct.
-func handleRequestHeaders(c *ServerConfiguration, w http.ResponseWriter, r *http.Request) error {
-	switch {
-	case c.Token != nil:
-		if err := handleBrokerBearerToken(c, w, r); err != nil {
-			return err
-		}
-	case c.BasicAuth != nil:
-		if err := handleBrokerBasicAuth(c, w, r); err != nil {
-			return err
-		}
-	default:
+// valid, and that content encodings are correct.  The returned request
+// has the authenticated client identity, and originating identity if
+// specified, attached to its context.
+func handleRequestHeaders(c *ServerConfiguration, w http.ResponseWriter, r *http.Request) (*http.Request, error) {
+	if c.Authenticator == nil {
 		httpResponse(w, http.StatusInternalServerError)
-		return ErrInternalError
+		return nil, ErrInternalError
+	}
+
+	identity, err := c.Authenticator.Authenticate(r)
+	if err != nil {
+		if c.RateLimiter != nil {
+			c.RateLimiter.AuthenticationFailed(r)
+		}
+
+		httpResponse(w, http.StatusUnauthorized)
+
+		return nil, err
 	}
 
 	if err := handleBrokerAPIHeader(w, r); err != nil {
-		return err
+		return nil, err
 	}
 
 	if err := handleContentTypeHeader(w, r); err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	r, err = handleOriginatingIdentityHeader(w, r)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.WithContext(contextWithIdentity(r.Context(), identity)), nil
 }
 
 // OpenServiceBrokerHandler wraps up a standard router but performs Open Service Broker
@@ -196,25 +170,31 @@ func handleRequestHeaders(c *ServerConfiguration, w http.ResponseWriter, r *http
 type openServiceBrokerHandler struct {
 	http.Handler
 	configuration *ServerConfiguration
+
+	// routes is used to look up the route a request matches.
+	routes *routeTable
 }
 
 // NewOpenServiceBrokerHandler initializes the main router with the Open Service Broker API.
 func NewOpenServiceBrokerHandler(configuration *ServerConfiguration) http.Handler {
-	router := httprouter.New()
-
-	router.GET("/readyz", handleReadyz)
-	router.GET("/v2/catalog", handleReadCatalog)
-	router.PUT("/v2/service_instances/:instance_id", handleCreateServiceInstance(configuration))
-	router.GET("/v2/service_instances/:instance_id", handleReadServiceInstance(configuration))
-	router.PATCH("/v2/service_instances/:instance_id", handleUpdateServiceInstance(configuration))
-	router.DELETE("/v2/service_instances/:instance_id", handleDeleteServiceInstance(configuration))
-	router.GET("/v2/service_instances/:instance_id/last_operation", handlePollServiceInstance(configuration))
-	router.PUT("/v2/service_instances/:instance_id/service_bindings/:binding_id", handleCreateServiceBinding(configuration))
-	router.DELETE("/v2/service_instances/:instance_id/service_bindings/:binding_id", handleDeleteServiceBinding(configuration))
+	routes := newRouteTable()
+
+	routes.handle(http.MethodGet, "/readyz", handleReadyz)
+	routes.handle(http.MethodGet, "/v2/catalog", handleReadCatalog)
+	routes.handle(http.MethodPut, "/v2/service_instances/:instance_id", handleCreateServiceInstance(configuration))
+	routes.handle(http.MethodGet, "/v2/service_instances/:instance_id", handleReadServiceInstance(configuration))
+	routes.handle(http.MethodPatch, "/v2/service_instances/:instance_id", handleUpdateServiceInstance(configuration))
+	routes.handle(http.MethodDelete, "/v2/service_instances/:instance_id", handleDeleteServiceInstance(configuration))
+	routes.handle(http.MethodGet, "/v2/service_instances/:instance_id/last_operation", handlePollServiceInstance(configuration))
+	routes.handle(http.MethodPut, "/v2/service_instances/:instance_id/service_bindings/:binding_id", handleCreateServiceBinding(configuration))
+	routes.handle(http.MethodGet, "/v2/service_instances/:instance_id/service_bindings/:binding_id", handleReadServiceBinding(configuration))
+	routes.handle(http.MethodDelete, "/v2/service_instances/:instance_id/service_bindings/:binding_id", handleDeleteServiceBinding(configuration))
+	routes.handle(http.MethodGet, "/v2/service_instances/:instance_id/service_bindings/:binding_id/last_operation", handlePollServiceBinding(configuration))
 
 	return &openServiceBrokerHandler{
-		Handler:       router,
+		Handler:       routes.router,
 		configuration: configuration,
+		routes:        routes,
 	}
 }
 
@@ -256,62 +236,137 @@ func (handler *openServiceBrokerHandler) ServeHTTP(w http.ResponseWriter, r *htt
 		writer: w,
 	}
 
+	// Tag the request so it can be traced through the logs, registry and resources.
+	r = handleRequestIdentityHeader(writer, r)
+
+	route := handler.routes.route(r)
+
+	r, span := startRequestSpan(r, route)
+
+	logger := log.FromContext(r.Context())
+
 	// Print out request logging information.
-	// DO NOT print out headers at info level as that will leak credentials into the log stream.
-	glog.Infof(`HTTP req: "%s %v %s" %s `, r.Method, r.URL, r.Proto, r.RemoteAddr)
+	// DO NOT print out headers at info level as that will leak credentials into the log stream,
+	// and mask credentials at debug level.
+	logger.Infof(`HTTP req: "%s %v %s" %s `, r.Method, r.URL, r.Proto, r.RemoteAddr)
 
 	for name, values := range r.Header {
 		for _, value := range values {
-			glog.V(log.LevelDebug).Infof(`HTTP hdr: "%s: %s"`, name, value)
+			logger.Debugf(`HTTP hdr: "%s: %s"`, name, log.RedactHeader(name, value))
 		}
 	}
 
 	defer func() {
-		glog.Infof(`HTTP rsp: "%d %s" %v`, writer.status, http.StatusText(writer.status), time.Since(start))
+		logger.Infof(`HTTP rsp: "%d %s" %v`, writer.status, http.StatusText(writer.status), time.Since(start))
+
+		observeRequest(route, writer.status, start)
+		endRequestSpan(span, writer.status)
 	}()
 
 	// Indicate that the service is not ready until configured.
 	if err := handleReadiness(writer); err != nil {
-		glog.V(log.LevelDebug).Info(err)
+		logger.Debugf("%v", err)
 		return
 	}
 
 	// Ignore security checks for the readiness handler
 	if r.URL.Path != "/readyz" {
+		// Reject clients that keep failing authentication before doing any work.
+		if handler.configuration.RateLimiter != nil {
+			if err := handler.configuration.RateLimiter.LimitAuthentication(r); err != nil {
+				logger.Debugf("%v", err)
+				jsonError(writer, err)
+
+				return
+			}
+		}
+
 		// Process headers, API versions, content types.
-		if err := handleRequestHeaders(handler.configuration, writer, r); err != nil {
-			glog.V(log.LevelDebug).Info(err)
+		authenticated, err := handleRequestHeaders(handler.configuration, writer, r)
+		if err != nil {
+			logger.Debugf("%v", err)
 			return
 		}
+
+		r = authenticated
+
+		// Protect the service broker from misbehaving clients.
+		if handler.configuration.RateLimiter != nil {
+			release, err := handler.configuration.RateLimiter.Limit(r)
+			if err != nil {
+				logger.Debugf("%v", err)
+				jsonError(writer, err)
+
+				return
+			}
+
+			defer release()
+		}
 	}
 
 	// Route and process the request.
 	handler.Handler.ServeHTTP(writer, r)
 }
 
-// ServerConfigurationBasicAuth defines basic authentication.
-type ServerConfigurationBasicAuth struct {
-	// Username is the user API requests will require.
-	Username string
-
-	// Password is the password for the user.
-	Password string
-}
-
 // ServerConfiguration is used to propagate server configuration to the server instance
 // and its handlers.
 type ServerConfiguration struct {
 	// Namespace is the namespace the broker is running in.
 	Namespace string
 
-	// Token is set when using bearer token authentication.
-	Token *string
-
-	// BasicAuth is set when using basic authentication.
-	BasicAuth *ServerConfigurationBasicAuth
+	// Authenticator is used to authenticate API requests.
+	Authenticator Authenticator
 
 	// Certificate is the TLS key/certificate to serve with.
 	Certificate tls.Certificate
+
+	// KeyPair is the TLS key/certificate to serve with, reloaded when it changes.
+	// If set, this takes precedence over Certificate.
+	KeyPair *KeyPair
+
+	// ListenAddress is the address to serve the API over TLS on.  If not set,
+	// DefaultListenAddress is used.
+	ListenAddress string
+
+	// PlaintextListenAddress, if set, is an address to also serve the API without
+	// TLS on, e.g. when TLS is terminated by a service mesh sidecar.
+	PlaintextListenAddress string
+
+	// TLSMinVersion is the minimum TLS version accepted.  If not set, the Go
+	// default is used.
+	TLSMinVersion uint16
+
+	// TLSCipherSuites are the cipher suites accepted for TLS 1.2 and below.  If not
+	// set, the Go defaults are used.
+	TLSCipherSuites []uint16
+
+	// ReadTimeout, WriteTimeout and IdleTimeout are the HTTP server timeouts.
+	// A timeout of zero means requests will not time out.
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
+
+	// OpsListenAddress, if set, is an address to serve the read-only operations
+	// API over TLS on.
+	OpsListenAddress string
+
+	// OpsAuthenticator is used to authenticate operations API requests.
+	OpsAuthenticator Authenticator
+
+	// Scheduler runs asynchronous operations.  If not set, a scheduler with the
+	// default settings is created when the server is configured.
+	Scheduler *operation.Scheduler
+
+	// OrphanMitigationPeriod is how often to look for orphaned service instances.
+	OrphanMitigationPeriod time.Duration
+
+	// OrphanMitigationGracePeriod is how long to give the client to clean up a
+	// failed or abandoned service instance before the service broker does.
+	OrphanMitigationGracePeriod time.Duration
+
+	// RateLimiter limits the rate and concurrency of API requests.  If not set,
+	// requests are not limited.
+	RateLimiter *RateLimiter
 }
 
 // ConfigureServer is the main entry point for both the container and test.
@@ -326,20 +381,86 @@ func ConfigureServer(clients client.Clients, configuration *ServerConfiguration)
 		return err
 	}
 
+	if configuration.Scheduler == nil {
+		configuration.Scheduler = operation.NewScheduler(operation.DefaultWorkers, operation.DefaultQueueSize)
+	}
+
 	return nil
 }
 
+// RunServer serves the API, over TLS and optionally plaintext, and the operations
+// API if configured, until a listener fails.
 func RunServer(configuration *ServerConfiguration) error {
+	address := configuration.ListenAddress
+	if address == "" {
+		address = DefaultListenAddress
+	}
+
+	handler := NewOpenServiceBrokerHandler(configuration)
+
 	// Start the server.
-	server := &http.Server{
-		Addr:    ":8443",
-		Handler: NewOpenServiceBrokerHandler(configuration),
-		TLSConfig: &tls.Config{
-			Certificates: []tls.Certificate{
-				configuration.Certificate,
-			},
-		},
+	server := configuration.newHTTPServer(address, handler)
+	server.TLSConfig = configuration.newTLSConfig(configuration.Authenticator)
+
+	errs := make(chan error, 3)
+
+	go func() {
+		errs <- server.ListenAndServeTLS("", "")
+	}()
+
+	if configuration.PlaintextListenAddress != "" {
+		plaintextServer := configuration.newHTTPServer(configuration.PlaintextListenAddress, handler)
+
+		go func() {
+			errs <- plaintextServer.ListenAndServe()
+		}()
+	}
+
+	if configuration.OpsListenAddress != "" {
+		opsServer := configuration.newHTTPServer(configuration.OpsListenAddress, NewOpsHandler(configuration))
+		opsServer.TLSConfig = configuration.newTLSConfig(configuration.OpsAuthenticator)
+
+		go func() {
+			errs <- opsServer.ListenAndServeTLS("", "")
+		}()
+	}
+
+	return <-errs
+}
+
+// newTLSConfig returns a TLS configuration for a server whose requests are
+// authenticated by the authenticator.
+func (c *ServerConfiguration) newTLSConfig(authenticator Authenticator) *tls.Config {
+	tlsConfig := &tls.Config{
+		GetCertificate: c.getCertificate,
+		MinVersion:     c.TLSMinVersion,
+		CipherSuites:   c.TLSCipherSuites,
+	}
+
+	if authenticator, ok := authenticator.(TLSAuthenticator); ok {
+		authenticator.ConfigureTLS(tlsConfig)
+	}
+
+	return tlsConfig
+}
+
+// newHTTPServer returns a HTTP server with the configured timeouts.
+func (c *ServerConfiguration) newHTTPServer(address string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:         address,
+		Handler:      handler,
+		ReadTimeout:  c.ReadTimeout,
+		WriteTimeout: c.WriteTimeout,
+		IdleTimeout:  c.IdleTimeout,
+	}
+}
+
+// getCertificate returns the TLS key/certificate to serve with.  This is looked up
+// on every handshake so that the key pair can be reloaded.
+func (c *ServerConfiguration) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if c.KeyPair != nil {
+		return c.KeyPair.GetCertificate(hello)
 	}
 
-	return server.ListenAndServeTLS("", "")
+	return &c.Certificate, nil
 }
@@ -17,4 +17,8 @@ package broker
 const (
 	// minBrokerAPIVersion is the minimum supported version of the broker API
 	minBrokerAPIVersion = 2.13
+
+	// retryAfterSeconds is how long a client should wait before retrying a request
+	// when the service broker is busy.
+	retryAfterSeconds = 5
 )
@@ -23,11 +23,11 @@ import (
 	"github.com/couchbase/service-broker/pkg/api"
 	"github.com/couchbase/service-broker/pkg/config"
 	"github.com/couchbase/service-broker/pkg/errors"
+	"github.com/couchbase/service-broker/pkg/log"
 	"github.com/couchbase/service-broker/pkg/operation"
 	"github.com/couchbase/service-broker/pkg/provisioners"
 	"github.com/couchbase/service-broker/pkg/registry"
 
-	"github.com/golang/glog"
 	"github.com/julienschmidt/httprouter"
 
 	"k8s.io/apimachinery/pkg/runtime"
@@ -43,9 +43,11 @@ func handleReadyz(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 }
 
 // handleReadCatalog advertises the classes of service we offer, and specifc plans to
-// implement those classes.
+// implement those classes.  Only plans available to the client are advertised.
 func handleReadCatalog(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	JSONResponse(w, http.StatusOK, config.Config().Spec.Catalog.Convert())
+	access := newCatalogAccessRequest(r)
+
+	JSONResponse(w, http.StatusOK, filterCatalog(config.Config(), access, config.Config().Spec.ConvertCatalog()))
 }
 
 // handleCreateServiceInstance creates a service instance of a plan.
@@ -76,6 +78,22 @@ func handleCreateServiceInstance(configuration *ServerConfiguration) func(http.R
 			return
 		}
 
+		access, err := newAccessRequest(r, request.Context)
+		if err != nil {
+			jsonError(w, err)
+			return
+		}
+
+		if err := authorizeServicePlan(config.Config(), access, request.ServiceID, request.PlanID); err != nil {
+			jsonError(w, err)
+			return
+		}
+
+		if err := validateMaintenanceInfo(config.Config(), request.ServiceID, request.PlanID, request.MaintenanceInfo); err != nil {
+			jsonError(w, err)
+			return
+		}
+
 		if err := validateParameters(config.Config(), request.ServiceID, request.PlanID, schemaTypeServiceInstance, schemaOperationCreate, request.Parameters); err != nil {
 			jsonError(w, err)
 			return
@@ -271,12 +289,47 @@ func handleCreateServiceInstance(configuration *ServerConfiguration) func(http.R
 			return
 		}
 
+		if err := setRequestIdentity(r, entry); err != nil {
+			jsonError(w, err)
+			return
+		}
+
+		if err := setOriginatingIdentity(r, entry); err != nil {
+			jsonError(w, err)
+			return
+		}
+
+		// Record the plan version the instance was created with, so we can detect
+		// upgrades later on.
+		maintenanceInfoVersion, ok, err := getMaintenanceInfoVersion(config.Config(), request.ServiceID, request.PlanID)
+		if err != nil {
+			jsonError(w, err)
+			return
+		}
+
+		if ok {
+			if err := entry.Set(registry.MaintenanceInfoVersion, maintenanceInfoVersion); err != nil {
+				jsonError(w, err)
+				return
+			}
+		}
+
+		// Reserve space for the operation before committing anything, so the
+		// request can be cleanly rejected if the service broker is busy.
+		reservation, err := configuration.Scheduler.Reserve()
+		if err != nil {
+			jsonError(w, err)
+			return
+		}
+
+		defer reservation.Release()
+
 		if err := entry.Commit(); err != nil {
 			jsonError(w, err)
 			return
 		}
 
-		glog.Infof("provisioning new service instance: %s", instanceID)
+		log.FromContext(r.Context()).Infof("provisioning new service instance: %s", instanceID)
 
 		// Create a provisioning engine, and perform synchronous tasks.  This also derives
 		// things like the dashboard URL for the synchronous response.
@@ -298,7 +351,7 @@ func handleCreateServiceInstance(configuration *ServerConfiguration) func(http.R
 
 		frozenEntry := entry.Clone()
 
-		go provisioner.Run(entry)
+		reservation.Submit(instanceID, func() { provisioner.Run(entry) })
 
 		operationID, ok, err := frozenEntry.GetString(registry.OperationID)
 		if err != nil {
@@ -431,6 +484,19 @@ func handleReadServiceInstance(configuration *ServerConfiguration) func(http.Res
 			PlanID:     serviceInstancePlanID,
 			Parameters: parameters,
 		}
+
+		maintenanceInfoVersion, ok, err := entry.GetString(registry.MaintenanceInfoVersion)
+		if err != nil {
+			jsonError(w, err)
+			return
+		}
+
+		if ok {
+			response.MaintenanceInfo = &api.MaintenanceInfo{
+				Version: maintenanceInfoVersion,
+			}
+		}
+
 		JSONResponse(w, http.StatusOK, response)
 	}
 }
@@ -502,17 +568,74 @@ func handleUpdateServiceInstance(configuration *ServerConfiguration) func(http.R
 			return
 		}
 
-		if err := validateParameters(config.Config(), request.ServiceID, planID, schemaTypeServiceInstance, schemaOperationUpdate, request.Parameters); err != nil {
+		if err := registryUnchanged(config.Config(), request.ServiceID, planID, newPlanID); err != nil {
+			jsonError(w, err)
+			return
+		}
+
+		// The platform context is optional for updates, so fall back to the one
+		// the service instance was created with.
+		accessContext := request.Context
+		if accessContext == nil {
+			accessContext = &runtime.RawExtension{}
+
+			if _, err := entry.Get(registry.Context, accessContext); err != nil {
+				jsonError(w, err)
+				return
+			}
+		}
+
+		access, err := newAccessRequest(r, accessContext)
+		if err != nil {
+			jsonError(w, err)
+			return
+		}
+
+		if err := authorizeServicePlan(config.Config(), access, request.ServiceID, newPlanID); err != nil {
+			jsonError(w, err)
+			return
+		}
+
+		if err := validateMaintenanceInfo(config.Config(), request.ServiceID, newPlanID, request.MaintenanceInfo); err != nil {
 			jsonErrorUsable(w, err)
 			return
 		}
 
-		parameters := &runtime.RawExtension{}
-		if request.Parameters != nil {
-			parameters = request.Parameters
+		// Parameters are validated against the plan being updated to.
+		if err := validateParameters(config.Config(), request.ServiceID, newPlanID, schemaTypeServiceInstance, schemaOperationUpdate, request.Parameters); err != nil {
+			jsonErrorUsable(w, err)
+			return
 		}
 
-		if err := entry.Set(registry.Parameters, parameters); err != nil {
+		maintenanceInfoVersion, _, err := entry.GetString(registry.MaintenanceInfoVersion)
+		if err != nil {
+			jsonError(w, err)
+			return
+		}
+
+		// An upgrade is where only the maintenance info version changes.  In this case
+		// the existing parameters are retained and the templates are simply re-rendered
+		// against the new version of the plan.
+		upgrade := request.MaintenanceInfo != nil && request.MaintenanceInfo.Version != maintenanceInfoVersion && newPlanID == planID && request.Parameters == nil
+
+		if !upgrade {
+			parameters := &runtime.RawExtension{}
+			if request.Parameters != nil {
+				parameters = request.Parameters
+			}
+
+			if err := entry.Set(registry.Parameters, parameters); err != nil {
+				jsonError(w, err)
+				return
+			}
+		}
+
+		if err := setRequestIdentity(r, entry); err != nil {
+			jsonError(w, err)
+			return
+		}
+
+		if err := setOriginatingIdentity(r, entry); err != nil {
 			jsonError(w, err)
 			return
 		}
@@ -523,11 +646,21 @@ func handleUpdateServiceInstance(configuration *ServerConfiguration) func(http.R
 			return
 		}
 
-		if err := updater.Prepare(entry); err != nil {
+		if err := updater.Prepare(r.Context(), entry); err != nil {
 			jsonErrorUsable(w, err)
 			return
 		}
 
+		// Reserve space for the operation before committing anything, so the
+		// request can be cleanly rejected if the service broker is busy.
+		reservation, err := configuration.Scheduler.Reserve()
+		if err != nil {
+			jsonError(w, err)
+			return
+		}
+
+		defer reservation.Release()
+
 		if err := operation.Start(entry, operation.TypeUpdate); err != nil {
 			jsonError(w, err)
 			return
@@ -535,7 +668,7 @@ func handleUpdateServiceInstance(configuration *ServerConfiguration) func(http.R
 
 		frozenEntry := entry.Clone()
 
-		go updater.Run(entry)
+		reservation.Submit(instanceID, func() { updater.Run(entry) })
 
 		operationID, ok, err := frozenEntry.GetString(registry.OperationID)
 		if err != nil {
@@ -574,16 +707,17 @@ func handleDeleteServiceInstance(configuration *ServerConfiguration) func(http.R
 
 		dirent := getDirectoryInstance(configuration.Namespace, instanceID)
 
-		// Probably the wrong place for this...
-		deleteDirectoryInstance(configuration.Namespace, instanceID)
-
 		entry, err := registry.New(registry.ServiceInstance, dirent.Namespace, instanceID, false)
 		if err != nil {
 			jsonError(w, err)
 			return
 		}
 
+		// The directory entry should have been deleted when the service instance was,
+		// but clean up anyway.
 		if !entry.Exists() {
+			deleteDirectoryInstance(configuration.Namespace, instanceID)
+
 			jsonError(w, errors.NewResourceGoneError("service instance does not exist"))
 			return
 		}
@@ -632,7 +766,49 @@ func handleDeleteServiceInstance(configuration *ServerConfiguration) func(http.R
 			return
 		}
 
-		deleter := provisioners.NewDeleter()
+		// If a deprovision is already in progress, then return the existing operation,
+		// any other operation is a conflict.
+		operationID, ok, err := getDeprovisionOperation(entry)
+		if err != nil {
+			jsonError(w, err)
+			return
+		}
+
+		if ok {
+			response := &api.CreateServiceInstanceResponse{
+				Operation: operationID,
+			}
+			JSONResponse(w, http.StatusAccepted, response)
+
+			return
+		}
+
+		if err := setRequestIdentity(r, entry); err != nil {
+			jsonError(w, err)
+			return
+		}
+
+		if err := setOriginatingIdentity(r, entry); err != nil {
+			jsonError(w, err)
+			return
+		}
+
+		deleter := provisioners.NewDeleter(provisioners.ResourceTypeServiceInstance)
+
+		if err := deleter.Prepare(entry); err != nil {
+			jsonError(w, err)
+			return
+		}
+
+		// Reserve space for the operation before committing anything, so the
+		// request can be cleanly rejected if the service broker is busy.
+		reservation, err := configuration.Scheduler.Reserve()
+		if err != nil {
+			jsonError(w, err)
+			return
+		}
+
+		defer reservation.Release()
 
 		// Start the delete operation in the background.
 		if err := operation.Start(entry, operation.TypeDeprovision); err != nil {
@@ -640,9 +816,11 @@ func handleDeleteServiceInstance(configuration *ServerConfiguration) func(http.R
 			return
 		}
 
-		go deleter.Run(entry)
+		frozenEntry := entry.Clone()
+
+		reservation.Submit(instanceID, func() { deleter.Run(entry) })
 
-		operationID, ok, err := entry.GetString(registry.OperationID)
+		operationID, ok, err = frozenEntry.GetString(registry.OperationID)
 		if err != nil {
 			jsonError(w, err)
 			return
@@ -723,6 +901,14 @@ func handlePollServiceInstance(configuration *ServerConfiguration) func(http.Res
 			jsonError(w, fmt.Errorf("%w: service instance missing operation ID", ErrUnexpected))
 		}
 
+		// During a plan change the client polls with the plan it was migrating
+		// from, which may no longer be recorded as the service instance's plan.
+		migratingPlanID, _, err := entry.GetString(registry.MigratingPlanID)
+		if err != nil {
+			jsonError(w, err)
+			return
+		}
+
 		instanceOperationID, ok, err := entry.GetString(registry.OperationID)
 		if err != nil {
 			jsonError(w, err)
@@ -742,7 +928,7 @@ func handlePollServiceInstance(configuration *ServerConfiguration) func(http.Res
 
 		// While not specified, we check that the provided plan ID matches the one
 		// we expect.  It may be indicative of a client error.
-		if planIDProvided && planID != instancePlanID {
+		if planIDProvided && planID != instancePlanID && planID != migratingPlanID {
 			jsonError(w, errors.NewQueryError("provided plan ID %s does not match %s", planID, instancePlanID))
 			return
 		}
@@ -785,6 +971,47 @@ func handlePollServiceInstance(configuration *ServerConfiguration) func(http.Res
 			return
 		}
 
+		// The operation has completed, but the service instance may not be healthy yet,
+		// so keep reporting the operation as in progress until it is.
+		if err := provisioners.Ready(r.Context(), provisioners.ResourceTypeServiceInstance, entry, instanceServiceID, instancePlanID); err != nil {
+			if !provisioners.IsConditionUnreadyError(err) {
+				jsonError(w, err)
+				return
+			}
+
+			response := &api.PollServiceInstanceResponse{
+				State:       api.PollStateInProgress,
+				Description: err.Error(),
+			}
+			JSONResponse(w, http.StatusOK, response)
+
+			return
+		}
+
+		// Deprovisioning has completed, so clean up the registry, subsequent polls
+		// will report the service instance as gone.
+		op, _, err := entry.GetString(registry.Operation)
+		if err != nil {
+			jsonError(w, err)
+			return
+		}
+
+		if operation.Type(op) == operation.TypeDeprovision {
+			if err := entry.Delete(); err != nil {
+				jsonError(w, err)
+				return
+			}
+
+			deleteDirectoryInstance(configuration.Namespace, instanceID)
+
+			response := &api.PollServiceInstanceResponse{
+				State: api.PollStateSucceeded,
+			}
+			JSONResponse(w, http.StatusOK, response)
+
+			return
+		}
+
 		// All checks have passed, instance successfully provisioned.
 		if err := operation.End(entry); err != nil {
 			jsonError(w, err)
@@ -821,6 +1048,12 @@ func handleCreateServiceBinding(configuration *ServerConfiguration) func(http.Re
 			return
 		}
 
+		async, err := asyncAccepted(r)
+		if err != nil {
+			jsonError(w, err)
+			return
+		}
+
 		if err := validateServicePlan(config.Config(), request.ServiceID, request.PlanID); err != nil {
 			jsonError(w, err)
 			return
@@ -850,6 +1083,29 @@ func handleCreateServiceBinding(configuration *ServerConfiguration) func(http.Re
 			return
 		}
 
+		// The platform context is optional for bindings, so fall back to the one
+		// the service instance was created with.
+		accessContext := request.Context
+		if accessContext == nil {
+			accessContext = &runtime.RawExtension{}
+
+			if _, err := instanceEntry.Get(registry.Context, accessContext); err != nil {
+				jsonError(w, err)
+				return
+			}
+		}
+
+		access, err := newAccessRequest(r, accessContext)
+		if err != nil {
+			jsonError(w, err)
+			return
+		}
+
+		if err := authorizeServicePlan(config.Config(), access, request.ServiceID, request.PlanID); err != nil {
+			jsonError(w, err)
+			return
+		}
+
 		// Check if the binding already exists.
 		entry, err := registry.New(registry.ServiceBinding, dirent.Namespace, bindingID, false)
 		if err != nil {
@@ -969,6 +1225,7 @@ func handleCreateServiceBinding(configuration *ServerConfiguration) func(http.Re
 					return
 				}
 
+				status = http.StatusAccepted
 				response.Operation = operationID
 			}
 
@@ -982,6 +1239,10 @@ func handleCreateServiceBinding(configuration *ServerConfiguration) func(http.Re
 		// are overridden buy those related to the binding.
 		entry.Inherit(instanceEntry)
 
+		// Resources are tracked per registry entry, the binding owns none of those
+		// created for the service instance.
+		entry.Unset(registry.Resources)
+
 		context := &runtime.RawExtension{}
 		if request.Context != nil {
 			context = request.Context
@@ -1007,12 +1268,32 @@ func handleCreateServiceBinding(configuration *ServerConfiguration) func(http.Re
 			return
 		}
 
+		if err := setRequestIdentity(r, entry); err != nil {
+			jsonError(w, err)
+			return
+		}
+
+		if err := setOriginatingIdentity(r, entry); err != nil {
+			jsonError(w, err)
+			return
+		}
+
+		// Schedule the operation before committing anything, so the request can
+		// be cleanly rejected if the service broker or service instance is busy.
+		reservation, done, err := scheduleBindingOperation(configuration, instanceID, async)
+		if err != nil {
+			jsonError(w, err)
+			return
+		}
+
+		defer done()
+
 		if err := entry.Commit(); err != nil {
 			jsonError(w, err)
 			return
 		}
 
-		glog.Infof("provisioning new service binding: %s", bindingID)
+		log.FromContext(r.Context()).Infof("provisioning new service binding: %s", bindingID)
 
 		// Create a provisioning engine, and perform synchronous tasks.  This also derives
 		// things like the dashboard URL for the synchronous response.
@@ -1034,6 +1315,30 @@ func handleCreateServiceBinding(configuration *ServerConfiguration) func(http.Re
 
 		frozenEntry := entry.Clone()
 
+		// If the client supports asynchronous operation, then run the provisioner
+		// in the background and let the client poll for completion.
+		if async {
+			reservation.Submit(instanceID, func() { provisioner.Run(entry) })
+
+			operationID, ok, err := frozenEntry.GetString(registry.OperationID)
+			if err != nil {
+				jsonError(w, err)
+				return
+			}
+
+			if !ok {
+				jsonError(w, fmt.Errorf("%w: service binding missing operation ID", ErrUnexpected))
+				return
+			}
+
+			response := &api.CreateServiceBindingResponse{
+				Operation: operationID,
+			}
+			JSONResponse(w, http.StatusAccepted, response)
+
+			return
+		}
+
 		provisioner.Run(entry)
 
 		operationStatus, ok, err := entry.GetString(registry.OperationStatus)
@@ -1073,8 +1378,8 @@ func handleCreateServiceBinding(configuration *ServerConfiguration) func(http.Re
 	}
 }
 
-// handleDeleteServiceBinding deletes a service binding.
-func handleDeleteServiceBinding(configuration *ServerConfiguration) func(http.ResponseWriter, *http.Request, httprouter.Params) {
+// handleReadServiceBinding allows a service binding to be read.
+func handleReadServiceBinding(configuration *ServerConfiguration) func(http.ResponseWriter, *http.Request, httprouter.Params) {
 	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
 		instanceID := params.ByName("instance_id")
 		if instanceID == "" {
@@ -1082,50 +1387,60 @@ func handleDeleteServiceBinding(configuration *ServerConfiguration) func(http.Re
 			return
 		}
 
+		bindingID := params.ByName("binding_id")
+		if bindingID == "" {
+			jsonError(w, fmt.Errorf("%w: request missing binding_id parameter", ErrUnexpected))
+			return
+		}
+
 		dirent := getDirectoryInstance(configuration.Namespace, instanceID)
 
-		instanceEntry, err := registry.New(registry.ServiceInstance, dirent.Namespace, instanceID, true)
+		// Check if the binding exists.
+		entry, err := registry.New(registry.ServiceBinding, dirent.Namespace, bindingID, true)
 		if err != nil {
 			jsonError(w, err)
 			return
 		}
 
-		if !instanceEntry.Exists() {
-			jsonError(w, errors.NewParameterError("service instance %s not found", instanceID))
+		// Not found, return a 404
+		if !entry.Exists() {
+			jsonError(w, errors.NewResourceNotFoundError("service binding does not exist"))
 			return
 		}
 
-		// Check parameters.
-		bindingID := params.ByName("binding_id")
-		if bindingID == "" {
-			jsonError(w, fmt.Errorf("%w: request missing binding_id parameter", ErrUnexpected))
+		// The binding inherits the instance ID from its service instance, use this
+		// to check the binding actually belongs to the requested instance.
+		bindingInstanceID, ok, err := entry.GetString(registry.InstanceID)
+		if err != nil {
+			jsonError(w, err)
 			return
 		}
 
-		entry, err := registry.New(registry.ServiceBinding, dirent.Namespace, bindingID, false)
-		if err != nil {
-			jsonError(w, err)
+		if !ok {
+			jsonError(w, fmt.Errorf("%w: unable to lookup existing instance ID", ErrUnexpected))
 			return
 		}
 
-		if !entry.Exists() {
-			jsonError(w, errors.NewResourceGoneError("service instance does not exist"))
+		if bindingInstanceID != instanceID {
+			jsonError(w, errors.NewResourceNotFoundError("service binding does not exist for service instance %s", instanceID))
 			return
 		}
 
-		serviceID, err := getSingleParameter(r, "service_id")
+		// service_id is optional and provoded as a hint.
+		serviceID, serviceIDProvided, err := maygetSingleParameter(r, "service_id")
 		if err != nil {
 			jsonError(w, err)
 			return
 		}
 
-		planID, err := getSingleParameter(r, "plan_id")
+		// plan_id is optional and provoded as a hint.
+		planID, planIDProvided, err := maygetSingleParameter(r, "plan_id")
 		if err != nil {
 			jsonError(w, err)
 			return
 		}
 
-		serviceInstanceServiceID, ok, err := entry.GetString(registry.ServiceID)
+		serviceBindingServiceID, ok, err := entry.GetString(registry.ServiceID)
 		if err != nil {
 			jsonError(w, err)
 			return
@@ -1136,7 +1451,7 @@ func handleDeleteServiceBinding(configuration *ServerConfiguration) func(http.Re
 			return
 		}
 
-		serviceInstancePlanID, ok, err := entry.GetString(registry.PlanID)
+		serviceBindingPlanID, ok, err := entry.GetString(registry.PlanID)
 		if err != nil {
 			jsonError(w, err)
 			return
@@ -1147,21 +1462,453 @@ func handleDeleteServiceBinding(configuration *ServerConfiguration) func(http.Re
 			return
 		}
 
-		if serviceID != serviceInstanceServiceID {
-			jsonError(w, errors.NewQueryError("specified service ID %s does not match %s", serviceID, serviceInstanceServiceID))
+		if serviceIDProvided && serviceID != serviceBindingServiceID {
+			jsonError(w, errors.NewQueryError("specified service ID %s does not match %s", serviceID, serviceBindingServiceID))
 			return
 		}
 
-		if planID != serviceInstancePlanID {
-			jsonError(w, errors.NewQueryError("specified plan ID %s does not match %s", planID, serviceInstancePlanID))
+		if planIDProvided && planID != serviceBindingPlanID {
+			jsonError(w, errors.NewQueryError("specified plan ID %s does not match %s", planID, serviceBindingPlanID))
 			return
 		}
 
-		deleter := provisioners.NewDeleter()
-
-		deleter.Run(entry)
-
-		response := &api.DeleteServiceBindingResponse{}
+		// The specification mandates a 404 if the binding is still being created.
+		op, ok, err := entry.GetString(registry.Operation)
+		if err != nil {
+			jsonError(w, err)
+			return
+		}
+
+		if ok && operation.Type(op) == operation.TypeProvision {
+			jsonError(w, errors.NewResourceNotFoundError("service binding %s operation in progress", op))
+			return
+		}
+
+		parameters := &runtime.RawExtension{}
+
+		ok, err = entry.Get(registry.Parameters, parameters)
+		if err != nil {
+			jsonError(w, err)
+			return
+		}
+
+		if !ok {
+			jsonError(w, fmt.Errorf("%w: unable to lookup existing parameters", ErrUnexpected))
+			return
+		}
+
+		response := &api.GetServiceBindingResponse{
+			Metadata:   &api.BindingMetadata{},
+			Parameters: parameters,
+		}
+
+		credentials := &runtime.RawExtension{}
+
+		ok, err = entry.Get(registry.Credentials, credentials)
+		if err != nil {
+			jsonError(w, err)
+			return
+		}
+
+		if ok {
+			response.Credentials = credentials
+		}
+
+		JSONResponse(w, http.StatusOK, response)
+	}
+}
+
+// handleDeleteServiceBinding deletes a service binding.
+func handleDeleteServiceBinding(configuration *ServerConfiguration) func(http.ResponseWriter, *http.Request, httprouter.Params) {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		instanceID := params.ByName("instance_id")
+		if instanceID == "" {
+			jsonError(w, fmt.Errorf("%w: request missing instance_id parameter", ErrUnexpected))
+			return
+		}
+
+		dirent := getDirectoryInstance(configuration.Namespace, instanceID)
+
+		instanceEntry, err := registry.New(registry.ServiceInstance, dirent.Namespace, instanceID, true)
+		if err != nil {
+			jsonError(w, err)
+			return
+		}
+
+		if !instanceEntry.Exists() {
+			jsonError(w, errors.NewParameterError("service instance %s not found", instanceID))
+			return
+		}
+
+		// Check parameters.
+		bindingID := params.ByName("binding_id")
+		if bindingID == "" {
+			jsonError(w, fmt.Errorf("%w: request missing binding_id parameter", ErrUnexpected))
+			return
+		}
+
+		entry, err := registry.New(registry.ServiceBinding, dirent.Namespace, bindingID, false)
+		if err != nil {
+			jsonError(w, err)
+			return
+		}
+
+		if !entry.Exists() {
+			jsonError(w, errors.NewResourceGoneError("service instance does not exist"))
+			return
+		}
+
+		serviceID, err := getSingleParameter(r, "service_id")
+		if err != nil {
+			jsonError(w, err)
+			return
+		}
+
+		planID, err := getSingleParameter(r, "plan_id")
+		if err != nil {
+			jsonError(w, err)
+			return
+		}
+
+		serviceInstanceServiceID, ok, err := entry.GetString(registry.ServiceID)
+		if err != nil {
+			jsonError(w, err)
+			return
+		}
+
+		if !ok {
+			jsonError(w, fmt.Errorf("%w: unable to lookup existing service ID", ErrUnexpected))
+			return
+		}
+
+		serviceInstancePlanID, ok, err := entry.GetString(registry.PlanID)
+		if err != nil {
+			jsonError(w, err)
+			return
+		}
+
+		if !ok {
+			jsonError(w, fmt.Errorf("%w: unable to lookup existing plan ID", ErrUnexpected))
+			return
+		}
+
+		if serviceID != serviceInstanceServiceID {
+			jsonError(w, errors.NewQueryError("specified service ID %s does not match %s", serviceID, serviceInstanceServiceID))
+			return
+		}
+
+		if planID != serviceInstancePlanID {
+			jsonError(w, errors.NewQueryError("specified plan ID %s does not match %s", planID, serviceInstancePlanID))
+			return
+		}
+
+		async, err := asyncAccepted(r)
+		if err != nil {
+			jsonError(w, err)
+			return
+		}
+
+		// If a deprovision is already in progress, then return the existing operation,
+		// any other operation is a conflict.
+		operationID, ok, err := getDeprovisionOperation(entry)
+		if err != nil {
+			jsonError(w, err)
+			return
+		}
+
+		if ok {
+			response := &api.DeleteServiceBindingResponse{
+				Operation: operationID,
+			}
+			JSONResponse(w, http.StatusAccepted, response)
+
+			return
+		}
+
+		if err := setRequestIdentity(r, entry); err != nil {
+			jsonError(w, err)
+			return
+		}
+
+		if err := setOriginatingIdentity(r, entry); err != nil {
+			jsonError(w, err)
+			return
+		}
+
+		deleter := provisioners.NewDeleter(provisioners.ResourceTypeServiceBinding)
+
+		if err := deleter.Prepare(entry); err != nil {
+			jsonError(w, err)
+			return
+		}
+
+		// Schedule the operation before committing anything, so the request can
+		// be cleanly rejected if the service broker or service instance is busy.
+		reservation, done, err := scheduleBindingOperation(configuration, instanceID, async)
+		if err != nil {
+			jsonError(w, err)
+			return
+		}
+
+		defer done()
+
+		// Start the delete operation.
+		if err := operation.Start(entry, operation.TypeDeprovision); err != nil {
+			jsonError(w, err)
+			return
+		}
+
+		if !async {
+			deleter.Run(entry)
+
+			operationStatus, _, err := entry.GetString(registry.OperationStatus)
+			if err != nil {
+				jsonError(w, err)
+				return
+			}
+
+			if operationStatus != "" {
+				if err := operation.End(entry); err != nil {
+					jsonError(w, err)
+					return
+				}
+
+				jsonError(w, fmt.Errorf("%w: %s", ErrUnexpected, operationStatus))
+
+				return
+			}
+
+			if err := entry.Delete(); err != nil {
+				jsonError(w, err)
+				return
+			}
+
+			response := &api.DeleteServiceBindingResponse{}
+			JSONResponse(w, http.StatusOK, response)
+
+			return
+		}
+
+		operationID, ok, err = entry.GetString(registry.OperationID)
+		if err != nil {
+			jsonError(w, err)
+			return
+		}
+
+		if !ok {
+			jsonError(w, fmt.Errorf("%w: service binding missing operation ID", ErrUnexpected))
+			return
+		}
+
+		reservation.Submit(instanceID, func() { deleter.Run(entry) })
+
+		response := &api.DeleteServiceBindingResponse{
+			Operation: operationID,
+		}
+		JSONResponse(w, http.StatusAccepted, response)
+	}
+}
+
+// scheduleBindingOperation reserves space for an asynchronous service binding operation,
+// which is run after any others on the service instance.  Synchronous operations are run
+// by the request handler, so must not wait for others, and are rejected if any are queued
+// or running on the service instance.  The returned function must be called once the
+// request has been handled.
+func scheduleBindingOperation(configuration *ServerConfiguration, instanceID string, async bool) (*operation.Reservation, func(), error) {
+	if !async {
+		if !configuration.Scheduler.TryLock(instanceID) {
+			return nil, nil, errors.NewConcurrencyError("service instance %s has an operation in progress", instanceID)
+		}
+
+		return nil, func() { configuration.Scheduler.Unlock(instanceID) }, nil
+	}
+
+	reservation, err := configuration.Scheduler.Reserve()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return reservation, reservation.Release, nil
+}
+
+// handlePollServiceBinding polls a service binding operation for status.
+func handlePollServiceBinding(configuration *ServerConfiguration) func(http.ResponseWriter, *http.Request, httprouter.Params) {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		instanceID := params.ByName("instance_id")
+		if instanceID == "" {
+			jsonError(w, fmt.Errorf("%w: request missing instance_id parameter", ErrUnexpected))
+			return
+		}
+
+		bindingID := params.ByName("binding_id")
+		if bindingID == "" {
+			jsonError(w, fmt.Errorf("%w: request missing binding_id parameter", ErrUnexpected))
+			return
+		}
+
+		dirent := getDirectoryInstance(configuration.Namespace, instanceID)
+
+		entry, err := registry.New(registry.ServiceBinding, dirent.Namespace, bindingID, false)
+		if err != nil {
+			jsonError(w, err)
+			return
+		}
+
+		// A missing binding indicates a deprovision operation has completed.
+		if !entry.Exists() {
+			JSONResponse(w, http.StatusGone, struct{}{})
+			return
+		}
+
+		bindingInstanceID, ok, err := entry.GetString(registry.InstanceID)
+		if err != nil {
+			jsonError(w, err)
+			return
+		}
+
+		if !ok {
+			jsonError(w, fmt.Errorf("%w: unable to lookup existing instance ID", ErrUnexpected))
+			return
+		}
+
+		if bindingInstanceID != instanceID {
+			jsonError(w, errors.NewResourceNotFoundError("service binding does not exist for service instance %s", instanceID))
+			return
+		}
+
+		// service_id is optional and provoded as a hint.
+		serviceID, serviceIDProvided, err := maygetSingleParameter(r, "service_id")
+		if err != nil {
+			jsonError(w, err)
+			return
+		}
+
+		// plan_id is optional and provided as a hint.
+		planID, planIDProvided, err := maygetSingleParameter(r, "plan_id")
+		if err != nil {
+			jsonError(w, err)
+			return
+		}
+
+		// operation is optional, and when provided must match the operation in progress.
+		operationID, operationIDProvided, err := maygetSingleParameter(r, "operation")
+		if err != nil {
+			jsonError(w, err)
+			return
+		}
+
+		bindingServiceID, ok, err := entry.GetString(registry.ServiceID)
+		if err != nil {
+			jsonError(w, err)
+			return
+		}
+
+		if !ok {
+			jsonError(w, fmt.Errorf("%w: unable to lookup existing service ID", ErrUnexpected))
+			return
+		}
+
+		bindingPlanID, ok, err := entry.GetString(registry.PlanID)
+		if err != nil {
+			jsonError(w, err)
+			return
+		}
+
+		if !ok {
+			jsonError(w, fmt.Errorf("%w: unable to lookup existing plan ID", ErrUnexpected))
+			return
+		}
+
+		if serviceIDProvided && serviceID != bindingServiceID {
+			jsonError(w, errors.NewQueryError("provided service ID %s does not match %s", serviceID, bindingServiceID))
+			return
+		}
+
+		if planIDProvided && planID != bindingPlanID {
+			jsonError(w, errors.NewQueryError("provided plan ID %s does not match %s", planID, bindingPlanID))
+			return
+		}
+
+		bindingOperationID, ok, err := entry.GetString(registry.OperationID)
+		if err != nil {
+			jsonError(w, err)
+			return
+		}
+
+		if !ok {
+			jsonError(w, errors.NewQueryError("no operation in progress for service binding %s", bindingID))
+			return
+		}
+
+		if operationIDProvided && operationID != bindingOperationID {
+			jsonError(w, errors.NewQueryError("provided operation %s does not match operation %s", operationID, bindingOperationID))
+			return
+		}
+
+		operationStatus, ok, err := entry.GetString(registry.OperationStatus)
+		if err != nil {
+			jsonError(w, err)
+			return
+		}
+
+		// If there is no status then the operation is still in progress (or has crashed...)
+		if !ok {
+			response := &api.PollServiceBindingResponse{
+				State:       api.PollStateInProgress,
+				Description: "asynchronous operation in progress",
+			}
+			JSONResponse(w, http.StatusOK, response)
+
+			return
+		}
+
+		// If the status isn't empty then we have encountered an error and need to report failure.
+		if operationStatus != "" {
+			if err := operation.End(entry); err != nil {
+				jsonError(w, err)
+				return
+			}
+
+			response := &api.PollServiceBindingResponse{
+				State:       api.PollStateFailed,
+				Description: operationStatus,
+			}
+			JSONResponse(w, http.StatusOK, response)
+
+			return
+		}
+
+		// Deprovisioning has completed, so clean up the registry, subsequent polls
+		// will report the service binding as gone.
+		op, _, err := entry.GetString(registry.Operation)
+		if err != nil {
+			jsonError(w, err)
+			return
+		}
+
+		if operation.Type(op) == operation.TypeDeprovision {
+			if err := entry.Delete(); err != nil {
+				jsonError(w, err)
+				return
+			}
+
+			response := &api.PollServiceBindingResponse{
+				State: api.PollStateSucceeded,
+			}
+			JSONResponse(w, http.StatusOK, response)
+
+			return
+		}
+
+		// All checks have passed, binding successfully provisioned.
+		if err := operation.End(entry); err != nil {
+			jsonError(w, err)
+			return
+		}
+
+		response := &api.PollServiceBindingResponse{
+			State: api.PollStateSucceeded,
+		}
 		JSONResponse(w, http.StatusOK, response)
 	}
 }
@@ -0,0 +1,186 @@
+// Copyright 2020-2021 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file  except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the  License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/couchbase/service-broker/pkg/api"
+	"github.com/couchbase/service-broker/pkg/log"
+	"github.com/couchbase/service-broker/pkg/registry"
+	"github.com/couchbase/service-broker/pkg/tracing"
+
+	"github.com/google/uuid"
+
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const (
+	// originatingIdentityHeader identifies the end user that initiated a request.
+	originatingIdentityHeader = "X-Broker-API-Originating-Identity"
+
+	// requestIdentityHeader identifies a request, so it can be correlated between
+	// the platform and the service broker.
+	requestIdentityHeader = "X-Broker-API-Request-Identity"
+
+	// maxRequestIdentityLength is the longest request identity accepted from a client.
+	maxRequestIdentityLength = 128
+)
+
+// requestIdentityKey is used to store the request identity in a request context.
+type requestIdentityKey struct{}
+
+// validRequestIdentity returns whether a client supplied request identity is safe
+// to use.  As it is written verbatim to logs and resources, it must be a short run
+// of printable ASCII characters.
+func validRequestIdentity(requestID string) bool {
+	if requestID == "" || len(requestID) > maxRequestIdentityLength {
+		return false
+	}
+
+	for _, c := range requestID {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// handleRequestIdentityHeader looks for the optional X-Broker-API-Request-Identity
+// header, generating a new identity if it is missing or invalid.  The identity is
+// returned to the client and the returned request has the identity, and a logger
+// that tags messages with it, attached to its context.
+func handleRequestIdentityHeader(w http.ResponseWriter, r *http.Request) *http.Request {
+	requestID := r.Header.Get(requestIdentityHeader)
+
+	if !validRequestIdentity(requestID) {
+		requestID = uuid.New().String()
+	}
+
+	w.Header().Set(requestIdentityHeader, requestID)
+
+	ctx := context.WithValue(r.Context(), requestIdentityKey{}, requestID)
+	ctx = log.NewContext(ctx, log.New(log.SubsystemAPI).WithRequestID(requestID))
+
+	return r.WithContext(ctx)
+}
+
+// setRequestIdentity records the request that initiated the operation in the registry
+// so that asynchronous operations can be correlated with it, both in logs and traces.
+func setRequestIdentity(r *http.Request, entry *registry.Entry) error {
+	if traceParent := tracing.SpanContextFromContext(r.Context()).TraceParent(); traceParent != "" {
+		if err := entry.Set(registry.TraceParent, traceParent); err != nil {
+			return err
+		}
+	} else {
+		entry.Unset(registry.TraceParent)
+	}
+
+	requestID, ok := r.Context().Value(requestIdentityKey{}).(string)
+	if !ok {
+		entry.Unset(registry.RequestID)
+		return nil
+	}
+
+	return entry.Set(registry.RequestID, requestID)
+}
+
+// originatingIdentityKey is used to store the originating identity in a request context.
+type originatingIdentityKey struct{}
+
+// contextWithOriginatingIdentity returns a new context containing the originating identity.
+func contextWithOriginatingIdentity(ctx context.Context, identity *api.OriginatingIdentity) context.Context {
+	return context.WithValue(ctx, originatingIdentityKey{}, identity)
+}
+
+// originatingIdentityFromContext returns the originating identity from a request context.
+func originatingIdentityFromContext(ctx context.Context) (*api.OriginatingIdentity, bool) {
+	identity, ok := ctx.Value(originatingIdentityKey{}).(*api.OriginatingIdentity)
+
+	return identity, ok
+}
+
+// parseOriginatingIdentity decodes an originating identity header.  This is formatted
+// as the platform name followed by a base64 encoded JSON object.
+func parseOriginatingIdentity(header string) (*api.OriginatingIdentity, error) {
+	fields := strings.Fields(header)
+
+	expectedFields := 2
+	if len(fields) != expectedFields {
+		return nil, fmt.Errorf("%w: malformed %s header", ErrRequestMalformed, originatingIdentityHeader)
+	}
+
+	data, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(fields[1], "="))
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed %s header: %v", ErrRequestMalformed, originatingIdentityHeader, err)
+	}
+
+	var value map[string]interface{}
+
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, fmt.Errorf("%w: malformed %s header: %v", ErrRequestMalformed, originatingIdentityHeader, err)
+	}
+
+	identity := &api.OriginatingIdentity{
+		Platform: fields[0],
+		Value: &runtime.RawExtension{
+			Raw: data,
+		},
+	}
+
+	return identity, nil
+}
+
+// handleOriginatingIdentityHeader looks for and verifies the optional
+// X-Broker-API-Originating-Identity header.  If present, the returned
+// request has the decoded identity attached to its context.
+func handleOriginatingIdentityHeader(w http.ResponseWriter, r *http.Request) (*http.Request, error) {
+	if _, err := getHeader(r, originatingIdentityHeader); err != nil {
+		return r, nil
+	}
+
+	header, err := getHeaderSingle(r, originatingIdentityHeader)
+	if err != nil {
+		httpResponse(w, http.StatusBadRequest)
+		return nil, err
+	}
+
+	identity, err := parseOriginatingIdentity(header)
+	if err != nil {
+		httpResponse(w, http.StatusBadRequest)
+		return nil, err
+	}
+
+	return r.WithContext(contextWithOriginatingIdentity(r.Context(), identity)), nil
+}
+
+// setOriginatingIdentity records the end user that initiated the operation in the
+// registry.  If the client did not send one, any existing identity, for example one
+// inherited from a service instance, is removed.
+func setOriginatingIdentity(r *http.Request, entry *registry.Entry) error {
+	identity, ok := originatingIdentityFromContext(r.Context())
+	if !ok {
+		entry.Unset(registry.OriginatingIdentity)
+		return nil
+	}
+
+	return entry.Set(registry.OriginatingIdentity, identity)
+}
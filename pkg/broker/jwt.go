@@ -0,0 +1,728 @@
+// Copyright 2020-2021 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file  except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the  License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	// Register hash functions used by signature algorithms.
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+const (
+	// DefaultJWTGroupsClaim is the default claim used to populate the client's groups.
+	DefaultJWTGroupsClaim = "groups"
+
+	// jwtLeeway is the allowed clock skew when checking token expiry.
+	jwtLeeway = time.Minute
+
+	// jwksRefreshPeriod is the minimum time between fetching remote key sets.
+	jwksRefreshPeriod = time.Minute
+)
+
+// ErrInvalidKey is raised when a JSON web key cannot be used.
+var ErrInvalidKey = errors.New("invalid key")
+
+// KeySet is a source of public keys used to verify token signatures.
+type KeySet interface {
+	// Key returns the public key with the given key ID.  If the key ID is
+	// empty, and there is only one key, then that is returned.
+	Key(kid string) (crypto.PublicKey, error)
+}
+
+// jsonWebKey is a public key as defined by RFC-7517.
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// decodeBigInt decodes a base64 URL encoded, big-endian integer.
+func decodeBigInt(s string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) == 0 {
+		return nil, fmt.Errorf("%w: missing integer parameter", ErrInvalidKey)
+	}
+
+	return new(big.Int).SetBytes(data), nil
+}
+
+// publicKey converts a JSON web key into a native public key.
+func (k *jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("%w: RSA exponent too large", ErrInvalidKey)
+		}
+
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("%w: unsupported curve %s", ErrInvalidKey, k.Crv)
+		}
+
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("%w: point not on curve %s", ErrInvalidKey, k.Crv)
+		}
+
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+
+	return nil, fmt.Errorf("%w: unsupported key type %s", ErrInvalidKey, k.Kty)
+}
+
+// JSONWebKeySet is a static set of public keys as defined by RFC-7517.
+type JSONWebKeySet struct {
+	// keys is the set of keys, in the order they were defined.
+	keys []crypto.PublicKey
+
+	// kids maps from key ID to key.
+	kids map[string]crypto.PublicKey
+}
+
+// ParseJSONWebKeySet parses a JSON web key set.  Keys that are not used for signing,
+// or are of an unsupported type, are ignored.
+func ParseJSONWebKeySet(data []byte) (*JSONWebKeySet, error) {
+	var raw struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("%w: malformed key set: %v", ErrInvalidKey, err)
+	}
+
+	set := &JSONWebKeySet{
+		kids: map[string]crypto.PublicKey{},
+	}
+
+	for i := range raw.Keys {
+		jwk := &raw.Keys[i]
+
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		key, err := jwk.publicKey()
+		if err != nil {
+			continue
+		}
+
+		set.keys = append(set.keys, key)
+
+		if jwk.Kid != "" {
+			set.kids[jwk.Kid] = key
+		}
+	}
+
+	if len(set.keys) == 0 {
+		return nil, fmt.Errorf("%w: key set contains no usable keys", ErrInvalidKey)
+	}
+
+	return set, nil
+}
+
+// Key returns the public key with the given key ID.
+func (s *JSONWebKeySet) Key(kid string) (crypto.PublicKey, error) {
+	if kid == "" {
+		if len(s.keys) != 1 {
+			return nil, fmt.Errorf("%w: token has no key ID, and key set is ambiguous", ErrUnauthorized)
+		}
+
+		return s.keys[0], nil
+	}
+
+	key, ok := s.kids[kid]
+	if !ok {
+		return nil, fmt.Errorf("%w: key %s not found", ErrUnauthorized, kid)
+	}
+
+	return key, nil
+}
+
+// CredentialKeySet is a key set loaded from a credential.  When used with a
+// FileCredential, keys can be rotated without restarting.
+type CredentialKeySet struct {
+	// credential holds the raw key set.
+	credential Credential
+
+	// lock protects the fields below.
+	lock sync.Mutex
+
+	// raw is the raw key set the parsed key set was created from.
+	raw []byte
+
+	// set is the parsed key set.
+	set *JSONWebKeySet
+}
+
+// NewCredentialKeySet returns a key set loaded from a credential.
+func NewCredentialKeySet(credential Credential) (*CredentialKeySet, error) {
+	s := &CredentialKeySet{
+		credential: credential,
+	}
+
+	if _, err := s.keySet(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// keySet returns the current key set, parsing it again if the credential has changed.
+// Should parsing fail, the last good key set is retained.
+func (s *CredentialKeySet) keySet() (*JSONWebKeySet, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	raw := s.credential.Value()
+
+	if s.set != nil && bytes.Equal(raw, s.raw) {
+		return s.set, nil
+	}
+
+	set, err := ParseJSONWebKeySet(raw)
+	if err != nil {
+		if s.set == nil {
+			return nil, err
+		}
+
+		set = s.set
+	}
+
+	s.raw = raw
+	s.set = set
+
+	return set, nil
+}
+
+// Key returns the public key with the given key ID.
+func (s *CredentialKeySet) Key(kid string) (crypto.PublicKey, error) {
+	set, err := s.keySet()
+	if err != nil {
+		return nil, err
+	}
+
+	return set.Key(kid)
+}
+
+// RemoteKeySet is a key set discovered from an OpenID Connect issuer.  The key set
+// is fetched when first used, and again when an unknown key ID is seen, so keys can
+// be rotated by the issuer.
+type RemoteKeySet struct {
+	// issuer is the OpenID Connect issuer URL.
+	issuer string
+
+	// client is used to fetch the discovery document and key set.
+	client *http.Client
+
+	// allowInsecure allows the key set to be fetched without TLS.
+	allowInsecure bool
+
+	// lock protects the fields below.
+	lock sync.Mutex
+
+	// set is the last key set fetched.
+	set *JSONWebKeySet
+
+	// fetched is when the key set was last fetched.
+	fetched time.Time
+
+	// refresh is set while the key set is being fetched, and closed once done.
+	refresh chan struct{}
+}
+
+// NewRemoteKeySet returns a key set discovered from an OpenID Connect issuer.  The
+// key set must be served over HTTPS unless allowInsecure is set.
+func NewRemoteKeySet(issuer string, client *http.Client, allowInsecure bool) *RemoteKeySet {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &RemoteKeySet{
+		issuer:        issuer,
+		client:        client,
+		allowInsecure: allowInsecure,
+	}
+}
+
+// get fetches a document from the issuer.
+func (s *RemoteKeySet) get(url string) ([]byte, error) {
+	response, err := s.client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: fetching %s returned status %d", ErrInvalidKey, url, response.StatusCode)
+	}
+
+	return ioutil.ReadAll(response.Body)
+}
+
+// fetch discovers and fetches the issuer's key set.
+func (s *RemoteKeySet) fetch() (*JSONWebKeySet, error) {
+	var discovery struct {
+		Issuer  string `json:"issuer"`
+		JWKSURI string `json:"jwks_uri"`
+	}
+
+	document, err := s.get(strings.TrimSuffix(s.issuer, "/") + "/.well-known/openid-configuration")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(document, &discovery); err != nil {
+		return nil, fmt.Errorf("%w: malformed discovery document: %v", ErrInvalidKey, err)
+	}
+
+	if discovery.Issuer != s.issuer {
+		return nil, fmt.Errorf("%w: discovered issuer %s does not match %s", ErrInvalidKey, discovery.Issuer, s.issuer)
+	}
+
+	if discovery.JWKSURI == "" {
+		return nil, fmt.Errorf("%w: issuer %s does not publish a key set", ErrInvalidKey, s.issuer)
+	}
+
+	jwksURI, err := url.Parse(discovery.JWKSURI)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed key set URI: %v", ErrInvalidKey, err)
+	}
+
+	if jwksURI.Scheme != "https" && !s.allowInsecure {
+		return nil, fmt.Errorf("%w: key set URI %s does not use https", ErrInvalidKey, discovery.JWKSURI)
+	}
+
+	raw, err := s.get(discovery.JWKSURI)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseJSONWebKeySet(raw)
+}
+
+// cachedKey returns the public key with the given key ID from the last key set fetched.
+func (s *RemoteKeySet) cachedKey(kid string) (crypto.PublicKey, error) {
+	s.lock.Lock()
+	set := s.set
+	s.lock.Unlock()
+
+	if set == nil {
+		return nil, fmt.Errorf("%w: key set for issuer %s unavailable", ErrUnauthorized, s.issuer)
+	}
+
+	return set.Key(kid)
+}
+
+// Key returns the public key with the given key ID.  The key set is fetched without
+// holding the lock, so a slow issuer does not hold up requests signed with known keys.
+func (s *RemoteKeySet) Key(kid string) (crypto.PublicKey, error) {
+	s.lock.Lock()
+
+	if s.set != nil {
+		if key, err := s.set.Key(kid); err == nil {
+			s.lock.Unlock()
+
+			return key, nil
+		}
+	}
+
+	// Only one request fetches the key set.  Others are served from the cached key
+	// set, or if there isn't one yet, wait for the fetch to complete.
+	if refresh := s.refresh; refresh != nil {
+		cached := s.set != nil
+
+		s.lock.Unlock()
+
+		if !cached {
+			<-refresh
+		}
+
+		return s.cachedKey(kid)
+	}
+
+	// Limit how often we go to the issuer, so bogus key IDs cannot be used
+	// to flood it with requests.
+	if time.Since(s.fetched) < jwksRefreshPeriod {
+		s.lock.Unlock()
+
+		return s.cachedKey(kid)
+	}
+
+	refresh := make(chan struct{})
+
+	s.fetched = time.Now()
+	s.refresh = refresh
+
+	s.lock.Unlock()
+
+	set, err := s.fetch()
+
+	s.lock.Lock()
+
+	if err == nil {
+		s.set = set
+	}
+
+	s.refresh = nil
+
+	s.lock.Unlock()
+
+	close(refresh)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return set.Key(kid)
+}
+
+// jwtHeader is a JOSE header as defined by RFC-7515.
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// jwtAlgorithm describes a signature algorithm as defined by RFC-7518.
+type jwtAlgorithm struct {
+	// hash is the hash function used to create the digest.
+	hash crypto.Hash
+
+	// pss is set for RSA-PSS signatures.
+	pss bool
+}
+
+// jwtAlgorithms are the supported signature algorithms.  Symmetric algorithms
+// are deliberately not supported, nor is "none".
+var jwtAlgorithms = map[string]jwtAlgorithm{
+	"RS256": {hash: crypto.SHA256},
+	"RS384": {hash: crypto.SHA384},
+	"RS512": {hash: crypto.SHA512},
+	"PS256": {hash: crypto.SHA256, pss: true},
+	"PS384": {hash: crypto.SHA384, pss: true},
+	"PS512": {hash: crypto.SHA512, pss: true},
+	"ES256": {hash: crypto.SHA256},
+	"ES384": {hash: crypto.SHA384},
+	"ES512": {hash: crypto.SHA512},
+}
+
+// verify checks the signature over the signed content.
+func (a jwtAlgorithm) verify(name string, key crypto.PublicKey, signed, signature []byte) error {
+	h := a.hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(name, "RS") && !strings.HasPrefix(name, "PS") {
+			break
+		}
+
+		if a.pss {
+			return rsa.VerifyPSS(key, a.hash, digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		}
+
+		return rsa.VerifyPKCS1v15(key, a.hash, digest, signature)
+
+	case *ecdsa.PublicKey:
+		if !strings.HasPrefix(name, "ES") {
+			break
+		}
+
+		// Signatures are the concatenation of fixed size R and S values.
+		size := (key.Curve.Params().BitSize + 7) / 8
+
+		if len(signature) != 2*size {
+			return fmt.Errorf("%w: malformed signature", ErrUnauthorized)
+		}
+
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+
+		if !ecdsa.Verify(key, digest, r, s) {
+			return fmt.Errorf("%w: signature verification failed", ErrUnauthorized)
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("%w: key type does not match algorithm %s", ErrUnauthorized, name)
+}
+
+// JWTAuthenticator implements RFC-7519 JSON web token authentication.  Tokens are
+// presented as bearer tokens, and must be signed by a key in the key set.
+type JWTAuthenticator struct {
+	// keys is the set of keys tokens may be signed with.
+	keys KeySet
+
+	// issuer is the required "iss" claim.
+	issuer string
+
+	// audience is the required "aud" claim.
+	audience string
+
+	// groupsClaim is the claim used to populate the client's groups.
+	groupsClaim string
+}
+
+// NewJWTAuthenticator returns an authenticator that accepts tokens signed by any key in
+// the key set, issued by the issuer and intended for the audience.  The client is
+// identified by the "sub" claim, and its groups by the groups claim.
+func NewJWTAuthenticator(keys KeySet, issuer, audience, groupsClaim string) *JWTAuthenticator {
+	if groupsClaim == "" {
+		groupsClaim = DefaultJWTGroupsClaim
+	}
+
+	return &JWTAuthenticator{
+		keys:        keys,
+		issuer:      issuer,
+		audience:    audience,
+		groupsClaim: groupsClaim,
+	}
+}
+
+// decodeSegment decodes a base64 URL encoded JSON token segment.
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return fmt.Errorf("%w: malformed token: %v", ErrUnauthorized, err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("%w: malformed token: %v", ErrUnauthorized, err)
+	}
+
+	return nil
+}
+
+// getNumericDate returns a time claim, and whether it was set.
+func getNumericDate(claims map[string]interface{}, name string) (time.Time, bool, error) {
+	value, ok := claims[name]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+
+	number, ok := value.(json.Number)
+	if !ok {
+		return time.Time{}, false, fmt.Errorf("%w: claim %s is not a number", ErrUnauthorized, name)
+	}
+
+	seconds, err := number.Float64()
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("%w: claim %s is not a number", ErrUnauthorized, name)
+	}
+
+	return time.Unix(int64(seconds), 0), true, nil
+}
+
+// getStrings returns a claim that may be either a string or an array of strings.
+func getStrings(claims map[string]interface{}, name string) ([]string, error) {
+	switch value := claims[name].(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []string{value}, nil
+	case []interface{}:
+		values := make([]string, len(value))
+
+		for i := range value {
+			s, ok := value[i].(string)
+			if !ok {
+				return nil, fmt.Errorf("%w: claim %s is not an array of strings", ErrUnauthorized, name)
+			}
+
+			values[i] = s
+		}
+
+		return values, nil
+	}
+
+	return nil, fmt.Errorf("%w: claim %s is not a string or an array of strings", ErrUnauthorized, name)
+}
+
+// Authenticate checks the request has a valid bearer token.
+func (a *JWTAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	token, err := getAuthorization(r, "Bearer")
+	if err != nil {
+		return nil, err
+	}
+
+	segments := strings.Split(token, ".")
+	if len(segments) != 3 {
+		return nil, fmt.Errorf("%w: malformed token", ErrUnauthorized)
+	}
+
+	header := &jwtHeader{}
+
+	if err := decodeSegment(segments[0], header); err != nil {
+		return nil, err
+	}
+
+	algorithm, ok := jwtAlgorithms[header.Alg]
+	if !ok {
+		return nil, fmt.Errorf("%w: unsupported algorithm %s", ErrUnauthorized, header.Alg)
+	}
+
+	key, err := a.keys.Key(header.Kid)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnauthorized, err)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(segments[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed signature: %v", ErrUnauthorized, err)
+	}
+
+	if err := algorithm.verify(header.Alg, key, []byte(segments[0]+"."+segments[1]), signature); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnauthorized, err)
+	}
+
+	// Only once the token is known to be authentic can the claims be trusted.
+	claims := map[string]interface{}{}
+
+	if err := decodeSegment(segments[1], &claims); err != nil {
+		return nil, err
+	}
+
+	if err := a.verifyClaims(claims); err != nil {
+		return nil, err
+	}
+
+	identity := &Identity{}
+
+	if subject, ok := claims["sub"].(string); ok {
+		identity.Subject = subject
+	}
+
+	groups, err := getStrings(claims, a.groupsClaim)
+	if err != nil {
+		return nil, err
+	}
+
+	identity.Groups = groups
+
+	return identity, nil
+}
+
+// verifyClaims checks the token was issued by the correct issuer, for this service
+// broker, and is within its validity period.
+func (a *JWTAuthenticator) verifyClaims(claims map[string]interface{}) error {
+	if issuer, _ := claims["iss"].(string); issuer != a.issuer {
+		return fmt.Errorf("%w: token issuer %s not trusted", ErrUnauthorized, issuer)
+	}
+
+	audiences, err := getStrings(claims, "aud")
+	if err != nil {
+		return err
+	}
+
+	audienceOK := false
+
+	for _, audience := range audiences {
+		if audience == a.audience {
+			audienceOK = true
+			break
+		}
+	}
+
+	if !audienceOK {
+		return fmt.Errorf("%w: token not intended for audience %s", ErrUnauthorized, a.audience)
+	}
+
+	now := time.Now()
+
+	expiry, ok, err := getNumericDate(claims, "exp")
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return fmt.Errorf("%w: token has no expiry", ErrUnauthorized)
+	}
+
+	if now.After(expiry.Add(jwtLeeway)) {
+		return fmt.Errorf("%w: token expired at %v", ErrUnauthorized, expiry)
+	}
+
+	notBefore, ok, err := getNumericDate(claims, "nbf")
+	if err != nil {
+		return err
+	}
+
+	if ok && now.Add(jwtLeeway).Before(notBefore) {
+		return fmt.Errorf("%w: token not valid until %v", ErrUnauthorized, notBefore)
+	}
+
+	return nil
+}
@@ -0,0 +1,182 @@
+// Copyright 2020-2021 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file  except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the  License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/couchbase/service-broker/pkg/log"
+	"github.com/couchbase/service-broker/pkg/metrics"
+	"github.com/couchbase/service-broker/pkg/registry"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	// unmatchedRoute labels requests that do not match any API route.
+	unmatchedRoute = "unmatched"
+)
+
+// routeTable registers API handlers, recording their path patterns so requests can
+// be labelled with the route they match.  Routes are used to label metrics and traces,
+// as paths contain unbounded instance and binding IDs.
+type routeTable struct {
+	// router dispatches requests to handlers.
+	router *httprouter.Router
+
+	// patterns are the registered path patterns for each method.
+	patterns map[string][]string
+}
+
+// newRouteTable returns an empty route table.
+func newRouteTable() *routeTable {
+	return &routeTable{
+		router:   httprouter.New(),
+		patterns: map[string][]string{},
+	}
+}
+
+// handle registers a handler for a method and path pattern.
+func (t *routeTable) handle(method, pattern string, handle httprouter.Handle) {
+	t.router.Handle(method, pattern, handle)
+	t.patterns[method] = append(t.patterns[method], pattern)
+}
+
+// matchPattern returns whether a path matches a pattern, where segments starting
+// with ":" match any non-empty segment.
+func matchPattern(pattern, path string) bool {
+	patternSegments := strings.Split(pattern, "/")
+	pathSegments := strings.Split(path, "/")
+
+	if len(patternSegments) != len(pathSegments) {
+		return false
+	}
+
+	for i := range patternSegments {
+		if strings.HasPrefix(patternSegments[i], ":") {
+			if pathSegments[i] == "" {
+				return false
+			}
+
+			continue
+		}
+
+		if patternSegments[i] != pathSegments[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// route returns the API route a request matches e.g. "GET /v2/service_instances/:instance_id".
+func (t *routeTable) route(r *http.Request) string {
+	if handle, _, _ := t.router.Lookup(r.Method, r.URL.Path); handle == nil {
+		return unmatchedRoute
+	}
+
+	for _, pattern := range t.patterns[r.Method] {
+		if matchPattern(pattern, r.URL.Path) {
+			return r.Method + " " + pattern
+		}
+	}
+
+	return unmatchedRoute
+}
+
+// observeRequest records request metrics.
+func observeRequest(route string, status int, start time.Time) {
+	// Handlers that write a body without a status code implicitly return OK.
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	code := strconv.Itoa(status)
+
+	metrics.HTTPRequests.Inc(route, code)
+	metrics.HTTPRequestDuration.Observe(time.Since(start).Seconds(), route, code)
+}
+
+// updateServiceInstanceMetrics counts the service instances of each plan.
+func updateServiceInstanceMetrics(configuration *ServerConfiguration) error {
+	namespaces, err := getRegistryNamespaces(configuration)
+	if err != nil {
+		return err
+	}
+
+	type plan struct {
+		serviceID string
+		planID    string
+	}
+
+	counts := map[plan]int{}
+
+	for _, namespace := range namespaces {
+		entries, err := registry.List(registry.ServiceInstance, namespace)
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range entries {
+			serviceID, _, err := entry.GetString(registry.ServiceID)
+			if err != nil {
+				return err
+			}
+
+			planID, _, err := entry.GetString(registry.PlanID)
+			if err != nil {
+				return err
+			}
+
+			counts[plan{serviceID: serviceID, planID: planID}]++
+		}
+	}
+
+	metrics.ServiceInstances.Reset()
+
+	for p, count := range counts {
+		metrics.ServiceInstances.Set(float64(count), p.serviceID, p.planID)
+	}
+
+	return nil
+}
+
+// updateSchedulerMetrics records how busy the operation scheduler is.
+func updateSchedulerMetrics(configuration *ServerConfiguration) {
+	metrics.OperationQueueDepth.Set(float64(configuration.Scheduler.Depth()))
+	metrics.OperationsInFlight.Set(float64(configuration.Scheduler.Running()))
+}
+
+// handleMetrics exposes metrics in the Prometheus text format.
+func handleMetrics(configuration *ServerConfiguration) func(http.ResponseWriter, *http.Request, httprouter.Params) {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		// Gauges are derived from the registry when scraped, a failure is not fatal
+		// as the other metrics are still useful.
+		if err := updateServiceInstanceMetrics(configuration); err != nil {
+			log.FromContext(r.Context()).Warningf("failed to update service instance metrics: %v", err)
+		}
+
+		updateSchedulerMetrics(configuration)
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+		httpResponse(w, http.StatusOK)
+
+		_ = metrics.Write(w)
+	}
+}
@@ -0,0 +1,452 @@
+// Copyright 2020-2021 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file  except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the  License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/couchbase/service-broker/pkg/api"
+	"github.com/couchbase/service-broker/pkg/config"
+	"github.com/couchbase/service-broker/pkg/errors"
+	"github.com/couchbase/service-broker/pkg/log"
+	"github.com/couchbase/service-broker/pkg/registry"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// opsHandler serves the read-only operations API.  This is separate from the Open
+// Service Broker API, and its clients are authenticated separately, so SREs can
+// inspect the service broker without being able to modify anything.
+type opsHandler struct {
+	http.Handler
+	configuration *ServerConfiguration
+}
+
+// NewOpsHandler initializes the router for the operations API and metrics.
+func NewOpsHandler(configuration *ServerConfiguration) http.Handler {
+	router := httprouter.New()
+
+	router.GET("/v1/service_instances", handleListServiceInstances(configuration))
+	router.GET("/v1/service_instances/:instance_id", handleGetServiceInstanceStatus(configuration))
+	router.GET("/v1/service_instances/:instance_id/service_bindings/:binding_id", handleGetServiceBindingStatus(configuration))
+	router.GET("/v1/service_bindings", handleListServiceBindings(configuration))
+	router.GET("/metrics", handleMetrics(configuration))
+
+	return &opsHandler{
+		Handler:       router,
+		configuration: configuration,
+	}
+}
+
+// ServeHTTP authenticates all operations API requests.
+func (handler *opsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	// Catalog lookups need a consistent view of the configuration.
+	config.Lock()
+	defer config.Unlock()
+
+	writer := &responseWriter{
+		writer: w,
+	}
+
+	r = handleRequestIdentityHeader(writer, r)
+
+	logger := log.FromContext(r.Context())
+
+	logger.Infof(`HTTP ops req: "%s %v %s" %s `, r.Method, r.URL, r.Proto, r.RemoteAddr)
+
+	defer func() {
+		logger.Infof(`HTTP ops rsp: "%d %s" %v`, writer.status, http.StatusText(writer.status), time.Since(start))
+	}()
+
+	if handler.configuration.OpsAuthenticator == nil {
+		httpResponse(writer, http.StatusInternalServerError)
+		return
+	}
+
+	identity, err := handler.configuration.OpsAuthenticator.Authenticate(r)
+	if err != nil {
+		logger.Debugf("%v", err)
+		httpResponse(writer, http.StatusUnauthorized)
+
+		return
+	}
+
+	r = r.WithContext(contextWithIdentity(r.Context(), identity))
+
+	handler.Handler.ServeHTTP(writer, r)
+}
+
+// getServiceAndPlanNames returns the catalog names of a service offering and plan.
+// Names are empty if the broker is unconfigured or they have since been removed from
+// the catalog.
+func getServiceAndPlanNames(serviceID, planID string) (string, string) {
+	if config.Config() == nil {
+		return "", ""
+	}
+
+	service, err := getServiceOffering(config.Config(), serviceID)
+	if err != nil {
+		return "", ""
+	}
+
+	plan, err := getServicePlan(config.Config(), serviceID, planID)
+	if err != nil {
+		return service.Name, ""
+	}
+
+	return service.Name, plan.Name
+}
+
+// getOperationStatus returns the current operation on a registry entry, if any.
+func getOperationStatus(entry *registry.Entry) (*api.OperationStatus, error) {
+	op, ok, err := entry.GetString(registry.Operation)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	status := &api.OperationStatus{
+		Type:  op,
+		State: api.PollStateInProgress,
+	}
+
+	if status.ID, _, err = entry.GetString(registry.OperationID); err != nil {
+		return nil, err
+	}
+
+	if status.Step, _, err = entry.GetString(registry.OperationStep); err != nil {
+		return nil, err
+	}
+
+	if status.RequestID, _, err = entry.GetString(registry.RequestID); err != nil {
+		return nil, err
+	}
+
+	var startTime time.Time
+
+	ok, err = entry.Get(registry.OperationStartTime, &startTime)
+	if err != nil {
+		return nil, err
+	}
+
+	if ok {
+		status.StartTime = &startTime
+	}
+
+	// An operation status is only recorded on completion, and is empty on success.
+	description, ok, err := entry.GetString(registry.OperationStatus)
+	if err != nil {
+		return nil, err
+	}
+
+	if ok {
+		status.State = api.PollStateSucceeded
+
+		if description != "" {
+			status.State = api.PollStateFailed
+			status.Description = description
+		}
+	}
+
+	return status, nil
+}
+
+// getResources returns the resources recorded as owned by a registry entry.
+func getResources(entry *registry.Entry) ([]api.ResourceReference, error) {
+	resources := []api.ResourceReference{}
+
+	if _, err := entry.Get(registry.Resources, &resources); err != nil {
+		return nil, err
+	}
+
+	return resources, nil
+}
+
+// getServiceInstanceStatus describes a service instance from its registry entry.
+func getServiceInstanceStatus(entry *registry.Entry, namespace string) (*api.ServiceInstanceStatus, error) {
+	status := &api.ServiceInstanceStatus{
+		Namespace: namespace,
+		Bindings:  []string{},
+	}
+
+	var err error
+
+	if status.ID, _, err = entry.GetString(registry.InstanceID); err != nil {
+		return nil, err
+	}
+
+	if status.ServiceID, _, err = entry.GetString(registry.ServiceID); err != nil {
+		return nil, err
+	}
+
+	if status.PlanID, _, err = entry.GetString(registry.PlanID); err != nil {
+		return nil, err
+	}
+
+	status.ServiceName, status.PlanName = getServiceAndPlanNames(status.ServiceID, status.PlanID)
+
+	if status.Operation, err = getOperationStatus(entry); err != nil {
+		return nil, err
+	}
+
+	if status.Resources, err = getResources(entry); err != nil {
+		return nil, err
+	}
+
+	return status, nil
+}
+
+// getServiceBindingStatus describes a service binding from its registry entry.
+func getServiceBindingStatus(entry *registry.Entry, namespace string) (*api.ServiceBindingStatus, error) {
+	status := &api.ServiceBindingStatus{
+		Namespace: namespace,
+	}
+
+	var err error
+
+	if status.ID, _, err = entry.GetString(registry.BindingID); err != nil {
+		return nil, err
+	}
+
+	if status.InstanceID, _, err = entry.GetString(registry.InstanceID); err != nil {
+		return nil, err
+	}
+
+	if status.ServiceID, _, err = entry.GetString(registry.ServiceID); err != nil {
+		return nil, err
+	}
+
+	if status.PlanID, _, err = entry.GetString(registry.PlanID); err != nil {
+		return nil, err
+	}
+
+	status.ServiceName, status.PlanName = getServiceAndPlanNames(status.ServiceID, status.PlanID)
+
+	if status.Operation, err = getOperationStatus(entry); err != nil {
+		return nil, err
+	}
+
+	if status.Resources, err = getResources(entry); err != nil {
+		return nil, err
+	}
+
+	return status, nil
+}
+
+// listServiceBindings returns all service bindings in a namespace.
+func listServiceBindings(namespace string) ([]api.ServiceBindingStatus, error) {
+	entries, err := registry.List(registry.ServiceBinding, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	bindings := []api.ServiceBindingStatus{}
+
+	for _, entry := range entries {
+		binding, err := getServiceBindingStatus(entry, namespace)
+		if err != nil {
+			return nil, err
+		}
+
+		bindings = append(bindings, *binding)
+	}
+
+	return bindings, nil
+}
+
+// listServiceInstances returns all service instances, and their bindings, in a namespace.
+func listServiceInstances(namespace string) ([]api.ServiceInstanceStatus, error) {
+	entries, err := registry.List(registry.ServiceInstance, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	bindings, err := listServiceBindings(namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	instances := []api.ServiceInstanceStatus{}
+
+	for _, entry := range entries {
+		instance, err := getServiceInstanceStatus(entry, namespace)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, binding := range bindings {
+			if binding.InstanceID == instance.ID {
+				instance.Bindings = append(instance.Bindings, binding.ID)
+			}
+		}
+
+		instances = append(instances, *instance)
+	}
+
+	return instances, nil
+}
+
+// handleListServiceInstances lists all service instances managed by the service broker.
+func handleListServiceInstances(configuration *ServerConfiguration) func(http.ResponseWriter, *http.Request, httprouter.Params) {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		namespaces, err := getRegistryNamespaces(configuration)
+		if err != nil {
+			jsonError(w, err)
+			return
+		}
+
+		response := &api.ListServiceInstancesResponse{
+			ServiceInstances: []api.ServiceInstanceStatus{},
+		}
+
+		for _, namespace := range namespaces {
+			instances, err := listServiceInstances(namespace)
+			if err != nil {
+				jsonError(w, err)
+				return
+			}
+
+			response.ServiceInstances = append(response.ServiceInstances, instances...)
+		}
+
+		sort.Slice(response.ServiceInstances, func(i, j int) bool {
+			return response.ServiceInstances[i].ID < response.ServiceInstances[j].ID
+		})
+
+		JSONResponse(w, http.StatusOK, response)
+	}
+}
+
+// handleGetServiceInstanceStatus describes a single service instance.
+func handleGetServiceInstanceStatus(configuration *ServerConfiguration) func(http.ResponseWriter, *http.Request, httprouter.Params) {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		instanceID := params.ByName("instance_id")
+		if instanceID == "" {
+			jsonError(w, fmt.Errorf("%w: request missing instance_id parameter", ErrUnexpected))
+			return
+		}
+
+		dirent := getDirectoryInstance(configuration.Namespace, instanceID)
+
+		entry, err := registry.New(registry.ServiceInstance, dirent.Namespace, instanceID, true)
+		if err != nil {
+			jsonError(w, err)
+			return
+		}
+
+		if !entry.Exists() {
+			jsonError(w, errors.NewResourceNotFoundError("service instance does not exist"))
+			return
+		}
+
+		instance, err := getServiceInstanceStatus(entry, dirent.Namespace)
+		if err != nil {
+			jsonError(w, err)
+			return
+		}
+
+		bindings, err := listServiceBindings(dirent.Namespace)
+		if err != nil {
+			jsonError(w, err)
+			return
+		}
+
+		for _, binding := range bindings {
+			if binding.InstanceID == instanceID {
+				instance.Bindings = append(instance.Bindings, binding.ID)
+			}
+		}
+
+		JSONResponse(w, http.StatusOK, instance)
+	}
+}
+
+// handleListServiceBindings lists all service bindings managed by the service broker.
+func handleListServiceBindings(configuration *ServerConfiguration) func(http.ResponseWriter, *http.Request, httprouter.Params) {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		namespaces, err := getRegistryNamespaces(configuration)
+		if err != nil {
+			jsonError(w, err)
+			return
+		}
+
+		response := &api.ListServiceBindingsResponse{
+			ServiceBindings: []api.ServiceBindingStatus{},
+		}
+
+		for _, namespace := range namespaces {
+			bindings, err := listServiceBindings(namespace)
+			if err != nil {
+				jsonError(w, err)
+				return
+			}
+
+			response.ServiceBindings = append(response.ServiceBindings, bindings...)
+		}
+
+		sort.Slice(response.ServiceBindings, func(i, j int) bool {
+			return response.ServiceBindings[i].ID < response.ServiceBindings[j].ID
+		})
+
+		JSONResponse(w, http.StatusOK, response)
+	}
+}
+
+// handleGetServiceBindingStatus describes a single service binding.
+func handleGetServiceBindingStatus(configuration *ServerConfiguration) func(http.ResponseWriter, *http.Request, httprouter.Params) {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		instanceID := params.ByName("instance_id")
+		if instanceID == "" {
+			jsonError(w, fmt.Errorf("%w: request missing instance_id parameter", ErrUnexpected))
+			return
+		}
+
+		bindingID := params.ByName("binding_id")
+		if bindingID == "" {
+			jsonError(w, fmt.Errorf("%w: request missing binding_id parameter", ErrUnexpected))
+			return
+		}
+
+		dirent := getDirectoryInstance(configuration.Namespace, instanceID)
+
+		entry, err := registry.New(registry.ServiceBinding, dirent.Namespace, bindingID, true)
+		if err != nil {
+			jsonError(w, err)
+			return
+		}
+
+		if !entry.Exists() {
+			jsonError(w, errors.NewResourceNotFoundError("service binding does not exist"))
+			return
+		}
+
+		binding, err := getServiceBindingStatus(entry, dirent.Namespace)
+		if err != nil {
+			jsonError(w, err)
+			return
+		}
+
+		if binding.InstanceID != instanceID {
+			jsonError(w, errors.NewResourceNotFoundError("service binding does not exist"))
+			return
+		}
+
+		JSONResponse(w, http.StatusOK, binding)
+	}
+}
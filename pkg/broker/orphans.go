@@ -0,0 +1,223 @@
+// Copyright 2020-2021 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file  except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the  License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"time"
+
+	"github.com/couchbase/service-broker/pkg/config"
+	"github.com/couchbase/service-broker/pkg/log"
+	"github.com/couchbase/service-broker/pkg/operation"
+	"github.com/couchbase/service-broker/pkg/provisioners"
+	"github.com/couchbase/service-broker/pkg/registry"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// DefaultOrphanMitigationGracePeriod is the default time to wait for a client to
+	// clean up a failed or abandoned service instance.
+	DefaultOrphanMitigationGracePeriod = 24 * time.Hour
+)
+
+// RunOrphanMitigation periodically looks for orphaned service instances and deprovisions
+// them.  This is intended to be run in a go routine, and never returns.
+func RunOrphanMitigation(configuration *ServerConfiguration) {
+	tick := time.NewTicker(configuration.OrphanMitigationPeriod)
+	defer tick.Stop()
+
+	for range tick.C {
+		if err := MitigateOrphans(configuration); err != nil {
+			log.New(log.SubsystemOperation).Infof("orphan mitigation failed: %v", err)
+		}
+	}
+}
+
+// MitigateOrphans looks for orphaned service instances and deprovisions them.  A service
+// instance is orphaned when a provisioning or deprovisioning operation failed, and the
+// client has been given the grace period to clean up, and has not.  A failure to handle
+// one service instance does not prevent others from being handled.
+func MitigateOrphans(configuration *ServerConfiguration) error {
+	// The configuration is global, so hold a read lock on it, just like a request.
+	config.Lock()
+	defer config.Unlock()
+
+	if config.Config() == nil {
+		return nil
+	}
+
+	namespaces, err := getRegistryNamespaces(configuration)
+	if err != nil {
+		return err
+	}
+
+	for _, namespace := range namespaces {
+		entries, err := registry.List(registry.ServiceInstance, namespace)
+		if err != nil {
+			log.New(log.SubsystemOperation).Warningf("unable to list service instances in namespace %s: %v", namespace, err)
+			continue
+		}
+
+		for _, entry := range entries {
+			orphaned, err := isOrphaned(entry, configuration.OrphanMitigationGracePeriod)
+			if err != nil {
+				entry.Logger().Warningf("unable to determine if service instance is orphaned: %v", err)
+				continue
+			}
+
+			if !orphaned {
+				continue
+			}
+
+			if err := mitigateOrphan(configuration, entry); err != nil {
+				entry.Logger().Warningf("unable to deprovision orphaned service instance: %v", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// isOrphaned returns whether a service instance has been orphaned by the client.
+func isOrphaned(entry *registry.Entry, gracePeriod time.Duration) (bool, error) {
+	op, ok, err := entry.GetString(registry.Operation)
+	if err != nil {
+		return false, err
+	}
+
+	// An operation has failed but was never polled.  Updates are ignored as the
+	// client is known to have successfully provisioned the service instance, and
+	// successful operations leave nothing to clean up.
+	if ok {
+		if operation.Type(op) != operation.TypeProvision && operation.Type(op) != operation.TypeDeprovision {
+			return false, nil
+		}
+
+		if status, ok, err := entry.GetString(registry.OperationStatus); err != nil || !ok || status == "" {
+			return false, err
+		}
+
+		// Entries created by older versions do not record when the operation
+		// completed, so fall back to when it started.
+		var endTime time.Time
+
+		ok, err := entry.Get(registry.OperationEndTime, &endTime)
+		if err != nil {
+			return false, err
+		}
+
+		if !ok {
+			if ok, err := entry.Get(registry.OperationStartTime, &endTime); err != nil || !ok {
+				return false, err
+			}
+		}
+
+		return time.Since(endTime) > gracePeriod, nil
+	}
+
+	// Provisioning failed, and the client has not deprovisioned.
+	var failedTime time.Time
+
+	if ok, err := entry.Get(registry.ProvisionFailedTime, &failedTime); err != nil || !ok {
+		return false, err
+	}
+
+	return time.Since(failedTime) > gracePeriod, nil
+}
+
+// mitigateOrphan deprovisions an orphaned service instance.  As there is no client to
+// poll for the result, the registry and directory entries are removed when the
+// operation completes successfully, otherwise it will be retried after the grace period.
+func mitigateOrphan(configuration *ServerConfiguration, entry *registry.Entry) error {
+	instanceID, ok, err := entry.GetString(registry.InstanceID)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		log.New(log.SubsystemOperation).Infof("orphaned service instance missing instance ID, ignoring")
+		return nil
+	}
+
+	// The operation is initiated by the service broker, not a client request, so
+	// give it a new identity to trace it by.
+	if err := entry.Set(registry.RequestID, uuid.New().String()); err != nil {
+		return err
+	}
+
+	entry.Unset(registry.OriginatingIdentity)
+	entry.Unset(registry.TraceParent)
+
+	logger := entry.Logger()
+
+	logger.Infof("service instance %s orphaned, deprovisioning", instanceID)
+
+	reservation, err := configuration.Scheduler.Reserve()
+	if err != nil {
+		logger.Infof("unable to deprovision orphaned service instance %s: %v", instanceID, err)
+		return nil
+	}
+
+	defer reservation.Release()
+
+	// Discard the result of any abandoned operation.
+	_, ok, err = entry.GetString(registry.Operation)
+	if err != nil {
+		return err
+	}
+
+	if ok {
+		if err := operation.End(entry); err != nil {
+			return err
+		}
+	}
+
+	deleter := provisioners.NewDeleter(provisioners.ResourceTypeServiceInstance)
+
+	if err := deleter.Prepare(entry); err != nil {
+		logger.Infof("unable to deprovision orphaned service instance %s: %v", instanceID, err)
+		return nil
+	}
+
+	if err := operation.Start(entry, operation.TypeDeprovision); err != nil {
+		return err
+	}
+
+	reservation.Submit(instanceID, func() {
+		deleter.Run(entry)
+
+		status, _, err := entry.GetString(registry.OperationStatus)
+		if err != nil {
+			logger.Infof("unable to lookup orphaned service instance %s status: %v", instanceID, err)
+			return
+		}
+
+		if status != "" {
+			logger.Infof("failed to deprovision orphaned service instance %s: %s", instanceID, status)
+			return
+		}
+
+		if err := entry.Delete(); err != nil {
+			logger.Infof("failed to delete orphaned service instance %s registry: %v", instanceID, err)
+			return
+		}
+
+		deleteDirectoryInstance(configuration.Namespace, instanceID)
+
+		logger.Infof("orphaned service instance %s deprovisioned", instanceID)
+	})
+
+	return nil
+}
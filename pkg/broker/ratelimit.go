@@ -0,0 +1,320 @@
+// Copyright 2020-2021 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file  except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the  License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/couchbase/service-broker/pkg/errors"
+)
+
+const (
+	// DefaultRateLimitBurst is the number of requests a client may make at once
+	// before being rate limited.
+	DefaultRateLimitBurst = 10
+
+	// rateLimitPrunePeriod is how often to discard token buckets for idle clients.
+	rateLimitPrunePeriod = time.Minute
+
+	// mutatingRequestRetryAfter is how long a client should wait before retrying a
+	// request rejected because too many mutating requests are in flight.
+	mutatingRequestRetryAfter = time.Second
+)
+
+// EndpointClass groups API endpoints that are rate limited together.
+type EndpointClass string
+
+const (
+	// EndpointClassCatalog is reading the service catalog.
+	EndpointClassCatalog EndpointClass = "catalog"
+
+	// EndpointClassProvision is creating, updating or deleting service instances.
+	EndpointClassProvision EndpointClass = "provision"
+
+	// EndpointClassPoll is reading service instances, service bindings and polling
+	// their asynchronous operations.
+	EndpointClassPoll EndpointClass = "poll"
+
+	// EndpointClassBind is creating or deleting service bindings.
+	EndpointClassBind EndpointClass = "bind"
+
+	// EndpointClassAuthentication is failed authentication attempts to any endpoint.
+	// As the client cannot be identified, these are limited per remote address.
+	EndpointClassAuthentication EndpointClass = "authentication"
+)
+
+// getEndpointClass returns the endpoint class of a request.
+func getEndpointClass(r *http.Request) EndpointClass {
+	path := r.URL.Path
+
+	switch {
+	case path == "/v2/catalog":
+		return EndpointClassCatalog
+	case r.Method == http.MethodGet || strings.HasSuffix(path, "/last_operation"):
+		return EndpointClassPoll
+	case strings.Contains(path, "/service_bindings/"):
+		return EndpointClassBind
+	default:
+		return EndpointClassProvision
+	}
+}
+
+// isMutatingRequest returns whether a request may modify service instances or bindings.
+func isMutatingRequest(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+
+	return false
+}
+
+// RateLimit defines a token bucket that limits the rate of requests.
+type RateLimit struct {
+	// Rate is the average number of requests allowed per second.
+	Rate float64
+
+	// Burst is the number of requests allowed at once.
+	Burst int
+}
+
+// tokenBucket tracks the requests made by a client.
+type tokenBucket struct {
+	// tokens is the number of requests the client may make.
+	tokens float64
+
+	// updated is when the tokens were last replenished.
+	updated time.Time
+}
+
+// replenish adds tokens that have accrued since the bucket was last updated.
+func (b *tokenBucket) replenish(limit RateLimit, now time.Time) {
+	b.tokens = math.Min(float64(limit.Burst), b.tokens+now.Sub(b.updated).Seconds()*limit.Rate)
+	b.updated = now
+}
+
+// wait returns how long to wait before a token is available.
+func (b *tokenBucket) wait(limit RateLimit) time.Duration {
+	if b.tokens >= 1 {
+		return 0
+	}
+
+	return time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second))
+}
+
+// take removes a token from the bucket, returning how long to wait before
+// trying again if none is available.
+func (b *tokenBucket) take(limit RateLimit, now time.Time) time.Duration {
+	b.replenish(limit, now)
+
+	if wait := b.wait(limit); wait > 0 {
+		return wait
+	}
+
+	b.tokens--
+
+	return 0
+}
+
+// bucketKey identifies a token bucket.
+type bucketKey struct {
+	// subject is the authenticated client identity.
+	subject string
+
+	// class is the endpoint class.
+	class EndpointClass
+}
+
+// RateLimiter limits the rate of requests each client may make to each class of
+// endpoint, and the number of mutating requests in flight at any one time.
+type RateLimiter struct {
+	// limits are the rate limits for each endpoint class.  Endpoint classes without
+	// a limit are not rate limited.
+	limits map[EndpointClass]RateLimit
+
+	// mutating contains a token for each mutating request in flight.  If nil the
+	// number of mutating requests is not limited.
+	mutating chan interface{}
+
+	// lock protects the token buckets.
+	lock sync.Mutex
+
+	// buckets are the token buckets for each client and endpoint class.
+	buckets map[bucketKey]*tokenBucket
+
+	// pruned is when idle token buckets were last discarded.
+	pruned time.Time
+}
+
+// NewRateLimiter creates a new rate limiter.  Limits with a non-positive rate
+// are ignored, and the number of mutating requests is unlimited if zero.
+func NewRateLimiter(limits map[EndpointClass]RateLimit, maxMutatingRequests int) *RateLimiter {
+	l := &RateLimiter{
+		limits:  map[EndpointClass]RateLimit{},
+		buckets: map[bucketKey]*tokenBucket{},
+		pruned:  time.Now(),
+	}
+
+	for class, limit := range limits {
+		if limit.Rate <= 0 {
+			continue
+		}
+
+		if limit.Burst < 1 {
+			limit.Burst = 1
+		}
+
+		l.limits[class] = limit
+	}
+
+	if maxMutatingRequests > 0 {
+		l.mutating = make(chan interface{}, maxMutatingRequests)
+	}
+
+	return l
+}
+
+// prune discards token buckets that have been idle long enough to be refilled,
+// as they are indistinguishable from new ones.  This must be called with the
+// lock held.
+func (l *RateLimiter) prune(now time.Time) {
+	if now.Sub(l.pruned) < rateLimitPrunePeriod {
+		return
+	}
+
+	l.pruned = now
+
+	for key, bucket := range l.buckets {
+		bucket.replenish(l.limits[key.class], now)
+
+		if bucket.tokens >= float64(l.limits[key.class].Burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// allow checks whether the client may make a request to an endpoint class.
+func (l *RateLimiter) allow(subject string, class EndpointClass) error {
+	limit, ok := l.limits[class]
+	if !ok {
+		return nil
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := time.Now()
+
+	l.prune(now)
+
+	key := bucketKey{
+		subject: subject,
+		class:   class,
+	}
+
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{
+			tokens:  float64(limit.Burst),
+			updated: now,
+		}
+
+		l.buckets[key] = bucket
+	}
+
+	if wait := bucket.take(limit, now); wait > 0 {
+		return errors.NewRateLimitedError(wait, "%s request rate limit exceeded", class)
+	}
+
+	return nil
+}
+
+// remoteAddressSubject returns the subject used to rate limit a request by the address
+// it was sent from.
+func remoteAddressSubject(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	return "address:" + host
+}
+
+// LimitAuthentication checks, before a request is authenticated, whether its remote
+// address has made too many failed authentication attempts.  This protects the service
+// broker, and any identity provider it calls, from clients guessing credentials.
+func (l *RateLimiter) LimitAuthentication(r *http.Request) error {
+	limit, ok := l.limits[EndpointClassAuthentication]
+	if !ok {
+		return nil
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := time.Now()
+
+	l.prune(now)
+
+	bucket, ok := l.buckets[bucketKey{subject: remoteAddressSubject(r), class: EndpointClassAuthentication}]
+	if !ok {
+		return nil
+	}
+
+	bucket.replenish(limit, now)
+
+	if wait := bucket.wait(limit); wait > 0 {
+		return errors.NewRateLimitedError(wait, "%s request rate limit exceeded", EndpointClassAuthentication)
+	}
+
+	return nil
+}
+
+// AuthenticationFailed records a failed authentication attempt from a request's
+// remote address.
+func (l *RateLimiter) AuthenticationFailed(r *http.Request) {
+	_ = l.allow(remoteAddressSubject(r), EndpointClassAuthentication)
+}
+
+// Limit checks whether a request is allowed.  If so the returned function must be
+// called when the request has been handled.
+func (l *RateLimiter) Limit(r *http.Request) (func(), error) {
+	var subject string
+
+	if identity, ok := IdentityFromContext(r.Context()); ok {
+		subject = identity.Subject
+	}
+
+	if err := l.allow(subject, getEndpointClass(r)); err != nil {
+		return nil, err
+	}
+
+	if l.mutating == nil || !isMutatingRequest(r) {
+		return func() {}, nil
+	}
+
+	select {
+	case l.mutating <- nil:
+	default:
+		return nil, errors.NewRateLimitedError(mutatingRequestRetryAfter, "too many concurrent requests, %d in progress", cap(l.mutating))
+	}
+
+	return func() { <-l.mutating }, nil
+}
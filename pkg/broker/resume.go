@@ -0,0 +1,194 @@
+// Copyright 2020-2021 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file  except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the  License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	goerrors "errors"
+	"fmt"
+	"time"
+
+	"github.com/couchbase/service-broker/pkg/config"
+	"github.com/couchbase/service-broker/pkg/operation"
+	"github.com/couchbase/service-broker/pkg/provisioners"
+	"github.com/couchbase/service-broker/pkg/registry"
+)
+
+// ErrOperationInterrupted is reported when an operation was interrupted by a service
+// broker restart and cannot be resumed.
+var ErrOperationInterrupted = goerrors.New("operation interrupted")
+
+// ResumeOperations scans all registry entries for asynchronous operations that were
+// in flight when the service broker last stopped.  Where possible these are resumed,
+// otherwise they are failed so clients polling the operation see it complete.
+func ResumeOperations(configuration *ServerConfiguration) error {
+	namespaces, err := getRegistryNamespaces(configuration)
+	if err != nil {
+		return err
+	}
+
+	resourceTypes := map[registry.Type]provisioners.ResourceType{
+		registry.ServiceInstance: provisioners.ResourceTypeServiceInstance,
+		registry.ServiceBinding:  provisioners.ResourceTypeServiceBinding,
+	}
+
+	for _, namespace := range namespaces {
+		for registryType, resourceType := range resourceTypes {
+			entries, err := registry.List(registryType, namespace)
+			if err != nil {
+				return err
+			}
+
+			// A single entry that cannot be resumed must not prevent the service
+			// broker from starting, or other operations from being resumed.
+			for _, entry := range entries {
+				if err := resumeEntry(configuration.Scheduler, resourceType, entry); err != nil {
+					entry.Logger().Errorf("unable to resume %s operation: %v", resourceType, err)
+					failOperation(entry, err)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// resumeEntry resumes an individual operation, with the configuration locked, as
+// the catalog and templates are read.
+func resumeEntry(scheduler *operation.Scheduler, resourceType provisioners.ResourceType, entry *registry.Entry) error {
+	config.Lock()
+	defer config.Unlock()
+
+	return resumeOperation(scheduler, resourceType, entry)
+}
+
+// failOperation marks an operation that could not be resumed as failed, so it is no
+// longer considered in flight.  Clients polling the operation see it fail, if the
+// registry entry can still be updated, otherwise the operation is ended.
+func failOperation(entry *registry.Entry, cause error) {
+	if _, ok, err := entry.GetString(registry.Operation); err == nil && !ok {
+		return
+	}
+
+	if err := operation.Complete(entry, fmt.Errorf("%w: %v", ErrOperationInterrupted, cause)); err == nil {
+		return
+	}
+
+	if err := operation.End(entry); err != nil {
+		entry.Logger().Errorf("unable to end operation: %v", err)
+	}
+}
+
+// getRegistryNamespaces returns all namespaces that may contain registry entries.
+func getRegistryNamespaces(configuration *ServerConfiguration) ([]string, error) {
+	namespaces := []string{configuration.Namespace}
+
+	directory, err := registry.NewDirectory(configuration.Namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	direntNamespaces, err := directory.Namespaces()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, namespace := range direntNamespaces {
+		if namespace != configuration.Namespace {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+
+	return namespaces, nil
+}
+
+// resumeOperation resumes an individual operation, if one is in flight.
+func resumeOperation(scheduler *operation.Scheduler, resourceType provisioners.ResourceType, entry *registry.Entry) error {
+	op, ok, err := entry.GetString(registry.Operation)
+	if err != nil {
+		return err
+	}
+
+	// No operation in flight.
+	if !ok {
+		return nil
+	}
+
+	// Operation has completed, it's just waiting to be polled.
+	if _, ok, err := entry.GetString(registry.OperationStatus); err != nil || ok {
+		return err
+	}
+
+	var startTime time.Time
+
+	if _, err := entry.Get(registry.OperationStartTime, &startTime); err != nil {
+		return err
+	}
+
+	entry.Logger().Infof("resuming %s %s operation started at %v", resourceType, op, startTime)
+
+	// Without a configuration there are no templates to work with.
+	if config.Config() == nil {
+		return operation.Complete(entry, fmt.Errorf("%w: service broker unconfigured", ErrOperationInterrupted))
+	}
+
+	// Operations are serialized per service instance.
+	instanceID, ok, err := entry.GetString(registry.InstanceID)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return operation.Complete(entry, fmt.Errorf("%w: registry missing instance ID", ErrOperationInterrupted))
+	}
+
+	reservation, err := scheduler.Reserve()
+	if err != nil {
+		return operation.Complete(entry, err)
+	}
+
+	defer reservation.Release()
+
+	switch operation.Type(op) {
+	case operation.TypeProvision:
+		creator, err := provisioners.NewCreator(resourceType)
+		if err != nil {
+			return err
+		}
+
+		if err := creator.PrepareResume(entry); err != nil {
+			entry.Logger().Infof("unable to resume %s %s operation: %v", resourceType, op, err)
+			return operation.Complete(entry, err)
+		}
+
+		reservation.Submit(instanceID, func() { creator.Run(entry) })
+
+	case operation.TypeDeprovision:
+		deleter := provisioners.NewDeleter(resourceType)
+
+		if err := deleter.Prepare(entry); err != nil {
+			entry.Logger().Infof("unable to resume %s %s operation: %v", resourceType, op, err)
+			return operation.Complete(entry, err)
+		}
+
+		reservation.Submit(instanceID, func() { deleter.Run(entry) })
+
+	default:
+		// Updates depend on the original request, which is not persisted, so fail
+		// the operation and let the client retry.
+		return operation.Complete(entry, fmt.Errorf("%w: %s operation was interrupted by a service broker restart, please retry", ErrOperationInterrupted, op))
+	}
+
+	return nil
+}
@@ -0,0 +1,128 @@
+// Copyright 2020-2021 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file  except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the  License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"bytes"
+	"crypto/tls"
+	"sync"
+
+	"github.com/couchbase/service-broker/pkg/log"
+)
+
+const (
+	// DefaultListenAddress is the address the TLS API server listens on.
+	DefaultListenAddress = ":8443"
+)
+
+// KeyPair is a TLS certificate and private key loaded from files.  The files are
+// watched for changes, so certificates rotated by e.g. cert-manager are served
+// without restarting.
+type KeyPair struct {
+	// certificate is the PEM encoded certificate chain.
+	certificate *FileCredential
+
+	// key is the PEM encoded private key.
+	key *FileCredential
+
+	// lock protects the fields below.
+	lock sync.Mutex
+
+	// current is the last valid key pair loaded.
+	current *tls.Certificate
+
+	// certificateData and keyData are the file contents last parsed, used to
+	// detect when they have changed.
+	certificateData []byte
+	keyData         []byte
+}
+
+// NewKeyPair loads a TLS certificate and private key from files.
+func NewKeyPair(certificatePath, keyPath string) (*KeyPair, error) {
+	certificate, err := NewFileCredential(certificatePath)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := NewFileCredential(keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	k := &KeyPair{
+		certificate: certificate,
+		key:         key,
+	}
+
+	if _, err := k.load(); err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+// Credentials returns the files that need to be watched for changes.
+func (k *KeyPair) Credentials() []*FileCredential {
+	return []*FileCredential{
+		k.certificate,
+		k.key,
+	}
+}
+
+// load returns the current key pair, parsing it again if the files have changed.
+// The certificate and key files are not updated atomically, so if they fail to
+// parse, e.g. the certificate has been updated but not yet the key, the last
+// valid key pair is retained.
+func (k *KeyPair) load() (*tls.Certificate, error) {
+	certificateData := k.certificate.Value()
+	keyData := k.key.Value()
+
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	if k.current != nil && bytes.Equal(certificateData, k.certificateData) && bytes.Equal(keyData, k.keyData) {
+		return k.current, nil
+	}
+
+	certificate, err := tls.X509KeyPair(certificateData, keyData)
+	if err != nil {
+		if k.current == nil {
+			return nil, err
+		}
+
+		log.New(log.SubsystemAuth).Warningf("failed to load TLS key pair %s: %v", k.certificate.path, err)
+
+		k.certificateData = certificateData
+		k.keyData = keyData
+
+		return k.current, nil
+	}
+
+	if k.current != nil {
+		log.New(log.SubsystemAuth).Infof("TLS key pair %s reloaded", k.certificate.path)
+	}
+
+	k.current = &certificate
+	k.certificateData = certificateData
+	k.keyData = keyData
+
+	return k.current, nil
+}
+
+// GetCertificate returns the newest valid key pair, and is intended to be used
+// as tls.Config.GetCertificate.
+func (k *KeyPair) GetCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return k.load()
+}
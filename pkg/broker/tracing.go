@@ -0,0 +1,66 @@
+// Copyright 2020-2021 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file  except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the  License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"net/http"
+
+	"github.com/couchbase/service-broker/pkg/tracing"
+)
+
+const (
+	// traceParentHeader is the W3C trace context header, used by a platform to
+	// include the service broker in its own traces.
+	traceParentHeader = "traceparent"
+)
+
+// startRequestSpan starts a span for an API request, continuing the client's trace
+// if it sent one.  The returned request has the span attached to its context.
+func startRequestSpan(r *http.Request, route string) (*http.Request, *tracing.Span) {
+	ctx := r.Context()
+
+	if spanContext, ok := tracing.ParseTraceParent(r.Header.Get(traceParentHeader)); ok {
+		ctx = tracing.ContextWithSpanContext(ctx, spanContext)
+	}
+
+	attributes := []tracing.Attribute{
+		tracing.String("http.method", r.Method),
+		tracing.String("http.target", r.URL.Path),
+	}
+
+	if requestID, ok := ctx.Value(requestIdentityKey{}).(string); ok {
+		attributes = append(attributes, tracing.String("service_broker.request_id", requestID))
+	}
+
+	ctx, span := tracing.StartServer(ctx, route, attributes...)
+
+	return r.WithContext(ctx), span
+}
+
+// endRequestSpan records the response status and ends the span.
+func endRequestSpan(span *tracing.Span, status int) {
+	// Handlers that write a body without a status code implicitly return OK.
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	span.SetAttributes(tracing.Int("http.status_code", status))
+
+	if status >= http.StatusInternalServerError {
+		span.SetError(http.StatusText(status))
+	}
+
+	span.End()
+}
@@ -18,21 +18,24 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"net/http"
 	"net/url"
+	"reflect"
+	"strconv"
 	"strings"
 
 	"github.com/couchbase/service-broker/pkg/api"
 	v1 "github.com/couchbase/service-broker/pkg/apis/servicebroker/v1alpha1"
 	"github.com/couchbase/service-broker/pkg/errors"
 	"github.com/couchbase/service-broker/pkg/log"
+	"github.com/couchbase/service-broker/pkg/operation"
 	"github.com/couchbase/service-broker/pkg/registry"
 
 	"github.com/go-openapi/jsonpointer"
 	"github.com/go-openapi/spec"
 	"github.com/go-openapi/strfmt"
 	"github.com/go-openapi/validate"
-	"github.com/golang/glog"
 
 	"k8s.io/apimachinery/pkg/runtime"
 )
@@ -51,7 +54,8 @@ func jsonRequest(r *http.Request, data interface{}) error {
 		return fmt.Errorf("unable to read body: %w", err)
 	}
 
-	glog.V(log.LevelDebug).Infof("JSON req: %s", string(body))
+	// Parameters may contain sensitive values e.g. passwords.
+	log.FromContext(r.Context()).Debugf("JSON req: %s", log.RedactJSON(body, "/parameters"))
 
 	if err := json.Unmarshal(body, data); err != nil {
 		return errors.NewParameterError("unable to unmarshal body: %v", err)
@@ -65,18 +69,19 @@ func jsonRequest(r *http.Request, data interface{}) error {
 func JSONResponse(w http.ResponseWriter, status int, data interface{}) {
 	resp, err := json.Marshal(data)
 	if err != nil {
-		glog.Infof("failed to marshal body: %v", err)
+		log.New(log.SubsystemAPI).Infof("failed to marshal body: %v", err)
 		httpResponse(w, http.StatusInternalServerError)
 	}
 
-	glog.V(log.LevelDebug).Infof("JSON rsp: %s", string(resp))
+	// Binding credentials are, by definition, sensitive.
+	log.New(log.SubsystemAPI).Debugf("JSON rsp: %s", log.RedactJSON(resp, "/credentials"))
 
 	w.Header().Set("Content-Type", "application/json")
 
 	httpResponse(w, status)
 
 	if _, err := w.Write(resp); err != nil {
-		glog.Infof("error writing response: %v", err)
+		log.New(log.SubsystemAPI).Infof("error writing response: %v", err)
 	}
 }
 
@@ -99,6 +104,16 @@ func translateError(err error) (int, api.ErrorType) {
 		return http.StatusNotFound, api.ErrorResourceNotFound
 	case errors.IsResourceGoneError(err):
 		return http.StatusGone, api.ErrorResourceGone
+	case errors.IsMaintenanceInfoConflictError(err):
+		return http.StatusUnprocessableEntity, api.ErrorMaintenanceInfoConflict
+	case errors.IsBusyError(err):
+		return http.StatusServiceUnavailable, api.ErrorBusy
+	case errors.IsConcurrencyError(err):
+		return http.StatusUnprocessableEntity, api.ErrorConcurrencyError
+	case errors.IsForbiddenError(err):
+		return http.StatusForbidden, api.ErrorForbidden
+	case errors.IsRateLimitedError(err):
+		return http.StatusTooManyRequests, api.ErrorRateLimited
 	default:
 		return http.StatusInternalServerError, api.ErrorInternalServerError
 	}
@@ -107,6 +122,16 @@ func translateError(err error) (int, api.ErrorType) {
 // jsonError is a helper method to return an error back to the client.
 func jsonError(w http.ResponseWriter, err error) {
 	status, apiError := translateError(err)
+
+	// Tell the client when it's worth trying again.
+	if errors.IsBusyError(err) {
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds))
+	}
+
+	if errors.IsRateLimitedError(err) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(errors.RetryAfter(err).Seconds()))))
+	}
+
 	e := &api.Error{
 		Error:       apiError,
 		Description: err.Error(),
@@ -180,6 +205,17 @@ func asyncRequired(r *http.Request) error {
 	return nil
 }
 
+// asyncAccepted is called when the handler optionally supports async requests.
+// Unlike asyncRequired, the client not supporting async requests is not an error.
+func asyncAccepted(r *http.Request) (bool, error) {
+	acceptsIncomplete, ok, err := maygetSingleParameter(r, "accepts_incomplete")
+	if err != nil {
+		return false, err
+	}
+
+	return ok && acceptsIncomplete == "true", nil
+}
+
 // getServiceOffering returns the service offering for a given service offering ID.
 func getServiceOffering(config *v1.ServiceBrokerConfig, serviceID string) (*v1.ServiceOffering, error) {
 	for index, service := range config.Spec.Catalog.Services {
@@ -216,6 +252,44 @@ func validateServicePlan(config *v1.ServiceBrokerConfig, serviceID, planID strin
 	return nil
 }
 
+// validateMaintenanceInfo checks that any maintenance info provided by the client matches
+// that advertised by the service plan.
+func validateMaintenanceInfo(config *v1.ServiceBrokerConfig, serviceID, planID string, maintenanceInfo *api.MaintenanceInfo) error {
+	if maintenanceInfo == nil {
+		return nil
+	}
+
+	plan, err := getServicePlan(config, serviceID, planID)
+	if err != nil {
+		return err
+	}
+
+	if plan.MaintenanceInfo == nil {
+		return errors.NewMaintenanceInfoConflictError("service plan %s does not support maintenance info", planID)
+	}
+
+	if maintenanceInfo.Version != plan.MaintenanceInfo.Version {
+		return errors.NewMaintenanceInfoConflictError("maintenance info version %s does not match service plan version %s", maintenanceInfo.Version, plan.MaintenanceInfo.Version)
+	}
+
+	return nil
+}
+
+// getMaintenanceInfoVersion returns the maintenance info version of a service plan if one
+// is defined.
+func getMaintenanceInfoVersion(config *v1.ServiceBrokerConfig, serviceID, planID string) (string, bool, error) {
+	plan, err := getServicePlan(config, serviceID, planID)
+	if err != nil {
+		return "", false, err
+	}
+
+	if plan.MaintenanceInfo == nil {
+		return "", false, nil
+	}
+
+	return plan.MaintenanceInfo.Version, true, nil
+}
+
 // schemaType is the type of schema we are referring to, either for a service instance
 // or a service binding.
 type schemaType string
@@ -322,6 +396,43 @@ func planUpdatable(config *v1.ServiceBrokerConfig, serviceID, planID, newPlanID
 	return nil
 }
 
+// registryScope returns the registry scope of a configuration binding, applying the
+// default.
+func registryScope(binding *v1.ConfigurationBinding) v1.RegistryScope {
+	if binding.RegistryScope == "" {
+		return v1.RegistryScopeBrokerLocal
+	}
+
+	return binding.RegistryScope
+}
+
+// registryUnchanged returns an error if a plan change would move the service instance's
+// registry, as the existing registry entries would no longer be found.
+func registryUnchanged(config *v1.ServiceBrokerConfig, serviceID, planID, newPlanID string) error {
+	if planID == newPlanID {
+		return nil
+	}
+
+	binding, err := config.GetTemplateBindings(serviceID, planID)
+	if err != nil {
+		return err
+	}
+
+	newBinding, err := config.GetTemplateBindings(serviceID, newPlanID)
+	if err != nil {
+		return err
+	}
+
+	if registryScope(binding) != registryScope(newBinding) ||
+		binding.RegistryNamespace != newBinding.RegistryNamespace ||
+		binding.RegistryPrefix != newBinding.RegistryPrefix ||
+		!reflect.DeepEqual(binding.RegistryEnabledOrganizations, newBinding.RegistryEnabledOrganizations) {
+		return errors.NewParameterError("service plan %s for service %s uses a different registry scope to service plan %s", newPlanID, serviceID, planID)
+	}
+
+	return nil
+}
+
 // verifyBindable returns an error if the plan cannot be bound to.
 func verifyBindable(config *v1.ServiceBrokerConfig, serviceID, planID string) error {
 	service, err := getServiceOffering(config, serviceID)
@@ -354,13 +465,13 @@ func getNamespace(context *runtime.RawExtension, namespace string) (string, erro
 		var ctx interface{}
 
 		if err := json.Unmarshal(context.Raw, &ctx); err != nil {
-			glog.Infof("unmarshal of client context failed: %v", err)
+			log.New(log.SubsystemAPI).Infof("unmarshal of client context failed: %v", err)
 			return "", err
 		}
 
 		pointer, err := jsonpointer.New("/namespace")
 		if err != nil {
-			glog.Infof("failed to parse JSON pointer: %v", err)
+			log.New(log.SubsystemAPI).Infof("failed to parse JSON pointer: %v", err)
 			return "", err
 		}
 
@@ -371,7 +482,7 @@ func getNamespace(context *runtime.RawExtension, namespace string) (string, erro
 				return namespace, nil
 			}
 
-			glog.Infof("request context namespace not a string")
+			log.New(log.SubsystemAPI).Infof("request context namespace not a string")
 
 			return "", errors.NewParameterError("request context namespace not a string")
 		}
@@ -384,13 +495,13 @@ func getContextValue(context *runtime.RawExtension, key string) (string, error)
 	var ctx interface{}
 
 	if err := json.Unmarshal(context.Raw, &ctx); err != nil {
-		glog.Infof("unmarshal of client context failed: %v", err)
+		log.New(log.SubsystemAPI).Infof("unmarshal of client context failed: %v", err)
 		return "", err
 	}
 
 	pointer, err := jsonpointer.New("/" + key)
 	if err != nil {
-		glog.Infof("failed to parse JSON pointer: %v", err)
+		log.New(log.SubsystemAPI).Infof("failed to parse JSON pointer: %v", err)
 		return "", err
 	}
 
@@ -398,7 +509,7 @@ func getContextValue(context *runtime.RawExtension, key string) (string, error)
 
 	value, ok := v.(string)
 	if !ok {
-		glog.Infof("request context " + key + " not a string")
+		log.New(log.SubsystemAPI).Infof("request context %s not a string", key)
 
 		return "", errors.NewParameterError("request context " + key + " not a string")
 	}
@@ -409,13 +520,13 @@ func setContextValue(context *runtime.RawExtension, key string, value string) er
 	var ctx interface{}
 
 	if err := json.Unmarshal(context.Raw, &ctx); err != nil {
-		glog.Infof("unmarshal of client context failed: %v", err)
+		log.New(log.SubsystemAPI).Infof("unmarshal of client context failed: %v", err)
 		return err
 	}
 
 	pointer, err := jsonpointer.New("/" + key)
 	if err != nil {
-		glog.Infof("failed to parse JSON pointer: %v", err)
+		log.New(log.SubsystemAPI).Infof("failed to parse JSON pointer: %v", err)
 		return err
 	}
 	ctx, err = pointer.Set(ctx, value)
@@ -557,3 +668,31 @@ func deleteDirectoryInstance(namespace, instanceID string) {
 
 	_ = directory.Remove(instanceID)
 }
+
+// getDeprovisionOperation returns the operation ID of a deprovision operation in progress.
+// Any other type of operation in progress is treated as a conflict.
+func getDeprovisionOperation(entry *registry.Entry) (string, bool, error) {
+	operationType, ok, err := entry.GetString(registry.Operation)
+	if err != nil {
+		return "", false, err
+	}
+
+	if !ok {
+		return "", false, nil
+	}
+
+	if operation.Type(operationType) != operation.TypeDeprovision {
+		return "", false, errors.NewResourceConflictError("existing %v operation in progress", operationType)
+	}
+
+	operationID, ok, err := entry.GetString(registry.OperationID)
+	if err != nil {
+		return "", false, err
+	}
+
+	if !ok {
+		return "", false, fmt.Errorf("%w: missing operation ID", ErrUnexpected)
+	}
+
+	return operationID, true, nil
+}
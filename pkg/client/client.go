@@ -19,8 +19,8 @@ import (
 	"time"
 
 	"github.com/couchbase/service-broker/generated/clientset/servicebroker"
-
-	"github.com/golang/glog"
+	"github.com/couchbase/service-broker/pkg/log"
+	"github.com/couchbase/service-broker/pkg/metrics"
 
 	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/client-go/dynamic"
@@ -146,11 +146,13 @@ func refresh(c *clientsImpl) {
 
 		mapper, err := getRESTMapper(c.kubernetes)
 		if err != nil {
-			glog.Warningf("failed to refersh REST mapper: %v", err)
+			log.New(log.SubsystemClient).Warningf("failed to refresh REST mapper: %v", err)
+			metrics.RESTMapperRefreshFailures.Inc()
+
 			continue
 		}
 
-		glog.Info("refreshed REST mapper")
+		log.New(log.SubsystemClient).Infof("refreshed REST mapper")
 
 		c.lock.Lock()
 		c.mapper = mapper
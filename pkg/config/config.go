@@ -20,6 +20,7 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+	"strconv"
 	"sync"
 	"time"
 
@@ -27,7 +28,6 @@ import (
 	v1 "github.com/couchbase/service-broker/pkg/apis/servicebroker/v1alpha1"
 	"github.com/couchbase/service-broker/pkg/client"
 	"github.com/couchbase/service-broker/pkg/log"
-	"github.com/golang/glog"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/tools/cache"
@@ -44,6 +44,11 @@ var (
 	// by flags for the main binary.
 	ConfigurationName = ConfigurationNameDefault
 
+	// TokenReviewRequired is set when TokenReview authentication is in use, so the
+	// configuration must define which clients are allowed to use the API.  This is
+	// overridden by flags for the main binary.
+	TokenReviewRequired bool
+
 	// ErrCacheSync is raised when a shared informer failed to synchronize.
 	ErrCacheSync = errors.New("cache synchronization error")
 )
@@ -71,20 +76,22 @@ var c *configuration
 // createHandler add the service broker configuration when the underlying
 // resource is created.
 func createHandler(obj interface{}) {
+	logger := log.New(log.SubsystemConfig)
+
 	brokerConfiguration, ok := obj.(*v1.ServiceBrokerConfig)
 	if !ok {
-		glog.Error("unexpected object type in config add")
+		logger.Errorf("unexpected object type in config add")
 		return
 	}
 
 	if brokerConfiguration.Name != ConfigurationName {
-		glog.V(log.LevelDebug).Info("unexpected object name in config delete:", brokerConfiguration.Name)
+		logger.Debugf("unexpected object name in config delete: %s", brokerConfiguration.Name)
 		return
 	}
 
 	if err := updateStatus(brokerConfiguration); err != nil {
-		glog.Info("service broker configuration invalid, see resource status for details")
-		glog.V(1).Info(err)
+		logger.Infof("service broker configuration invalid, see resource status for details")
+		logger.Debugf("%v", err)
 
 		c.lock.Lock()
 		defer c.lock.Unlock()
@@ -94,13 +101,10 @@ func createHandler(obj interface{}) {
 		return
 	}
 
-	glog.Info("service broker configuration created, service ready")
+	logger.Infof("service broker configuration created, service ready")
 
-	if glog.V(1) {
-		object, err := json.Marshal(brokerConfiguration)
-		if err == nil {
-			glog.V(1).Info(string(object))
-		}
+	if logger.DebugEnabled() {
+		logger.Debugf("%s", redactConfiguration(brokerConfiguration))
 	}
 
 	c.lock.Lock()
@@ -111,20 +115,22 @@ func createHandler(obj interface{}) {
 // updateHandler modifies the service broker configuration when the underlying
 // resource updates.
 func updateHandler(oldObj, newObj interface{}) {
+	logger := log.New(log.SubsystemConfig)
+
 	brokerConfiguration, ok := newObj.(*v1.ServiceBrokerConfig)
 	if !ok {
-		glog.Error("unexpected object type in config update")
+		logger.Errorf("unexpected object type in config update")
 		return
 	}
 
 	if brokerConfiguration.Name != ConfigurationName {
-		glog.V(log.LevelDebug).Info("unexpected object name in config update:", brokerConfiguration.Name)
+		logger.Debugf("unexpected object name in config update: %s", brokerConfiguration.Name)
 		return
 	}
 
 	if err := updateStatus(brokerConfiguration); err != nil {
-		glog.Info("service broker configuration invalid, see resource status for details")
-		glog.V(1).Info(err)
+		logger.Infof("service broker configuration invalid, see resource status for details")
+		logger.Debugf("%v", err)
 
 		c.lock.Lock()
 		defer c.lock.Unlock()
@@ -134,13 +140,10 @@ func updateHandler(oldObj, newObj interface{}) {
 		return
 	}
 
-	glog.Info("service broker configuration updated")
+	logger.Infof("service broker configuration updated")
 
-	if glog.V(1) {
-		object, err := json.Marshal(brokerConfiguration)
-		if err == nil {
-			glog.V(1).Info(string(object))
-		}
+	if logger.DebugEnabled() {
+		logger.Debugf("%s", redactConfiguration(brokerConfiguration))
 	}
 
 	c.lock.Lock()
@@ -152,28 +155,51 @@ func updateHandler(oldObj, newObj interface{}) {
 // deleteHandler deletes the service broker configuration when the underlying
 // resource is deleted.
 func deleteHandler(obj interface{}) {
+	logger := log.New(log.SubsystemConfig)
+
 	brokerConfiguration, ok := obj.(*v1.ServiceBrokerConfig)
 	if !ok {
-		glog.Error("unexpected object type in config delete")
+		logger.Errorf("unexpected object type in config delete")
 		return
 	}
 
 	if brokerConfiguration.Name != ConfigurationName {
-		glog.V(log.LevelDebug).Info("unexpected object name in config delete:", brokerConfiguration.Name)
+		logger.Debugf("unexpected object name in config delete: %s", brokerConfiguration.Name)
 		return
 	}
 
-	glog.Info("service broker configuration deleted, service unready")
+	logger.Infof("service broker configuration deleted, service unready")
 
 	c.lock.Lock()
 	c.config = nil
 	c.lock.Unlock()
 }
 
+// redactConfiguration returns the configuration as JSON for logging, with the
+// dashboard client secrets and sensitive template fields masked.
+func redactConfiguration(config *v1.ServiceBrokerConfig) string {
+	pointers := []string{
+		"/spec/catalog/services/*/dashboardClient/secret",
+	}
+
+	for i, template := range config.Spec.Templates {
+		for _, field := range template.SensitiveFields {
+			pointers = append(pointers, "/spec/templates/"+strconv.Itoa(i)+"/template"+field)
+		}
+	}
+
+	object, err := json.Marshal(config)
+	if err != nil {
+		return log.Mask
+	}
+
+	return log.RedactJSON(object, pointers...)
+}
+
 // Configure initializes global configuration and must be called before starting
 // the API service.
 func Configure(clients client.Clients, namespace string) error {
-	glog.Info("configuring service broker")
+	log.New(log.SubsystemConfig).Infof("configuring service broker")
 
 	// Create the global configuration structure.
 	c = &configuration{
@@ -274,7 +300,7 @@ func updateStatus(config *v1.ServiceBrokerConfig) error {
 	newConfig.Status = status
 
 	if _, err := c.clients.Broker().ServicebrokerV1alpha1().ServiceBrokerConfigs(newConfig.Namespace).Update(context.TODO(), newConfig, metav1.UpdateOptions{}); err != nil {
-		glog.Infof("failed to update service broker configuration status: %v", err)
+		log.New(log.SubsystemConfig).Infof("failed to update service broker configuration status: %v", err)
 		return rerr
 	}
 
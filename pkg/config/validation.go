@@ -17,6 +17,7 @@ package config
 import (
 	"errors"
 	"fmt"
+	"strings"
 
 	v1 "github.com/couchbase/service-broker/pkg/apis/servicebroker/v1alpha1"
 )
@@ -49,6 +50,11 @@ func getTemplateByName(config *v1.ServiceBrokerConfig, templateName string) *v1.
 // validate does any validation that cannot be performed by the JSON schema
 // included in the CRD.
 func validate(config *v1.ServiceBrokerConfig) error {
+	// TokenReview authentication would otherwise silently deny every client.
+	if TokenReviewRequired && config.Spec.TokenReview == nil {
+		return fmt.Errorf("%w: tokenReview must be specified when using tokenreview authentication", ErrConfigurationInvalid)
+	}
+
 	// Check that service offerings and plans are bound properly to configuration.
 	for _, service := range config.Spec.Catalog.Services {
 		for _, plan := range service.Plans {
@@ -74,6 +80,15 @@ func validate(config *v1.ServiceBrokerConfig) error {
 		}
 	}
 
+	// Sensitive fields must be JSON pointers into the template.
+	for _, template := range config.Spec.Templates {
+		for _, field := range template.SensitiveFields {
+			if !strings.HasPrefix(field, "/") {
+				return fmt.Errorf("%w: template '%s' sensitive field '%s' must be a JSON pointer", ErrConfigurationInvalid, template.Name, field)
+			}
+		}
+	}
+
 	// Check that configuration bindings are properly configured.
 	for _, binding := range config.Spec.Bindings {
 		// Bindings cannot do nothing.
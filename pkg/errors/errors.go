@@ -16,6 +16,7 @@ package errors
 
 import (
 	"fmt"
+	"time"
 )
 
 // configurationError errors are raised when the configuration is incorrect e.g. the
@@ -215,3 +216,139 @@ func IsResourceGoneError(err error) bool {
 func (e *resourceGoneError) Error() string {
 	return e.message
 }
+
+// maintenanceInfoConflictError errors are raised when the maintenance info in
+// a request does not match that of the service plan.
+type maintenanceInfoConflictError struct {
+	message string
+}
+
+// NewMaintenanceInfoConflictError returns a new maintenance info conflict error formatted like fmt.Errorf.
+func NewMaintenanceInfoConflictError(message string, arguments ...interface{}) error {
+	return &maintenanceInfoConflictError{message: fmt.Sprintf(message, arguments...)}
+}
+
+// IsMaintenanceInfoConflictError returns whether an error is a maintenance info conflict error.
+func IsMaintenanceInfoConflictError(err error) bool {
+	if _, ok := err.(*maintenanceInfoConflictError); !ok {
+		return false
+	}
+
+	return true
+}
+
+// Error returns the maintenance info conflict error string.
+func (e *maintenanceInfoConflictError) Error() string {
+	return e.message
+}
+
+// busyError errors are raised when the service broker is too busy to accept
+// a request.
+type busyError struct {
+	message string
+}
+
+// NewBusyError returns a new busy error formatted like fmt.Errorf.
+func NewBusyError(message string, arguments ...interface{}) error {
+	return &busyError{message: fmt.Sprintf(message, arguments...)}
+}
+
+// IsBusyError returns whether an error is a busy error.
+func IsBusyError(err error) bool {
+	if _, ok := err.(*busyError); !ok {
+		return false
+	}
+
+	return true
+}
+
+// Error returns the busy error string.
+func (e *busyError) Error() string {
+	return e.message
+}
+
+// concurrencyError errors are raised when a request conflicts with an operation
+// already in progress.
+type concurrencyError struct {
+	message string
+}
+
+// NewConcurrencyError returns a new concurrency error formatted like fmt.Errorf.
+func NewConcurrencyError(message string, arguments ...interface{}) error {
+	return &concurrencyError{message: fmt.Sprintf(message, arguments...)}
+}
+
+// IsConcurrencyError returns whether an error is a concurrency error.
+func IsConcurrencyError(err error) bool {
+	if _, ok := err.(*concurrencyError); !ok {
+		return false
+	}
+
+	return true
+}
+
+// Error returns the concurrency error string.
+func (e *concurrencyError) Error() string {
+	return e.message
+}
+
+// forbiddenError errors are raised when the client is not allowed to perform
+// a request e.g. use a service plan.
+type forbiddenError struct {
+	message string
+}
+
+// NewForbiddenError returns a new forbidden error formatted like fmt.Errorf.
+func NewForbiddenError(message string, arguments ...interface{}) error {
+	return &forbiddenError{message: fmt.Sprintf(message, arguments...)}
+}
+
+// IsForbiddenError returns whether an error is a forbidden error.
+func IsForbiddenError(err error) bool {
+	if _, ok := err.(*forbiddenError); !ok {
+		return false
+	}
+
+	return true
+}
+
+// Error returns the forbidden error string.
+func (e *forbiddenError) Error() string {
+	return e.message
+}
+
+// rateLimitedError errors are raised when a client has made too many requests.
+type rateLimitedError struct {
+	message string
+
+	// retryAfter is how long the client should wait before trying again.
+	retryAfter time.Duration
+}
+
+// NewRateLimitedError returns a new rate limited error formatted like fmt.Errorf.
+func NewRateLimitedError(retryAfter time.Duration, message string, arguments ...interface{}) error {
+	return &rateLimitedError{message: fmt.Sprintf(message, arguments...), retryAfter: retryAfter}
+}
+
+// IsRateLimitedError returns whether an error is a rate limited error.
+func IsRateLimitedError(err error) bool {
+	if _, ok := err.(*rateLimitedError); !ok {
+		return false
+	}
+
+	return true
+}
+
+// RetryAfter returns how long a client should wait before retrying a rate limited request.
+func RetryAfter(err error) time.Duration {
+	if e, ok := err.(*rateLimitedError); ok {
+		return e.retryAfter
+	}
+
+	return 0
+}
+
+// Error returns the rate limited error string.
+func (e *rateLimitedError) Error() string {
+	return e.message
+}
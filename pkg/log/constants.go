@@ -14,8 +14,57 @@
 
 package log
 
+// Level is the severity of a log message.
+type Level int
+
+const (
+	// LevelDebug is for messages that are not necessary for problem diagnosis,
+	// but internal debugging.
+	LevelDebug Level = iota
+
+	// LevelInfo is for messages that describe normal operation.
+	LevelInfo
+
+	// LevelWarning is for problems that the service broker can recover from.
+	LevelWarning
+
+	// LevelError is for problems that the service broker cannot recover from.
+	LevelError
+)
+
+// Subsystems allow the verbosity of logging to be controlled for each part of
+// the service broker independently.
 const (
-	// LevelDebug is for logs to be emitted at -v 1.
-	// These are not necessary for problem diagnosis, but internal debugging.
-	LevelDebug = 1
+	// SubsystemAPI logs API requests and responses.
+	SubsystemAPI = "api"
+
+	// SubsystemAuth logs authentication, credentials and TLS certificates.
+	SubsystemAuth = "auth"
+
+	// SubsystemClient logs Kubernetes client activity.
+	SubsystemClient = "client"
+
+	// SubsystemConfig logs service broker configuration changes.
+	SubsystemConfig = "config"
+
+	// SubsystemMain logs service broker start up.
+	SubsystemMain = "main"
+
+	// SubsystemOperation logs service instance and binding operations, including
+	// template rendering and resource creation.
+	SubsystemOperation = "operation"
+
+	// SubsystemTracing logs trace exporting.
+	SubsystemTracing = "tracing"
 )
+
+// Subsystems is the set of all subsystems.
+var Subsystems = []string{
+	SubsystemAPI,
+	SubsystemAuth,
+	SubsystemClient,
+	SubsystemConfig,
+	SubsystemMain,
+	SubsystemOperation,
+	SubsystemTracing,
+}
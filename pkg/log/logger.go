@@ -0,0 +1,289 @@
+// Copyright 2020-2021 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file  except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the  License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrLevelInvalid is returned when a log level cannot be parsed.
+var ErrLevelInvalid = errors.New("invalid log level")
+
+// levelNames maps from levels to their names in log messages and configuration.
+var levelNames = map[Level]string{
+	LevelDebug:   "debug",
+	LevelInfo:    "info",
+	LevelWarning: "warning",
+	LevelError:   "error",
+}
+
+// String returns the level name.
+func (l Level) String() string {
+	return levelNames[l]
+}
+
+// ParseLevel parses a level name.
+func ParseLevel(name string) (Level, error) {
+	for level, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return level, nil
+		}
+	}
+
+	return LevelInfo, fmt.Errorf("%w: %s", ErrLevelInvalid, name)
+}
+
+// output is where log messages are written to, and which are written.
+var output = struct {
+	// lock protects the fields below, and serializes writes.
+	lock sync.Mutex
+
+	// writer is where log messages are written.
+	writer io.Writer
+
+	// level is the minimum level logged for subsystems not in levels.
+	level Level
+
+	// levels is the minimum level logged for each subsystem.
+	levels map[string]Level
+}{
+	writer: os.Stderr,
+	level:  LevelInfo,
+	levels: map[string]Level{},
+}
+
+// SetOutput sets where log messages are written to.
+func SetOutput(writer io.Writer) {
+	output.lock.Lock()
+	defer output.lock.Unlock()
+
+	output.writer = writer
+}
+
+// SetLevel sets the minimum level logged for all subsystems without their own level.
+func SetLevel(level Level) {
+	output.lock.Lock()
+	defer output.lock.Unlock()
+
+	output.level = level
+}
+
+// SetSubsystemLevel sets the minimum level logged for a subsystem.
+func SetSubsystemLevel(subsystem string, level Level) {
+	output.lock.Lock()
+	defer output.lock.Unlock()
+
+	output.levels[subsystem] = level
+}
+
+// Enabled returns whether messages of the given level are logged for a subsystem.
+func Enabled(subsystem string, level Level) bool {
+	output.lock.Lock()
+	defer output.lock.Unlock()
+
+	return enabled(subsystem, level)
+}
+
+// enabled returns whether messages of the given level are logged for a subsystem.
+// This must be called with the lock held.
+func enabled(subsystem string, level Level) bool {
+	threshold, ok := output.levels[subsystem]
+	if !ok {
+		threshold = output.level
+	}
+
+	return level >= threshold
+}
+
+// field is a key value pair attached to all messages from a logger.
+type field struct {
+	key   string
+	value interface{}
+}
+
+// Logger writes structured log messages, as JSON, for a subsystem.  Loggers may
+// have fields attached, e.g. the request identity, so a single request can be
+// traced through the logs, and a redactor to mask sensitive values.  Loggers are
+// immutable, so may be shared.
+type Logger struct {
+	// subsystem is the part of the service broker the logger is for.
+	subsystem string
+
+	// fields are attached to all log messages.
+	fields []field
+
+	// redactor, if set, masks sensitive values in log messages.
+	redactor *Redactor
+}
+
+// New returns a logger for a subsystem.
+func New(subsystem string) *Logger {
+	return &Logger{
+		subsystem: subsystem,
+	}
+}
+
+// clone returns a copy of the logger that can be modified.
+func (l *Logger) clone() *Logger {
+	return &Logger{
+		subsystem: l.subsystem,
+		fields:    append([]field{}, l.fields...),
+		redactor:  l.redactor,
+	}
+}
+
+// With returns a new logger that attaches a field to all log messages.
+func (l *Logger) With(key string, value interface{}) *Logger {
+	logger := l.clone()
+	logger.fields = append(logger.fields, field{key: key, value: value})
+
+	return logger
+}
+
+// WithRequestID returns a new logger that tags log messages with the request
+// identity they relate to.  If the request identity is empty messages are not tagged.
+func (l *Logger) WithRequestID(requestID string) *Logger {
+	if requestID == "" {
+		return l
+	}
+
+	return l.With("request_id", requestID)
+}
+
+// WithSubsystem returns a new logger for a different subsystem, retaining any fields.
+func (l *Logger) WithSubsystem(subsystem string) *Logger {
+	logger := l.clone()
+	logger.subsystem = subsystem
+
+	return logger
+}
+
+// WithRedactor returns a new logger that masks sensitive values known to the redactor.
+func (l *Logger) WithRedactor(redactor *Redactor) *Logger {
+	logger := l.clone()
+	logger.redactor = redactor
+
+	return logger
+}
+
+// DebugEnabled returns whether debug messages are logged, so expensive debug
+// messages can be avoided.
+func (l *Logger) DebugEnabled() bool {
+	return Enabled(l.subsystem, LevelDebug)
+}
+
+// Debugf logs a formatted message at debug level.
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	l.log(LevelDebug, format, args)
+}
+
+// Infof logs a formatted message at info level.
+func (l *Logger) Infof(format string, args ...interface{}) {
+	l.log(LevelInfo, format, args)
+}
+
+// Warningf logs a formatted message at warning level.
+func (l *Logger) Warningf(format string, args ...interface{}) {
+	l.log(LevelWarning, format, args)
+}
+
+// Errorf logs a formatted message at error level.
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.log(LevelError, format, args)
+}
+
+// log formats and writes out a log message, as a single line of JSON, if the
+// level is enabled for the logger's subsystem.
+func (l *Logger) log(level Level, format string, args []interface{}) {
+	if !Enabled(l.subsystem, level) {
+		return
+	}
+
+	message := fmt.Sprintf(format, args...)
+
+	if l.redactor != nil {
+		message = l.redactor.Redact(message)
+	}
+
+	buffer := &bytes.Buffer{}
+
+	buffer.WriteString(`{"time":`)
+	writeJSON(buffer, time.Now().UTC().Format(time.RFC3339Nano))
+	buffer.WriteString(`,"level":`)
+	writeJSON(buffer, level.String())
+	buffer.WriteString(`,"subsystem":`)
+	writeJSON(buffer, l.subsystem)
+	buffer.WriteString(`,"message":`)
+	writeJSON(buffer, message)
+
+	for _, f := range l.fields {
+		buffer.WriteString(",")
+		writeJSON(buffer, f.key)
+		buffer.WriteString(":")
+
+		value := f.value
+
+		if s, ok := value.(string); ok && l.redactor != nil {
+			value = l.redactor.Redact(s)
+		}
+
+		writeJSON(buffer, value)
+	}
+
+	buffer.WriteString("}\n")
+
+	output.lock.Lock()
+	defer output.lock.Unlock()
+
+	_, _ = output.writer.Write(buffer.Bytes())
+}
+
+// writeJSON writes a JSON encoded value, falling back to a string if it cannot be
+// encoded, so a log message is never lost.
+func writeJSON(buffer *bytes.Buffer, value interface{}) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(value))
+	}
+
+	buffer.Write(data)
+}
+
+// loggerKey is used to store the logger in a request context.
+type loggerKey struct{}
+
+// NewContext returns a new context containing the logger.
+func NewContext(ctx context.Context, logger *Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// FromContext returns the logger from a context, or an API logger that does not tag
+// messages if none exists.
+func FromContext(ctx context.Context) *Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*Logger); ok {
+		return logger
+	}
+
+	return New(SubsystemAPI)
+}
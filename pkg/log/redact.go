@@ -0,0 +1,323 @@
+// Copyright 2020-2021 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file  except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the  License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package log
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// Mask replaces sensitive values in log messages.
+	Mask = "[REDACTED]"
+
+	// minimumRedactedLength is the shortest value a redactor will mask, unless it
+	// is known to be sensitive.  Masking short values, e.g. "true", would make log
+	// messages unreadable.
+	minimumRedactedLength = 8
+)
+
+// Redactor masks known sensitive values, e.g. passwords and private keys, wherever
+// they appear in log messages.
+type Redactor struct {
+	// lock allows values to be added while loggers are using the redactor.
+	lock sync.RWMutex
+
+	// values are the sensitive values, longest first, so a value that contains
+	// another is masked in its entirety.
+	values []string
+}
+
+// NewRedactor returns a redactor with no sensitive values.
+func NewRedactor() *Redactor {
+	return &Redactor{}
+}
+
+// Add adds a value that may be sensitive, e.g. a user defined registry value.  If
+// the value is an object or array, the strings it contains are added individually.
+// Short strings are ignored, as they are unlikely to be secret.
+func (r *Redactor) Add(value interface{}) {
+	r.add(value, false)
+}
+
+// AddSensitive adds a value that is known to be sensitive, e.g. a generated password.
+// Unlike Add, strings are masked regardless of their length.
+func (r *Redactor) AddSensitive(value interface{}) {
+	r.add(value, true)
+}
+
+// add recursively adds the strings contained in a value.
+func (r *Redactor) add(value interface{}, sensitive bool) {
+	switch v := value.(type) {
+	case string:
+		r.addString(v, sensitive)
+	case []interface{}:
+		for _, element := range v {
+			r.add(element, sensitive)
+		}
+	case map[string]interface{}:
+		for _, element := range v {
+			r.add(element, sensitive)
+		}
+	}
+}
+
+// addString adds a string to mask.
+func (r *Redactor) addString(value string, sensitive bool) {
+	if value == "" || (!sensitive && len(value) < minimumRedactedLength) {
+		return
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.insert(value)
+
+	// Values are often logged as part of a JSON document where special characters,
+	// e.g. the new lines in a PEM encoded private key, are escaped.
+	if data, err := json.Marshal(value); err == nil {
+		if escaped := string(data[1 : len(data)-1]); escaped != value {
+			r.insert(escaped)
+		}
+	}
+}
+
+// insert adds a value in order, longest first, ignoring duplicates.
+func (r *Redactor) insert(value string) {
+	i := sort.Search(len(r.values), func(i int) bool {
+		return len(r.values[i]) <= len(value)
+	})
+
+	for j := i; j < len(r.values) && len(r.values[j]) == len(value); j++ {
+		if r.values[j] == value {
+			return
+		}
+	}
+
+	r.values = append(r.values, "")
+	copy(r.values[i+1:], r.values[i:])
+	r.values[i] = value
+}
+
+// Clone returns a copy of the redactor, so values added to one are not added to
+// the other.
+func (r *Redactor) Clone() *Redactor {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	return &Redactor{
+		values: append([]string(nil), r.values...),
+	}
+}
+
+// Contains returns whether a string contains any sensitive value.
+func (r *Redactor) Contains(s string) bool {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	for _, value := range r.values {
+		if strings.Contains(s, value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Redact masks all sensitive values in a string.
+func (r *Redactor) Redact(s string) string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	for _, value := range r.values {
+		s = strings.ReplaceAll(s, value, Mask)
+	}
+
+	return s
+}
+
+// secretFields are the fields of a Kubernetes Secret that contain sensitive data.
+var secretFields = []string{
+	"/data/*",
+	"/stringData/*",
+}
+
+// RedactObject returns a copy of a JSON object, e.g. a Kubernetes resource, with
+// sensitive fields masked so it can be logged.  The data in Kubernetes Secrets is
+// always masked.  Other fields are specified as JSON pointers, where "*" matches
+// any attribute or array element.
+func RedactObject(object interface{}, pointers ...string) interface{} {
+	data, err := json.Marshal(object)
+	if err != nil {
+		return Mask
+	}
+
+	var redacted interface{}
+
+	if err := json.Unmarshal(data, &redacted); err != nil {
+		return Mask
+	}
+
+	if o, ok := redacted.(map[string]interface{}); ok && o["kind"] == "Secret" {
+		pointers = append(pointers, secretFields...)
+	}
+
+	for _, pointer := range pointers {
+		if pointer == "" {
+			return Mask
+		}
+
+		redactPointer(redacted, splitPointer(pointer))
+	}
+
+	return redacted
+}
+
+// RedactJSON returns a JSON document with sensitive fields masked, as a string so
+// it can be logged.  See RedactObject for details.
+func RedactJSON(data []byte, pointers ...string) string {
+	var object interface{}
+
+	if err := json.Unmarshal(data, &object); err != nil {
+		return Mask
+	}
+
+	redacted, err := json.Marshal(RedactObject(object, pointers...))
+	if err != nil {
+		return Mask
+	}
+
+	return string(redacted)
+}
+
+// splitPointer splits a JSON pointer into its unescaped reference tokens.
+func splitPointer(pointer string) []string {
+	tokens := strings.Split(strings.TrimPrefix(pointer, "/"), "/")
+
+	for i := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(tokens[i])
+	}
+
+	return tokens
+}
+
+// escapeToken escapes a JSON pointer reference token.
+func escapeToken(token string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(token)
+}
+
+// redactPointer masks the values selected by JSON pointer reference tokens.
+func redactPointer(node interface{}, tokens []string) {
+	token, rest := tokens[0], tokens[1:]
+
+	switch n := node.(type) {
+	case map[string]interface{}:
+		for key, child := range n {
+			if token != "*" && token != key {
+				continue
+			}
+
+			if len(rest) == 0 {
+				n[key] = Mask
+				continue
+			}
+
+			redactPointer(child, rest)
+		}
+	case []interface{}:
+		for index, child := range n {
+			if token != "*" && token != strconv.Itoa(index) {
+				continue
+			}
+
+			if len(rest) == 0 {
+				n[index] = Mask
+				continue
+			}
+
+			redactPointer(child, rest)
+		}
+	}
+}
+
+// SensitiveSchemaFields returns JSON pointers to the properties of a JSON schema
+// that are marked as sensitive with "writeOnly": true.  These can be used to mask
+// parameters that conform to the schema.
+func SensitiveSchemaFields(schema []byte) []string {
+	var object interface{}
+
+	if err := json.Unmarshal(schema, &object); err != nil {
+		return nil
+	}
+
+	pointers := sensitiveSchemaFields(object, "")
+
+	sort.Strings(pointers)
+
+	return pointers
+}
+
+// sensitiveSchemaFields recursively finds sensitive properties of a JSON schema.
+func sensitiveSchemaFields(schema interface{}, pointer string) []string {
+	s, ok := schema.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	if writeOnly, ok := s["writeOnly"].(bool); ok && writeOnly {
+		return []string{pointer}
+	}
+
+	var pointers []string
+
+	if properties, ok := s["properties"].(map[string]interface{}); ok {
+		for name, property := range properties {
+			pointers = append(pointers, sensitiveSchemaFields(property, pointer+"/"+escapeToken(name))...)
+		}
+	}
+
+	if items, ok := s["items"]; ok {
+		pointers = append(pointers, sensitiveSchemaFields(items, pointer+"/*")...)
+	}
+
+	if additionalProperties, ok := s["additionalProperties"]; ok {
+		pointers = append(pointers, sensitiveSchemaFields(additionalProperties, pointer+"/*")...)
+	}
+
+	return pointers
+}
+
+// sensitiveHeaders are HTTP headers that contain credentials.
+var sensitiveHeaders = []string{
+	"Authorization",
+	"Cookie",
+	"Proxy-Authorization",
+	"Set-Cookie",
+}
+
+// RedactHeader returns an HTTP header value, masked if the header contains credentials.
+func RedactHeader(name, value string) string {
+	for _, header := range sensitiveHeaders {
+		if http.CanonicalHeaderKey(name) == header {
+			return Mask
+		}
+	}
+
+	return value
+}
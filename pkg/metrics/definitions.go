@@ -0,0 +1,66 @@
+// Copyright 2020-2021 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file  except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the  License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+const (
+	// namespace prefixes all service broker metric names.
+	namespace = "service_broker_"
+)
+
+var (
+	// HTTPRequests counts API requests by route and status code.
+	HTTPRequests = NewCounterVec(namespace+"http_requests_total", "Number of API requests handled.", "route", "status")
+
+	// HTTPRequestDuration measures API request latency by route and status code.
+	HTTPRequestDuration = NewHistogramVec(namespace+"http_request_duration_seconds", "API request latency in seconds.", "route", "status")
+
+	// OperationsStarted counts asynchronous operations started by type and plan.
+	OperationsStarted = NewCounterVec(namespace+"operations_started_total", "Number of asynchronous operations started.", "type", "plan_id")
+
+	// OperationsSucceeded counts asynchronous operations that succeeded by type and plan.
+	OperationsSucceeded = NewCounterVec(namespace+"operations_succeeded_total", "Number of asynchronous operations that succeeded.", "type", "plan_id")
+
+	// OperationsFailed counts asynchronous operations that failed by type and plan.
+	OperationsFailed = NewCounterVec(namespace+"operations_failed_total", "Number of asynchronous operations that failed.", "type", "plan_id")
+
+	// OperationQueueDepth is the number of asynchronous operations waiting to run.
+	OperationQueueDepth = NewGaugeVec(namespace+"operation_queue_depth", "Number of asynchronous operations waiting to run.")
+
+	// OperationsInFlight is the number of asynchronous operations being run.
+	OperationsInFlight = NewGaugeVec(namespace+"operations_in_flight", "Number of asynchronous operations being run.")
+
+	// ReadinessCheckDuration measures how long readiness checks take to pass or time out.
+	ReadinessCheckDuration = NewHistogramVec(namespace+"readiness_check_duration_seconds", "Readiness check duration in seconds.", "check")
+
+	// ReadinessCheckTimeouts counts readiness checks that timed out.
+	ReadinessCheckTimeouts = NewCounterVec(namespace+"readiness_check_timeouts_total", "Number of readiness checks that timed out.", "check")
+
+	// RegistryErrors counts failures to read or write registry entries.
+	RegistryErrors = NewCounterVec(namespace+"registry_errors_total", "Number of registry read or write errors.", "operation")
+
+	// RESTMapperRefreshFailures counts failures to refresh the REST mapper.
+	RESTMapperRefreshFailures = NewCounterVec(namespace+"rest_mapper_refresh_failures_total", "Number of failed REST mapper refreshes.")
+
+	// ServiceInstances is the number of service instances by plan.
+	ServiceInstances = NewGaugeVec(namespace+"service_instances", "Number of service instances.", "service_id", "plan_id")
+)
+
+const (
+	// RegistryRead labels registry read errors.
+	RegistryRead = "read"
+
+	// RegistryWrite labels registry write errors.
+	RegistryWrite = "write"
+)
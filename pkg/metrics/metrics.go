@@ -0,0 +1,338 @@
+// Copyright 2020-2021 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file  except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the  License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the histogram bucket upper bounds, in seconds, used when
+// none are specified.  These cover fast API calls through to slow readiness checks.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300}
+
+// metricType is the Prometheus metric type.
+type metricType string
+
+const (
+	counter   metricType = "counter"
+	gauge     metricType = "gauge"
+	histogram metricType = "histogram"
+)
+
+// series is a single set of label values and the data recorded against them.
+type series struct {
+	// labelValues are the values of the metric's labels.
+	labelValues []string
+
+	// value is the counter or gauge value.
+	value float64
+
+	// buckets are the histogram bucket counts, these are not cumulative.
+	buckets []uint64
+
+	// sum and count are the histogram sum and count of observations.
+	sum   float64
+	count uint64
+}
+
+// metric is a named metric, partitioned by label values.
+type metric struct {
+	// name is the metric name.
+	name string
+
+	// help is a description of the metric.
+	help string
+
+	// t is the type of metric.
+	t metricType
+
+	// labels are the label names.
+	labels []string
+
+	// bounds are the histogram bucket upper bounds.
+	bounds []float64
+
+	// lock protects the series.
+	lock sync.Mutex
+
+	// series are indexed by label values.
+	series map[string]*series
+}
+
+// registry is the set of all metrics that are exposed.
+var registry = struct {
+	lock    sync.Mutex
+	metrics []*metric
+}{}
+
+// newMetric creates and registers a new metric.
+func newMetric(name, help string, t metricType, labels []string) *metric {
+	m := &metric{
+		name:   name,
+		help:   help,
+		t:      t,
+		labels: labels,
+		series: map[string]*series{},
+	}
+
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	registry.metrics = append(registry.metrics, m)
+
+	return m
+}
+
+// key returns the series index for the label values.
+func (m *metric) key(labelValues []string) string {
+	if len(labelValues) != len(m.labels) {
+		panic(fmt.Sprintf("metric %s expects %d labels, got %d", m.name, len(m.labels), len(labelValues)))
+	}
+
+	return strings.Join(labelValues, "\xff")
+}
+
+// lookup returns the series for the label values, or an empty one if nothing has
+// been recorded.  This must be called with the lock held.
+func (m *metric) lookup(labelValues []string) *series {
+	if s, ok := m.series[m.key(labelValues)]; ok {
+		return s
+	}
+
+	return &series{}
+}
+
+// get returns the series for the label values, creating it if it doesn't exist.
+// This must be called with the lock held.
+func (m *metric) get(labelValues []string) *series {
+	key := m.key(labelValues)
+
+	s, ok := m.series[key]
+	if !ok {
+		s = &series{
+			labelValues: append([]string{}, labelValues...),
+		}
+
+		if m.t == histogram {
+			s.buckets = make([]uint64, len(m.bounds))
+		}
+
+		m.series[key] = s
+	}
+
+	return s
+}
+
+// CounterVec is a monotonically increasing count, partitioned by label values.
+type CounterVec struct {
+	metric *metric
+}
+
+// NewCounterVec creates and registers a new counter.
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{
+		metric: newMetric(name, help, counter, labels),
+	}
+}
+
+// Inc increments the counter for the label values.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.metric.lock.Lock()
+	defer c.metric.lock.Unlock()
+
+	c.metric.get(labelValues).value++
+}
+
+// Value returns the counter value for the label values.
+func (c *CounterVec) Value(labelValues ...string) float64 {
+	c.metric.lock.Lock()
+	defer c.metric.lock.Unlock()
+
+	return c.metric.lookup(labelValues).value
+}
+
+// GaugeVec is a value that may go up and down, partitioned by label values.
+type GaugeVec struct {
+	metric *metric
+}
+
+// NewGaugeVec creates and registers a new gauge.
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{
+		metric: newMetric(name, help, gauge, labels),
+	}
+}
+
+// Set sets the gauge for the label values.
+func (g *GaugeVec) Set(value float64, labelValues ...string) {
+	g.metric.lock.Lock()
+	defer g.metric.lock.Unlock()
+
+	g.metric.get(labelValues).value = value
+}
+
+// Value returns the gauge value for the label values.
+func (g *GaugeVec) Value(labelValues ...string) float64 {
+	g.metric.lock.Lock()
+	defer g.metric.lock.Unlock()
+
+	return g.metric.lookup(labelValues).value
+}
+
+// Reset removes all label values, e.g. before the gauge is recalculated so that
+// stale values are not reported.
+func (g *GaugeVec) Reset() {
+	g.metric.lock.Lock()
+	defer g.metric.lock.Unlock()
+
+	g.metric.series = map[string]*series{}
+}
+
+// HistogramVec counts observations in buckets, partitioned by label values.
+type HistogramVec struct {
+	metric *metric
+}
+
+// NewHistogramVec creates and registers a new histogram with the default buckets.
+func NewHistogramVec(name, help string, labels ...string) *HistogramVec {
+	m := newMetric(name, help, histogram, labels)
+	m.bounds = DefaultBuckets
+
+	return &HistogramVec{
+		metric: m,
+	}
+}
+
+// Observe records an observation for the label values.
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	h.metric.lock.Lock()
+	defer h.metric.lock.Unlock()
+
+	s := h.metric.get(labelValues)
+
+	s.sum += value
+	s.count++
+
+	for i, bound := range h.metric.bounds {
+		if value <= bound {
+			s.buckets[i]++
+			break
+		}
+	}
+}
+
+// Count returns the number of observations for the label values.
+func (h *HistogramVec) Count(labelValues ...string) uint64 {
+	h.metric.lock.Lock()
+	defer h.metric.lock.Unlock()
+
+	return h.metric.lookup(labelValues).count
+}
+
+// escape escapes a label value.
+func escape(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// formatFloat formats a sample value.
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	}
+
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// formatLabels formats label names and values, with an optional extra label
+// e.g. the histogram bucket bound.
+func formatLabels(names, values []string, extra ...string) string {
+	pairs := []string{}
+
+	for i := range names {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, names[i], escape(values[i])))
+	}
+
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extra[i], escape(extra[i+1])))
+	}
+
+	if len(pairs) == 0 {
+		return ""
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// write writes out the metric in the Prometheus text format.
+func (m *metric) write(w io.Writer) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n", m.name, m.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", m.name, m.t)
+
+	keys := make([]string, 0, len(m.series))
+
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := m.series[key]
+
+		if m.t != histogram {
+			fmt.Fprintf(w, "%s%s %s\n", m.name, formatLabels(m.labels, s.labelValues), formatFloat(s.value))
+			continue
+		}
+
+		var cumulative uint64
+
+		for i, bound := range m.bounds {
+			cumulative += s.buckets[i]
+
+			fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, formatLabels(m.labels, s.labelValues, "le", formatFloat(bound)), cumulative)
+		}
+
+		fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, formatLabels(m.labels, s.labelValues, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", m.name, formatLabels(m.labels, s.labelValues), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", m.name, formatLabels(m.labels, s.labelValues), s.count)
+	}
+}
+
+// Write writes out all metrics in the Prometheus text format.
+func Write(w io.Writer) error {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	buffer := bufio.NewWriter(w)
+
+	for _, m := range registry.metrics {
+		m.write(buffer)
+	}
+
+	return buffer.Flush()
+}
@@ -17,7 +17,9 @@ package operation
 import (
 	"errors"
 	"fmt"
+	"time"
 
+	"github.com/couchbase/service-broker/pkg/metrics"
 	"github.com/couchbase/service-broker/pkg/registry"
 
 	"github.com/google/uuid"
@@ -64,6 +66,33 @@ func Start(entry *registry.Entry, t Type) error {
 		return err
 	}
 
+	if err := entry.Set(registry.OperationStartTime, time.Now()); err != nil {
+		return err
+	}
+
+	if err := entry.Commit(); err != nil {
+		return err
+	}
+
+	metrics.OperationsStarted.Inc(string(t), planID(entry))
+
+	return nil
+}
+
+// planID returns the plan ID of a registry entry to label metrics with.
+func planID(entry *registry.Entry) string {
+	planID, _, _ := entry.GetString(registry.PlanID)
+
+	return planID
+}
+
+// Step records the step an asynchronous operation is processing, so it can be
+// resumed should the service broker restart.
+func Step(entry *registry.Entry, name string) error {
+	if err := entry.Set(registry.OperationStep, name); err != nil {
+		return err
+	}
+
 	if err := entry.Commit(); err != nil {
 		return err
 	}
@@ -91,14 +120,25 @@ func Complete(entry *registry.Entry, status error) error {
 		return err
 	}
 
+	if err := entry.Set(registry.OperationEndTime, time.Now()); err != nil {
+		return err
+	}
+
 	if err := entry.Commit(); err != nil {
 		return err
 	}
 
+	if status != nil {
+		metrics.OperationsFailed.Inc(op, planID(entry))
+	} else {
+		metrics.OperationsSucceeded.Inc(op, planID(entry))
+	}
+
 	return err
 }
 
-// End ends an asynchronous operation on the registry entry.
+// End ends an asynchronous operation on the registry entry.  Failed provisioning
+// operations are recorded, so they can be cleaned up if the client does not.
 func End(entry *registry.Entry) error {
 	op, ok, err := entry.GetString(registry.Operation)
 	if err != nil {
@@ -109,9 +149,25 @@ func End(entry *registry.Entry) error {
 		return fmt.Errorf("%w: %s operation does not exist for instance", ErrOperationDoesNotExist, op)
 	}
 
+	status, ok, err := entry.GetString(registry.OperationStatus)
+	if err != nil {
+		return err
+	}
+
+	if Type(op) == TypeProvision && ok && status != "" {
+		if err := entry.Set(registry.ProvisionFailedTime, time.Now()); err != nil {
+			return err
+		}
+	}
+
 	entry.Unset(registry.Operation)
 	entry.Unset(registry.OperationID)
 	entry.Unset(registry.OperationStatus)
+	entry.Unset(registry.OperationStartTime)
+	entry.Unset(registry.OperationEndTime)
+	entry.Unset(registry.OperationStep)
+	entry.Unset(registry.OperationChecksum)
+	entry.Unset(registry.MigratingPlanID)
 
 	if err := entry.Commit(); err != nil {
 		return err
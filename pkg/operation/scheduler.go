@@ -0,0 +1,262 @@
+// Copyright 2020-2021 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file  except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the  License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operation
+
+import (
+	"sync"
+
+	"github.com/couchbase/service-broker/pkg/errors"
+	"github.com/couchbase/service-broker/pkg/log"
+)
+
+const (
+	// DefaultWorkers is the default number of operations that may run concurrently.
+	DefaultWorkers = 16
+
+	// DefaultQueueSize is the default number of operations that may be waiting
+	// to run before requests are rejected.
+	DefaultQueueSize = 256
+)
+
+// task is a unit of work to be run by the scheduler.
+type task struct {
+	// key is used to serialize tasks e.g. a service instance ID.
+	key string
+
+	// f is the function to run.
+	f func()
+}
+
+// Scheduler runs asynchronous operations with a bounded number of workers.
+// Operations with the same key are run one at a time, in the order they
+// were submitted.
+type Scheduler struct {
+	// lock protects all the fields below.
+	lock sync.Mutex
+
+	// cond is signalled when a task becomes runnable.
+	cond *sync.Cond
+
+	// queue is the set of tasks that can be run by the next free worker.
+	queue []*task
+
+	// pending is the set of tasks waiting for another task with the same
+	// key to complete.
+	pending map[string][]*task
+
+	// busy records keys with a task either queued or running.
+	busy map[string]bool
+
+	// depth is the number of tasks waiting to run.
+	depth int
+
+	// running is the number of tasks being run by workers.
+	running int
+
+	// reserved is the number of reservations that have not been submitted.
+	reserved int
+
+	// queueSize is the maximum number of tasks that may be waiting to run,
+	// including reservations.
+	queueSize int
+}
+
+// NewScheduler creates a scheduler and starts its workers.
+func NewScheduler(workers, queueSize int) *Scheduler {
+	if workers < 1 {
+		workers = DefaultWorkers
+	}
+
+	if queueSize < 1 {
+		queueSize = DefaultQueueSize
+	}
+
+	s := &Scheduler{
+		pending:   map[string][]*task{},
+		busy:      map[string]bool{},
+		queueSize: queueSize,
+	}
+
+	s.cond = sync.NewCond(&s.lock)
+
+	for i := 0; i < workers; i++ {
+		go s.worker()
+	}
+
+	return s
+}
+
+// Depth returns the number of operations waiting to run.
+func (s *Scheduler) Depth() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.depth
+}
+
+// Running returns the number of operations being run by workers.
+func (s *Scheduler) Running() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.running
+}
+
+// Reserve reserves space in the queue for an operation.  This should be done before
+// committing anything to the registry, so the request can be rejected cleanly when
+// the service broker is busy.  The reservation must be either submitted or released.
+func (s *Scheduler) Reserve() (*Reservation, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.depth+s.reserved >= s.queueSize {
+		return nil, errors.NewBusyError("operation queue is full, %d operations waiting", s.depth)
+	}
+
+	s.reserved++
+
+	return &Reservation{scheduler: s}, nil
+}
+
+// worker runs tasks until the end of time.
+func (s *Scheduler) worker() {
+	for {
+		s.lock.Lock()
+
+		for len(s.queue) == 0 {
+			s.cond.Wait()
+		}
+
+		t := s.queue[0]
+		s.queue = s.queue[1:]
+		s.depth--
+		s.running++
+
+		s.lock.Unlock()
+
+		t.f()
+
+		s.lock.Lock()
+
+		s.running--
+
+		s.free(t.key)
+
+		s.lock.Unlock()
+	}
+}
+
+// free promotes the next task waiting on a key, otherwise the key is free for use.
+// The lock must be held.
+func (s *Scheduler) free(key string) {
+	if next := s.pending[key]; len(next) != 0 {
+		s.queue = append(s.queue, next[0])
+		s.pending[key] = next[1:]
+
+		if len(s.pending[key]) == 0 {
+			delete(s.pending, key)
+		}
+
+		s.cond.Signal()
+
+		return
+	}
+
+	delete(s.busy, key)
+}
+
+// TryLock claims a key for work the caller runs itself, e.g. a synchronous
+// operation, without waiting.  Returns false if an operation with the same key
+// is queued or running.  Operations submitted with the key while it is claimed
+// wait until it is unlocked.
+func (s *Scheduler) TryLock(key string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.busy[key] {
+		return false
+	}
+
+	s.busy[key] = true
+
+	return true
+}
+
+// Unlock releases a key claimed with TryLock.
+func (s *Scheduler) Unlock(key string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.free(key)
+}
+
+// Reservation is space in the scheduler queue for an operation.
+type Reservation struct {
+	// scheduler is the scheduler the reservation belongs to.
+	scheduler *Scheduler
+
+	// done is set when the reservation has been submitted or released.
+	done bool
+}
+
+// Submit queues a function to be run.  Functions with the same key will not be run
+// concurrently.
+func (r *Reservation) Submit(key string, f func()) {
+	if r.done {
+		log.New(log.SubsystemOperation).Errorf("operation reservation already used")
+		return
+	}
+
+	r.done = true
+
+	s := r.scheduler
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.reserved--
+	s.depth++
+
+	t := &task{
+		key: key,
+		f:   f,
+	}
+
+	if s.busy[key] {
+		s.pending[key] = append(s.pending[key], t)
+		return
+	}
+
+	s.busy[key] = true
+	s.queue = append(s.queue, t)
+	s.cond.Signal()
+}
+
+// Release frees the reservation if it has not been submitted.  This is safe to call
+// unconditionally, so it can be deferred.
+func (r *Reservation) Release() {
+	if r.done {
+		return
+	}
+
+	r.done = true
+
+	s := r.scheduler
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.reserved--
+}
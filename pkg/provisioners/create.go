@@ -23,8 +23,7 @@ import (
 	"github.com/couchbase/service-broker/pkg/config"
 	"github.com/couchbase/service-broker/pkg/operation"
 	"github.com/couchbase/service-broker/pkg/registry"
-
-	"github.com/golang/glog"
+	"github.com/couchbase/service-broker/pkg/tracing"
 
 	k8s_errors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
@@ -53,6 +52,18 @@ type Creator struct {
 	// Each creation is modelled as a set of steps with optional barriers
 	// in between them.
 	steps []createStep
+
+	// resume is set when resuming an operation interrupted by a restart.
+	resume bool
+
+	// resumeStep is the index of the step to resume from.
+	resumeStep int
+
+	// currentStep is the index of the step being processed.
+	currentStep int
+
+	// rollbackOnFailure deletes resources created by the operation if it fails.
+	rollbackOnFailure bool
 }
 
 // NewCreator initializes all the data required for
@@ -66,20 +77,32 @@ func NewCreator(resourceType ResourceType) (*Creator, error) {
 }
 
 // createResource instantiates rendered template resources.
-func (p *Creator) createResource(template *v1.ConfigurationTemplate, entry *registry.Entry) error {
+func createResource(ctx context.Context, template *v1.ConfigurationTemplate, entry *registry.Entry) error {
+	ctx, span := tracing.Start(ctx, "createResource", tracing.String("service_broker.template", template.Name))
+	defer span.End()
+
+	err := createTemplateResource(ctx, template, entry)
+
+	span.RecordError(err)
+
+	return err
+}
+
+// createTemplateResource does the work for createResource.
+func createTemplateResource(ctx context.Context, template *v1.ConfigurationTemplate, entry *registry.Entry) error {
 	if template.Template == nil || template.Template.Raw == nil {
-		glog.Infof("template has no associated object, skipping")
+		entry.Logger().Infof("template has no associated object, skipping")
 		return nil
 	}
 
 	// Unmarshal into instructured JSON.
 	object := &unstructured.Unstructured{}
 	if err := json.Unmarshal(template.Template.Raw, object); err != nil {
-		glog.Infof("unmarshal of template failed: %v", err)
+		entry.Logger().Infof("unmarshal of template failed: %v", err)
 		return err
 	}
 
-	glog.Infof("creating resource %s/%s %s", object.GetAPIVersion(), object.GetKind(), object.GetName())
+	entry.Logger().Infof("creating resource %s/%s %s", object.GetAPIVersion(), object.GetKind(), object.GetName())
 
 	// To support updates, knowing that Kubernetes can modify resources,
 	// we must annotate the resource with the deterministic representation
@@ -103,6 +126,11 @@ func (p *Creator) createResource(template *v1.ConfigurationTemplate, entry *regi
 		return err
 	}
 
+	// Record who asked for the resource, again this is not part of the cached annotation.
+	if err := annotateOriginatingIdentity(object, entry); err != nil {
+		return err
+	}
+
 	// First we need to set up owner references so that we can garbage collect the
 	// cluster easily.  These should not be considered as part of the cached annotation
 	// defined above.
@@ -134,15 +162,15 @@ func (p *Creator) createResource(template *v1.ConfigurationTemplate, entry *regi
 		namespace = n
 	}
 
-	glog.Infof("using namespace %s", namespace)
+	entry.Logger().Infof("using namespace %s", namespace)
 
 	// Create the object
 	client := config.Clients().Dynamic()
 
 	if mapping.Scope.Name() == meta.RESTScopeNameRoot {
-		_, err = client.Resource(mapping.Resource).Create(context.TODO(), object, metav1.CreateOptions{})
+		_, err = client.Resource(mapping.Resource).Create(ctx, object, metav1.CreateOptions{})
 	} else {
-		_, err = client.Resource(mapping.Resource).Namespace(namespace).Create(context.TODO(), object, metav1.CreateOptions{})
+		_, err = client.Resource(mapping.Resource).Namespace(namespace).Create(ctx, object, metav1.CreateOptions{})
 	}
 
 	if err != nil {
@@ -150,45 +178,45 @@ func (p *Creator) createResource(template *v1.ConfigurationTemplate, entry *regi
 		// update the owner references to include this new serivce instance so it
 		// will not be garbage collected when an existing service instance is removed.
 		if k8s_errors.IsAlreadyExists(err) && template.Singleton {
-			glog.Infof("singleton resource already exists, adding owner reference")
+			entry.Logger().Infof("singleton resource already exists, adding owner reference")
 
-			existing, err := client.Resource(mapping.Resource).Namespace(namespace).Get(context.TODO(), object.GetName(), metav1.GetOptions{})
+			existing, err := client.Resource(mapping.Resource).Namespace(namespace).Get(ctx, object.GetName(), metav1.GetOptions{})
 			if err != nil {
-				glog.Infof("unable to get existing singleton resource: %v", err)
+				entry.Logger().Infof("unable to get existing singleton resource: %v", err)
 				return err
 			}
 
 			owners, found, err := unstructured.NestedSlice(existing.Object, "metadata", "ownerReferences")
 			if err != nil {
-				glog.Infof("unable to get owner references for object: %v", err)
+				entry.Logger().Infof("unable to get owner references for object: %v", err)
 				return err
 			}
 
 			if !found {
-				glog.Infof("owner references unexpectedly missing")
+				entry.Logger().Infof("owner references unexpectedly missing")
 				return fmt.Errorf("%w: owner references unexpectedly missing", ErrResourceAttributeMissing)
 			}
 
 			unstructuredOwnerReference, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&ownerReference)
 			if err != nil {
-				glog.Infof("failed to convert owner reference to unstructured: %v", err)
+				entry.Logger().Infof("failed to convert owner reference to unstructured: %v", err)
 				return err
 			}
 
 			owners = append(owners, unstructuredOwnerReference)
 			if err := unstructured.SetNestedSlice(existing.Object, owners, "metadata", "ownerReferences"); err != nil {
-				glog.Infof("unable to patch owner references for object: %v", err)
+				entry.Logger().Infof("unable to patch owner references for object: %v", err)
 				return err
 			}
 
 			if mapping.Scope.Name() == meta.RESTScopeNameRoot {
-				_, err = client.Resource(mapping.Resource).Update(context.TODO(), existing, metav1.UpdateOptions{})
+				_, err = client.Resource(mapping.Resource).Update(ctx, existing, metav1.UpdateOptions{})
 			} else {
-				_, err = client.Resource(mapping.Resource).Namespace(namespace).Update(context.TODO(), existing, metav1.UpdateOptions{})
+				_, err = client.Resource(mapping.Resource).Namespace(namespace).Update(ctx, existing, metav1.UpdateOptions{})
 			}
 
 			if err != nil {
-				glog.Infof("unable to update singleton resource owner references: %v", err)
+				entry.Logger().Infof("unable to update singleton resource owner references: %v", err)
 				return err
 			}
 
@@ -198,41 +226,37 @@ func (p *Creator) createResource(template *v1.ConfigurationTemplate, entry *regi
 		return err
 	}
 
-	return nil
+	// Singletons are shared, so are never tracked as they must not be pruned.
+	if template.Singleton {
+		return nil
+	}
+
+	return trackResource(entry, object)
 }
 
 // Prepare does provisional synchronous tasks before provisioning.  This does
 // basic template collection and rendering.
 func (p *Creator) Prepare(entry *registry.Entry) error {
-	serviceID, ok, err := entry.GetString(registry.ServiceID)
-	if err != nil {
-		return err
-	}
+	_, span := tracing.Start(traceContext(entry), "Creator.Prepare", tracing.String("service_broker.resource_type", string(p.resourceType)))
+	defer span.End()
 
-	if !ok {
-		return fmt.Errorf("%w: unable to lookup service ID", ErrResourceReferenceMissing)
-	}
-
-	planID, ok, err := entry.GetString(registry.PlanID)
-	if err != nil {
-		return err
-	}
+	err := p.prepare(entry)
 
-	if !ok {
-		return fmt.Errorf("%w: unable to lookup plan ID", ErrResourceReferenceMissing)
-	}
+	span.RecordError(err)
 
-	glog.Infof("looking up bindings for service %s, plan %s", serviceID, planID)
+	return err
+}
 
-	// Collate and render our templates.
-	templates, err := getTemplateBinding(p.resourceType, serviceID, planID)
+// prepare does the work for Prepare.
+func (p *Creator) prepare(entry *registry.Entry) error {
+	templates, err := p.getTemplateBinding(entry)
 	if err != nil {
 		return err
 	}
 
 	// Render any parameters.  As they are not associated with any template they
 	// can only ever be committed to the registry.
-	glog.Infof("rendering parameters for binding")
+	entry.Logger().Infof("rendering parameters for binding")
 
 	for _, registry := range templates.Registry {
 		value, err := renderTemplateString(registry.Value, entry, nil)
@@ -249,20 +273,119 @@ func (p *Creator) Prepare(entry *registry.Entry) error {
 		}
 	}
 
-	glog.Infof("rendering templates for binding")
+	if err := p.prepareSteps(templates, entry); err != nil {
+		return err
+	}
 
-	// Use either the provided steps, or implictly create a default step.
-	steps := templates.Steps
-	if steps == nil {
-		steps = append(steps, v1.ServiceBrokerTemplateListStep{
-			Name:            "default",
-			Templates:       templates.Templates,
-			ReadinessChecks: templates.ReadinessChecks,
-		})
+	if err := p.prepareRollback(entry); err != nil {
+		return err
 	}
 
-	for _, step := range steps {
-		glog.Infof("rendering templates for step %s", step.Name)
+	// Record what we are about to create so it can be verified if the operation
+	// needs to be resumed.
+	sum, err := checksum(templates)
+	if err != nil {
+		return err
+	}
+
+	return entry.Set(registry.OperationChecksum, sum)
+}
+
+// PrepareResume does synchronous tasks before resuming an interrupted provisioning
+// operation.  Registry parameters have already been rendered and committed, so they
+// are not rendered again, as this may generate different values e.g. passwords.
+func (p *Creator) PrepareResume(entry *registry.Entry) error {
+	templates, err := p.getTemplateBinding(entry)
+	if err != nil {
+		return err
+	}
+
+	if err := p.prepareSteps(templates, entry); err != nil {
+		return err
+	}
+
+	if err := p.prepareRollback(entry); err != nil {
+		return err
+	}
+
+	sum, err := checksum(templates)
+	if err != nil {
+		return err
+	}
+
+	expected, ok, err := entry.GetString(registry.OperationChecksum)
+	if err != nil {
+		return err
+	}
+
+	if !ok || expected != sum {
+		return fmt.Errorf("%w: templates have changed since the operation started", ErrOperationInconsistent)
+	}
+
+	step, ok, err := entry.GetString(registry.OperationStep)
+	if err != nil {
+		return err
+	}
+
+	p.resume = true
+
+	if ok {
+		for index := range p.steps {
+			if p.steps[index].name == step {
+				p.resumeStep = index
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+// getTemplateBinding looks up the templates for the service instance or binding.
+func (p *Creator) getTemplateBinding(entry *registry.Entry) (*v1.ServiceBrokerTemplateList, error) {
+	serviceID, ok, err := entry.GetString(registry.ServiceID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("%w: unable to lookup service ID", ErrResourceReferenceMissing)
+	}
+
+	planID, ok, err := entry.GetString(registry.PlanID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("%w: unable to lookup plan ID", ErrResourceReferenceMissing)
+	}
+
+	entry.Logger().Infof("looking up bindings for service %s, plan %s", serviceID, planID)
+
+	// Collate and render our templates.
+	return getTemplateBinding(p.resourceType, serviceID, planID)
+}
+
+// prepareRollback records whether to delete created resources on failure.
+func (p *Creator) prepareRollback(entry *registry.Entry) error {
+	bindings, err := getConfigurationBinding(entry)
+	if err != nil {
+		return err
+	}
+
+	p.rollbackOnFailure = bindings.RollbackOnFailure
+
+	return nil
+}
+
+// prepareSteps renders the templates for each step.
+func (p *Creator) prepareSteps(templates *v1.ServiceBrokerTemplateList, entry *registry.Entry) error {
+	entry.Logger().Infof("rendering templates for binding")
+
+	// Use either the provided steps, or implictly create a default step.
+	for _, step := range getTemplateSteps(templates) {
+		entry.Logger().Infof("rendering templates for step %s", step.Name)
 
 		createStep := createStep{
 			name:            step.Name,
@@ -290,20 +413,72 @@ func (p *Creator) Prepare(entry *registry.Entry) error {
 }
 
 // run performs asynchronous creation tasks.
-func (p *Creator) run(entry *registry.Entry) error {
-	for _, step := range p.steps {
-		glog.Infof("creating resources for step %s", step.name)
+func (p *Creator) run(ctx context.Context, entry *registry.Entry) error {
+	for index, step := range p.steps {
+		if index < p.resumeStep {
+			entry.Logger().Infof("step %s already complete, skipping", step.name)
+			continue
+		}
 
-		for _, template := range step.templates {
-			if err := p.createResource(template, entry); err != nil {
-				return err
-			}
+		p.currentStep = index
+
+		if err := ctx.Err(); err != nil {
+			return err
 		}
 
-		for _, check := range step.readinessChecks {
-			if err := barrier(check, entry); err != nil {
-				return err
+		// Persist the step so we know where to resume from, this also persists
+		// resources created by previous steps.
+		if err := operation.Step(entry, step.name); err != nil {
+			return err
+		}
+
+		if err := p.runStep(ctx, step, entry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// runStep creates the resources for a step, then waits for them to become ready.
+func (p *Creator) runStep(ctx context.Context, step createStep, entry *registry.Entry) error {
+	ctx, span := tracing.Start(ctx, "Creator.step", tracing.String("service_broker.step", step.name))
+	defer span.End()
+
+	err := p.createStep(ctx, step, entry)
+
+	span.RecordError(err)
+
+	return err
+}
+
+// createStep does the work for runStep.
+func (p *Creator) createStep(ctx context.Context, step createStep, entry *registry.Entry) error {
+	entry.Logger().Infof("creating resources for step %s", step.name)
+
+	for _, template := range step.templates {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if err := createResource(ctx, template, entry); err != nil {
+			// When resuming, the resource may have been created before the
+			// operation was interrupted.
+			if p.resume && k8s_errors.IsAlreadyExists(err) {
+				if err := trackTemplate(template, entry); err != nil {
+					return err
+				}
+
+				continue
 			}
+
+			return err
+		}
+	}
+
+	for _, check := range step.readinessChecks {
+		if err := barrier(ctx, check, entry); err != nil {
+			return err
 		}
 	}
 
@@ -312,7 +487,23 @@ func (p *Creator) run(entry *registry.Entry) error {
 
 // Run performs asynchronous creation tasks.
 func (p *Creator) Run(entry *registry.Entry) {
-	if err := operation.Complete(entry, p.run(entry)); err != nil {
-		glog.Infof("failed to create instance: %v", err)
+	ctx, cancel := operationContext(entry)
+	defer cancel()
+
+	ctx, span := tracing.Start(ctx, "Creator.Run", tracing.String("service_broker.resource_type", string(p.resourceType)), tracing.Bool("service_broker.resume", p.resume))
+	defer span.End()
+
+	err := deadlineError(ctx, entry, p.run(ctx, entry))
+
+	// Rollback is not bound by the operation deadline, as it may have been the
+	// cause of the failure.
+	if err != nil && p.rollbackOnFailure {
+		err = p.rollback(tracing.ContextWithSpanContext(context.Background(), span.SpanContext()), entry, err)
+	}
+
+	span.RecordError(err)
+
+	if err := operation.Complete(entry, err); err != nil {
+		entry.Logger().Infof("failed to create instance: %v", err)
 	}
 }
@@ -15,22 +15,347 @@
 package provisioners
 
 import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/couchbase/service-broker/pkg/config"
+	"github.com/couchbase/service-broker/pkg/operation"
 	"github.com/couchbase/service-broker/pkg/registry"
+	"github.com/couchbase/service-broker/pkg/tracing"
+	"github.com/couchbase/service-broker/pkg/util"
+
+	k8s_errors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
 
-	"github.com/golang/glog"
+const (
+	// deletionTimeout is how long to wait for an individual resource to be
+	// removed by Kubernetes before giving up.
+	deletionTimeout = 5 * time.Minute
 )
 
-// Deleter caches various data associated with deleting a service instance.
-type Deleter struct{}
+// Deleter caches various data associated with deleting a service instance
+// or binding.
+type Deleter struct {
+	resourceType ResourceType
+
+	// teardown is a list of steps that are created before any resources are
+	// deleted e.g. to perform a backup.
+	teardown []createStep
+
+	// deletions is an ordered list of resources to delete.  This is the
+	// reverse of the order they were created in.
+	deletions []resourceReference
+
+	// teardownDeletions is the list of resources created by the teardown
+	// steps, these are deleted last.
+	teardownDeletions []resourceReference
+}
+
+// NewDeleter returns a new controller capable of deleting a service instance
+// or binding.
+func NewDeleter(resourceType ResourceType) *Deleter {
+	return &Deleter{
+		resourceType: resourceType,
+	}
+}
+
+// deleteResource deletes a resource, waiting for dependent resources to be deleted
+// first.  Missing resources are ignored.
+func deleteResource(ctx context.Context, mapping *meta.RESTMapping, namespace, name string) error {
+	client := config.Clients().Dynamic()
+
+	propagationPolicy := metav1.DeletePropagationForeground
+
+	options := metav1.DeleteOptions{
+		PropagationPolicy: &propagationPolicy,
+	}
+
+	var err error
+
+	if mapping.Scope.Name() == meta.RESTScopeNameRoot {
+		err = client.Resource(mapping.Resource).Delete(ctx, name, options)
+	} else {
+		err = client.Resource(mapping.Resource).Namespace(namespace).Delete(ctx, name, options)
+	}
+
+	if err != nil && !k8s_errors.IsNotFound(err) {
+		return err
+	}
+
+	return nil
+}
+
+// Prepare does provisional synchronous tasks before deprovisioning.  This renders
+// any teardown templates and calculates the order in which to delete resources.
+func (d *Deleter) Prepare(entry *registry.Entry) error {
+	serviceID, ok, err := entry.GetString(registry.ServiceID)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return fmt.Errorf("%w: unable to lookup service ID", ErrResourceReferenceMissing)
+	}
+
+	planID, ok, err := entry.GetString(registry.PlanID)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return fmt.Errorf("%w: unable to lookup plan ID", ErrResourceReferenceMissing)
+	}
+
+	// seen records the resources already scheduled for deletion so duplicates
+	// are ignored.
+	seen := map[string]interface{}{}
+
+	tracked, err := getTrackedResources(entry)
+	if err != nil {
+		return err
+	}
+
+	// If the plan has been removed from the configuration, we can still clean
+	// up everything we know we created.
+	templates, err := getTemplateBinding(d.resourceType, serviceID, planID)
+	if err != nil {
+		entry.Logger().Infof("unable to lookup bindings for service %s, plan %s, deleting tracked resources only: %v", serviceID, planID, err)
+
+		return d.prepareDeletions(reverseReferences(tracked), seen, entry)
+	}
+
+	for _, step := range templates.Teardown {
+		entry.Logger().Infof("rendering templates for teardown step %s", step.Name)
+
+		teardownStep := createStep{
+			name:            step.Name,
+			readinessChecks: step.ReadinessChecks,
+		}
+
+		for _, templateName := range step.Templates {
+			t, object, err := renderUnstructured(templateName, entry)
+			if err != nil {
+				return err
+			}
+
+			teardownStep.templates = append(teardownStep.templates, t)
+
+			_, namespace, err := getResourceMapping(object, entry)
+			if err != nil {
+				return err
+			}
+
+			reference := newResourceReference(object, namespace)
+
+			// Teardown resources may be tracked from a previous failed attempt, they
+			// should not be deleted until everything else has been.
+			key, err := reference.key(entry)
+			if err != nil {
+				return err
+			}
+
+			seen[key] = nil
+
+			d.teardownDeletions = append([]resourceReference{reference}, d.teardownDeletions...)
+		}
+
+		d.teardown = append(d.teardown, teardownStep)
+	}
+
+	// Render the templates in reverse order, so resources are deleted in the reverse
+	// order they were created in.  Singletons are shared with other service instances,
+	// so leave them to be garbage collected when all owners have been deleted.
+	var rendered []resourceReference
+
+	steps := getTemplateSteps(templates)
+
+	for i := len(steps) - 1; i >= 0; i-- {
+		for j := len(steps[i].Templates) - 1; j >= 0; j-- {
+			t, object, err := renderUnstructured(steps[i].Templates[j], entry)
+			if err != nil {
+				return err
+			}
+
+			if t.Singleton {
+				continue
+			}
+
+			_, namespace, err := getResourceMapping(object, entry)
+			if err != nil {
+				return err
+			}
+
+			rendered = append(rendered, newResourceReference(object, namespace))
+		}
+	}
+
+	// Anything that is tracked, but no longer rendered is deleted first, as nothing
+	// defined by the current configuration can depend on it.
+	renderedKeys := map[string]interface{}{}
+
+	for _, reference := range rendered {
+		key, err := reference.key(entry)
+		if err != nil {
+			return err
+		}
+
+		renderedKeys[key] = nil
+	}
+
+	var orphaned []resourceReference
+
+	for _, reference := range reverseReferences(tracked) {
+		key, err := reference.key(entry)
+		if err != nil {
+			return err
+		}
+
+		if _, ok := renderedKeys[key]; !ok {
+			orphaned = append(orphaned, reference)
+		}
+	}
+
+	if err := d.prepareDeletions(orphaned, seen, entry); err != nil {
+		return err
+	}
 
-// NewDeleter returns a new controller capable of deleting a service instance.
-func NewDeleter() *Deleter {
-	return &Deleter{}
+	return d.prepareDeletions(rendered, seen, entry)
 }
 
-// Run performs asynchronous update tasks.
+// reverseReferences returns a copy of the resource references in reverse order.
+// Tracked resources are recorded in creation order, so this is the order they
+// should be deleted in.
+func reverseReferences(references []resourceReference) []resourceReference {
+	reversed := make([]resourceReference, 0, len(references))
+
+	for i := len(references) - 1; i >= 0; i-- {
+		reversed = append(reversed, references[i])
+	}
+
+	return reversed
+}
+
+// prepareDeletions appends resources to the deletion list, ignoring any that have
+// already been seen.
+func (d *Deleter) prepareDeletions(references []resourceReference, seen map[string]interface{}, entry *registry.Entry) error {
+	for _, reference := range references {
+		key, err := reference.key(entry)
+		if err != nil {
+			return err
+		}
+
+		if _, ok := seen[key]; ok {
+			continue
+		}
+
+		seen[key] = nil
+
+		d.deletions = append(d.deletions, reference)
+	}
+
+	return nil
+}
+
+// delete deletes a resource and waits for it to be removed.  Resources not owned
+// by the service instance or binding are ignored.
+func (d *Deleter) delete(ctx context.Context, reference resourceReference, entry *registry.Entry) error {
+	mapping, namespace, err := getResourceMapping(reference.object(), entry)
+	if err != nil {
+		return err
+	}
+
+	object, err := getResource(ctx, mapping, namespace, reference.Name)
+	if err != nil {
+		if k8s_errors.IsNotFound(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	if !ownedBy(object, entry) {
+		entry.Logger().Infof("resource %s/%s %s not owned by %s, ignoring", reference.APIVersion, reference.Kind, reference.Name, d.resourceType)
+		return nil
+	}
+
+	entry.Logger().Infof("deleting resource %s/%s %s", reference.APIVersion, reference.Kind, reference.Name)
+
+	if err := deleteResource(ctx, mapping, namespace, reference.Name); err != nil {
+		return err
+	}
+
+	// Wait for the resource to be removed before continuing, so dependent
+	// resources are never left without their dependencies.
+	deleted := func() error {
+		if _, err := getResource(ctx, mapping, namespace, reference.Name); err != nil {
+			if k8s_errors.IsNotFound(err) {
+				return nil
+			}
+
+			return err
+		}
+
+		return fmt.Errorf("%w: resource %s/%s %s still exists", ErrResourceExists, reference.APIVersion, reference.Kind, reference.Name)
+	}
+
+	return util.WaitFor(ctx, deleted, deletionTimeout)
+}
+
+// run performs asynchronous deletion tasks.
+func (d *Deleter) run(ctx context.Context, entry *registry.Entry) error {
+	for _, step := range d.teardown {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		entry.Logger().Infof("creating resources for teardown step %s", step.name)
+
+		for _, template := range step.templates {
+			// Teardown resources may already exist if a previous attempt failed.
+			if err := createResource(ctx, template, entry); err != nil && !k8s_errors.IsAlreadyExists(err) {
+				return err
+			}
+		}
+
+		for _, check := range step.readinessChecks {
+			if err := barrier(ctx, check, entry); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, reference := range d.deletions {
+		if err := d.delete(ctx, reference, entry); err != nil {
+			return err
+		}
+	}
+
+	for _, reference := range d.teardownDeletions {
+		if err := d.delete(ctx, reference, entry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Run performs asynchronous deletion tasks.  The registry entry is not deleted here,
+// that is done once the completion has been reported to the client.
 func (d *Deleter) Run(entry *registry.Entry) {
-	if err := entry.Delete(); err != nil {
-		glog.Infof("failed to delete instance")
+	ctx, cancel := operationContext(entry)
+	defer cancel()
+
+	ctx, span := tracing.Start(ctx, "Deleter.Run", tracing.String("service_broker.resource_type", string(d.resourceType)))
+	defer span.End()
+
+	err := deadlineError(ctx, entry, d.run(ctx, entry))
+
+	span.RecordError(err)
+
+	if err := operation.Complete(entry, err); err != nil {
+		entry.Logger().Infof("failed to delete %s: %v", d.resourceType, err)
 	}
 }
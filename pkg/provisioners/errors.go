@@ -30,3 +30,15 @@ var ErrResourceReferenceMissing = errors.New("resource reference missing")
 
 // ErrUndefinedType is raised when an bad enumeration or similar is provided.
 var ErrUndefinedType = errors.New("undefined type")
+
+// ErrResourceExists is raised when a resource still exists after it should have been deleted.
+var ErrResourceExists = errors.New("resource exists")
+
+// ErrDeadlineExceeded is raised when an operation takes longer than its maximum polling duration.
+var ErrDeadlineExceeded = errors.New("deadline exceeded")
+
+// ErrRollbackFailed is raised when resources created by a failed operation cannot be removed.
+var ErrRollbackFailed = errors.New("rollback failed")
+
+// ErrOperationInconsistent is raised when an interrupted operation cannot be resumed.
+var ErrOperationInconsistent = errors.New("operation inconsistent")
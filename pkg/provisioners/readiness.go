@@ -16,14 +16,17 @@ package provisioners
 
 import (
 	"context"
+	goerrors "errors"
 	"fmt"
 	"time"
 
 	v1 "github.com/couchbase/service-broker/pkg/apis/servicebroker/v1alpha1"
 	"github.com/couchbase/service-broker/pkg/config"
 	"github.com/couchbase/service-broker/pkg/errors"
+	"github.com/couchbase/service-broker/pkg/metrics"
 	"github.com/couchbase/service-broker/pkg/operation"
 	"github.com/couchbase/service-broker/pkg/registry"
+	"github.com/couchbase/service-broker/pkg/tracing"
 	"github.com/couchbase/service-broker/pkg/util"
 
 	"k8s.io/apimachinery/pkg/api/meta"
@@ -59,7 +62,27 @@ func (e *conditionUnreadyError) Error() string {
 
 // conditionReady waits for a condition on a resource to report as ready.  Returns nil on success and
 // an error otherwise.
-func conditionReady(entry *registry.Entry, condition *v1.ConfigurationReadinessCheckCondition) error {
+func conditionReady(ctx context.Context, entry *registry.Entry, condition *v1.ConfigurationReadinessCheckCondition) error {
+	ctx, span := tracing.Start(ctx, "conditionReady",
+		tracing.String("service_broker.condition.api_version", condition.APIVersion),
+		tracing.String("service_broker.condition.kind", condition.Kind),
+		tracing.String("service_broker.condition.type", condition.Type))
+	defer span.End()
+
+	err := checkCondition(ctx, entry, condition)
+
+	// A resource not being ready yet is expected, so is not recorded as a failure.
+	span.SetAttributes(tracing.Bool("service_broker.condition.ready", err == nil))
+
+	if err != nil && !IsConditionUnreadyError(err) {
+		span.RecordError(err)
+	}
+
+	return err
+}
+
+// checkCondition does the work for conditionReady.
+func checkCondition(ctx context.Context, entry *registry.Entry, condition *v1.ConfigurationReadinessCheckCondition) error {
 	namespaceRaw, err := renderTemplateString(condition.Namespace, entry, nil)
 	if err != nil {
 		return err
@@ -97,9 +120,9 @@ func conditionReady(entry *registry.Entry, condition *v1.ConfigurationReadinessC
 	var object *unstructured.Unstructured
 
 	if mapping.Scope.Name() == meta.RESTScopeNameRoot {
-		object, err = client.Resource(mapping.Resource).Get(context.TODO(), name, metav1.GetOptions{})
+		object, err = client.Resource(mapping.Resource).Get(ctx, name, metav1.GetOptions{})
 	} else {
-		object, err = client.Resource(mapping.Resource).Namespace(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+		object, err = client.Resource(mapping.Resource).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
 	}
 
 	if err != nil {
@@ -141,13 +164,13 @@ func conditionReady(entry *registry.Entry, condition *v1.ConfigurationReadinessC
 	return newConditionUnreadyError("resource %s/%s %s doesn't contain the condition %s", condition.APIVersion, condition.Kind, name, condition.Type)
 }
 
-// Ready processes any readiness checks and returns nil on success.  For now this is intended to
-// be called from the service instance polling code.  In the future we may allow waits within the
-// template rendering path.  Returns nil on success and an error otherwise.
-func Ready(t ResourceType, entry *registry.Entry, serviceID, planID string) error {
-	// Only do this for provisioning operations, it makes no sense to check for
-	// readiness when deprovisioning and we expect updates to maintain service
-	// availability.
+// Ready processes any readiness checks and returns nil on success.  This is intended to
+// be called from the service instance polling code, to ensure the service instance is healthy
+// before reporting a provisioning or update operation as complete.  Returns nil on success
+// and an error otherwise.
+func Ready(ctx context.Context, t ResourceType, entry *registry.Entry, serviceID, planID string) error {
+	// Only do this for provisioning and update operations, it makes no sense to
+	// check for readiness when deprovisioning.
 	op, ok, err := entry.GetString(registry.Operation)
 	if err != nil {
 		return err
@@ -157,8 +180,8 @@ func Ready(t ResourceType, entry *registry.Entry, serviceID, planID string) erro
 		return fmt.Errorf("%w: service instance missing operation", ErrRegistryEntryMissing)
 	}
 
-	if operation.Type(op) != operation.TypeProvision {
-		return err
+	if operation.Type(op) != operation.TypeProvision && operation.Type(op) != operation.TypeUpdate {
+		return nil
 	}
 
 	// Collate and render our templates.
@@ -167,10 +190,16 @@ func Ready(t ResourceType, entry *registry.Entry, serviceID, planID string) erro
 		return err
 	}
 
-	for _, readinessCheck := range templates.ReadinessChecks {
+	var readinessChecks []v1.ConfigurationReadinessCheck
+
+	for _, step := range getTemplateSteps(templates) {
+		readinessChecks = append(readinessChecks, step.ReadinessChecks...)
+	}
+
+	for _, readinessCheck := range readinessChecks {
 		switch {
 		case readinessCheck.Condition != nil:
-			if err := conditionReady(entry, readinessCheck.Condition); err != nil {
+			if err := conditionReady(ctx, entry, readinessCheck.Condition); err != nil {
 				return err
 			}
 		default:
@@ -182,11 +211,11 @@ func Ready(t ResourceType, entry *registry.Entry, serviceID, planID string) erro
 }
 
 // barrier waits for a readiness check to complete before continuing.
-func barrier(readinessCheck v1.ConfigurationReadinessCheck, entry *registry.Entry) error {
+func barrier(ctx context.Context, readinessCheck v1.ConfigurationReadinessCheck, entry *registry.Entry) error {
 	doCheck := func() error {
 		switch {
 		case readinessCheck.Condition != nil:
-			if err := conditionReady(entry, readinessCheck.Condition); err != nil {
+			if err := conditionReady(ctx, entry, readinessCheck.Condition); err != nil {
 				return err
 			}
 		default:
@@ -201,5 +230,15 @@ func barrier(readinessCheck v1.ConfigurationReadinessCheck, entry *registry.Entr
 		timeout = readinessCheck.Timeout.Duration
 	}
 
-	return util.WaitFor(doCheck, timeout)
+	start := time.Now()
+
+	err := util.WaitFor(ctx, doCheck, timeout)
+
+	metrics.ReadinessCheckDuration.Observe(time.Since(start).Seconds(), readinessCheck.Name)
+
+	if goerrors.Is(err, util.ErrTimeout) && ctx.Err() == nil {
+		metrics.ReadinessCheckTimeouts.Inc(readinessCheck.Name)
+	}
+
+	return err
 }
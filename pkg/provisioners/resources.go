@@ -0,0 +1,137 @@
+// Copyright 2020-2021 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file  except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the  License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provisioners
+
+import (
+	"encoding/json"
+
+	v1 "github.com/couchbase/service-broker/pkg/apis/servicebroker/v1alpha1"
+	"github.com/couchbase/service-broker/pkg/registry"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// resourceReference records a resource created by the service broker for a service
+// instance or binding.  These are recorded in the registry so that resources no
+// longer rendered by a configuration binding can be safely pruned.
+type resourceReference struct {
+	// APIVersion is the resource API version e.g. "apps/v1".
+	APIVersion string `json:"apiVersion"`
+
+	// Kind is the resource kind e.g. "Deployment".
+	Kind string `json:"kind"`
+
+	// Namespace is the resource namespace, this is empty for cluster
+	// scoped resources.
+	Namespace string `json:"namespace,omitempty"`
+
+	// Name is the resource name.
+	Name string `json:"name"`
+}
+
+// newResourceReference creates a reference to a resource.
+func newResourceReference(object *unstructured.Unstructured, namespace string) resourceReference {
+	return resourceReference{
+		APIVersion: object.GetAPIVersion(),
+		Kind:       object.GetKind(),
+		Namespace:  namespace,
+		Name:       object.GetName(),
+	}
+}
+
+// object returns a skeleton unstructured object for the reference.
+func (r resourceReference) object() *unstructured.Unstructured {
+	object := &unstructured.Unstructured{}
+	object.SetAPIVersion(r.APIVersion)
+	object.SetKind(r.Kind)
+	object.SetNamespace(r.Namespace)
+	object.SetName(r.Name)
+
+	return object
+}
+
+// key returns a unique identifier for the referenced resource.
+func (r resourceReference) key(entry *registry.Entry) (string, error) {
+	mapping, namespace, err := getResourceMapping(r.object(), entry)
+	if err != nil {
+		return "", err
+	}
+
+	return resourceKey(mapping, namespace, r.Name), nil
+}
+
+// ownedBy returns whether a resource is owned by the registry entry.
+func ownedBy(object *unstructured.Unstructured, entry *registry.Entry) bool {
+	owner := entry.GetOwnerReference()
+
+	for _, reference := range object.GetOwnerReferences() {
+		if reference.UID == owner.UID && reference.Name == owner.Name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getTrackedResources returns all resources recorded as created by the service broker.
+func getTrackedResources(entry *registry.Entry) ([]resourceReference, error) {
+	var references []resourceReference
+
+	if _, err := entry.Get(registry.Resources, &references); err != nil {
+		return nil, err
+	}
+
+	return references, nil
+}
+
+// trackResource records that a resource has been created by the service broker.
+func trackResource(entry *registry.Entry, object *unstructured.Unstructured) error {
+	_, namespace, err := getResourceMapping(object, entry)
+	if err != nil {
+		return err
+	}
+
+	references, err := getTrackedResources(entry)
+	if err != nil {
+		return err
+	}
+
+	reference := newResourceReference(object, namespace)
+
+	for _, r := range references {
+		if r == reference {
+			return nil
+		}
+	}
+
+	references = append(references, reference)
+
+	return entry.Set(registry.Resources, references)
+}
+
+// trackTemplate records that a resource rendered from a template has been created by
+// the service broker.  Singletons are shared, so are never tracked.
+func trackTemplate(template *v1.ConfigurationTemplate, entry *registry.Entry) error {
+	if template.Singleton || template.Template == nil || template.Template.Raw == nil {
+		return nil
+	}
+
+	object := &unstructured.Unstructured{}
+	if err := json.Unmarshal(template.Template.Raw, object); err != nil {
+		return err
+	}
+
+	return trackResource(entry, object)
+}
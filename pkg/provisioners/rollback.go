@@ -0,0 +1,185 @@
+// Copyright 2020-2021 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file  except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the  License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provisioners
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	v1 "github.com/couchbase/service-broker/pkg/apis/servicebroker/v1alpha1"
+	"github.com/couchbase/service-broker/pkg/config"
+	"github.com/couchbase/service-broker/pkg/registry"
+
+	k8s_errors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// removeOwnerReference removes the registry entry from a resource's owner references.
+// Once no owners remain, nothing would garbage collect the resource, so it is deleted.
+// Missing resources are ignored.
+func removeOwnerReference(ctx context.Context, mapping *meta.RESTMapping, namespace, name string, entry *registry.Entry) error {
+	object, err := getResource(ctx, mapping, namespace, name)
+	if err != nil {
+		if k8s_errors.IsNotFound(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	owner := entry.GetOwnerReference()
+
+	var references []metav1.OwnerReference
+
+	for _, reference := range object.GetOwnerReferences() {
+		if reference.UID == owner.UID && reference.Name == owner.Name {
+			continue
+		}
+
+		references = append(references, reference)
+	}
+
+	if len(references) == len(object.GetOwnerReferences()) {
+		return nil
+	}
+
+	if len(references) == 0 {
+		entry.Logger().Infof("deleting unowned resource %s/%s %s", object.GetAPIVersion(), object.GetKind(), name)
+
+		return deleteResource(ctx, mapping, namespace, name)
+	}
+
+	entry.Logger().Infof("removing owner reference from resource %s/%s %s", object.GetAPIVersion(), object.GetKind(), name)
+
+	object.SetOwnerReferences(references)
+
+	client := config.Clients().Dynamic()
+
+	if mapping.Scope.Name() == meta.RESTScopeNameRoot {
+		_, err = client.Resource(mapping.Resource).Update(ctx, object, metav1.UpdateOptions{})
+	} else {
+		_, err = client.Resource(mapping.Resource).Namespace(namespace).Update(ctx, object, metav1.UpdateOptions{})
+	}
+
+	return err
+}
+
+// rollback deletes all resources created by a failed operation, in the reverse order
+// they were created in.  The outcome is added to the original error so that it is
+// reported to the client.
+func rollback(ctx context.Context, resourceType ResourceType, entry *registry.Entry, cause error, doRollback func(context.Context, *registry.Entry) error) error {
+	entry.Logger().Infof("rolling back %s: %v", resourceType, cause)
+
+	if err := doRollback(ctx, entry); err != nil {
+		entry.Logger().Infof("failed to roll back %s: %v", resourceType, err)
+
+		return fmt.Errorf("%w: %v, resources created by the operation may remain: %v", ErrRollbackFailed, cause, err)
+	}
+
+	return fmt.Errorf("%w, resources created by the operation have been removed", cause)
+}
+
+// rollback deletes all resources created by a failed creation.
+func (p *Creator) rollback(ctx context.Context, entry *registry.Entry, cause error) error {
+	return rollback(ctx, p.resourceType, entry, cause, p.doRollback)
+}
+
+// rollback deletes all resources created by a failed plan migration, so the service
+// instance is left as it was on its original plan.
+func (u *Updater) rollback(ctx context.Context, entry *registry.Entry, cause error) error {
+	return rollback(ctx, u.resourceType, entry, cause, u.doRollback)
+}
+
+// doRollback deletes resources created by the update so far, and restores the set of
+// resources tracked before it started.
+func (u *Updater) doRollback(ctx context.Context, entry *registry.Entry) error {
+	if err := rollbackTemplates(ctx, u.resourceType, u.created, entry); err != nil {
+		return err
+	}
+
+	if u.previous == nil {
+		entry.Unset(registry.Resources)
+
+		return nil
+	}
+
+	return entry.Set(registry.Resources, u.previous)
+}
+
+// doRollback deletes resources created by the steps processed so far.  Resources that
+// were never created, or are not owned by the service instance or binding, are ignored.
+func (p *Creator) doRollback(ctx context.Context, entry *registry.Entry) error {
+	var templates []*v1.ConfigurationTemplate
+
+	for i := 0; i <= p.currentStep && i < len(p.steps); i++ {
+		templates = append(templates, p.steps[i].templates...)
+	}
+
+	if err := rollbackTemplates(ctx, p.resourceType, templates, entry); err != nil {
+		return err
+	}
+
+	// Nothing created by the operation remains.
+	entry.Unset(registry.Resources)
+
+	return nil
+}
+
+// rollbackTemplates deletes resources rendered from a set of templates, in the reverse
+// order they were created in.  Singletons are shared with other service instances, so
+// just our claim on them is removed, unless nothing else owns them.
+func rollbackTemplates(ctx context.Context, resourceType ResourceType, templates []*v1.ConfigurationTemplate, entry *registry.Entry) error {
+	deleter := NewDeleter(resourceType)
+
+	for i := len(templates) - 1; i >= 0; i-- {
+		template := templates[i]
+
+		if template.Template == nil || template.Template.Raw == nil {
+			continue
+		}
+
+		object := &unstructured.Unstructured{}
+		if err := json.Unmarshal(template.Template.Raw, object); err != nil {
+			return err
+		}
+
+		// Resources of an unknown kind can never have been created.
+		mapping, namespace, err := getResourceMapping(object, entry)
+		if err != nil {
+			if meta.IsNoMatchError(err) {
+				continue
+			}
+
+			return err
+		}
+
+		if template.Singleton {
+			if err := removeOwnerReference(ctx, mapping, namespace, object.GetName(), entry); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if err := deleter.delete(ctx, newResourceReference(object, namespace), entry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
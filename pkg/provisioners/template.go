@@ -20,6 +20,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"math/big"
+	"reflect"
 	"strings"
 	"text/template"
 	"text/template/parse"
@@ -32,7 +33,6 @@ import (
 
 	petname "github.com/dustinkirkland/golang-petname"
 	"github.com/go-openapi/jsonpointer"
-	"github.com/golang/glog"
 )
 
 // templateFunctionRegistry looks up a registry value.
@@ -41,7 +41,7 @@ import (
 // if the key does not exist.
 func templateFunctionRegistry(entry *registry.Entry) func(string) (interface{}, error) {
 	return func(key string) (interface{}, error) {
-		glog.V(log.LevelDebug).Infof("registry: key '%s'", key)
+		entry.Logger().Debugf("registry: key '%s'", key)
 
 		value, ok, err := entry.GetUser(key)
 		if err != nil {
@@ -52,7 +52,7 @@ func templateFunctionRegistry(entry *registry.Entry) func(string) (interface{},
 			return nil, nil
 		}
 
-		glog.V(log.LevelDebug).Infof("registry: value '%v'", value)
+		entry.Logger().Debugf("registry: value '%v'", log.Mask)
 
 		return value, nil
 	}
@@ -63,7 +63,7 @@ func templateFunctionRegistry(entry *registry.Entry) func(string) (interface{},
 // a nil value if the path does not exist.
 func templateFunctionParameter(entry *registry.Entry) func(string) (interface{}, error) {
 	return func(path string) (interface{}, error) {
-		glog.V(log.LevelDebug).Infof("parameter: path '%s'", path)
+		entry.Logger().Debugf("parameter: path '%s'", path)
 
 		var parameters interface{}
 
@@ -90,7 +90,49 @@ func templateFunctionParameter(entry *registry.Entry) func(string) (interface{},
 			return nil, nil
 		}
 
-		glog.V(log.LevelDebug).Infof("parameter: value '%v'", value)
+		// Parameters marked as sensitive by the service plan's schemas must be
+		// masked wherever they are logged, e.g. in rendered templates.
+		redacted, _, _ := pointer.Get(log.RedactObject(parameters, sensitiveParameters(entry)...))
+		if !reflect.DeepEqual(redacted, value) {
+			entry.AddSensitive(value)
+		}
+
+		entry.Logger().Debugf("parameter: value '%v'", redacted)
+
+		return value, nil
+	}
+}
+
+// templateFunctionOriginatingIdentity returns the value of the end user identity
+// that initiated the operation.  The path is a JSON pointer into an object with
+// "platform" and "value" attributes, e.g. "/value/user_id".  Returns nil if the
+// client did not provide an identity, or the path does not exist.
+func templateFunctionOriginatingIdentity(entry *registry.Entry) func(string) (interface{}, error) {
+	return func(path string) (interface{}, error) {
+		entry.Logger().Debugf("originating identity: path '%s'", path)
+
+		var identity interface{}
+
+		ok, err := entry.Get(registry.OriginatingIdentity, &identity)
+		if err != nil {
+			return nil, err
+		}
+
+		if !ok {
+			return nil, nil
+		}
+
+		pointer, err := jsonpointer.New(path)
+		if err != nil {
+			return nil, errors.NewConfigurationError("json pointer malformed: %v", err)
+		}
+
+		value, _, err := pointer.Get(identity)
+		if err != nil {
+			return nil, nil
+		}
+
+		entry.Logger().Debugf("originating identity: value '%v'", value)
 
 		return value, nil
 	}
@@ -101,7 +143,7 @@ func templateFunctionParameter(entry *registry.Entry) func(string) (interface{},
 // the template fialed.
 func templateFunctionSnippet(entry *registry.Entry) func(name string) (interface{}, error) {
 	return func(name string) (interface{}, error) {
-		glog.V(log.LevelDebug).Infof("template: name '%s'", name)
+		entry.Logger().Debugf("template: name '%s'", name)
 
 		template, err := getTemplate(name)
 		if err != nil {
@@ -119,7 +161,7 @@ func templateFunctionSnippet(entry *registry.Entry) func(name string) (interface
 			return nil, errors.NewConfigurationError("template not JSON formatted: %v", err)
 		}
 
-		glog.V(log.LevelDebug).Infof("template: value '%v'", value)
+		entry.Logger().Debugf("template: value '%v'", value)
 
 		return value, nil
 	}
@@ -129,7 +171,7 @@ func templateFunctionSnippet(entry *registry.Entry) func(name string) (interface
 // in the specified list and yields an array.
 func templateFunctionSnippetArray(entry *registry.Entry) func(name string, parameters []interface{}) ([]interface{}, error) {
 	return func(name string, parameters []interface{}) ([]interface{}, error) {
-		glog.V(log.LevelDebug).Infof("snippetArray: values '%v'", parameters)
+		entry.Logger().Debugf("snippetArray: values '%v'", parameters)
 
 		template, err := getTemplate(name)
 		if err != nil {
@@ -150,12 +192,12 @@ func templateFunctionSnippetArray(entry *registry.Entry) func(name string, param
 				return nil, errors.NewConfigurationError("template not JSON formatted: %v", err)
 			}
 
-			glog.V(log.LevelDebug).Infof("snippetArray: element '%v'", value)
+			entry.Logger().Debugf("snippetArray: element '%v'", value)
 
 			result[i] = value
 		}
 
-		glog.V(log.LevelDebug).Infof("snippetArray: result '%v'", result)
+		entry.Logger().Debugf("snippetArray: result '%v'", result)
 
 		return result, nil
 	}
@@ -176,126 +218,134 @@ func templateFunctionGeneratePetName(numWords int) (string, error) {
 }
 
 // templateFunctionGeneratePassword generates a password.
-func templateFunctionGeneratePassword(length int, dictionary interface{}) (string, error) {
-	d := "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+func templateFunctionGeneratePassword(entry *registry.Entry) func(int, interface{}) (string, error) {
+	return func(length int, dictionary interface{}) (string, error) {
+		d := "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+		if dictionary != nil {
+			typed, ok := dictionary.(string)
+			if !ok {
+				return "", errors.NewConfigurationError("password dictionary not a string")
+			}
 
-	if dictionary != nil {
-		typed, ok := dictionary.(string)
-		if !ok {
-			return "", errors.NewConfigurationError("password dictionary not a string")
+			d = typed
 		}
 
-		d = typed
-	}
+		entry.Logger().Debugf("generatingPassword: length %d, dictionary '%s'", length, d)
 
-	glog.V(log.LevelDebug).Infof("generatingPassword: length %d, dictionary '%s'", length, d)
+		// Adjust so the length is within array bounds.
+		arrayIndexOffset := 1
+		dictionaryLength := len(d) - arrayIndexOffset
 
-	// Adjust so the length is within array bounds.
-	arrayIndexOffset := 1
-	dictionaryLength := len(d) - arrayIndexOffset
+		limit := big.NewInt(int64(dictionaryLength))
+		value := ""
 
-	limit := big.NewInt(int64(dictionaryLength))
-	value := ""
+		for i := 0; i < length; i++ {
+			indexBig, err := rand.Int(rand.Reader, limit)
+			if err != nil {
+				return "", err
+			}
 
-	for i := 0; i < length; i++ {
-		indexBig, err := rand.Int(rand.Reader, limit)
-		if err != nil {
-			return "", err
-		}
+			if !indexBig.IsInt64() {
+				return "", errors.NewConfigurationError("random index overflow")
+			}
+
+			index := int(indexBig.Int64())
 
-		if !indexBig.IsInt64() {
-			return "", errors.NewConfigurationError("random index overflow")
+			value += d[index : index+1]
 		}
 
-		index := int(indexBig.Int64())
+		entry.AddSensitive(value)
+		entry.Logger().Debugf("generatePassword: value '%v'", log.Mask)
 
-		value += d[index : index+1]
+		return value, nil
 	}
-
-	glog.V(log.LevelDebug).Infof("generatePassword: value '%v'", value)
-
-	return value, nil
 }
 
 // templateFunctionGeneratePrivatekey generates a private key.
-func templateFunctionGeneratePrivatekey(typ, encoding string, bits interface{}) (string, error) {
-	glog.V(log.LevelDebug).Infof("generatingPrivateKey: type '%s', encoding '%s', bits %v", typ, encoding, bits)
+func templateFunctionGeneratePrivatekey(entry *registry.Entry) func(string, string, interface{}) (string, error) {
+	return func(typ, encoding string, bits interface{}) (string, error) {
+		entry.Logger().Debugf("generatingPrivateKey: type '%s', encoding '%s', bits %v", typ, encoding, bits)
 
-	var b *int
+		var b *int
 
-	if bits != nil {
-		value, ok := bits.(int)
-		if !ok {
-			return "", errors.NewConfigurationError("bits is not an integer")
-		}
+		if bits != nil {
+			value, ok := bits.(int)
+			if !ok {
+				return "", errors.NewConfigurationError("bits is not an integer")
+			}
 
-		b = &value
-	}
+			b = &value
+		}
 
-	key, err := util.GenerateKey(util.KeyType(typ), util.KeyEncodingType(encoding), b)
-	if err != nil {
-		return "", err
-	}
+		key, err := util.GenerateKey(util.KeyType(typ), util.KeyEncodingType(encoding), b)
+		if err != nil {
+			return "", err
+		}
 
-	value := string(key)
+		value := string(key)
 
-	glog.V(log.LevelDebug).Infof("generatePrivateKey: value '%v'", value)
+		entry.AddSensitive(value)
+		entry.Logger().Debugf("generatePrivateKey: value '%v'", log.Mask)
 
-	return value, nil
+		return value, nil
+	}
 }
 
 // templateFunctionGenerateCertificate generates a certiifcate.
-func templateFunctionGenerateCertificate(key, cn, lifetime, usage string, sans []interface{}, caKey, caCert interface{}) (string, error) {
-	glog.V(log.LevelDebug).Infof("generateCertificate: key '%s', cn '%s', lifetime '%s', usage '%s', sans %v, ca key '%s', ca cert '%s'", key, cn, lifetime, usage, sans, caKey, caCert)
+func templateFunctionGenerateCertificate(entry *registry.Entry) func(string, string, string, string, []interface{}, interface{}, interface{}) (string, error) {
+	return func(key, cn, lifetime, usage string, sans []interface{}, caKey, caCert interface{}) (string, error) {
+		entry.Logger().Debugf("generateCertificate: key '%s', cn '%s', lifetime '%s', usage '%s', sans %v, ca key '%s', ca cert '%s'", log.Mask, cn, lifetime, usage, sans, log.Mask, caCert)
 
-	duration, err := time.ParseDuration(lifetime)
-	if err != nil {
-		return "", err
-	}
+		duration, err := time.ParseDuration(lifetime)
+		if err != nil {
+			return "", err
+		}
 
-	var caKeyTyped []byte
+		var caKeyTyped []byte
 
-	if caKey != nil {
-		t, ok := caKey.(string)
-		if !ok {
-			return "", errors.NewConfigurationError("CA key not a string")
+		if caKey != nil {
+			t, ok := caKey.(string)
+			if !ok {
+				return "", errors.NewConfigurationError("CA key not a string")
+			}
+
+			caKeyTyped = []byte(t)
 		}
 
-		caKeyTyped = []byte(t)
-	}
+		var caCertTyped []byte
 
-	var caCertTyped []byte
+		if caCert != nil {
+			t, ok := caCert.(string)
+			if !ok {
+				return "", errors.NewConfigurationError("CA certificate not a string")
+			}
 
-	if caCert != nil {
-		t, ok := caCert.(string)
-		if !ok {
-			return "", errors.NewConfigurationError("CA certificate not a string")
+			caCertTyped = []byte(t)
 		}
 
-		caCertTyped = []byte(t)
-	}
+		sansTyped := make([]string, len(sans))
 
-	sansTyped := make([]string, len(sans))
+		for index, san := range sans {
+			t, ok := san.(string)
+			if !ok {
+				return "", errors.NewConfigurationError("SAN %v not a strings", san)
+			}
 
-	for index, san := range sans {
-		t, ok := san.(string)
-		if !ok {
-			return "", errors.NewConfigurationError("SAN %v not a strings", san)
+			sansTyped[index] = t
 		}
 
-		sansTyped[index] = t
-	}
-
-	cert, err := util.GenerateCertificate([]byte(key), cn, duration, util.CertificateUsage(usage), sansTyped, caKeyTyped, caCertTyped)
-	if err != nil {
-		return "", err
-	}
+		cert, err := util.GenerateCertificate([]byte(key), cn, duration, util.CertificateUsage(usage), sansTyped, caKeyTyped, caCertTyped)
+		if err != nil {
+			return "", err
+		}
 
-	value := string(cert)
+		value := string(cert)
 
-	glog.V(log.LevelDebug).Infof("generateCertificate: value '%v'", value)
+		entry.Logger().Debugf("generateCertificate: value '%v'", value)
 
-	return value, nil
+		return value, nil
+	}
 }
 
 // templateFunctionRequired returns an error if the input is nil.
@@ -308,16 +358,18 @@ func templateFunctionRequired(value interface{}) (interface{}, error) {
 }
 
 // templateFunctionGenerateDefault sets a default if its input is nil.
-func templateFunctionGenerateDefault(def, value interface{}) interface{} {
-	glog.V(log.LevelDebug).Infof("default: default '%v',  value '%v'", def, value)
+func templateFunctionGenerateDefault(entry *registry.Entry) func(interface{}, interface{}) interface{} {
+	return func(def, value interface{}) interface{} {
+		entry.Logger().Debugf("default: default '%v',  value '%v'", def, value)
 
-	if value == nil {
-		value = def
-	}
+		if value == nil {
+			value = def
+		}
 
-	glog.V(log.LevelDebug).Infof("default: value '%v'", value)
+		entry.Logger().Debugf("default: value '%v'", value)
 
-	return value
+		return value
+	}
 }
 
 // templateFunctionUpper converts strings to upper case.
@@ -338,19 +390,21 @@ func templateFunctionTitle(value string) string {
 // templateFunctionGenerateJSON marshals template output into a JSON string.  As template
 // processing assumes the output is a string, we have to encode to JSON to preserve structure
 // as a string.
-func templateFunctionGenerateJSON(object interface{}) (string, error) {
-	glog.V(log.LevelDebug).Infof("json: object '%v'", object)
+func templateFunctionGenerateJSON(entry *registry.Entry) func(interface{}) (string, error) {
+	return func(object interface{}) (string, error) {
+		entry.Logger().Debugf("json: object '%v'", object)
 
-	raw, err := json.Marshal(object)
-	if err != nil {
-		return "", err
-	}
+		raw, err := json.Marshal(object)
+		if err != nil {
+			return "", err
+		}
 
-	value := string(raw)
+		value := string(raw)
 
-	glog.V(log.LevelDebug).Infof("json: value '%v'", value)
+		entry.Logger().Debugf("json: value '%v'", value)
 
-	return value, nil
+		return value, nil
+	}
 }
 
 const (
@@ -417,24 +471,25 @@ func renderTemplateString(str string, entry *registry.Entry, data interface{}) (
 		return nil, errors.NewConfigurationError("dynamic attribute '%s' malformed", str)
 	}
 
-	glog.V(log.LevelDebug).Infof("resolving dynamic attribute %s", str)
+	entry.Logger().Debugf("resolving dynamic attribute %s", str)
 
 	funcs := map[string]interface{}{
 		"registry":            templateFunctionRegistry(entry),
 		"parameter":           templateFunctionParameter(entry),
+		"originatingIdentity": templateFunctionOriginatingIdentity(entry),
 		"snippet":             templateFunctionSnippet(entry),
 		"snippetArray":        templateFunctionSnippetArray(entry),
 		"list":                templateFunctionList,
-		"generatePassword":    templateFunctionGeneratePassword,
+		"generatePassword":    templateFunctionGeneratePassword(entry),
 		"generatePetName":     templateFunctionGeneratePetName,
-		"generatePrivateKey":  templateFunctionGeneratePrivatekey,
-		"generateCertificate": templateFunctionGenerateCertificate,
+		"generatePrivateKey":  templateFunctionGeneratePrivatekey(entry),
+		"generateCertificate": templateFunctionGenerateCertificate(entry),
 		"required":            templateFunctionRequired,
-		"default":             templateFunctionGenerateDefault,
+		"default":             templateFunctionGenerateDefault(entry),
 		"upper":               templateFunctionUpper,
 		"lower":               templateFunctionLower,
 		"title":               templateFunctionTitle,
-		"json":                templateFunctionGenerateJSON,
+		"json":                templateFunctionGenerateJSON(entry),
 	}
 
 	tmpl, err := template.New("inline template").Funcs(funcs).Parse(str)
@@ -475,9 +530,11 @@ func recurseRenderTemplate(object interface{}, entry *registry.Entry, data inter
 				return nil, err
 			}
 
+			// Remove attributes that render as nil, but keep going, the
+			// remaining attributes still need rendering.
 			if value == nil {
 				delete(t, k)
-				break
+				continue
 			}
 
 			t[k] = value
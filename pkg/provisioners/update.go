@@ -19,21 +19,44 @@ import (
 	"encoding/json"
 	"fmt"
 	"reflect"
+	"strings"
 
 	"github.com/couchbase/service-broker/pkg/api"
 	v1 "github.com/couchbase/service-broker/pkg/apis/servicebroker/v1alpha1"
 	"github.com/couchbase/service-broker/pkg/config"
+	"github.com/couchbase/service-broker/pkg/log"
 	"github.com/couchbase/service-broker/pkg/operation"
 	"github.com/couchbase/service-broker/pkg/registry"
+	"github.com/couchbase/service-broker/pkg/tracing"
 
 	"github.com/evanphx/json-patch"
-	"github.com/golang/glog"
 
+	k8s_errors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 )
 
+// updateStep mirrors a creation step, applying all updates for a step before
+// blocking on readiness checks.
+type updateStep struct {
+	// name of the step.
+	name string
+
+	// creations is a list of rendered templates that need to be created
+	// as they are missing e.g. added to the configuration binding or a
+	// plan migration.
+	creations []*v1.ConfigurationTemplate
+
+	// resources is a list of resources that need to be updated as a result
+	// of any required update operations.
+	resources []*unstructured.Unstructured
+
+	// readinessChecks are used to block progress between steps until something
+	// is known to be up and in a good state.
+	readinessChecks []v1.ConfigurationReadinessCheck
+}
+
 // Updater caches various data associated with updating a service instance.
 type Updater struct {
 	resourceType ResourceType
@@ -41,9 +64,33 @@ type Updater struct {
 	// request is the incoming client requesst.
 	request *api.UpdateServiceInstanceRequest
 
-	// resources is a list of resources that need to be updated as a result
-	// of any required update operations.
-	resources []*unstructured.Unstructured
+	// planID is the plan a service instance is being migrated to.  This is
+	// only set when the update changes the service plan.
+	planID string
+
+	// Each update is modelled as a set of steps with optional barriers
+	// in between them, as for creation.
+	steps []updateStep
+
+	// deletions is a list of resources created by the service broker that are
+	// no longer rendered by the configuration binding.
+	deletions []*unstructured.Unstructured
+
+	// tracked is the set of resources that will be tracked in the registry
+	// once the update has completed successfully.
+	tracked []resourceReference
+
+	// maintenanceInfoVersion is the maintenance info version of the plan being
+	// migrated to, empty if it doesn't define one.
+	maintenanceInfoVersion string
+
+	// created is the list of templates created by the update so far, so they
+	// can be rolled back should a plan migration fail.
+	created []*v1.ConfigurationTemplate
+
+	// previous is the set of resources tracked in the registry before the
+	// update started, restored should a plan migration be rolled back.
+	previous []resourceReference
 }
 
 // NewUpdater returns a new controler capable of updaing a service instance.
@@ -56,8 +103,81 @@ func NewUpdater(resourceType ResourceType, request *api.UpdateServiceInstanceReq
 	return u, nil
 }
 
+// getResourceMapping returns the REST mapping and namespace of a rendered resource.
+// The namespace defaults to that configured in the object, if not specified we use
+// the namespace defined in the context (where the service instance or binding is
+// created).  Cluster scoped resources have no namespace.
+func getResourceMapping(object *unstructured.Unstructured, entry *registry.Entry) (*meta.RESTMapping, string, error) {
+	gvk := object.GroupVersionKind()
+
+	mapping, err := config.Clients().RESTMapper().RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if mapping.Scope.Name() == meta.RESTScopeNameRoot {
+		return mapping, "", nil
+	}
+
+	namespace := object.GetNamespace()
+	if namespace == "" {
+		n, ok, err := entry.GetString(registry.Namespace)
+		if err != nil {
+			return nil, "", err
+		}
+
+		if !ok {
+			return nil, "", fmt.Errorf("%w: unable to lookup namespace", ErrRegistryEntryMissing)
+		}
+
+		namespace = n
+	}
+
+	return mapping, namespace, nil
+}
+
+// getResource gets the current state of a resource from Kubernetes.
+func getResource(ctx context.Context, mapping *meta.RESTMapping, namespace, name string) (*unstructured.Unstructured, error) {
+	client := config.Clients().Dynamic()
+
+	if mapping.Scope.Name() == meta.RESTScopeNameRoot {
+		return client.Resource(mapping.Resource).Get(ctx, name, metav1.GetOptions{})
+	}
+
+	return client.Resource(mapping.Resource).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+}
+
+// resourceKey returns a unique identifier for a resource, used to compare the resources
+// generated by different service plans.
+func resourceKey(mapping *meta.RESTMapping, namespace, name string) string {
+	return fmt.Sprintf("%s/%s/%s", mapping.GroupVersionKind.GroupKind().String(), namespace, name)
+}
+
+// renderUnstructured renders a named template and returns it as an unstructured object.
+func renderUnstructured(templateName string, entry *registry.Entry) (*v1.ConfigurationTemplate, *unstructured.Unstructured, error) {
+	// Lookup the template, the name may be dynamic e.g. based on instance
+	// ID so render it first before getting from the API.
+	template, err := getTemplate(templateName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	t, err := renderTemplate(template, entry, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Unmarshal the object so we can derive the kind and name.
+	object := &unstructured.Unstructured{}
+	if err := json.Unmarshal(t.Template.Raw, object); err != nil {
+		return nil, nil, err
+	}
+
+	return t, object, nil
+}
+
 // Prepare pre-processes the registry and templates.
-func (u *Updater) Prepare(entry *registry.Entry) error {
+func (u *Updater) Prepare(ctx context.Context, entry *registry.Entry) error {
 	// Use the cached versions, as the request parameters may not be set.
 	serviceID, ok, err := entry.GetString(registry.ServiceID)
 	if err != nil {
@@ -77,73 +197,90 @@ func (u *Updater) Prepare(entry *registry.Entry) error {
 		return fmt.Errorf("%w: unable to lookup service instance plan ID", ErrResourceReferenceMissing)
 	}
 
+	// If the plan is changing then we render the new plan's templates and
+	// reconcile them against those generated by the current plan.
+	newPlanID := planID
+
+	if u.request.PlanID != "" && u.request.PlanID != planID {
+		u.planID = u.request.PlanID
+		newPlanID = u.planID
+
+		entry.Logger().Infof("migrating from plan %s to plan %s", planID, newPlanID)
+
+		if err := entry.Set(registry.MigratingPlanID, newPlanID); err != nil {
+			return err
+		}
+
+		u.maintenanceInfoVersion, _ = getMaintenanceInfoVersion(serviceID, newPlanID)
+	}
+
 	// Collate and render our templates.
-	glog.Infof("looking up bindings for service %s, plan %s", serviceID, planID)
+	entry.Logger().Infof("looking up bindings for service %s, plan %s", serviceID, newPlanID)
 
-	templates, err := getTemplateBinding(u.resourceType, serviceID, planID)
+	templates, err := getTemplateBinding(u.resourceType, serviceID, newPlanID)
 	if err != nil {
 		return err
 	}
 
-	// Prepare the client code
-	client := config.Clients().Dynamic()
-
-	for _, templateName := range templates.Templates {
-		glog.Infof("getting resource for template %s", templateName)
-
-		// Lookup the template, the name may be dynamic e.g. based on instance
-		// ID so render it first before getting from the API.
-		template, err := getTemplate(templateName)
-		if err != nil {
+	// When migrating, the new plan may depend on registry values that were not
+	// generated by the old plan.  Existing values are retained so things like
+	// passwords are not regenerated.
+	if u.planID != "" {
+		if err := u.prepareRegistry(templates, entry); err != nil {
 			return err
 		}
+	}
 
-		// Updates from multiple service instances or bindings will
-		// inevitably lead to split-brain, with values changing at
-		// random.
-		if template.Singleton {
-			glog.Info("template is a singleton, ignoring update")
-			continue
+	// desired records the set of resources rendered for the plan, and is used
+	// to determine resources that need deleting during a plan migration.
+	desired := map[string]interface{}{}
+
+	for _, step := range getTemplateSteps(templates) {
+		entry.Logger().Infof("rendering templates for step %s", step.Name)
+
+		updateStep := updateStep{
+			name:            step.Name,
+			readinessChecks: step.ReadinessChecks,
 		}
 
-		t, err := renderTemplate(template, entry, nil)
-		if err != nil {
+		if err := u.prepareStep(ctx, &updateStep, step.Templates, desired, entry); err != nil {
 			return err
 		}
 
-		newJSON := t.Template.Raw
+		u.steps = append(u.steps, updateStep)
+	}
 
-		// Unmarshal the object so we can derive the kind and name.
-		newObject := &unstructured.Unstructured{}
-		if err := json.Unmarshal(newJSON, newObject); err != nil {
-			return err
-		}
+	if err := u.prepareDeletions(ctx, serviceID, planID, desired, entry); err != nil {
+		return err
+	}
 
-		gvk := newObject.GroupVersionKind()
+	return nil
+}
+
+// prepareStep renders the templates for an individual step, and calculates what
+// resources need to be created or updated.
+func (u *Updater) prepareStep(ctx context.Context, step *updateStep, templateNames []string, desired map[string]interface{}, entry *registry.Entry) error {
+	for _, templateName := range templateNames {
+		entry.Logger().Infof("getting resource for template %s", templateName)
 
-		mapping, err := config.Clients().RESTMapper().RESTMapping(gvk.GroupKind(), gvk.Version)
+		t, newObject, err := renderUnstructured(templateName, entry)
 		if err != nil {
 			return err
 		}
 
-		// The namespace defaults to that configured in the object, if not
-		// specified we use the namespace defined in the context (where the
-		// service instance or binding is created).
-		namespace := newObject.GetNamespace()
-		if namespace == "" {
-			n, ok, err := entry.GetString(registry.Namespace)
-			if err != nil {
-				return err
-			}
+		mapping, namespace, err := getResourceMapping(newObject, entry)
+		if err != nil {
+			return err
+		}
 
-			if !ok {
-				return fmt.Errorf("%w: unable to lookup namespace", ErrRegistryEntryMissing)
-			}
+		desired[resourceKey(mapping, namespace, newObject.GetName())] = nil
 
-			namespace = n
+		// Singletons are shared, so are never tracked as they must not be pruned.
+		if !t.Singleton {
+			u.tracked = append(u.tracked, newResourceReference(newObject, namespace))
 		}
 
-		glog.Infof("using namespace %s", namespace)
+		entry.Logger().Infof("using namespace %s", namespace)
 
 		// Get the current resource.
 		// We will extract the annotation that contains the JSON we generated
@@ -153,100 +290,246 @@ func (u *Updater) Prepare(entry *registry.Entry) error {
 		// remove configuration in response to parameter changes and also
 		// preserve any mutations that have been applied by Kubernetes or any
 		// other controller.
-		var currentObject *unstructured.Unstructured
+		currentObject, err := getResource(ctx, mapping, namespace, newObject.GetName())
+		if err != nil {
+			// Resources that are missing e.g. have been added to the configuration
+			// binding or introduced by a new plan, need to be created.
+			if k8s_errors.IsNotFound(err) {
+				entry.Logger().Infof("resource %s/%s %s missing, creating", newObject.GetAPIVersion(), newObject.GetKind(), newObject.GetName())
 
-		if mapping.Scope.Name() == meta.RESTScopeNameRoot {
-			currentObject, err = client.Resource(mapping.Resource).Get(context.TODO(), newObject.GetName(), metav1.GetOptions{})
-		} else {
-			currentObject, err = client.Resource(mapping.Resource).Namespace(namespace).Get(context.TODO(), newObject.GetName(), metav1.GetOptions{})
+				step.creations = append(step.creations, t)
+
+				continue
+			}
+
+			entry.Logger().Infof("failed to get resource %s/%s %s", newObject.GetAPIVersion(), newObject.GetKind(), newObject.GetName())
+
+			return err
+		}
+
+		// Updates from multiple service instances or bindings will
+		// inevitably lead to split-brain, with values changing at
+		// random.
+		if t.Singleton {
+			entry.Logger().Infof("template is a singleton, ignoring update")
+			continue
 		}
 
+		mergedObject, err := mergeResource(entry.Logger(), currentObject, newObject, t.Template.Raw, t.SensitiveFields)
 		if err != nil {
-			glog.Infof("failed to get resource %s/%s %s", newObject.GetAPIVersion(), newObject.GetKind(), newObject.GetName())
 			return err
 		}
 
-		originalJSONString, ok, _ := unstructured.NestedString(currentObject.Object, "metadata", "annotations", v1.ResourceAnnotation)
-		if !ok {
-			return fmt.Errorf("%w: failed to lookup original resource", ErrResourceAttributeMissing)
+		if mergedObject == nil {
+			continue
 		}
 
-		originalJSON := []byte(originalJSONString)
+		step.resources = append(step.resources, mergedObject)
+	}
+
+	return nil
+}
+
+// prepareRegistry renders any registry values defined by the new plan that do not
+// already exist.
+func (u *Updater) prepareRegistry(templates *v1.ServiceBrokerTemplateList, entry *registry.Entry) error {
+	entry.Logger().Infof("rendering parameters for binding")
 
-		originalObject := &unstructured.Unstructured{}
-		if err := json.Unmarshal(originalJSON, originalObject); err != nil {
+	for _, value := range templates.Registry {
+		_, ok, err := entry.GetUser(value.Name)
+		if err != nil {
 			return err
 		}
 
-		glog.Infof("original resource: %s", string(originalJSON))
-		glog.Infof("new resource: %s", string(newJSON))
-
-		// jsonpatch.Equal is broken, so use reflection.
-		if reflect.DeepEqual(originalObject, newObject) {
-			glog.Infof("resource unchanged")
+		if ok {
 			continue
 		}
 
-		mergePatch, err := jsonpatch.CreateMergePatch(originalJSON, newJSON)
+		v, err := renderTemplateString(value.Value, entry, nil)
 		if err != nil {
 			return err
 		}
 
-		glog.Infof("marge patch: %s", string(mergePatch))
+		if v == nil {
+			continue
+		}
 
-		currentJSON, err := json.Marshal(currentObject)
-		if err != nil {
+		if err := entry.SetUser(value.Name, v); err != nil {
 			return err
 		}
+	}
 
-		glog.Infof("current resource: %s", string(currentJSON))
+	return nil
+}
 
-		mergedJSON, err := jsonpatch.MergePatch(currentJSON, mergePatch)
+// prepareDeletions calculates the set of resources that were created by the service broker
+// but are no longer rendered by the configuration binding.  These are either tracked in the
+// registry, or, when migrating plans, rendered from the current plan.
+func (u *Updater) prepareDeletions(ctx context.Context, serviceID, planID string, desired map[string]interface{}, entry *registry.Entry) error {
+	candidates, err := getTrackedResources(entry)
+	if err != nil {
+		return err
+	}
+
+	// Service instances created before resources were tracked will not have
+	// a complete record, so fall back to rendering the current plan's templates.
+	if u.planID != "" {
+		templates, err := getTemplateBinding(u.resourceType, serviceID, planID)
 		if err != nil {
 			return err
 		}
 
-		mergedObject := &unstructured.Unstructured{}
-		if err := json.Unmarshal(mergedJSON, mergedObject); err != nil {
+		for _, templateName := range getTemplateNames(templates) {
+			t, object, err := renderUnstructured(templateName, entry)
+			if err != nil {
+				return err
+			}
+
+			// Singletons are shared with other service instances, so leave them
+			// to be garbage collected when all owners have been deleted.
+			if t.Singleton {
+				continue
+			}
+
+			_, namespace, err := getResourceMapping(object, entry)
+			if err != nil {
+				return err
+			}
+
+			candidates = append(candidates, newResourceReference(object, namespace))
+		}
+	}
+
+	for _, candidate := range candidates {
+		object := candidate.object()
+
+		mapping, namespace, err := getResourceMapping(object, entry)
+		if err != nil {
 			return err
 		}
 
-		// Update the resource annotation with our new idealized representation
-		// of what we asked for, so future updates will diff against the right
-		// things.
-		if err := unstructured.SetNestedField(mergedObject.Object, string(newJSON), "metadata", "annotations", v1.ResourceAnnotation); err != nil {
+		key := resourceKey(mapping, namespace, object.GetName())
+
+		if _, ok := desired[key]; ok {
+			continue
+		}
+
+		// Mark as desired so that duplicate candidates are ignored.
+		desired[key] = nil
+
+		currentObject, err := getResource(ctx, mapping, namespace, object.GetName())
+		if err != nil {
+			if k8s_errors.IsNotFound(err) {
+				continue
+			}
+
 			return err
 		}
 
-		glog.Infof("merged resource: %s", string(mergedJSON))
+		// Only ever delete resources that are owned by this service instance.
+		if !ownedBy(currentObject, entry) {
+			entry.Logger().Infof("resource %s/%s %s not owned by service instance, ignoring", object.GetAPIVersion(), object.GetKind(), object.GetName())
+			continue
+		}
 
-		u.resources = append(u.resources, mergedObject)
+		entry.Logger().Infof("resource %s/%s %s no longer required, deleting", object.GetAPIVersion(), object.GetKind(), object.GetName())
+
+		u.deletions = append(u.deletions, currentObject)
 	}
 
 	return nil
 }
 
-// run performs asynchronous update tasks.
-func (u *Updater) run() error {
-	glog.Info("updating resources")
+// mergeResource takes the current resource and applies a merge patch generated from
+// the original and new resource templates.  Returns nil if no update is required.
+func mergeResource(logger *log.Logger, currentObject, newObject *unstructured.Unstructured, newJSON []byte, sensitiveFields []string) (*unstructured.Unstructured, error) {
+	originalJSONString, ok, _ := unstructured.NestedString(currentObject.Object, "metadata", "annotations", v1.ResourceAnnotation)
+	if !ok {
+		return nil, fmt.Errorf("%w: failed to lookup original resource", ErrResourceAttributeMissing)
+	}
+
+	originalJSON := []byte(originalJSONString)
+
+	originalObject := &unstructured.Unstructured{}
+	if err := json.Unmarshal(originalJSON, originalObject); err != nil {
+		return nil, err
+	}
+
+	// Resources may contain sensitive data, so mask it when logging.  The resource
+	// annotation is a copy of the template, and merge patches don't carry the kind
+	// so Secret data needs to be masked explicitly.
+	pointers := append([]string{"/metadata/annotations/" + strings.ReplaceAll(v1.ResourceAnnotation, "/", "~1")}, sensitiveFields...)
+
+	if newObject.GetKind() == "Secret" {
+		pointers = append(pointers, "/data/*", "/stringData/*")
+	}
+
+	logger.Debugf("original resource: %s", log.RedactJSON(originalJSON, pointers...))
+	logger.Debugf("new resource: %s", log.RedactJSON(newJSON, pointers...))
+
+	// jsonpatch.Equal is broken, so use reflection.
+	if reflect.DeepEqual(originalObject, newObject) {
+		logger.Infof("resource unchanged")
+		return nil, nil
+	}
+
+	mergePatch, err := jsonpatch.CreateMergePatch(originalJSON, newJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Debugf("merge patch: %s", log.RedactJSON(mergePatch, pointers...))
 
+	currentJSON, err := json.Marshal(currentObject)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Debugf("current resource: %s", log.RedactJSON(currentJSON, pointers...))
+
+	mergedJSON, err := jsonpatch.MergePatch(currentJSON, mergePatch)
+	if err != nil {
+		return nil, err
+	}
+
+	mergedObject := &unstructured.Unstructured{}
+	if err := json.Unmarshal(mergedJSON, mergedObject); err != nil {
+		return nil, err
+	}
+
+	// Update the resource annotation with our new idealized representation
+	// of what we asked for, so future updates will diff against the right
+	// things.
+	if err := unstructured.SetNestedField(mergedObject.Object, string(newJSON), "metadata", "annotations", v1.ResourceAnnotation); err != nil {
+		return nil, err
+	}
+
+	logger.Debugf("merged resource: %s", log.RedactJSON(mergedJSON, pointers...))
+
+	return mergedObject, nil
+}
+
+// updateResources applies updates to a set of resources.
+func updateResources(ctx context.Context, resources []*unstructured.Unstructured, entry *registry.Entry) error {
 	// Prepare the client code
 	client := config.Clients().Dynamic()
 
-	for _, resource := range u.resources {
-		glog.Infof("updating resource %s/%s %s", resource.GetAPIVersion(), resource.GetKind(), resource.GetName())
+	for _, resource := range resources {
+		entry.Logger().Infof("updating resource %s/%s %s", resource.GetAPIVersion(), resource.GetKind(), resource.GetName())
 
-		gvk := resource.GroupVersionKind()
+		if err := annotateOriginatingIdentity(resource, entry); err != nil {
+			return err
+		}
 
-		mapping, err := config.Clients().RESTMapper().RESTMapping(gvk.GroupKind(), gvk.Version)
+		mapping, namespace, err := getResourceMapping(resource, entry)
 		if err != nil {
 			return err
 		}
 
 		if mapping.Scope.Name() == meta.RESTScopeNameRoot {
-			_, err = client.Resource(mapping.Resource).Update(context.TODO(), resource, metav1.UpdateOptions{})
+			_, err = client.Resource(mapping.Resource).Update(ctx, resource, metav1.UpdateOptions{})
 		} else {
-			_, err = client.Resource(mapping.Resource).Namespace(resource.GetNamespace()).Update(context.TODO(), resource, metav1.UpdateOptions{})
+			_, err = client.Resource(mapping.Resource).Namespace(namespace).Update(ctx, resource, metav1.UpdateOptions{})
 		}
 
 		if err != nil {
@@ -257,9 +540,129 @@ func (u *Updater) run() error {
 	return nil
 }
 
+// run performs asynchronous update tasks.
+func (u *Updater) run(ctx context.Context, entry *registry.Entry) error {
+	previous, err := getTrackedResources(entry)
+	if err != nil {
+		return err
+	}
+
+	u.previous = previous
+
+	for _, step := range u.steps {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		entry.Logger().Infof("updating resources for step %s", step.name)
+
+		for _, template := range step.creations {
+			if err := createResource(ctx, template, entry); err != nil {
+				return err
+			}
+
+			u.created = append(u.created, template)
+		}
+
+		if err := updateResources(ctx, step.resources, entry); err != nil {
+			return err
+		}
+
+		for _, check := range step.readinessChecks {
+			if err := barrier(ctx, check, entry); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, resource := range u.deletions {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		entry.Logger().Infof("deleting resource %s/%s %s", resource.GetAPIVersion(), resource.GetKind(), resource.GetName())
+
+		mapping, namespace, err := getResourceMapping(resource, entry)
+		if err != nil {
+			return err
+		}
+
+		if err := deleteResource(ctx, mapping, namespace, resource.GetName()); err != nil {
+			return err
+		}
+	}
+
+	// Record the resources now owned by the service instance.
+	if err := entry.Set(registry.Resources, u.tracked); err != nil {
+		return err
+	}
+
+	// Finally persist the new plan once everything has been migrated.  The client
+	// will poll with the previous plan until it sees the operation has completed.
+	if u.planID != "" {
+		planID, _, err := entry.GetString(registry.PlanID)
+		if err != nil {
+			return err
+		}
+
+		if err := entry.Set(registry.MigratingPlanID, planID); err != nil {
+			return err
+		}
+
+		if err := entry.Set(registry.PlanID, u.planID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// setMaintenanceInfoVersion records the maintenance info version the service instance
+// has been upgraded or migrated to.  The request may omit the maintenance info when
+// migrating, so the version is always that of the new plan.
+func (u *Updater) setMaintenanceInfoVersion(entry *registry.Entry) error {
+	switch {
+	case u.planID != "":
+		if u.maintenanceInfoVersion == "" {
+			entry.Unset(registry.MaintenanceInfoVersion)
+
+			return nil
+		}
+
+		return entry.Set(registry.MaintenanceInfoVersion, u.maintenanceInfoVersion)
+	case u.request.MaintenanceInfo != nil:
+		return entry.Set(registry.MaintenanceInfoVersion, u.request.MaintenanceInfo.Version)
+	}
+
+	return nil
+}
+
 // Run performs asynchronous update tasks.
 func (u *Updater) Run(entry *registry.Entry) {
-	if err := operation.Complete(entry, u.run()); err != nil {
-		glog.Infof("failed to delete instance")
+	ctx, cancel := operationContext(entry)
+	defer cancel()
+
+	ctx, span := tracing.Start(ctx, "Updater.Run")
+	defer span.End()
+
+	err := deadlineError(ctx, entry, u.run(ctx, entry))
+
+	// A failed plan migration leaves the service instance on its original plan, so
+	// remove anything created for the new one.  Rollback is not bound by the operation
+	// deadline, as it may have been the cause of the failure.
+	if err != nil && u.planID != "" {
+		err = u.rollback(tracing.ContextWithSpanContext(context.Background(), span.SpanContext()), entry, err)
+	}
+
+	// Record the new plan version once the upgrade or migration has been successfully
+	// applied.
+	if err == nil {
+		err = u.setMaintenanceInfoVersion(entry)
+	}
+
+	span.RecordError(err)
+
+	if err := operation.Complete(entry, err); err != nil {
+		entry.Logger().Infof("failed to delete instance")
 	}
 }
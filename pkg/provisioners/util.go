@@ -15,16 +15,21 @@
 package provisioners
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"time"
 
 	v1 "github.com/couchbase/service-broker/pkg/apis/servicebroker/v1alpha1"
 	"github.com/couchbase/service-broker/pkg/config"
 	"github.com/couchbase/service-broker/pkg/errors"
 	"github.com/couchbase/service-broker/pkg/log"
 	"github.com/couchbase/service-broker/pkg/registry"
+	"github.com/couchbase/service-broker/pkg/tracing"
 
-	"github.com/golang/glog"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 )
 
 // getTemplateBinding returns the binding associated with a specific resource type.
@@ -52,6 +57,146 @@ func getTemplateBinding(t ResourceType, serviceID, planID string) (*v1.ServiceBr
 	return templates, nil
 }
 
+// getConfigurationBinding returns the configuration binding for the service plan
+// associated with a registry entry.
+func getConfigurationBinding(entry *registry.Entry) (*v1.ConfigurationBinding, error) {
+	serviceID, ok, err := entry.GetString(registry.ServiceID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("%w: unable to lookup service ID", ErrResourceReferenceMissing)
+	}
+
+	planID, ok, err := entry.GetString(registry.PlanID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("%w: unable to lookup plan ID", ErrResourceReferenceMissing)
+	}
+
+	return config.Config().GetTemplateBindings(serviceID, planID)
+}
+
+// getMaximumPollingDuration returns the maximum polling duration for the service
+// plan associated with a registry entry, if one is defined.
+func getMaximumPollingDuration(entry *registry.Entry) (time.Duration, bool) {
+	bindings, err := getConfigurationBinding(entry)
+	if err != nil || bindings.MaximumPollingDuration == nil {
+		return 0, false
+	}
+
+	return bindings.MaximumPollingDuration.Duration, true
+}
+
+// traceContext returns a context that continues the trace of the API request that
+// started the operation, so work done in the background, or after a restart, is
+// recorded in the same trace.
+func traceContext(entry *registry.Entry) context.Context {
+	ctx := context.Background()
+
+	traceParent, ok, err := entry.GetString(registry.TraceParent)
+	if err != nil || !ok {
+		return ctx
+	}
+
+	spanContext, ok := tracing.ParseTraceParent(traceParent)
+	if !ok {
+		return ctx
+	}
+
+	return tracing.ContextWithSpanContext(ctx, spanContext)
+}
+
+// operationContext returns a context for an asynchronous operation.  If the service
+// plan defines a maximum polling duration then the context expires when that duration
+// has elapsed since the operation started, so it is honored across restarts.
+func operationContext(entry *registry.Entry) (context.Context, context.CancelFunc) {
+	ctx := traceContext(entry)
+
+	duration, ok := getMaximumPollingDuration(entry)
+	if !ok {
+		return context.WithCancel(ctx)
+	}
+
+	startTime := time.Now()
+
+	if _, err := entry.Get(registry.OperationStartTime, &startTime); err != nil {
+		entry.Logger().Infof("unable to lookup operation start time: %v", err)
+	}
+
+	return context.WithDeadline(ctx, startTime.Add(duration))
+}
+
+// deadlineError replaces an operation error with something more descriptive if it
+// was caused by the operation exceeding its maximum polling duration.
+func deadlineError(ctx context.Context, entry *registry.Entry, err error) error {
+	if err == nil || ctx.Err() != context.DeadlineExceeded {
+		return err
+	}
+
+	duration, _ := getMaximumPollingDuration(entry)
+
+	return fmt.Errorf("%w: operation exceeded the maximum polling duration of %v", ErrDeadlineExceeded, duration)
+}
+
+// getTemplateSteps returns the steps associated with a template list.  If steps are not
+// explicitly defined, then a default step is implicitly created from the deprecated
+// templates and readiness checks.
+func getTemplateSteps(templates *v1.ServiceBrokerTemplateList) []v1.ServiceBrokerTemplateListStep {
+	if templates.Steps != nil {
+		return templates.Steps
+	}
+
+	return []v1.ServiceBrokerTemplateListStep{
+		{
+			Name:            "default",
+			Templates:       templates.Templates,
+			ReadinessChecks: templates.ReadinessChecks,
+		},
+	}
+}
+
+// getTemplateNames returns all template names associated with a template list.
+func getTemplateNames(templates *v1.ServiceBrokerTemplateList) []string {
+	var names []string
+
+	for _, step := range getTemplateSteps(templates) {
+		names = append(names, step.Templates...)
+	}
+
+	return names
+}
+
+// checksum returns a checksum of the template definitions used by a set of steps.  This
+// is used to check that an interrupted operation would create the same resources when it
+// is resumed.  The template source is used rather than the rendered output, as templates
+// may generate values, e.g. passwords, that differ every time they are rendered.  As
+// templates may include snippets, all template definitions are considered.
+func checksum(templates *v1.ServiceBrokerTemplateList) (string, error) {
+	hash := sha256.New()
+
+	for _, step := range getTemplateSteps(templates) {
+		_, _ = hash.Write([]byte(step.Name))
+
+		for _, name := range step.Templates {
+			_, _ = hash.Write([]byte(name))
+		}
+	}
+
+	raw, err := json.Marshal(config.Config().Spec.Templates)
+	if err != nil {
+		return "", err
+	}
+
+	_, _ = hash.Write(raw)
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
 // getTemplate returns the template corresponding to a template name.
 func getTemplate(name string) (*v1.ConfigurationTemplate, error) {
 	for index, template := range config.Config().Spec.Templates {
@@ -66,13 +211,13 @@ func getTemplate(name string) (*v1.ConfigurationTemplate, error) {
 // renderTemplate accepts a template defined in the configuration and applies any
 // request or metadata parameters to it.
 func renderTemplate(template *v1.ConfigurationTemplate, entry *registry.Entry, data interface{}) (*v1.ConfigurationTemplate, error) {
-	glog.Infof("rendering template %s", template.Name)
+	entry.Logger().Infof("rendering template %s", template.Name)
 
 	if template.Template == nil || template.Template.Raw == nil {
 		return nil, errors.NewConfigurationError("template %s is not defined", template.Name)
 	}
 
-	glog.V(log.LevelDebug).Infof("template source: %s", string(template.Template.Raw))
+	entry.Logger().Debugf("template source: %s", log.RedactJSON(template.Template.Raw, template.SensitiveFields...))
 
 	// We will be modifying the template in place, so first clone it as the
 	// config is immutable.
@@ -95,7 +240,83 @@ func renderTemplate(template *v1.ConfigurationTemplate, entry *registry.Entry, d
 
 	t.Template.Raw = raw
 
-	glog.Infof("rendered template %s", string(t.Template.Raw))
+	entry.Logger().Debugf("rendered template %s", log.RedactJSON(t.Template.Raw, t.SensitiveFields...))
 
 	return t, nil
 }
+
+// getMaintenanceInfoVersion returns the maintenance info version of a service plan
+// if one is defined.
+func getMaintenanceInfoVersion(serviceID, planID string) (string, bool) {
+	for _, service := range config.Config().Spec.Catalog.Services {
+		if service.ID != serviceID {
+			continue
+		}
+
+		for _, plan := range service.Plans {
+			if plan.ID == planID && plan.MaintenanceInfo != nil {
+				return plan.MaintenanceInfo.Version, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// sensitiveParameters returns JSON pointers to the parameters that are marked as
+// sensitive by any of the service plan's schemas.
+func sensitiveParameters(entry *registry.Entry) []string {
+	serviceID, _, _ := entry.GetString(registry.ServiceID)
+	planID, _, _ := entry.GetString(registry.PlanID)
+
+	var pointers []string
+
+	for _, service := range config.Config().Spec.Catalog.Services {
+		if service.ID != serviceID {
+			continue
+		}
+
+		for _, plan := range service.Plans {
+			if plan.ID != planID || plan.Schemas == nil {
+				continue
+			}
+
+			var schemas []*v1.InputParamtersSchema
+
+			if plan.Schemas.ServiceInstance != nil {
+				schemas = append(schemas, plan.Schemas.ServiceInstance.Create, plan.Schemas.ServiceInstance.Update)
+			}
+
+			if plan.Schemas.ServiceBinding != nil {
+				schemas = append(schemas, plan.Schemas.ServiceBinding.Create)
+			}
+
+			for _, schema := range schemas {
+				if schema != nil && schema.Parameters != nil {
+					pointers = append(pointers, log.SensitiveSchemaFields(schema.Parameters.Raw)...)
+				}
+			}
+		}
+	}
+
+	return pointers
+}
+
+// annotateOriginatingIdentity records the end user that initiated the operation on a
+// resource, so it can be audited.  If the client did not provide an identity, any
+// existing annotation is removed as it is no longer accurate.
+func annotateOriginatingIdentity(object *unstructured.Unstructured, entry *registry.Entry) error {
+	var identity json.RawMessage
+
+	ok, err := entry.Get(registry.OriginatingIdentity, &identity)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		unstructured.RemoveNestedField(object.Object, "metadata", "annotations", v1.OriginatingIdentityAnnotation)
+		return nil
+	}
+
+	return unstructured.SetNestedField(object.Object, string(identity), "metadata", "annotations", v1.OriginatingIdentityAnnotation)
+}
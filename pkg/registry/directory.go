@@ -164,3 +164,27 @@ func (d *Directory) Remove(instanceID string) error {
 
 	return d.commit()
 }
+
+// Namespaces returns the set of namespaces that contain registry entries.
+func (d *Directory) Namespaces() ([]string, error) {
+	namespaces := []string{}
+
+	seen := map[string]interface{}{}
+
+	for instanceID := range d.secret.Data {
+		dirent, err := d.Lookup(instanceID)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, ok := seen[dirent.Namespace]; ok {
+			continue
+		}
+
+		seen[dirent.Namespace] = nil
+
+		namespaces = append(namespaces, dirent.Namespace)
+	}
+
+	return namespaces, nil
+}
@@ -20,17 +20,19 @@ import (
 	"encoding/json"
 	goerrors "errors"
 	"fmt"
+	"strings"
 
 	v1 "github.com/couchbase/service-broker/pkg/apis/servicebroker/v1alpha1"
 	"github.com/couchbase/service-broker/pkg/config"
 	"github.com/couchbase/service-broker/pkg/errors"
+	"github.com/couchbase/service-broker/pkg/log"
+	"github.com/couchbase/service-broker/pkg/metrics"
 	"github.com/couchbase/service-broker/pkg/version"
 
-	"github.com/golang/glog"
-
 	corev1 "k8s.io/api/core/v1"
 	k8s_errors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 )
 
 // Key is an indentifier of a value in the registry entry's KV map.
@@ -58,6 +60,10 @@ const (
 	// Parameters are the parameters used to create or update the instance or binding.
 	Parameters Key = "parameters"
 
+	// OriginatingIdentity is the end user that initiated the current or last operation
+	// on the instance or binding, as reported by the platform.
+	OriginatingIdentity Key = "originating-identity"
+
 	// Operation records there is an asynchronous operation in progress for the instance or binding.
 	// This is the analogue to an operation.Type.
 	Operation Key = "operation"
@@ -65,14 +71,62 @@ const (
 	// OperationID is the unique ID for an asynchronous operation on an instance or binding.
 	OperationID Key = "operation-id"
 
+	// RequestID is the identity of the API request that started the current or last
+	// operation on the instance or binding.  This allows an operation to be correlated
+	// with API requests, logs and resources.
+	RequestID Key = "request-id"
+
+	// TraceParent is the W3C trace context of the API request that started the
+	// current or last operation on the instance or binding.  This allows the
+	// asynchronous operation to be recorded in the same trace as the request.
+	TraceParent Key = "trace-parent"
+
 	// OperationStatus is the error string returned by an aysynchronous operation.
 	OperationStatus Key = "operation-status"
 
+	// OperationStartTime is the time an asynchronous operation was started.
+	OperationStartTime Key = "operation-start-time"
+
+	// OperationEndTime is the time an asynchronous operation completed.
+	OperationEndTime Key = "operation-end-time"
+
+	// OperationStep is the step an asynchronous operation is currently processing.
+	// This allows an operation to be resumed if the service broker restarts.
+	OperationStep Key = "operation-step"
+
+	// OperationChecksum is a checksum of the template definitions used by an
+	// asynchronous operation.  When an operation is resumed, the template definitions
+	// must match.
+	OperationChecksum Key = "operation-checksum"
+
+	// ProvisionFailedTime is the time a failed provisioning operation was reported
+	// to the client.  Such service instances are candidates for orphan mitigation.
+	ProvisionFailedTime Key = "provision-failed-time"
+
+	// MigratingPlanID is the other service plan involved in a plan change, the new
+	// plan while the operation is in progress, and the previous plan once complete.
+	// Clients may poll the operation with either plan until the operation ends.
+	MigratingPlanID Key = "migrating-plan-id"
+
 	// DashboardURL is the dashboard URL associated with a service instance.
 	DashboardURL Key = "dashboard-url"
 
 	// Credentials is the set of credentials that may be generated for a service binding.
 	Credentials Key = "credentials"
+
+	// MaintenanceInfoVersion is the service plan maintenance info version a service instance
+	// was last provisioned or upgraded with.
+	MaintenanceInfoVersion Key = "maintenance-info-version"
+
+	// Resources is the set of resources created by the service broker for a service
+	// instance or binding.  This is used to safely prune resources that are no longer
+	// required.
+	Resources Key = "resources"
+
+	// SensitiveKeys is the set of user defined keys whose values contain sensitive
+	// data, e.g. generated passwords.  This allows them to be masked in log messages
+	// however short they are, when the entry is loaded again.
+	SensitiveKeys Key = "sensitive-keys"
 )
 
 // ErrPermsission is raised when you don't have permission to read/write a registry key.
@@ -122,6 +176,11 @@ var keyPolicies = []keyPolicy{
 		read:  false,
 		write: false,
 	},
+	{
+		name:  OriginatingIdentity,
+		read:  false,
+		write: false,
+	},
 	{
 		name:  Operation,
 		read:  false,
@@ -132,11 +191,66 @@ var keyPolicies = []keyPolicy{
 		read:  false,
 		write: false,
 	},
+	{
+		name:  RequestID,
+		read:  false,
+		write: false,
+	},
+	{
+		name:  TraceParent,
+		read:  false,
+		write: false,
+	},
 	{
 		name:  OperationStatus,
 		read:  false,
 		write: false,
 	},
+	{
+		name:  OperationStartTime,
+		read:  false,
+		write: false,
+	},
+	{
+		name:  OperationStep,
+		read:  false,
+		write: false,
+	},
+	{
+		name:  OperationEndTime,
+		read:  false,
+		write: false,
+	},
+	{
+		name:  OperationChecksum,
+		read:  false,
+		write: false,
+	},
+	{
+		name:  ProvisionFailedTime,
+		read:  false,
+		write: false,
+	},
+	{
+		name:  Resources,
+		read:  false,
+		write: false,
+	},
+	{
+		name:  SensitiveKeys,
+		read:  false,
+		write: false,
+	},
+	{
+		name:  MigratingPlanID,
+		read:  false,
+		write: false,
+	},
+	{
+		name:  MaintenanceInfoVersion,
+		read:  true,
+		write: false,
+	},
 	{
 		name:  DashboardURL,
 		read:  true,
@@ -203,6 +317,15 @@ type Entry struct {
 	// Once set it cannot be unset.  Read only instances cannot be deleted or
 	// updated.
 	readOnly bool
+
+	// redactor masks user defined values, credentials and other sensitive values
+	// in log messages.  It is created on first use, then kept up to date as the
+	// entry is modified.
+	redactor *log.Redactor
+
+	// sensitive holds values known to be sensitive, so user defined keys they are
+	// stored under can be recorded as sensitive.
+	sensitive *log.Redactor
 }
 
 // Name returns the name of the registry secret.
@@ -219,6 +342,7 @@ func New(t Type, namespace, name string, readOnly bool) (*Entry, error) {
 	secret, err := config.Clients().Kubernetes().CoreV1().Secrets(namespace).Get(context.TODO(), resourceName, metav1.GetOptions{})
 	if err != nil {
 		if !k8s_errors.IsNotFound(err) {
+			metrics.RegistryErrors.Inc(metrics.RegistryRead)
 			return nil, err
 		}
 
@@ -250,14 +374,57 @@ func New(t Type, namespace, name string, readOnly bool) (*Entry, error) {
 	return entry, nil
 }
 
+// List returns all registry entries of the requested type in a namespace.
+func List(t Type, namespace string) ([]*Entry, error) {
+	options := metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(labels.Set{"app": version.Application}).String(),
+	}
+
+	secrets, err := config.Clients().Kubernetes().CoreV1().Secrets(namespace).List(context.TODO(), options)
+	if err != nil {
+		metrics.RegistryErrors.Inc(metrics.RegistryRead)
+		return nil, err
+	}
+
+	prefix := Name(t, "")
+
+	var entries []*Entry
+
+	for i := range secrets.Items {
+		if !strings.HasPrefix(secrets.Items[i].Name, prefix) {
+			continue
+		}
+
+		entry := &Entry{
+			secret: &secrets.Items[i],
+			exists: true,
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
 // Clone duplicates a registry entry, the clone is read only to allow concurrency
 // while the master copy retains its read/write status.
 func (e *Entry) Clone() *Entry {
-	return &Entry{
+	clone := &Entry{
 		secret:   e.secret.DeepCopy(),
 		exists:   e.exists,
 		readOnly: true,
 	}
+
+	// Sensitive values may not be stored in the entry, so copy them too.
+	if e.redactor != nil {
+		clone.redactor = e.redactor.Clone()
+	}
+
+	if e.sensitive != nil {
+		clone.sensitive = e.sensitive.Clone()
+	}
+
+	return clone
 }
 
 // Inherit is used when creating a service binding registry entry.  It gets a copy
@@ -274,6 +441,9 @@ func (e *Entry) Inherit(o *Entry) {
 	for k, v := range o.secret.Data {
 		e.secret.Data[k] = v
 	}
+
+	// Rebuild the redactor on next use to include the inherited values.
+	e.redactor = nil
 }
 
 // Exists indicates whether the entry existed in Kubernetes when it was created.
@@ -290,6 +460,7 @@ func (e *Entry) Commit() error {
 	if e.exists {
 		secret, err := config.Clients().Kubernetes().CoreV1().Secrets(e.secret.Namespace).Update(context.TODO(), e.secret, metav1.UpdateOptions{})
 		if err != nil {
+			metrics.RegistryErrors.Inc(metrics.RegistryWrite)
 			return err
 		}
 
@@ -300,6 +471,7 @@ func (e *Entry) Commit() error {
 
 	secret, err := config.Clients().Kubernetes().CoreV1().Secrets(e.secret.Namespace).Create(context.TODO(), e.secret, metav1.CreateOptions{})
 	if err != nil {
+		metrics.RegistryErrors.Inc(metrics.RegistryWrite)
 		return err
 	}
 
@@ -320,6 +492,7 @@ func (e *Entry) Delete() error {
 	}
 
 	if err := config.Clients().Kubernetes().CoreV1().Secrets(e.secret.Namespace).Delete(context.TODO(), e.secret.Name, metav1.DeleteOptions{}); err != nil {
+		metrics.RegistryErrors.Inc(metrics.RegistryWrite)
 		return err
 	}
 
@@ -369,9 +542,41 @@ func (e *Entry) Set(key Key, value interface{}) error {
 
 	e.secret.Data[string(key)] = data
 
+	if findKeyPolicy(string(key)) == nil && e.sensitive != nil && e.sensitive.Contains(string(data)) {
+		if err := e.addSensitiveKey(string(key)); err != nil {
+			return err
+		}
+	}
+
+	if e.redactor != nil {
+		e.addRedactedValue(string(key), data)
+	}
+
 	return nil
 }
 
+// addSensitiveKey records that a user defined key contains a sensitive value.
+func (e *Entry) addSensitiveKey(key string) error {
+	keys := e.sensitiveKeys()
+
+	for _, k := range keys {
+		if k == key {
+			return nil
+		}
+	}
+
+	return e.Set(SensitiveKeys, append(keys, key))
+}
+
+// sensitiveKeys returns the user defined keys that contain sensitive values.
+func (e *Entry) sensitiveKeys() []string {
+	var keys []string
+
+	_, _ = e.Get(SensitiveKeys, &keys)
+
+	return keys
+}
+
 // GetUser gets and decodes a JSON object from the registry.
 func (e *Entry) GetUser(key string) (interface{}, bool, error) {
 	if !isKeyReadable(key) {
@@ -390,7 +595,7 @@ func (e *Entry) GetUser(key string) (interface{}, bool, error) {
 
 // SetUser encodes a JSON object and sets the entry item.
 func (e *Entry) SetUser(key string, value interface{}) error {
-	glog.Infof("setting registry entry %s to %s", key, value)
+	e.Logger().Infof("setting registry entry %s", key)
 
 	if !isKeyWritable(key) {
 		return errors.NewConfigurationError("registry key %s cannot be written", key)
@@ -399,6 +604,75 @@ func (e *Entry) SetUser(key string, value interface{}) error {
 	return e.Set(Key(key), value)
 }
 
+// Logger returns a logger that tags messages with the API request that started the
+// current or last operation on the entry.  User defined values and credentials are
+// masked in all messages, as they may contain generated passwords and private keys.
+func (e *Entry) Logger() *log.Logger {
+	requestID, _, _ := e.GetString(RequestID)
+
+	return log.New(log.SubsystemOperation).WithRequestID(requestID).WithRedactor(e.getRedactor())
+}
+
+// addRedactedValue masks an entry item in log messages.  Credentials and keys
+// recorded as sensitive are always masked, while other user defined values may be
+// anything, so short values are not.  Other keys hold broker state that is safe to log.
+func (e *Entry) addRedactedValue(key string, data []byte) {
+	switch {
+	case Key(key) == Credentials:
+		e.redactor.AddSensitive(decode(data))
+	case findKeyPolicy(key) == nil:
+		for _, k := range e.sensitiveKeys() {
+			if k == key {
+				e.redactor.AddSensitive(decode(data))
+				return
+			}
+		}
+
+		e.redactor.Add(decode(data))
+	}
+}
+
+// decode returns the generic JSON form of an entry item, so the strings it contains
+// can be masked.
+func decode(data []byte) interface{} {
+	var value interface{}
+
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil
+	}
+
+	return value
+}
+
+// getRedactor returns a redactor that masks user defined values and credentials,
+// creating it on first use.
+func (e *Entry) getRedactor() *log.Redactor {
+	if e.redactor != nil {
+		return e.redactor
+	}
+
+	e.redactor = log.NewRedactor()
+
+	for key, data := range e.secret.Data {
+		e.addRedactedValue(key, data)
+	}
+
+	return e.redactor
+}
+
+// AddSensitive records a sensitive value, e.g. a generated password, so it is masked
+// in all log messages from the entry's logger, regardless of its length.  Any user
+// defined key the value is subsequently stored under is recorded as sensitive.
+func (e *Entry) AddSensitive(value interface{}) {
+	e.getRedactor().AddSensitive(value)
+
+	if e.sensitive == nil {
+		e.sensitive = log.NewRedactor()
+	}
+
+	e.sensitive.AddSensitive(value)
+}
+
 // Unset removes an item from the entry item.
 func (e *Entry) Unset(key Key) {
 	delete(e.secret.Data, string(key))
@@ -0,0 +1,285 @@
+// Copyright 2020-2021 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file  except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the  License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/couchbase/service-broker/pkg/version"
+)
+
+const (
+	// DefaultOTLPEndpoint is the default OTLP/HTTP traces endpoint, that of a
+	// collector running locally e.g. as a sidecar.
+	DefaultOTLPEndpoint = "http://localhost:4318/v1/traces"
+
+	// otlpTimeout is how long to wait for the collector to accept spans.
+	otlpTimeout = 10 * time.Second
+
+	// scopeName identifies the instrumentation that generated the spans.
+	scopeName = "github.com/couchbase/service-broker"
+
+	// defaultServiceName is used when the application name is not set at build time.
+	defaultServiceName = "service-broker"
+)
+
+// ErrExportFailed is returned when the collector rejects spans.
+var ErrExportFailed = errors.New("trace export failed")
+
+// otlpAnyValue is an OTLP attribute value.  Integers are encoded as strings
+// as defined by the protobuf JSON mapping.
+type otlpAnyValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+	BoolValue   *bool   `json:"boolValue,omitempty"`
+}
+
+// otlpKeyValue is an OTLP attribute.
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+// otlpStatus is an OTLP span status.
+type otlpStatus struct {
+	Code    StatusCode `json:"code,omitempty"`
+	Message string     `json:"message,omitempty"`
+}
+
+// otlpSpan is an OTLP span.  Trace and span IDs are hex encoded as defined by
+// the OTLP/JSON specification.
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              SpanKind       `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+// otlpScope identifies the instrumentation that generated spans.
+type otlpScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+// otlpScopeSpans is a set of spans generated by some instrumentation.
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+// otlpResource identifies the process that generated spans.
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+// otlpResourceSpans is a set of spans generated by a process.
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+// otlpRequest is an OTLP ExportTraceServiceRequest.
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+// newOTLPKeyValue encodes an attribute.
+func newOTLPKeyValue(attribute Attribute) otlpKeyValue {
+	kv := otlpKeyValue{
+		Key: attribute.Key,
+	}
+
+	switch value := attribute.Value.(type) {
+	case int64:
+		s := strconv.FormatInt(value, 10)
+		kv.Value.IntValue = &s
+	case bool:
+		kv.Value.BoolValue = &value
+	default:
+		s := fmt.Sprint(value)
+		kv.Value.StringValue = &s
+	}
+
+	return kv
+}
+
+// newOTLPSpan encodes a span.
+func newOTLPSpan(span *SpanData) otlpSpan {
+	s := otlpSpan{
+		TraceID:           span.SpanContext.TraceID.String(),
+		SpanID:            span.SpanContext.SpanID.String(),
+		Name:              span.Name,
+		Kind:              span.Kind,
+		StartTimeUnixNano: strconv.FormatInt(span.StartTime.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(span.EndTime.UnixNano(), 10),
+		Status: otlpStatus{
+			Code:    span.Status,
+			Message: span.StatusMessage,
+		},
+	}
+
+	if span.ParentSpanID != (SpanID{}) {
+		s.ParentSpanID = span.ParentSpanID.String()
+	}
+
+	for _, attribute := range span.Attributes {
+		s.Attributes = append(s.Attributes, newOTLPKeyValue(attribute))
+	}
+
+	return s
+}
+
+// newOTLPRequest encodes a batch of spans.
+func newOTLPRequest(spans []*SpanData) *otlpRequest {
+	serviceName := version.Application
+	if serviceName == "" {
+		serviceName = defaultServiceName
+	}
+
+	scopeSpans := otlpScopeSpans{
+		Scope: otlpScope{
+			Name:    scopeName,
+			Version: version.Version,
+		},
+	}
+
+	for _, span := range spans {
+		scopeSpans.Spans = append(scopeSpans.Spans, newOTLPSpan(span))
+	}
+
+	resource := otlpResource{
+		Attributes: []otlpKeyValue{
+			newOTLPKeyValue(String("service.name", serviceName)),
+		},
+	}
+
+	if version.Version != "" {
+		resource.Attributes = append(resource.Attributes, newOTLPKeyValue(String("service.version", version.Version)))
+	}
+
+	return &otlpRequest{
+		ResourceSpans: []otlpResourceSpans{
+			{
+				Resource:   resource,
+				ScopeSpans: []otlpScopeSpans{scopeSpans},
+			},
+		},
+	}
+}
+
+// OTLPExporter sends spans to an OpenTelemetry collector using OTLP/HTTP with
+// JSON encoding.
+type OTLPExporter struct {
+	// endpoint is the collector's traces URL.
+	endpoint string
+
+	// client is used to send spans.
+	client *http.Client
+}
+
+// NewOTLPExporter returns an exporter that sends spans to the OTLP/HTTP traces
+// endpoint e.g. http://localhost:4318/v1/traces.
+func NewOTLPExporter(endpoint string) *OTLPExporter {
+	return &OTLPExporter{
+		endpoint: endpoint,
+		client: &http.Client{
+			Timeout: otlpTimeout,
+		},
+	}
+}
+
+// Export sends a batch of spans to the collector.
+func (e *OTLPExporter) Export(spans []*SpanData) error {
+	body, err := json.Marshal(newOTLPRequest(spans))
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequest(http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := e.client.Do(request)
+	if err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+
+	// Drain the body so the connection can be reused.
+	_, _ = io.Copy(ioutil.Discard, response.Body)
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("%w: %s responded %s", ErrExportFailed, e.endpoint, response.Status)
+	}
+
+	return nil
+}
+
+// WriterExporter writes spans as OTLP/JSON, one per line.  It is intended for
+// offline testing where there is no collector.
+type WriterExporter struct {
+	// lock serializes writes.
+	lock sync.Mutex
+
+	// writer is where spans are written.
+	writer io.Writer
+}
+
+// NewWriterExporter returns an exporter that writes spans to the writer.
+func NewWriterExporter(writer io.Writer) *WriterExporter {
+	return &WriterExporter{
+		writer: writer,
+	}
+}
+
+// NewStdoutExporter returns an exporter that writes spans to standard output.
+func NewStdoutExporter() *WriterExporter {
+	return NewWriterExporter(os.Stdout)
+}
+
+// Export writes a batch of spans.
+func (e *WriterExporter) Export(spans []*SpanData) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	encoder := json.NewEncoder(e.writer)
+
+	for _, span := range spans {
+		if err := encoder.Encode(newOTLPSpan(span)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
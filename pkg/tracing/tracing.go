@@ -0,0 +1,467 @@
+// Copyright 2020-2021 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file  except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the  License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/couchbase/service-broker/pkg/log"
+)
+
+const (
+	// maxQueueSize is the maximum number of ended spans waiting to be exported.
+	// Spans are dropped when this is exceeded, e.g. when the collector is down.
+	maxQueueSize = 2048
+
+	// maxBatchSize is the maximum number of spans exported at once.
+	maxBatchSize = 512
+
+	// exportInterval is how often ended spans are exported.
+	exportInterval = 5 * time.Second
+)
+
+// TraceID uniquely identifies a trace.
+type TraceID [16]byte
+
+// String returns the trace ID as hex.
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+// SpanID uniquely identifies a span within a trace.
+type SpanID [8]byte
+
+// String returns the span ID as hex.
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+// SpanContext identifies a span, and is propagated to child spans, across
+// goroutines and across processes.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+}
+
+// IsValid returns whether the span context identifies a span.
+func (s SpanContext) IsValid() bool {
+	return s.TraceID != TraceID{} && s.SpanID != SpanID{}
+}
+
+// TraceParent returns the span context as a W3C traceparent header value, or an
+// empty string if the span context is not valid.
+func (s SpanContext) TraceParent() string {
+	if !s.IsValid() {
+		return ""
+	}
+
+	return fmt.Sprintf("00-%s-%s-01", s.TraceID, s.SpanID)
+}
+
+// ParseTraceParent parses a W3C traceparent header value.
+func ParseTraceParent(traceParent string) (SpanContext, bool) {
+	fields := strings.Split(traceParent, "-")
+
+	expectedFields := 4
+	if len(fields) < expectedFields || len(fields[0]) != 2 || fields[0] == "ff" {
+		return SpanContext{}, false
+	}
+
+	var s SpanContext
+
+	traceID, err := hex.DecodeString(fields[1])
+	if err != nil || len(traceID) != len(s.TraceID) {
+		return SpanContext{}, false
+	}
+
+	spanID, err := hex.DecodeString(fields[2])
+	if err != nil || len(spanID) != len(s.SpanID) {
+		return SpanContext{}, false
+	}
+
+	copy(s.TraceID[:], traceID)
+	copy(s.SpanID[:], spanID)
+
+	if !s.IsValid() {
+		return SpanContext{}, false
+	}
+
+	return s, true
+}
+
+// SpanKind describes the relationship between a span and its parent, the values
+// are those used by OTLP.
+type SpanKind int
+
+const (
+	// SpanKindInternal is an operation within the service broker.
+	SpanKindInternal SpanKind = 1
+
+	// SpanKindServer is an API request handled by the service broker.
+	SpanKindServer SpanKind = 2
+)
+
+// StatusCode is the status of a span, the values are those used by OTLP.
+type StatusCode int
+
+const (
+	// StatusUnset is the default status.
+	StatusUnset StatusCode = 0
+
+	// StatusError indicates the operation failed.
+	StatusError StatusCode = 2
+)
+
+// Attribute is a key value pair attached to a span.
+type Attribute struct {
+	Key string
+
+	// Value is either a string, an int64 or a bool.
+	Value interface{}
+}
+
+// String returns a string attribute.
+func String(key, value string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Int returns an integer attribute.
+func Int(key string, value int) Attribute {
+	return Attribute{Key: key, Value: int64(value)}
+}
+
+// Bool returns a boolean attribute.
+func Bool(key string, value bool) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// SpanData is a record of an ended span.
+type SpanData struct {
+	// Name describes the operation.
+	Name string
+
+	// Kind is the relationship between the span and its parent.
+	Kind SpanKind
+
+	// SpanContext identifies the span.
+	SpanContext SpanContext
+
+	// ParentSpanID is the parent span, and is zero for root spans.
+	ParentSpanID SpanID
+
+	// StartTime and EndTime are when the operation started and ended.
+	StartTime time.Time
+	EndTime   time.Time
+
+	// Attributes describe the operation.
+	Attributes []Attribute
+
+	// Status and StatusMessage record whether the operation failed and why.
+	Status        StatusCode
+	StatusMessage string
+}
+
+// Span records a single operation.  A nil span, returned when tracing is disabled,
+// is valid and does nothing.
+type Span struct {
+	// lock protects the fields below.
+	lock sync.Mutex
+
+	// data is the span record exported when the span ends.
+	data SpanData
+
+	// ended is set once the span has ended, further changes are ignored.
+	ended bool
+}
+
+// SpanContext returns the span's identity.
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+
+	return s.data.SpanContext
+}
+
+// SetAttributes adds attributes to the span.
+func (s *Span) SetAttributes(attributes ...Attribute) {
+	if s == nil {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.data.Attributes = append(s.data.Attributes, attributes...)
+}
+
+// RecordError marks the span as failed if the error is not nil.
+func (s *Span) RecordError(err error) {
+	if err == nil {
+		return
+	}
+
+	s.SetError(err.Error())
+}
+
+// SetError marks the span as failed.
+func (s *Span) SetError(message string) {
+	if s == nil {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.data.Status = StatusError
+	s.data.StatusMessage = message
+}
+
+// End ends the span and queues it for export.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.ended {
+		return
+	}
+
+	s.ended = true
+	s.data.EndTime = time.Now()
+
+	data := s.data
+	data.Attributes = append([]Attribute{}, s.data.Attributes...)
+
+	enqueue(&data)
+}
+
+// spanContextKey is used to store the current span context in a context.
+type spanContextKey struct{}
+
+// ContextWithSpanContext returns a new context containing the span context, new
+// spans started from the context will be its children.  This is used to continue
+// a trace started elsewhere e.g. by the platform or a previous API request.
+func ContextWithSpanContext(ctx context.Context, spanContext SpanContext) context.Context {
+	if !spanContext.IsValid() {
+		return ctx
+	}
+
+	return context.WithValue(ctx, spanContextKey{}, spanContext)
+}
+
+// SpanContextFromContext returns the current span context, this is not valid if
+// there is no current span.
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	if spanContext, ok := ctx.Value(spanContextKey{}).(SpanContext); ok {
+		return spanContext
+	}
+
+	return SpanContext{}
+}
+
+// Start starts a new internal span as a child of the current span, if any.  The
+// returned context contains the new span.  The span must be ended.
+func Start(ctx context.Context, name string, attributes ...Attribute) (context.Context, *Span) {
+	return start(ctx, SpanKindInternal, name, attributes)
+}
+
+// StartServer starts a new span for an API request as a child of the current span,
+// if any.  The returned context contains the new span.  The span must be ended.
+func StartServer(ctx context.Context, name string, attributes ...Attribute) (context.Context, *Span) {
+	return start(ctx, SpanKindServer, name, attributes)
+}
+
+// start starts a new span.
+func start(ctx context.Context, kind SpanKind, name string, attributes []Attribute) (context.Context, *Span) {
+	if !Enabled() {
+		return ctx, nil
+	}
+
+	parent := SpanContextFromContext(ctx)
+
+	span := &Span{
+		data: SpanData{
+			Name:         name,
+			Kind:         kind,
+			ParentSpanID: parent.SpanID,
+			StartTime:    time.Now(),
+			Attributes:   append([]Attribute{}, attributes...),
+		},
+	}
+
+	span.data.SpanContext.TraceID = parent.TraceID
+
+	if !parent.IsValid() {
+		randomID(span.data.SpanContext.TraceID[:])
+	}
+
+	randomID(span.data.SpanContext.SpanID[:])
+
+	return ContextWithSpanContext(ctx, span.data.SpanContext), span
+}
+
+// randomID fills an ID with random data.
+func randomID(id []byte) {
+	if _, err := rand.Read(id); err != nil {
+		log.New(log.SubsystemTracing).Warningf("failed to generate trace ID: %v", err)
+	}
+}
+
+// Exporter sends ended spans to a tracing backend.
+type Exporter interface {
+	// Export sends a batch of spans.
+	Export(spans []*SpanData) error
+}
+
+// provider queues ended spans and periodically exports them.
+var provider = struct {
+	// lock protects the fields below.
+	lock sync.Mutex
+
+	// exporter is where spans are sent, tracing is disabled if nil.
+	exporter Exporter
+
+	// queue is the set of ended spans waiting to be exported.
+	queue []*SpanData
+
+	// dropped is the number of spans dropped since the last export, because
+	// the queue was full.
+	dropped int
+
+	// exportLock serializes exports.
+	exportLock sync.Mutex
+
+	// once starts the export loop.
+	once sync.Once
+}{}
+
+// SetExporter enables tracing and sets where spans are exported to.  Setting a
+// nil exporter disables tracing.
+func SetExporter(exporter Exporter) {
+	provider.lock.Lock()
+	defer provider.lock.Unlock()
+
+	provider.exporter = exporter
+	provider.queue = nil
+
+	if exporter != nil {
+		provider.once.Do(func() {
+			go exportLoop()
+		})
+	}
+}
+
+// Enabled returns whether tracing is enabled.
+func Enabled() bool {
+	provider.lock.Lock()
+	defer provider.lock.Unlock()
+
+	return provider.exporter != nil
+}
+
+// enqueue queues an ended span for export.
+func enqueue(span *SpanData) {
+	provider.lock.Lock()
+	defer provider.lock.Unlock()
+
+	if provider.exporter == nil {
+		return
+	}
+
+	if len(provider.queue) >= maxQueueSize {
+		provider.dropped++
+		return
+	}
+
+	provider.queue = append(provider.queue, span)
+}
+
+// export exports a batch of spans, returning whether there are more to export.
+func export() (bool, error) {
+	provider.exportLock.Lock()
+	defer provider.exportLock.Unlock()
+
+	provider.lock.Lock()
+
+	exporter := provider.exporter
+	batch := provider.queue
+
+	if len(batch) > maxBatchSize {
+		batch = batch[:maxBatchSize]
+	}
+
+	provider.queue = provider.queue[len(batch):]
+	more := len(provider.queue) != 0
+
+	dropped := provider.dropped
+	provider.dropped = 0
+
+	provider.lock.Unlock()
+
+	if dropped != 0 {
+		log.New(log.SubsystemTracing).Warningf("trace queue full, dropped %d spans", dropped)
+	}
+
+	if exporter == nil || len(batch) == 0 {
+		return false, nil
+	}
+
+	if err := exporter.Export(batch); err != nil {
+		return false, err
+	}
+
+	return more, nil
+}
+
+// exportLoop periodically exports ended spans, and never returns.
+func exportLoop() {
+	for range time.Tick(exportInterval) {
+		for {
+			more, err := export()
+			if err != nil {
+				log.New(log.SubsystemTracing).Warningf("failed to export spans: %v", err)
+				break
+			}
+
+			if !more {
+				break
+			}
+		}
+	}
+}
+
+// Flush exports all spans that have ended, e.g. before the process exits.
+func Flush() error {
+	for {
+		more, err := export()
+		if err != nil {
+			return err
+		}
+
+		if !more {
+			return nil
+		}
+	}
+}
@@ -33,9 +33,10 @@ var ErrTimeout = errors.New("process timed out")
 // WaitFunc is a callback that stops a wait when nil.
 type WaitFunc func() error
 
-// WaitFor waits until a condition is nil.
-func WaitFor(f WaitFunc, timeout time.Duration) error {
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+// WaitFor waits until a condition is nil.  The wait is abandoned early if the
+// parent context is cancelled or reaches its deadline.
+func WaitFor(ctx context.Context, f WaitFunc, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
 	tick := time.NewTicker(retryPeriod)
@@ -54,7 +55,7 @@ func WaitFor(f WaitFunc, timeout time.Duration) error {
 
 // MustWaitFor waits until a condition is nil.
 func MustWaitFor(t *testing.T, f WaitFunc, timeout time.Duration) {
-	if err := WaitFor(f, timeout); err != nil {
+	if err := WaitFor(context.Background(), f, timeout); err != nil {
 		t.Fatal(err)
 	}
 }
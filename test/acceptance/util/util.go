@@ -377,7 +377,7 @@ func DeleteResource(clients client.Clients, namespace string, object *unstructur
 	}
 
 	// Wait for deletion.
-	if err := util.WaitFor(ResourceDeleted(clients, namespace, object), time.Minute); err != nil {
+	if err := util.WaitFor(context.Background(), ResourceDeleted(clients, namespace, object), time.Minute); err != nil {
 		glog.V(1).Info(err)
 	}
 }
@@ -15,6 +15,7 @@
 package util
 
 import (
+	"context"
 	"testing"
 	"time"
 
@@ -23,7 +24,7 @@ import (
 
 // WaitFor waits until a condition is nil.
 func WaitFor(f util.WaitFunc, timeout time.Duration) error {
-	return util.WaitFor(f, timeout)
+	return util.WaitFor(context.Background(), f, timeout)
 }
 
 // MustWaitFor waits until a condition is nil.
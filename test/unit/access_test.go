@@ -0,0 +1,170 @@
+// Copyright 2020-2021 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file  except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the  License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unit_test
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/couchbase/service-broker/pkg/api"
+	v1 "github.com/couchbase/service-broker/pkg/apis/servicebroker/v1alpha1"
+	"github.com/couchbase/service-broker/pkg/broker"
+	"github.com/couchbase/service-broker/test/unit/fixtures"
+	"github.com/couchbase/service-broker/test/unit/util"
+
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// identityAuthenticator authenticates every request as a fixed identity.
+type identityAuthenticator struct {
+	identity *broker.Identity
+}
+
+// Authenticate returns the fixed identity.
+func (a *identityAuthenticator) Authenticate(r *http.Request) (*broker.Identity, error) {
+	return a.identity, nil
+}
+
+// mustSetIdentity authenticates all requests as the given identity, returning a
+// function to restore the original authenticator.
+func mustSetIdentity(subject string, groups ...string) func() {
+	return mustSetAuthenticator(&identityAuthenticator{
+		identity: &broker.Identity{
+			Subject: subject,
+			Groups:  groups,
+		},
+	})
+}
+
+// mustGetCatalogPlans returns the names of all service plans in the catalog.
+func mustGetCatalogPlans(t *testing.T) []string {
+	catalog := &api.ServiceCatalog{}
+	util.MustGet(t, "/v2/catalog", http.StatusOK, catalog)
+
+	plans := []string{}
+
+	for _, service := range catalog.Services {
+		for _, plan := range service.Plans {
+			plans = append(plans, plan.Name)
+		}
+	}
+
+	return plans
+}
+
+// cloudFoundryContext returns a Cloud Foundry platform context for an organization.
+func cloudFoundryContext(organization string) *runtime.RawExtension {
+	return &runtime.RawExtension{
+		Raw: []byte(`{"platform":"cloudfoundry","organization_guid":"` + organization + `","space_guid":"grayskull"}`),
+	}
+}
+
+// TestAccessCatalogIdentity tests that the catalog only contains service plans
+// available to the client's identity.
+func TestAccessCatalogIdentity(t *testing.T) {
+	defer mustReset(t)
+
+	configuration := fixtures.BasicConfiguration()
+	configuration.AccessRules = []v1.AccessRule{
+		{
+			Service:  "test-offering",
+			Plans:    []string{"test-plan-2"},
+			Subjects: []string{"HeMan"},
+		},
+		{
+			Service: "test-offering",
+			Plans:   []string{"test-plan-2", "test-plan-3"},
+			Groups:  []string{"evil-horde"},
+		},
+	}
+
+	util.MustReplaceBrokerConfig(t, clients, configuration)
+
+	restore := mustSetIdentity("HeMan", "masters-of-the-universe")
+	util.Assert(t, reflect.DeepEqual(mustGetCatalogPlans(t), []string{"test-plan", "test-plan-2", "test-plan-4"}))
+	restore()
+
+	restore = mustSetIdentity("Skeletor", "evil-horde")
+	util.Assert(t, reflect.DeepEqual(mustGetCatalogPlans(t), []string{"test-plan", "test-plan-2", "test-plan-3", "test-plan-4"}))
+	restore()
+
+	restore = mustSetIdentity("Orko")
+	util.Assert(t, reflect.DeepEqual(mustGetCatalogPlans(t), []string{"test-plan", "test-plan-4"}))
+	restore()
+}
+
+// TestAccessCreateServiceInstancePlatformContext tests that service instances can only
+// be created from a platform context allowed to use the service plan.
+func TestAccessCreateServiceInstancePlatformContext(t *testing.T) {
+	defer mustReset(t)
+
+	configuration := fixtures.BasicConfiguration()
+	configuration.AccessRules = []v1.AccessRule{
+		{
+			Service:       "test-offering",
+			Plans:         []string{"test-plan"},
+			Organizations: []string{"eternia"},
+		},
+	}
+
+	util.MustReplaceBrokerConfig(t, clients, configuration)
+
+	// The catalog has no platform context, so the plan must be advertised.
+	util.Assert(t, reflect.DeepEqual(mustGetCatalogPlans(t), []string{"test-plan", "test-plan-2", "test-plan-3", "test-plan-4"}))
+
+	req := fixtures.BasicServiceInstanceCreateRequest()
+	req.Context = cloudFoundryContext("snake-mountain")
+	util.MustPutAndError(t, util.ServiceInstanceURI(fixtures.ServiceInstanceName, util.CreateServiceInstanceQuery()), http.StatusForbidden, req, api.ErrorForbidden)
+
+	req.Context = nil
+	util.MustPutAndError(t, util.ServiceInstanceURI(fixtures.ServiceInstanceName, util.CreateServiceInstanceQuery()), http.StatusForbidden, req, api.ErrorForbidden)
+
+	req.Context = cloudFoundryContext("eternia")
+	util.MustCreateServiceInstanceSuccessfully(t, fixtures.ServiceInstanceName, req)
+}
+
+// TestAccessExistingServiceInstance tests that service instances cannot be updated or
+// bound once the platform context is no longer allowed to use the service plan.
+func TestAccessExistingServiceInstance(t *testing.T) {
+	defer mustReset(t)
+
+	configuration := fixtures.BasicConfiguration()
+
+	util.MustReplaceBrokerConfig(t, clients, configuration)
+
+	req := fixtures.BasicServiceInstanceCreateRequest()
+	req.Context = cloudFoundryContext("eternia")
+	util.MustCreateServiceInstanceSuccessfully(t, fixtures.ServiceInstanceName, req)
+
+	configuration.AccessRules = []v1.AccessRule{
+		{
+			Service:       "test-offering",
+			Organizations: []string{"snake-mountain"},
+		},
+	}
+
+	util.MustReplaceBrokerConfig(t, clients, configuration)
+
+	// The platform context is inherited from the service instance.
+	updateReq := fixtures.BasicServiceInstanceUpdateRequest()
+	util.MustPatchAndError(t, util.ServiceInstanceURI(fixtures.ServiceInstanceName, util.UpdateServiceInstanceQuery()), http.StatusForbidden, updateReq, api.ErrorForbidden)
+
+	bindingReq := fixtures.BasicServiceBindingCreateRequest()
+	util.MustPutAndError(t, util.ServiceBindingURI(fixtures.ServiceInstanceName, fixtures.ServiceBindingName, nil), http.StatusForbidden, bindingReq, api.ErrorForbidden)
+
+	bindingReq.Context = cloudFoundryContext("snake-mountain")
+	util.MustCreateServiceBinding(t, fixtures.ServiceInstanceName, fixtures.ServiceBindingName, bindingReq)
+}
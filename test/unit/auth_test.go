@@ -0,0 +1,327 @@
+// Copyright 2020-2021 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file  except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the  License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unit_test
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/couchbase/service-broker/pkg/broker"
+	"github.com/couchbase/service-broker/test/unit/fixtures"
+	"github.com/couchbase/service-broker/test/unit/util"
+)
+
+// mustSetAuthenticator replaces the service broker's authenticator, returning a
+// function to restore the original.
+func mustSetAuthenticator(authenticator broker.Authenticator) func() {
+	original := configuration.Authenticator
+
+	configuration.Authenticator = authenticator
+
+	return func() {
+		configuration.Authenticator = original
+	}
+}
+
+// mustVerifyAuthorization checks the response to a request with an Authorization header.
+func mustVerifyAuthorization(t *testing.T, authorization string, statusCode int) {
+	request := util.MustDefaultRequest(t, http.MethodGet, "/v2/catalog")
+	request.Header.Set("Authorization", authorization)
+
+	client := util.MustDefaultClient(t)
+
+	response := util.MustDoRequest(t, client, request)
+	defer response.Body.Close()
+
+	util.MustVerifyStatusCode(t, response, statusCode)
+}
+
+// mustVerifyBasicAuthorization checks the response to a request with basic authorization.
+func mustVerifyBasicAuthorization(t *testing.T, username, password string, statusCode int) {
+	request := util.MustDefaultRequest(t, http.MethodGet, "/v2/catalog")
+	request.SetBasicAuth(username, password)
+
+	client := util.MustDefaultClient(t)
+
+	response := util.MustDoRequest(t, client, request)
+	defer response.Body.Close()
+
+	util.MustVerifyStatusCode(t, response, statusCode)
+}
+
+// TestAuthenticationBearerTokenMultiple tests that any configured bearer token is accepted.
+func TestAuthenticationBearerTokenMultiple(t *testing.T) {
+	defer mustReset(t)
+
+	util.MustReplaceBrokerConfig(t, clients, fixtures.BasicConfiguration())
+
+	defer mustSetAuthenticator(broker.NewBearerTokenAuthenticator(broker.StaticCredential("HeMan"), broker.StaticCredential("Skeletor")))()
+
+	mustVerifyAuthorization(t, "Bearer HeMan", http.StatusOK)
+	mustVerifyAuthorization(t, "Bearer Skeletor", http.StatusOK)
+	mustVerifyAuthorization(t, "Bearer Orko", http.StatusUnauthorized)
+	mustVerifyAuthorization(t, "Basic SGVNYW4=", http.StatusUnauthorized)
+}
+
+// TestAuthenticationBasic tests that any configured username and password pair is accepted.
+func TestAuthenticationBasic(t *testing.T) {
+	defer mustReset(t)
+
+	util.MustReplaceBrokerConfig(t, clients, fixtures.BasicConfiguration())
+
+	credentials := []broker.BasicAuthCredential{
+		{
+			Username: broker.StaticCredential("HeMan"),
+			Password: broker.StaticCredential("Grayskull"),
+		},
+		{
+			Username: broker.StaticCredential("Skeletor"),
+			Password: broker.StaticCredential("SnakeMountain"),
+		},
+	}
+
+	defer mustSetAuthenticator(broker.NewBasicAuthenticator(credentials...))()
+
+	mustVerifyBasicAuthorization(t, "HeMan", "Grayskull", http.StatusOK)
+	mustVerifyBasicAuthorization(t, "Skeletor", "SnakeMountain", http.StatusOK)
+	mustVerifyBasicAuthorization(t, "HeMan", "SnakeMountain", http.StatusUnauthorized)
+	mustVerifyAuthorization(t, "Basic not-base64", http.StatusUnauthorized)
+	mustVerifyAuthorization(t, "Bearer HeMan", http.StatusUnauthorized)
+}
+
+// TestAuthenticationCredentialReload tests that credentials loaded from a file are
+// updated when the file changes.
+func TestAuthenticationCredentialReload(t *testing.T) {
+	defer mustReset(t)
+
+	util.MustReplaceBrokerConfig(t, clients, fixtures.BasicConfiguration())
+
+	dir, err := ioutil.TempDir("", "credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "token")
+
+	if err := ioutil.WriteFile(path, []byte("HeMan"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	credential, err := broker.NewFileCredential(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer mustSetAuthenticator(broker.NewBearerTokenAuthenticator(credential))()
+
+	mustVerifyAuthorization(t, "Bearer HeMan", http.StatusOK)
+
+	if err := ioutil.WriteFile(path, []byte("Skeletor"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := credential.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	mustVerifyAuthorization(t, "Bearer HeMan", http.StatusUnauthorized)
+	mustVerifyAuthorization(t, "Bearer Skeletor", http.StatusOK)
+}
+
+// TestAuthenticationCredentialReloadEmpty tests that an empty credential file is
+// rejected, and the last good credential is retained.
+func TestAuthenticationCredentialReloadEmpty(t *testing.T) {
+	defer mustReset(t)
+
+	util.MustReplaceBrokerConfig(t, clients, fixtures.BasicConfiguration())
+
+	dir, err := ioutil.TempDir("", "credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "token")
+
+	if err := ioutil.WriteFile(path, []byte(" \n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := broker.NewFileCredential(path); err == nil {
+		t.Fatal("expected empty credential to be rejected")
+	}
+
+	if err := ioutil.WriteFile(path, []byte("HeMan"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	credential, err := broker.NewFileCredential(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer mustSetAuthenticator(broker.NewBearerTokenAuthenticator(credential))()
+
+	if err := ioutil.WriteFile(path, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := credential.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	mustVerifyAuthorization(t, "Bearer HeMan", http.StatusOK)
+	mustVerifyAuthorization(t, "Bearer ", http.StatusUnauthorized)
+}
+
+// mustStartClientCertificateServer starts a service broker that authenticates with client
+// certificates.  This needs its own server, as TLS configuration is fixed at start up.
+func mustStartClientCertificateServer(authenticator *broker.ClientCertificateAuthenticator) *httptest.Server {
+	c := &broker.ServerConfiguration{
+		Namespace:     configuration.Namespace,
+		Authenticator: authenticator,
+		Scheduler:     configuration.Scheduler,
+	}
+
+	server := httptest.NewUnstartedServer(broker.NewOpenServiceBrokerHandler(c))
+	server.TLS = &tls.Config{}
+	authenticator.ConfigureTLS(server.TLS)
+	server.StartTLS()
+
+	return server
+}
+
+// mustVerifyClientCertificate checks the response to a request with an optional client certificate.
+func mustVerifyClientCertificate(t *testing.T, server *httptest.Server, certificate *tls.Certificate, statusCode int) {
+	// Use a new transport each time, so connections with other certificates are
+	// not reused.
+	transport, ok := server.Client().Transport.(*http.Transport)
+	util.Assert(t, ok)
+
+	transport = transport.Clone()
+
+	if certificate != nil {
+		transport.TLSClientConfig.Certificates = []tls.Certificate{*certificate}
+	}
+
+	client := &http.Client{
+		Transport: transport,
+	}
+
+	request, err := http.NewRequest(http.MethodGet, server.URL+"/v2/catalog", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request.Header.Set("X-Broker-API-Version", "2.13")
+
+	response := util.MustDoRequest(t, client, request)
+	defer response.Body.Close()
+
+	util.MustVerifyStatusCode(t, response, statusCode)
+}
+
+// mustFailClientCertificateHandshake checks a request with an optional client certificate
+// is rejected by the TLS handshake.
+func mustFailClientCertificateHandshake(t *testing.T, server *httptest.Server, certificate *tls.Certificate) {
+	transport, ok := server.Client().Transport.(*http.Transport)
+	util.Assert(t, ok)
+
+	transport = transport.Clone()
+
+	if certificate != nil {
+		transport.TLSClientConfig.Certificates = []tls.Certificate{*certificate}
+	}
+
+	client := &http.Client{
+		Transport: transport,
+	}
+
+	if response, err := client.Get(server.URL + "/v2/catalog"); err == nil {
+		response.Body.Close()
+		t.Fatal("expected TLS handshake to fail")
+	}
+}
+
+// TestAuthenticationClientCertificate tests that any client certificate signed by a
+// trusted CA is accepted.
+func TestAuthenticationClientCertificate(t *testing.T) {
+	defer mustReset(t)
+
+	util.MustReplaceBrokerConfig(t, clients, fixtures.BasicConfiguration())
+
+	ca := util.MustNewCertificateAuthority(t)
+	untrusted := util.MustNewCertificateAuthority(t)
+
+	server := mustStartClientCertificateServer(broker.NewClientCertificateAuthenticator(ca.CertPool()))
+	defer server.Close()
+
+	certificate := ca.MustNewClientCertificate(t, "HeMan")
+	mustVerifyClientCertificate(t, server, &certificate, http.StatusOK)
+
+	// Missing and untrusted certificates fail the TLS handshake.
+	mustFailClientCertificateHandshake(t, server, nil)
+
+	certificate = untrusted.MustNewClientCertificate(t, "Skeletor")
+	mustFailClientCertificateHandshake(t, server, &certificate)
+}
+
+// TestAuthenticationClientCertificateReadiness tests that the readiness probe is not
+// exempt from client certificate verification.
+func TestAuthenticationClientCertificateReadiness(t *testing.T) {
+	defer mustReset(t)
+
+	util.MustReplaceBrokerConfig(t, clients, fixtures.BasicConfiguration())
+
+	ca := util.MustNewCertificateAuthority(t)
+
+	server := mustStartClientCertificateServer(broker.NewClientCertificateAuthenticator(ca.CertPool()))
+	defer server.Close()
+
+	if response, err := server.Client().Get(server.URL + "/readyz"); err == nil {
+		response.Body.Close()
+		t.Fatal("expected TLS handshake to fail")
+	}
+}
+
+// TestAuthenticationClientCertificateAllowedNames tests that client certificates must
+// match an allowed name, if specified.
+func TestAuthenticationClientCertificateAllowedNames(t *testing.T) {
+	defer mustReset(t)
+
+	util.MustReplaceBrokerConfig(t, clients, fixtures.BasicConfiguration())
+
+	ca := util.MustNewCertificateAuthority(t)
+
+	server := mustStartClientCertificateServer(broker.NewClientCertificateAuthenticator(ca.CertPool(), "HeMan", "castle.grayskull.eternia"))
+	defer server.Close()
+
+	certificate := ca.MustNewClientCertificate(t, "HeMan")
+	mustVerifyClientCertificate(t, server, &certificate, http.StatusOK)
+
+	certificate = ca.MustNewClientCertificate(t, "Teela", "castle.grayskull.eternia")
+	mustVerifyClientCertificate(t, server, &certificate, http.StatusOK)
+
+	certificate = ca.MustNewClientCertificate(t, "Skeletor", "snake.mountain.eternia")
+	mustVerifyClientCertificate(t, server, &certificate, http.StatusUnauthorized)
+}
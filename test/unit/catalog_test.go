@@ -22,7 +22,10 @@ import (
 
 	"github.com/couchbase/service-broker/pkg/api"
 	v1 "github.com/couchbase/service-broker/pkg/apis/servicebroker/v1alpha1"
+	"github.com/couchbase/service-broker/test/unit/fixtures"
 	"github.com/couchbase/service-broker/test/unit/util"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 var (
@@ -60,3 +63,20 @@ func TestCatalogUpdate(t *testing.T) {
 	}
 	util.MustWaitFor(t, validator, time.Minute)
 }
+
+// TestCatalogMaximumPollingDuration tests that a configuration binding's maximum polling
+// duration is advertised by the service plan.
+func TestCatalogMaximumPollingDuration(t *testing.T) {
+	defer mustReset(t)
+
+	configuration := fixtures.BasicConfiguration()
+	configuration.Bindings[0].MaximumPollingDuration = &metav1.Duration{Duration: time.Hour}
+	util.MustReplaceBrokerConfig(t, clients, configuration)
+
+	catalog := &api.ServiceCatalog{}
+	util.MustGet(t, "/v2/catalog", http.StatusOK, catalog)
+
+	plan := catalog.Services[0].Plans[0]
+	util.Assert(t, plan.MaximumPollingDuration != nil)
+	util.Assert(t, *plan.MaximumPollingDuration == 3600)
+}
@@ -63,6 +63,12 @@ func NewParameterPipeline(arg interface{}) Pipeline {
 	return NewPipeline(Parameter(arg))
 }
 
+// NewOriginatingIdentityPipeline creates a pipeline initialized with an originating
+// identity lookup function.
+func NewOriginatingIdentityPipeline(arg interface{}) Pipeline {
+	return NewPipeline(OriginatingIdentity(arg))
+}
+
 // NewGeneratePasswordPipeline creates a pipeline initialized with a generate
 // password function.
 func NewGeneratePasswordPipeline(length, dictionary interface{}) Pipeline {
@@ -134,6 +140,11 @@ func Parameter(arg interface{}) Function {
 	return NewFunction("parameter", arg)
 }
 
+// OriginatingIdentity returns a function that looks up an originating identity path.
+func OriginatingIdentity(arg interface{}) Function {
+	return NewFunction("originatingIdentity", arg)
+}
+
 // GeneratePassword returns a function that generates a random password string.
 func GeneratePassword(length, dictionary interface{}) Function {
 	return NewFunction("generatePassword", length, dictionary)
@@ -0,0 +1,204 @@
+// Copyright 2020-2021 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file  except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the  License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unit_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/couchbase/service-broker/pkg/api"
+	"github.com/couchbase/service-broker/pkg/apis/servicebroker/v1alpha1"
+	"github.com/couchbase/service-broker/pkg/registry"
+	"github.com/couchbase/service-broker/test/unit/fixtures"
+	"github.com/couchbase/service-broker/test/unit/util"
+
+	"github.com/google/uuid"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// originatingIdentityUser is the end user that initiates requests.
+	originatingIdentityUser = "683ea748-3092-4ff4-b656-39cacc4d5360"
+)
+
+// originatingIdentityHeader returns an encoded Cloud Foundry originating identity.
+func originatingIdentityHeader() string {
+	return "cloudfoundry " + base64.StdEncoding.EncodeToString([]byte(`{"user_id":"`+originatingIdentityUser+`"}`))
+}
+
+// mustCreateServiceInstanceWithHeader creates a service instance with the requested
+// header, expecting a certain response.
+func mustCreateServiceInstanceWithHeader(t *testing.T, name, value string, statusCode int) *api.CreateServiceInstanceResponse {
+	raw, err := json.Marshal(fixtures.BasicServiceInstanceCreateRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := util.MustDefaultRequestWithBody(t, http.MethodPut, util.ServiceInstanceURI(fixtures.ServiceInstanceName, util.CreateServiceInstanceQuery()), bytes.NewBuffer(raw))
+	request.Header.Set(name, value)
+
+	response := util.MustDoRequest(t, util.MustDefaultClient(t), request)
+	defer response.Body.Close()
+
+	if err := util.VerifyStatusCode(response, statusCode); err != nil {
+		t.Fatal(err)
+	}
+
+	rsp := &api.CreateServiceInstanceResponse{}
+	if err := json.NewDecoder(response.Body).Decode(rsp); err != nil {
+		t.Fatal(err)
+	}
+
+	return rsp
+}
+
+// mustGetServiceInstancePodAnnotations returns the annotations of the pod created
+// for the service instance.
+func mustGetServiceInstancePodAnnotations(t *testing.T) map[string]string {
+	pods := clients.Dynamic().Resource(corev1.SchemeGroupVersion.WithResource("pods")).Namespace(util.Namespace)
+
+	pod, err := pods.Get(context.TODO(), "instance-"+fixtures.ServiceInstanceName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pod.GetAnnotations()
+}
+
+// TestOriginatingIdentity tests that the originating identity is recorded in the
+// registry, is available to templates, and annotates created resources.
+func TestOriginatingIdentity(t *testing.T) {
+	defer mustReset(t)
+
+	configuration := fixtures.BasicConfiguration()
+	fixtures.AddRegistry(configuration, key, fixtures.NewOriginatingIdentityPipeline("/value/user_id"))
+	util.MustReplaceBrokerConfig(t, clients, configuration)
+
+	rsp := mustCreateServiceInstanceWithHeader(t, "X-Broker-API-Originating-Identity", originatingIdentityHeader(), http.StatusAccepted)
+	util.MustPollServiceInstanceForCompletion(t, fixtures.ServiceInstanceName, rsp)
+
+	expected := `{"platform":"cloudfoundry","value":{"user_id":"` + originatingIdentityUser + `"}}`
+
+	entry := util.MustGetRegistryEntry(t, clients, registry.ServiceInstance, fixtures.ServiceInstanceName)
+	util.MustHaveRegistryEntryWithValue(t, entry, registry.Key(key), originatingIdentityUser)
+	util.Assert(t, string(entry.Data[string(registry.OriginatingIdentity)]) == expected)
+
+	annotations := mustGetServiceInstancePodAnnotations(t)
+	util.Assert(t, annotations[v1alpha1.OriginatingIdentityAnnotation] == expected)
+}
+
+// TestOriginatingIdentityMissing tests that the originating identity is optional.
+func TestOriginatingIdentityMissing(t *testing.T) {
+	defer mustReset(t)
+
+	configuration := fixtures.BasicConfiguration()
+	fixtures.AddRegistry(configuration, key, fixtures.NewOriginatingIdentityPipeline("/value/user_id"))
+	util.MustReplaceBrokerConfig(t, clients, configuration)
+
+	req := fixtures.BasicServiceInstanceCreateRequest()
+	util.MustCreateServiceInstanceSuccessfully(t, fixtures.ServiceInstanceName, req)
+
+	entry := util.MustGetRegistryEntry(t, clients, registry.ServiceInstance, fixtures.ServiceInstanceName)
+	util.MustNotHaveRegistryEntry(t, entry, registry.Key(key))
+	util.MustNotHaveRegistryEntry(t, entry, registry.OriginatingIdentity)
+
+	_, ok := mustGetServiceInstancePodAnnotations(t)[v1alpha1.OriginatingIdentityAnnotation]
+	util.Assert(t, !ok)
+}
+
+// TestOriginatingIdentityMalformed tests that a malformed originating identity is rejected.
+func TestOriginatingIdentityMalformed(t *testing.T) {
+	defer mustReset(t)
+
+	util.MustReplaceBrokerConfig(t, clients, fixtures.BasicConfiguration())
+
+	headers := []string{
+		"cloudfoundry",
+		"cloudfoundry !!!",
+		"cloudfoundry " + base64.StdEncoding.EncodeToString([]byte(`"user"`)),
+	}
+
+	for _, header := range headers {
+		request := util.MustDefaultRequest(t, http.MethodGet, "/v2/catalog")
+		request.Header.Set("X-Broker-API-Originating-Identity", header)
+
+		response := util.MustDoRequest(t, util.MustDefaultClient(t), request)
+		response.Body.Close()
+
+		if err := util.VerifyStatusCode(response, http.StatusBadRequest); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// mustGetCatalogRequestIdentity reads the catalog with the requested request identity,
+// returning the request identity sent back by the service broker.
+func mustGetCatalogRequestIdentity(t *testing.T, requestID string) string {
+	request := util.MustDefaultRequest(t, http.MethodGet, "/v2/catalog")
+
+	if requestID != "" {
+		request.Header.Set("X-Broker-API-Request-Identity", requestID)
+	}
+
+	response := util.MustDoRequest(t, util.MustDefaultClient(t), request)
+	response.Body.Close()
+
+	if err := util.VerifyStatusCode(response, http.StatusOK); err != nil {
+		t.Fatal(err)
+	}
+
+	return response.Header.Get("X-Broker-API-Request-Identity")
+}
+
+// TestRequestIdentity tests that the request identity is returned to the client, and
+// is generated if not supplied or unsafe to use.
+func TestRequestIdentity(t *testing.T) {
+	defer mustReset(t)
+
+	util.MustReplaceBrokerConfig(t, clients, fixtures.BasicConfiguration())
+
+	requestID := uuid.New().String()
+	util.Assert(t, mustGetCatalogRequestIdentity(t, requestID) == requestID)
+
+	for _, requestID := range []string{"", "skeletor\tsnake-mountain"} {
+		generated := mustGetCatalogRequestIdentity(t, requestID)
+
+		if _, err := uuid.Parse(generated); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestRequestIdentityRegistry tests that the request identity is recorded in the
+// registry, so asynchronous operations can be correlated with the request.
+func TestRequestIdentityRegistry(t *testing.T) {
+	defer mustReset(t)
+
+	util.MustReplaceBrokerConfig(t, clients, fixtures.BasicConfiguration())
+
+	requestID := uuid.New().String()
+
+	rsp := mustCreateServiceInstanceWithHeader(t, "X-Broker-API-Request-Identity", requestID, http.StatusAccepted)
+	util.MustPollServiceInstanceForCompletion(t, fixtures.ServiceInstanceName, rsp)
+
+	entry := util.MustGetRegistryEntry(t, clients, registry.ServiceInstance, fixtures.ServiceInstanceName)
+	util.MustHaveRegistryEntryWithValue(t, entry, registry.RequestID, requestID)
+}
@@ -0,0 +1,282 @@
+// Copyright 2020-2021 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file  except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the  License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unit_test
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/couchbase/service-broker/pkg/broker"
+	"github.com/couchbase/service-broker/test/unit/fixtures"
+	"github.com/couchbase/service-broker/test/unit/util"
+)
+
+const (
+	// jwtIssuer is the issuer used to sign test tokens.
+	jwtIssuer = "https://eternia.example.com"
+
+	// jwtAudience is the audience test tokens are intended for.
+	jwtAudience = "service-broker"
+)
+
+// jwtClaims returns a set of valid claims.
+func jwtClaims() map[string]interface{} {
+	return map[string]interface{}{
+		"iss":    jwtIssuer,
+		"aud":    jwtAudience,
+		"sub":    "HeMan",
+		"exp":    time.Now().Add(time.Hour).Unix(),
+		"groups": []string{"masters-of-the-universe"},
+	}
+}
+
+// mustWriteJWKS writes a JSON web key set to a file, returning the file path.
+func mustWriteJWKS(t *testing.T, dir string, signers ...*util.JWTSigner) string {
+	path := filepath.Join(dir, "jwks.json")
+
+	if err := ioutil.WriteFile(path, util.MustNewJWKS(t, signers...), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// mustNewJWTAuthenticator creates a JWT authenticator with keys loaded from a file.
+func mustNewJWTAuthenticator(t *testing.T, path string) (*broker.JWTAuthenticator, *broker.FileCredential) {
+	credential, err := broker.NewFileCredential(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := broker.NewCredentialKeySet(credential)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return broker.NewJWTAuthenticator(keys, jwtIssuer, jwtAudience, ""), credential
+}
+
+// TestAuthenticationJWT tests that tokens signed by a trusted key are accepted, and
+// that issuer, audience and expiry are checked.
+func TestAuthenticationJWT(t *testing.T) {
+	defer mustReset(t)
+
+	util.MustReplaceBrokerConfig(t, clients, fixtures.BasicConfiguration())
+
+	dir, err := ioutil.TempDir("", "jwks")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	rsaSigner := util.MustNewRSAJWTSigner(t, "grayskull")
+	ecdsaSigner := util.MustNewECDSAJWTSigner(t, "eternia")
+	untrusted := util.MustNewECDSAJWTSigner(t, "snake-mountain")
+
+	authenticator, _ := mustNewJWTAuthenticator(t, mustWriteJWKS(t, dir, rsaSigner, ecdsaSigner))
+
+	defer mustSetAuthenticator(authenticator)()
+
+	mustVerifyAuthorization(t, "Bearer "+rsaSigner.MustSign(t, jwtClaims()), http.StatusOK)
+	mustVerifyAuthorization(t, "Bearer "+ecdsaSigner.MustSign(t, jwtClaims()), http.StatusOK)
+	mustVerifyAuthorization(t, "Bearer "+untrusted.MustSign(t, jwtClaims()), http.StatusUnauthorized)
+
+	claims := jwtClaims()
+	claims["aud"] = []string{"other", jwtAudience}
+	mustVerifyAuthorization(t, "Bearer "+rsaSigner.MustSign(t, claims), http.StatusOK)
+
+	claims = jwtClaims()
+	claims["iss"] = "https://snake-mountain.example.com"
+	mustVerifyAuthorization(t, "Bearer "+rsaSigner.MustSign(t, claims), http.StatusUnauthorized)
+
+	claims = jwtClaims()
+	claims["aud"] = "other"
+	mustVerifyAuthorization(t, "Bearer "+rsaSigner.MustSign(t, claims), http.StatusUnauthorized)
+
+	claims = jwtClaims()
+	claims["exp"] = time.Now().Add(-time.Hour).Unix()
+	mustVerifyAuthorization(t, "Bearer "+rsaSigner.MustSign(t, claims), http.StatusUnauthorized)
+
+	claims = jwtClaims()
+	delete(claims, "exp")
+	mustVerifyAuthorization(t, "Bearer "+rsaSigner.MustSign(t, claims), http.StatusUnauthorized)
+
+	claims = jwtClaims()
+	claims["nbf"] = time.Now().Add(time.Hour).Unix()
+	mustVerifyAuthorization(t, "Bearer "+rsaSigner.MustSign(t, claims), http.StatusUnauthorized)
+
+	// Tampering with the claims invalidates the signature.
+	segments := strings.Split(rsaSigner.MustSign(t, jwtClaims()), ".")
+
+	claims = jwtClaims()
+	claims["sub"] = "Skeletor"
+
+	data, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	segments[1] = base64.RawURLEncoding.EncodeToString(data)
+	mustVerifyAuthorization(t, "Bearer "+strings.Join(segments, "."), http.StatusUnauthorized)
+
+	// Unsigned tokens are never accepted.
+	segments[0] = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+	mustVerifyAuthorization(t, "Bearer "+segments[0]+"."+segments[1]+".", http.StatusUnauthorized)
+}
+
+// TestAuthenticationJWTIdentity tests that the client identity is populated from token claims.
+func TestAuthenticationJWTIdentity(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jwks")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	signer := util.MustNewRSAJWTSigner(t, "grayskull")
+
+	authenticator, _ := mustNewJWTAuthenticator(t, mustWriteJWKS(t, dir, signer))
+
+	request := httptest.NewRequest(http.MethodGet, "/v2/catalog", nil)
+	request.Header.Set("Authorization", "Bearer "+signer.MustSign(t, jwtClaims()))
+
+	identity, err := authenticator.Authenticate(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	util.Assert(t, identity.Subject == "HeMan")
+	util.Assert(t, reflect.DeepEqual(identity.Groups, []string{"masters-of-the-universe"}))
+}
+
+// TestAuthenticationJWTKeyRotation tests that keys loaded from a file are updated when
+// the file changes.
+func TestAuthenticationJWTKeyRotation(t *testing.T) {
+	defer mustReset(t)
+
+	util.MustReplaceBrokerConfig(t, clients, fixtures.BasicConfiguration())
+
+	dir, err := ioutil.TempDir("", "jwks")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	oldSigner := util.MustNewRSAJWTSigner(t, "old")
+	newSigner := util.MustNewRSAJWTSigner(t, "new")
+
+	authenticator, credential := mustNewJWTAuthenticator(t, mustWriteJWKS(t, dir, oldSigner))
+
+	defer mustSetAuthenticator(authenticator)()
+
+	mustVerifyAuthorization(t, "Bearer "+oldSigner.MustSign(t, jwtClaims()), http.StatusOK)
+	mustVerifyAuthorization(t, "Bearer "+newSigner.MustSign(t, jwtClaims()), http.StatusUnauthorized)
+
+	mustWriteJWKS(t, dir, newSigner)
+
+	if err := credential.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	mustVerifyAuthorization(t, "Bearer "+oldSigner.MustSign(t, jwtClaims()), http.StatusUnauthorized)
+	mustVerifyAuthorization(t, "Bearer "+newSigner.MustSign(t, jwtClaims()), http.StatusOK)
+}
+
+// mustStartJWTIssuer starts an OpenID Connect issuer that publishes the signer's key.
+func mustStartJWTIssuer(t *testing.T, signer *util.JWTSigner, secure bool) *httptest.Server {
+	var issuer string
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":   issuer,
+			"jwks_uri": issuer + "/keys",
+		})
+	})
+
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(util.MustNewJWKS(t, signer))
+	})
+
+	var server *httptest.Server
+
+	if secure {
+		server = httptest.NewTLSServer(mux)
+	} else {
+		server = httptest.NewServer(mux)
+	}
+
+	issuer = server.URL
+
+	return server
+}
+
+// TestAuthenticationJWTDiscovery tests that keys can be discovered from an OpenID
+// Connect issuer.
+func TestAuthenticationJWTDiscovery(t *testing.T) {
+	defer mustReset(t)
+
+	util.MustReplaceBrokerConfig(t, clients, fixtures.BasicConfiguration())
+
+	signer := util.MustNewECDSAJWTSigner(t, "eternia")
+
+	server := mustStartJWTIssuer(t, signer, true)
+	defer server.Close()
+
+	keys := broker.NewRemoteKeySet(server.URL, server.Client(), false)
+
+	defer mustSetAuthenticator(broker.NewJWTAuthenticator(keys, server.URL, jwtAudience, ""))()
+
+	claims := jwtClaims()
+	claims["iss"] = server.URL
+	mustVerifyAuthorization(t, "Bearer "+signer.MustSign(t, claims), http.StatusOK)
+
+	mustVerifyAuthorization(t, "Bearer "+signer.MustSign(t, jwtClaims()), http.StatusUnauthorized)
+}
+
+// TestAuthenticationJWTDiscoveryInsecure tests that keys are not fetched over plain
+// HTTP unless explicitly allowed.
+func TestAuthenticationJWTDiscoveryInsecure(t *testing.T) {
+	defer mustReset(t)
+
+	util.MustReplaceBrokerConfig(t, clients, fixtures.BasicConfiguration())
+
+	signer := util.MustNewECDSAJWTSigner(t, "eternia")
+
+	server := mustStartJWTIssuer(t, signer, false)
+	defer server.Close()
+
+	claims := jwtClaims()
+	claims["iss"] = server.URL
+
+	restore := mustSetAuthenticator(broker.NewJWTAuthenticator(broker.NewRemoteKeySet(server.URL, server.Client(), false), server.URL, jwtAudience, ""))
+	mustVerifyAuthorization(t, "Bearer "+signer.MustSign(t, claims), http.StatusUnauthorized)
+	restore()
+
+	defer mustSetAuthenticator(broker.NewJWTAuthenticator(broker.NewRemoteKeySet(server.URL, server.Client(), true), server.URL, jwtAudience, ""))()
+	mustVerifyAuthorization(t, "Bearer "+signer.MustSign(t, claims), http.StatusOK)
+}
@@ -0,0 +1,331 @@
+// Copyright 2020-2021 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file  except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the  License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unit_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/couchbase/service-broker/pkg/log"
+	"github.com/couchbase/service-broker/pkg/registry"
+	"github.com/couchbase/service-broker/test/unit/fixtures"
+	"github.com/couchbase/service-broker/test/unit/util"
+
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const (
+	// sensitiveValue is a parameter value that must never be logged.
+	sensitiveValue = "correct-horse-battery-staple"
+
+	// shortPasswordLength is shorter than values the logger would otherwise
+	// consider too short to be secret.
+	shortPasswordLength = 6
+
+	// sensitiveSchemaParameters marks the hostname parameter as sensitive.
+	sensitiveSchemaParameters = `{"$schema":"http://json-schema.org/draft-04/schema#","type":"object","properties":{"hostname":{"type":"string","writeOnly":true}}}`
+)
+
+// logRecorder captures log messages in memory.
+type logRecorder struct {
+	lock   sync.Mutex
+	buffer bytes.Buffer
+}
+
+// Write records log messages.
+func (r *logRecorder) Write(p []byte) (int, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.buffer.Write(p)
+}
+
+// String returns all recorded log messages.
+func (r *logRecorder) String() string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.buffer.String()
+}
+
+// messages returns all recorded log messages, failing if any are not valid JSON.
+func (r *logRecorder) messages(t *testing.T) []map[string]interface{} {
+	var messages []map[string]interface{}
+
+	for _, line := range strings.Split(strings.TrimSpace(r.String()), "\n") {
+		var message map[string]interface{}
+
+		if err := json.Unmarshal([]byte(line), &message); err != nil {
+			t.Fatalf("log message %s not JSON: %v", line, err)
+		}
+
+		messages = append(messages, message)
+	}
+
+	return messages
+}
+
+// recordLogs captures all log messages at the requested level.  Logging must be
+// restored when done.
+func recordLogs(level log.Level) *logRecorder {
+	recorder := &logRecorder{}
+
+	log.SetOutput(recorder)
+	log.SetLevel(level)
+
+	return recorder
+}
+
+// restoreLogs discards log messages again.
+func restoreLogs() {
+	log.SetOutput(ioutil.Discard)
+	log.SetLevel(log.LevelInfo)
+}
+
+// TestLoggingStructured tests log messages are JSON, and tagged with the subsystem
+// and request that generated them.
+func TestLoggingStructured(t *testing.T) {
+	defer mustReset(t)
+
+	recorder := recordLogs(log.LevelInfo)
+	defer restoreLogs()
+
+	util.MustReplaceBrokerConfig(t, clients, fixtures.BasicConfiguration())
+
+	req := fixtures.BasicServiceInstanceCreateRequest()
+	util.MustCreateServiceInstanceSuccessfully(t, fixtures.ServiceInstanceName, req)
+
+	subsystems := map[interface{}]bool{}
+	tagged := false
+
+	for _, message := range recorder.messages(t) {
+		util.Assert(t, message["time"] != nil)
+		util.Assert(t, message["message"] != nil)
+		util.Assert(t, message["level"] != log.LevelDebug.String())
+
+		subsystems[message["subsystem"]] = true
+
+		if message["request_id"] != nil {
+			tagged = true
+		}
+	}
+
+	util.Assert(t, subsystems[log.SubsystemAPI])
+	util.Assert(t, subsystems[log.SubsystemOperation])
+	util.Assert(t, tagged)
+}
+
+// TestLoggingSubsystemLevel tests debug messages can be enabled for a single subsystem.
+func TestLoggingSubsystemLevel(t *testing.T) {
+	defer mustReset(t)
+
+	recorder := recordLogs(log.LevelInfo)
+	defer restoreLogs()
+
+	log.SetSubsystemLevel(log.SubsystemAPI, log.LevelDebug)
+	defer log.SetSubsystemLevel(log.SubsystemAPI, log.LevelInfo)
+
+	util.MustReplaceBrokerConfig(t, clients, fixtures.BasicConfiguration())
+
+	req := fixtures.BasicServiceInstanceCreateRequest()
+	util.MustCreateServiceInstanceSuccessfully(t, fixtures.ServiceInstanceName, req)
+
+	debug := false
+
+	for _, message := range recorder.messages(t) {
+		if message["level"] != log.LevelDebug.String() {
+			continue
+		}
+
+		util.Assert(t, message["subsystem"] == log.SubsystemAPI)
+
+		debug = true
+	}
+
+	util.Assert(t, debug)
+}
+
+// TestLoggingRedactRegistry tests that generated passwords and Secret data are
+// not logged, even at debug level.
+func TestLoggingRedactRegistry(t *testing.T) {
+	defer mustReset(t)
+
+	recorder := recordLogs(log.LevelDebug)
+	defer restoreLogs()
+
+	configuration := fixtures.BasicConfiguration()
+	fixtures.SetRegistry(configuration, key, fixtures.NewGeneratePasswordPipeline(defaultPasswordLength, nil))
+	util.MustReplaceBrokerConfig(t, clients, configuration)
+
+	req := fixtures.BasicServiceInstanceCreateRequest()
+	util.MustCreateServiceInstanceSuccessfully(t, fixtures.ServiceInstanceName, req)
+
+	entry := util.MustGetRegistryEntry(t, clients, registry.ServiceInstance, fixtures.ServiceInstanceName)
+
+	var password string
+
+	if err := json.Unmarshal(entry.Data[key], &password); err != nil {
+		t.Fatal(err)
+	}
+
+	logs := recorder.String()
+
+	util.Assert(t, !strings.Contains(logs, password))
+	util.Assert(t, strings.Contains(logs, log.Mask))
+}
+
+// TestLoggingRedactShortPassword tests that generated passwords are masked however
+// short they are.
+func TestLoggingRedactShortPassword(t *testing.T) {
+	defer mustReset(t)
+
+	recorder := recordLogs(log.LevelDebug)
+	defer restoreLogs()
+
+	// The default function logs the value it is passed.
+	configuration := fixtures.BasicConfiguration()
+	fixtures.SetRegistry(configuration, key, fixtures.NewGeneratePasswordPipeline(shortPasswordLength, nil).With(fixtures.Default("fallback")))
+	util.MustReplaceBrokerConfig(t, clients, configuration)
+
+	req := fixtures.BasicServiceInstanceCreateRequest()
+	util.MustCreateServiceInstanceSuccessfully(t, fixtures.ServiceInstanceName, req)
+
+	entry := util.MustGetRegistryEntry(t, clients, registry.ServiceInstance, fixtures.ServiceInstanceName)
+
+	var password string
+
+	if err := json.Unmarshal(entry.Data[key], &password); err != nil {
+		t.Fatal(err)
+	}
+
+	logs := recorder.String()
+
+	util.Assert(t, len(password) == shortPasswordLength)
+	util.Assert(t, strings.Contains(logs, "default: "))
+	util.Assert(t, !strings.Contains(logs, password))
+}
+
+// TestLoggingRedactShortPasswordReloaded tests that short generated passwords are
+// still masked when the registry entry is loaded again, or cloned.
+func TestLoggingRedactShortPasswordReloaded(t *testing.T) {
+	defer mustReset(t)
+
+	configuration := fixtures.BasicConfiguration()
+	fixtures.SetRegistry(configuration, key, fixtures.NewGeneratePasswordPipeline(shortPasswordLength, nil))
+	util.MustReplaceBrokerConfig(t, clients, configuration)
+
+	req := fixtures.BasicServiceInstanceCreateRequest()
+	util.MustCreateServiceInstanceSuccessfully(t, fixtures.ServiceInstanceName, req)
+
+	recorder := recordLogs(log.LevelDebug)
+	defer restoreLogs()
+
+	entry, err := registry.New(registry.ServiceInstance, util.Namespace, fixtures.ServiceInstanceName, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	password, ok, err := entry.GetString(registry.Key(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	util.Assert(t, ok)
+	util.Assert(t, len(password) == shortPasswordLength)
+
+	// Values generated but not stored in the registry are also retained by clones.
+	generated := "Zq7"
+	entry.AddSensitive(generated)
+
+	entry.Logger().Infof("password %s", password)
+	entry.Clone().Logger().Infof("password %s", password)
+	entry.Clone().Logger().Infof("generated %s", generated)
+
+	logs := recorder.String()
+
+	util.Assert(t, strings.Count(logs, log.Mask) == 3)
+	util.Assert(t, !strings.Contains(logs, password))
+	util.Assert(t, !strings.Contains(logs, generated))
+}
+
+// TestLoggingRedactTemplateSensitiveFields tests that fields marked as sensitive
+// in a template are masked when the rendered template is logged.
+func TestLoggingRedactTemplateSensitiveFields(t *testing.T) {
+	defer mustReset(t)
+
+	recorder := recordLogs(log.LevelDebug)
+	defer restoreLogs()
+
+	configuration := fixtures.BasicConfiguration()
+
+	for i := range configuration.Templates {
+		if configuration.Templates[i].Name == "test-template" {
+			configuration.Templates[i].SensitiveFields = []string{"/spec/hostname"}
+		}
+	}
+
+	util.MustReplaceBrokerConfig(t, clients, configuration)
+
+	req := fixtures.BasicServiceInstanceCreateRequest()
+	req.Parameters = &runtime.RawExtension{
+		Raw: []byte(`{"` + fixtures.OptionalParameter + `":"` + sensitiveValue + `"}`),
+	}
+	util.MustCreateServiceInstanceSuccessfully(t, fixtures.ServiceInstanceName, req)
+
+	rendered := false
+
+	for _, message := range recorder.messages(t) {
+		text, ok := message["message"].(string)
+		if !ok || !strings.HasPrefix(text, "rendered template") || !strings.Contains(text, `"hostname"`) {
+			continue
+		}
+
+		util.Assert(t, !strings.Contains(text, sensitiveValue))
+		util.Assert(t, strings.Contains(text, log.Mask))
+
+		rendered = true
+	}
+
+	util.Assert(t, rendered)
+}
+
+// TestLoggingRedactSchemaWriteOnly tests that parameters marked as write only by
+// the service plan's schema are never logged.
+func TestLoggingRedactSchemaWriteOnly(t *testing.T) {
+	defer mustReset(t)
+
+	recorder := recordLogs(log.LevelDebug)
+	defer restoreLogs()
+
+	configuration := fixtures.BasicConfiguration()
+	configuration.Catalog.Services[0].Plans[0].Schemas = fixtures.BasicSchema()
+	configuration.Catalog.Services[0].Plans[0].Schemas.ServiceInstance.Create.Parameters.Raw = []byte(sensitiveSchemaParameters)
+	util.MustReplaceBrokerConfig(t, clients, configuration)
+
+	req := fixtures.BasicServiceInstanceCreateRequest()
+	req.Parameters = &runtime.RawExtension{
+		Raw: []byte(`{"` + fixtures.OptionalParameter + `":"` + sensitiveValue + `"}`),
+	}
+	util.MustCreateServiceInstanceSuccessfully(t, fixtures.ServiceInstanceName, req)
+
+	logs := recorder.String()
+
+	util.Assert(t, strings.Contains(logs, "rendered template"))
+	util.Assert(t, !strings.Contains(logs, sensitiveValue))
+}
@@ -18,12 +18,14 @@ import (
 	"crypto/tls"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"testing"
 	"time"
 
 	"github.com/couchbase/service-broker/pkg/broker"
 	"github.com/couchbase/service-broker/pkg/client"
+	"github.com/couchbase/service-broker/pkg/log"
 	"github.com/couchbase/service-broker/test/unit/util"
 )
 
@@ -38,6 +40,9 @@ var (
 	// written to to trigger behviours, witness consequences and
 	// verify actions.  They should be reset after each test.
 	clients client.Clients
+
+	// configuration is the global service broker server configuration.
+	configuration *broker.ServerConfiguration
 )
 
 // reset cleans the client of any resources that we may have registered and
@@ -59,6 +64,9 @@ func mustReset(t *testing.T) {
 func TestMain(m *testing.M) {
 	flag.Parse()
 
+	// Keep test output readable, tests that check logging capture it themselves.
+	log.SetOutput(ioutil.Discard)
+
 	// Load up the test TLS configuration (valid for DNS:localhost).
 	cert, err := tls.X509KeyPair([]byte(util.Cert), []byte(util.Key))
 	if err != nil {
@@ -66,12 +74,13 @@ func TestMain(m *testing.M) {
 		os.Exit(errorCode)
 	}
 
-	token := util.Token
-
-	configuration := &broker.ServerConfiguration{
-		Namespace:   util.Namespace,
-		Token:       &token,
-		Certificate: cert,
+	configuration = &broker.ServerConfiguration{
+		Namespace:              util.Namespace,
+		Authenticator:          broker.NewBearerTokenAuthenticator(broker.StaticCredential(util.Token)),
+		Certificate:            cert,
+		PlaintextListenAddress: util.PlaintextListenAddress,
+		OpsListenAddress:       util.OpsListenAddress,
+		OpsAuthenticator:       broker.NewBearerTokenAuthenticator(broker.StaticCredential(util.OpsToken)),
 	}
 
 	// Create fake clients we can use to mock Kubernetes and have complete
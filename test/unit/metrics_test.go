@@ -0,0 +1,196 @@
+// Copyright 2020-2021 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file  except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the  License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unit_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/couchbase/service-broker/pkg/api"
+	"github.com/couchbase/service-broker/pkg/metrics"
+	"github.com/couchbase/service-broker/pkg/operation"
+	"github.com/couchbase/service-broker/test/unit/fixtures"
+	"github.com/couchbase/service-broker/test/unit/util"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// mustGetMetrics scrapes the metrics endpoint.
+func mustGetMetrics(t *testing.T) string {
+	request := util.MustBasicRequest(t, http.MethodGet, "/metrics")
+	request.URL.Host = util.OpsListenAddress
+	request.Header.Set("Authorization", "Bearer "+util.OpsToken)
+
+	response := util.MustDoRequest(t, util.MustDefaultClient(t), request)
+
+	defer response.Body.Close()
+
+	util.MustVerifyStatusCode(t, response, http.StatusOK)
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(body)
+}
+
+// mustContainMetric checks the scraped metrics contain a sample.
+func mustContainMetric(t *testing.T, body, sample string) {
+	for _, line := range strings.Split(body, "\n") {
+		if line == sample {
+			return
+		}
+	}
+
+	t.Fatalf("metrics do not contain %s", sample)
+}
+
+// TestMetricsRequests tests API requests are counted by route and status.
+func TestMetricsRequests(t *testing.T) {
+	defer mustReset(t)
+
+	util.MustReplaceBrokerConfig(t, clients, fixtures.BasicConfiguration())
+
+	route := "GET /v2/service_instances/:instance_id"
+
+	notFound := metrics.HTTPRequests.Value(route, "404")
+
+	util.MustGetAndError(t, util.ServiceInstanceURI(fixtures.ServiceInstanceName, nil), http.StatusNotFound, api.ErrorResourceNotFound)
+
+	util.Assert(t, metrics.HTTPRequests.Value(route, "404") == notFound+1)
+
+	body := mustGetMetrics(t)
+	mustContainMetric(t, body, "# TYPE service_broker_http_requests_total counter")
+	mustContainMetric(t, body, "# TYPE service_broker_http_request_duration_seconds histogram")
+	util.Assert(t, strings.Contains(body, `service_broker_http_request_duration_seconds_count{route="GET /v2/service_instances/:instance_id",status="404"}`))
+}
+
+// TestMetricsRequestsRouteParameters tests requests are labelled with their route when
+// parameter values are the same as other path segments.
+func TestMetricsRequestsRouteParameters(t *testing.T) {
+	defer mustReset(t)
+
+	util.MustReplaceBrokerConfig(t, clients, fixtures.BasicConfiguration())
+
+	route := "GET /v2/service_instances/:instance_id"
+
+	notFound := metrics.HTTPRequests.Value(route, "404")
+
+	util.MustGetAndError(t, util.ServiceInstanceURI("v2", nil), http.StatusNotFound, api.ErrorResourceNotFound)
+
+	util.Assert(t, metrics.HTTPRequests.Value(route, "404") == notFound+1)
+}
+
+// TestMetricsOperations tests operations and service instances are counted by plan.
+func TestMetricsOperations(t *testing.T) {
+	defer mustReset(t)
+
+	util.MustReplaceBrokerConfig(t, clients, fixtures.BasicConfiguration())
+
+	started := metrics.OperationsStarted.Value("provision", fixtures.BasicConfigurationPlanID)
+	succeeded := metrics.OperationsSucceeded.Value("provision", fixtures.BasicConfigurationPlanID)
+
+	req := fixtures.BasicServiceInstanceCreateRequest()
+	util.MustCreateServiceInstanceSuccessfully(t, fixtures.ServiceInstanceName, req)
+
+	util.Assert(t, metrics.OperationsStarted.Value("provision", fixtures.BasicConfigurationPlanID) == started+1)
+	util.Assert(t, metrics.OperationsSucceeded.Value("provision", fixtures.BasicConfigurationPlanID) == succeeded+1)
+
+	body := mustGetMetrics(t)
+	mustContainMetric(t, body, `service_broker_service_instances{service_id="`+fixtures.BasicConfigurationOfferingID+`",plan_id="`+fixtures.BasicConfigurationPlanID+`"} 1`)
+}
+
+// TestMetricsReadinessCheckTimeout tests readiness check timeouts and failed operations
+// are counted.
+func TestMetricsReadinessCheckTimeout(t *testing.T) {
+	defer mustReset(t)
+
+	configuration := fixtures.BasicConfigurationWithReadiness()
+	configuration.Bindings[0].ServiceInstance.ReadinessChecks[0].Timeout = &metav1.Duration{Duration: 100 * time.Millisecond}
+	util.MustReplaceBrokerConfig(t, clients, configuration)
+
+	check := configuration.Bindings[0].ServiceInstance.ReadinessChecks[0].Name
+
+	timeouts := metrics.ReadinessCheckTimeouts.Value(check)
+	durations := metrics.ReadinessCheckDuration.Count(check)
+	failed := metrics.OperationsFailed.Value("provision", fixtures.BasicConfigurationPlanID)
+
+	req := fixtures.BasicServiceInstanceCreateRequest()
+	rsp := util.MustCreateServiceInstance(t, fixtures.ServiceInstanceName, req)
+
+	util.MustPollServiceInstanceForFailure(t, fixtures.ServiceInstanceName, rsp)
+
+	util.Assert(t, metrics.ReadinessCheckTimeouts.Value(check) == timeouts+1)
+	util.Assert(t, metrics.ReadinessCheckDuration.Count(check) == durations+1)
+	util.Assert(t, metrics.OperationsFailed.Value("provision", fixtures.BasicConfigurationPlanID) == failed+1)
+}
+
+// TestMetricsScheduler tests the operation queue depth and in-flight operations are
+// reported when scraped.
+func TestMetricsScheduler(t *testing.T) {
+	defer mustReset(t)
+
+	util.MustReplaceBrokerConfig(t, clients, fixtures.BasicConfiguration())
+
+	scheduler := configuration.Scheduler
+	defer func() {
+		configuration.Scheduler = scheduler
+	}()
+
+	configuration.Scheduler = operation.NewScheduler(1, 4)
+
+	started := make(chan interface{})
+	blocker := make(chan interface{})
+
+	var wg sync.WaitGroup
+
+	wg.Add(2)
+
+	mustSubmit(t, configuration.Scheduler, "a", func() {
+		defer wg.Done()
+
+		close(started)
+		<-blocker
+	})
+	mustSubmit(t, configuration.Scheduler, "b", wg.Done)
+
+	<-started
+
+	body := mustGetMetrics(t)
+	mustContainMetric(t, body, "# TYPE service_broker_operation_queue_depth gauge")
+	mustContainMetric(t, body, "service_broker_operation_queue_depth 1")
+	mustContainMetric(t, body, "service_broker_operations_in_flight 1")
+
+	close(blocker)
+	wg.Wait()
+
+	util.MustWaitFor(t, func() error {
+		if running := configuration.Scheduler.Running(); running != 0 {
+			return fmt.Errorf("%d operations running", running)
+		}
+
+		return nil
+	}, time.Minute)
+
+	body = mustGetMetrics(t)
+	mustContainMetric(t, body, "service_broker_operation_queue_depth 0")
+	mustContainMetric(t, body, "service_broker_operations_in_flight 0")
+}
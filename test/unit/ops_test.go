@@ -0,0 +1,172 @@
+// Copyright 2020-2021 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file  except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the  License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unit_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/couchbase/service-broker/pkg/api"
+	"github.com/couchbase/service-broker/test/unit/fixtures"
+	"github.com/couchbase/service-broker/test/unit/util"
+)
+
+// mustGetOps does an operations API GET call and expects a certain response.
+func mustGetOps(t *testing.T, path, token string, statusCode int, response interface{}) {
+	request := util.MustBasicRequest(t, http.MethodGet, path)
+	request.URL.Host = util.OpsListenAddress
+	request.Header.Set("Authorization", "Bearer "+token)
+
+	rsp := util.MustDoRequest(t, util.MustDefaultClient(t), request)
+
+	defer rsp.Body.Close()
+
+	util.MustVerifyStatusCode(t, rsp, statusCode)
+
+	if response == nil {
+		return
+	}
+
+	raw, err := ioutil.ReadAll(rsp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := json.Unmarshal(raw, response); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestOpsListServiceInstances tests service instances and their bindings and resources
+// can be inspected with the operations API.
+func TestOpsListServiceInstances(t *testing.T) {
+	defer mustReset(t)
+
+	util.MustReplaceBrokerConfig(t, clients, fixtures.BasicConfiguration())
+
+	req := fixtures.BasicServiceInstanceCreateRequest()
+	util.MustCreateServiceInstanceSuccessfully(t, fixtures.ServiceInstanceName, req)
+
+	bindingReq := fixtures.BasicServiceBindingCreateRequest()
+	util.MustCreateServiceBinding(t, fixtures.ServiceInstanceName, fixtures.ServiceBindingName, bindingReq)
+
+	instances := &api.ListServiceInstancesResponse{}
+	mustGetOps(t, "/v1/service_instances", util.OpsToken, http.StatusOK, instances)
+
+	util.Assert(t, len(instances.ServiceInstances) == 1)
+
+	instance := instances.ServiceInstances[0]
+	util.Assert(t, instance.ID == fixtures.ServiceInstanceName)
+	util.Assert(t, instance.Namespace == util.Namespace)
+	util.Assert(t, instance.ServiceName == "test-offering")
+	util.Assert(t, instance.PlanName == "test-plan")
+	util.Assert(t, len(instance.Bindings) == 1)
+	util.Assert(t, instance.Bindings[0] == fixtures.ServiceBindingName)
+
+	// Only the templated pod is tracked, singletons are shared.
+	util.Assert(t, len(instance.Resources) == 1)
+	util.Assert(t, instance.Resources[0].Kind == "Pod")
+	util.Assert(t, instance.Resources[0].Name == "instance-"+fixtures.ServiceInstanceName)
+
+	// Completed operations are ended when polled.
+	util.Assert(t, instance.Operation == nil)
+
+	single := &api.ServiceInstanceStatus{}
+	mustGetOps(t, "/v1/service_instances/"+fixtures.ServiceInstanceName, util.OpsToken, http.StatusOK, single)
+
+	util.Assert(t, single.ID == fixtures.ServiceInstanceName)
+	util.Assert(t, len(single.Resources) == 1)
+	util.Assert(t, len(single.Bindings) == 1)
+
+	bindings := &api.ListServiceBindingsResponse{}
+	mustGetOps(t, "/v1/service_bindings", util.OpsToken, http.StatusOK, bindings)
+
+	util.Assert(t, len(bindings.ServiceBindings) == 1)
+
+	binding := bindings.ServiceBindings[0]
+	util.Assert(t, binding.ID == fixtures.ServiceBindingName)
+	util.Assert(t, binding.InstanceID == fixtures.ServiceInstanceName)
+	util.Assert(t, binding.PlanName == "test-plan")
+
+	// Bindings do not own the service instance's resources.
+	util.Assert(t, len(binding.Resources) == 0)
+
+	mustGetOps(t, "/v1/service_instances/"+fixtures.ServiceInstanceName+"/service_bindings/"+fixtures.ServiceBindingName, util.OpsToken, http.StatusOK, nil)
+}
+
+// TestOpsOperationStatus tests that operations in progress are reported.
+func TestOpsOperationStatus(t *testing.T) {
+	defer mustReset(t)
+
+	util.MustReplaceBrokerConfig(t, clients, fixtures.BasicConfiguration())
+
+	req := fixtures.BasicServiceInstanceCreateRequest()
+	rsp := util.MustCreateServiceInstance(t, fixtures.ServiceInstanceName, req)
+
+	// The operation remains until it is polled.
+	util.MustWaitFor(t, func() error {
+		instance := &api.ServiceInstanceStatus{}
+		mustGetOps(t, "/v1/service_instances/"+fixtures.ServiceInstanceName, util.OpsToken, http.StatusOK, instance)
+
+		if instance.Operation == nil || instance.Operation.State != api.PollStateSucceeded {
+			return fmt.Errorf("operation incomplete: %v", instance.Operation)
+		}
+
+		return nil
+	}, time.Minute)
+
+	instance := &api.ServiceInstanceStatus{}
+	mustGetOps(t, "/v1/service_instances/"+fixtures.ServiceInstanceName, util.OpsToken, http.StatusOK, instance)
+
+	util.Assert(t, instance.Operation.Type == "provision")
+	util.Assert(t, instance.Operation.ID == rsp.Operation)
+	util.Assert(t, instance.Operation.StartTime != nil)
+
+	util.MustPollServiceInstanceForCompletion(t, fixtures.ServiceInstanceName, rsp)
+}
+
+// TestOpsNotFound tests that missing service instances and bindings are reported.
+func TestOpsNotFound(t *testing.T) {
+	defer mustReset(t)
+
+	util.MustReplaceBrokerConfig(t, clients, fixtures.BasicConfiguration())
+
+	mustGetOps(t, "/v1/service_instances/"+fixtures.ServiceInstanceName, util.OpsToken, http.StatusNotFound, nil)
+	mustGetOps(t, "/v1/service_instances/"+fixtures.ServiceInstanceName+"/service_bindings/"+fixtures.ServiceBindingName, util.OpsToken, http.StatusNotFound, nil)
+}
+
+// TestOpsAuthentication tests that the operations API is authenticated separately
+// from the Open Service Broker API.
+func TestOpsAuthentication(t *testing.T) {
+	defer mustReset(t)
+
+	util.MustReplaceBrokerConfig(t, clients, fixtures.BasicConfiguration())
+
+	mustGetOps(t, "/v1/service_instances", util.Token, http.StatusUnauthorized, nil)
+	mustGetOps(t, "/v1/service_instances", util.OpsToken, http.StatusOK, nil)
+
+	// The operations API is not served by the Open Service Broker API listener.
+	request := util.MustBasicRequest(t, http.MethodGet, "/v1/service_instances")
+	request.Header.Set("Authorization", "Bearer "+util.OpsToken)
+
+	response := util.MustDoRequest(t, util.MustDefaultClient(t), request)
+	response.Body.Close()
+
+	util.MustVerifyStatusCode(t, response, http.StatusUnauthorized)
+}
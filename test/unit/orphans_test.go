@@ -0,0 +1,270 @@
+// Copyright 2020-2021 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file  except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the  License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unit_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/couchbase/service-broker/pkg/broker"
+	"github.com/couchbase/service-broker/pkg/registry"
+	"github.com/couchbase/service-broker/test/unit/fixtures"
+	"github.com/couchbase/service-broker/test/unit/util"
+
+	corev1 "k8s.io/api/core/v1"
+	k8s_errors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// mustMitigateOrphans runs orphan mitigation with the requested grace period.
+func mustMitigateOrphans(t *testing.T, gracePeriod time.Duration) {
+	defaultGracePeriod := configuration.OrphanMitigationGracePeriod
+	defer func() {
+		configuration.OrphanMitigationGracePeriod = defaultGracePeriod
+	}()
+
+	configuration.OrphanMitigationGracePeriod = gracePeriod
+
+	if err := broker.MitigateOrphans(configuration); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// serviceInstanceRegistryExists returns whether the registry entry for a service instance exists.
+func serviceInstanceRegistryExists(t *testing.T, name string) bool {
+	entry, err := registry.New(registry.ServiceInstance, util.Namespace, name, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return entry.Exists()
+}
+
+// mustWaitForOrphanMitigation waits for a service instance and its resources to be removed.
+func mustWaitForOrphanMitigation(t *testing.T, name string) {
+	callback := func() error {
+		if serviceInstanceRegistryExists(t, name) {
+			return fmt.Errorf("service instance %s registry exists", name)
+		}
+
+		return nil
+	}
+	util.MustWaitFor(t, callback, time.Minute)
+
+	pods := clients.Dynamic().Resource(corev1.SchemeGroupVersion.WithResource("pods")).Namespace(util.Namespace)
+
+	if _, err := pods.Get(context.TODO(), "instance-"+name, metav1.GetOptions{}); !k8s_errors.IsNotFound(err) {
+		t.Fatal("expected resource to be deleted", err)
+	}
+}
+
+// TestOrphanMitigationFailedProvision tests that a service instance whose provisioning
+// failed is deprovisioned.
+func TestOrphanMitigationFailedProvision(t *testing.T) {
+	defer mustReset(t)
+
+	configuration := fixtures.BasicConfigurationWithReadiness()
+	configuration.Bindings[0].ServiceInstance.ReadinessChecks[0].Timeout = &metav1.Duration{Duration: 100 * time.Millisecond}
+	util.MustReplaceBrokerConfig(t, clients, configuration)
+
+	req := fixtures.BasicServiceInstanceCreateRequest()
+	rsp := util.MustCreateServiceInstance(t, fixtures.ServiceInstanceName, req)
+
+	util.MustPollServiceInstanceForFailure(t, fixtures.ServiceInstanceName, rsp)
+
+	mustMitigateOrphans(t, 0)
+	mustWaitForOrphanMitigation(t, fixtures.ServiceInstanceName)
+}
+
+// mustWaitForOperationCompletion waits for an operation to complete, without polling it.
+func mustWaitForOperationCompletion(t *testing.T, name string) {
+	callback := func() error {
+		entry, err := registry.New(registry.ServiceInstance, util.Namespace, name, true)
+		if err != nil {
+			return err
+		}
+
+		if _, ok, err := entry.GetString(registry.OperationStatus); err != nil || !ok {
+			return fmt.Errorf("operation incomplete: %v", err)
+		}
+
+		return nil
+	}
+	util.MustWaitFor(t, callback, time.Minute)
+}
+
+// mustWaitForOperationsIdle waits for any operation scheduled for a service instance,
+// for example by orphan mitigation, to finish.
+func mustWaitForOperationsIdle(t *testing.T, name string) {
+	callback := func() error {
+		if !configuration.Scheduler.TryLock(name) {
+			return fmt.Errorf("service instance %s has an operation in progress", name)
+		}
+
+		configuration.Scheduler.Unlock(name)
+
+		return nil
+	}
+	util.MustWaitFor(t, callback, time.Minute)
+}
+
+// TestOrphanMitigationAbandonedProvision tests that a service instance whose failed
+// provisioning was never polled is deprovisioned.
+func TestOrphanMitigationAbandonedProvision(t *testing.T) {
+	defer mustReset(t)
+
+	configuration := fixtures.BasicConfigurationWithReadiness()
+	configuration.Bindings[0].ServiceInstance.ReadinessChecks[0].Timeout = &metav1.Duration{Duration: 100 * time.Millisecond}
+	util.MustReplaceBrokerConfig(t, clients, configuration)
+
+	req := fixtures.BasicServiceInstanceCreateRequest()
+	util.MustCreateServiceInstance(t, fixtures.ServiceInstanceName, req)
+
+	mustWaitForOperationCompletion(t, fixtures.ServiceInstanceName)
+
+	mustMitigateOrphans(t, 0)
+	mustWaitForOrphanMitigation(t, fixtures.ServiceInstanceName)
+}
+
+// TestOrphanMitigationAbandonedSuccessfulProvision tests that a service instance whose
+// successful provisioning was never polled is not deprovisioned.
+func TestOrphanMitigationAbandonedSuccessfulProvision(t *testing.T) {
+	defer mustReset(t)
+
+	util.MustReplaceBrokerConfig(t, clients, fixtures.BasicConfiguration())
+
+	req := fixtures.BasicServiceInstanceCreateRequest()
+	util.MustCreateServiceInstance(t, fixtures.ServiceInstanceName, req)
+
+	mustWaitForOperationCompletion(t, fixtures.ServiceInstanceName)
+
+	mustMitigateOrphans(t, 0)
+	mustWaitForOperationsIdle(t, fixtures.ServiceInstanceName)
+
+	util.Assert(t, serviceInstanceRegistryExists(t, fixtures.ServiceInstanceName))
+}
+
+// TestOrphanMitigationGracePeriodFromCompletion tests that the grace period of a
+// failed operation that was never polled starts when the operation completed.
+func TestOrphanMitigationGracePeriodFromCompletion(t *testing.T) {
+	defer mustReset(t)
+
+	configuration := fixtures.BasicConfigurationWithReadiness()
+	configuration.Bindings[0].ServiceInstance.ReadinessChecks[0].Timeout = &metav1.Duration{Duration: 100 * time.Millisecond}
+	util.MustReplaceBrokerConfig(t, clients, configuration)
+
+	req := fixtures.BasicServiceInstanceCreateRequest()
+	util.MustCreateServiceInstance(t, fixtures.ServiceInstanceName, req)
+
+	mustWaitForOperationCompletion(t, fixtures.ServiceInstanceName)
+
+	// Pretend the operation took a long time to complete.
+	entry, err := registry.New(registry.ServiceInstance, util.Namespace, fixtures.ServiceInstanceName, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := entry.Set(registry.OperationStartTime, time.Now().Add(-2*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := entry.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	mustMitigateOrphans(t, time.Hour)
+	mustWaitForOperationsIdle(t, fixtures.ServiceInstanceName)
+
+	util.Assert(t, serviceInstanceRegistryExists(t, fixtures.ServiceInstanceName))
+}
+
+// TestOrphanMitigationCorruptEntry tests that a registry entry that cannot be handled
+// does not prevent other orphaned service instances from being deprovisioned.
+func TestOrphanMitigationCorruptEntry(t *testing.T) {
+	defer mustReset(t)
+
+	configuration := fixtures.BasicConfigurationWithReadiness()
+	configuration.Bindings[0].ServiceInstance.ReadinessChecks[0].Timeout = &metav1.Duration{Duration: 100 * time.Millisecond}
+	util.MustReplaceBrokerConfig(t, clients, configuration)
+
+	corrupt, err := registry.New(registry.ServiceInstance, util.Namespace, "corrupt", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := map[registry.Key]interface{}{
+		registry.Operation:          "provision",
+		registry.OperationStatus:    "failed",
+		registry.OperationStartTime: "yesterday",
+		registry.OperationEndTime:   "yesterday",
+	}
+
+	for key, value := range values {
+		if err := corrupt.Set(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := corrupt.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	req := fixtures.BasicServiceInstanceCreateRequest()
+	rsp := util.MustCreateServiceInstance(t, fixtures.ServiceInstanceName, req)
+
+	util.MustPollServiceInstanceForFailure(t, fixtures.ServiceInstanceName, rsp)
+
+	mustMitigateOrphans(t, 0)
+	mustWaitForOrphanMitigation(t, fixtures.ServiceInstanceName)
+}
+
+// TestOrphanMitigationGracePeriod tests that a failed service instance is left for the
+// client to clean up during the grace period.
+func TestOrphanMitigationGracePeriod(t *testing.T) {
+	defer mustReset(t)
+
+	configuration := fixtures.BasicConfigurationWithReadiness()
+	configuration.Bindings[0].ServiceInstance.ReadinessChecks[0].Timeout = &metav1.Duration{Duration: 100 * time.Millisecond}
+	util.MustReplaceBrokerConfig(t, clients, configuration)
+
+	req := fixtures.BasicServiceInstanceCreateRequest()
+	rsp := util.MustCreateServiceInstance(t, fixtures.ServiceInstanceName, req)
+
+	util.MustPollServiceInstanceForFailure(t, fixtures.ServiceInstanceName, rsp)
+
+	mustMitigateOrphans(t, time.Hour)
+
+	util.Assert(t, serviceInstanceRegistryExists(t, fixtures.ServiceInstanceName))
+
+	// The client is still able to clean up.
+	util.MustDeleteServiceInstanceSuccessfully(t, fixtures.ServiceInstanceName, req)
+}
+
+// TestOrphanMitigationProvisioned tests that successfully provisioned service instances
+// are not deprovisioned.
+func TestOrphanMitigationProvisioned(t *testing.T) {
+	defer mustReset(t)
+
+	util.MustReplaceBrokerConfig(t, clients, fixtures.BasicConfiguration())
+
+	req := fixtures.BasicServiceInstanceCreateRequest()
+	util.MustCreateServiceInstanceSuccessfully(t, fixtures.ServiceInstanceName, req)
+
+	mustMitigateOrphans(t, 0)
+
+	util.Assert(t, serviceInstanceRegistryExists(t, fixtures.ServiceInstanceName))
+}
@@ -0,0 +1,198 @@
+// Copyright 2020-2021 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file  except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the  License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unit_test
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/couchbase/service-broker/pkg/api"
+	"github.com/couchbase/service-broker/pkg/broker"
+	"github.com/couchbase/service-broker/test/unit/fixtures"
+	"github.com/couchbase/service-broker/test/unit/util"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+// mustSetRateLimiter replaces the rate limiter, returning a function to restore the
+// original.
+func mustSetRateLimiter(limiter *broker.RateLimiter) func() {
+	original := configuration.RateLimiter
+
+	configuration.RateLimiter = limiter
+
+	return func() {
+		configuration.RateLimiter = original
+	}
+}
+
+// mustVerifyRateLimited checks that a catalog request is rate limited.
+func mustVerifyRateLimited(t *testing.T) {
+	request := util.MustDefaultRequest(t, http.MethodGet, "/v2/catalog")
+
+	response := util.MustDoRequest(t, util.MustDefaultClient(t), request)
+	response.Body.Close()
+
+	if err := util.VerifyStatusCode(response, http.StatusTooManyRequests); err != nil {
+		t.Fatal(err)
+	}
+
+	retryAfter, err := strconv.Atoi(response.Header.Get("Retry-After"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	util.Assert(t, retryAfter > 0)
+}
+
+// TestRateLimit tests that requests are rate limited per client and endpoint class.
+func TestRateLimit(t *testing.T) {
+	defer mustReset(t)
+
+	util.MustReplaceBrokerConfig(t, clients, fixtures.BasicConfiguration())
+
+	limits := map[broker.EndpointClass]broker.RateLimit{
+		broker.EndpointClassCatalog: {
+			Rate:  0.01,
+			Burst: 2,
+		},
+	}
+
+	defer mustSetRateLimiter(broker.NewRateLimiter(limits, 0))()
+
+	restore := mustSetIdentity("HeMan")
+
+	util.MustGet(t, "/v2/catalog", http.StatusOK, nil)
+	util.MustGet(t, "/v2/catalog", http.StatusOK, nil)
+	mustVerifyRateLimited(t)
+	util.MustGetAndError(t, "/v2/catalog", http.StatusTooManyRequests, api.ErrorRateLimited)
+
+	// Other endpoint classes are not affected.
+	req := fixtures.BasicServiceInstanceCreateRequest()
+	util.MustCreateServiceInstanceSuccessfully(t, fixtures.ServiceInstanceName, req)
+
+	restore()
+
+	// Other clients are not affected.
+	defer mustSetIdentity("Skeletor")()
+
+	util.MustGet(t, "/v2/catalog", http.StatusOK, nil)
+}
+
+// TestRateLimitBearerTokens tests that clients using different bearer tokens are
+// rate limited independently.
+func TestRateLimitBearerTokens(t *testing.T) {
+	defer mustReset(t)
+
+	util.MustReplaceBrokerConfig(t, clients, fixtures.BasicConfiguration())
+
+	limits := map[broker.EndpointClass]broker.RateLimit{
+		broker.EndpointClassCatalog: {
+			Rate:  0.01,
+			Burst: 1,
+		},
+	}
+
+	defer mustSetRateLimiter(broker.NewRateLimiter(limits, 0))()
+	defer mustSetAuthenticator(broker.NewBearerTokenAuthenticator(broker.StaticCredential("HeMan"), broker.StaticCredential("Skeletor")))()
+
+	mustVerifyAuthorization(t, "Bearer HeMan", http.StatusOK)
+	mustVerifyAuthorization(t, "Bearer HeMan", http.StatusTooManyRequests)
+	mustVerifyAuthorization(t, "Bearer Skeletor", http.StatusOK)
+	mustVerifyAuthorization(t, "Bearer Skeletor", http.StatusTooManyRequests)
+}
+
+// TestRateLimitAuthenticationFailures tests that clients failing authentication are
+// rate limited before being authenticated, and clients authenticating are not.
+func TestRateLimitAuthenticationFailures(t *testing.T) {
+	defer mustReset(t)
+
+	util.MustReplaceBrokerConfig(t, clients, fixtures.BasicConfiguration())
+
+	limits := map[broker.EndpointClass]broker.RateLimit{
+		broker.EndpointClassAuthentication: {
+			Rate:  0.01,
+			Burst: 2,
+		},
+	}
+
+	defer mustSetRateLimiter(broker.NewRateLimiter(limits, 0))()
+	defer mustSetAuthenticator(broker.NewBearerTokenAuthenticator(broker.StaticCredential("HeMan")))()
+
+	mustVerifyAuthorization(t, "Bearer HeMan", http.StatusOK)
+	mustVerifyAuthorization(t, "Bearer HeMan", http.StatusOK)
+	mustVerifyAuthorization(t, "Bearer HeMan", http.StatusOK)
+	mustVerifyAuthorization(t, "Bearer Skeletor", http.StatusUnauthorized)
+	mustVerifyAuthorization(t, "Bearer Skeletor", http.StatusUnauthorized)
+	mustVerifyAuthorization(t, "Bearer Skeletor", http.StatusTooManyRequests)
+
+	// Valid credentials from the same address are rejected too, so credentials
+	// cannot be guessed.
+	mustVerifyAuthorization(t, "Bearer HeMan", http.StatusTooManyRequests)
+}
+
+// TestRateLimitMutatingRequests tests that the number of concurrent mutating requests
+// is limited.
+func TestRateLimitMutatingRequests(t *testing.T) {
+	defer mustReset(t)
+
+	util.MustReplaceBrokerConfig(t, clients, fixtures.BasicConfiguration())
+
+	defer mustSetRateLimiter(broker.NewRateLimiter(nil, 1))()
+
+	// Block the first request on its first API call, so it remains in flight.
+	started := make(chan interface{})
+	release := make(chan interface{})
+
+	var once sync.Once
+
+	client, ok := clients.Kubernetes().(*fake.Clientset)
+	util.Assert(t, ok)
+
+	client.PrependReactor("*", "*", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		once.Do(func() {
+			close(started)
+			<-release
+		})
+
+		return false, nil, nil
+	})
+
+	req := fixtures.BasicServiceInstanceCreateRequest()
+
+	done := make(chan interface{})
+
+	go func() {
+		defer close(done)
+
+		if err := util.Put(util.ServiceInstanceURI(fixtures.ServiceInstanceName, util.CreateServiceInstanceQuery()), http.StatusAccepted, req, nil); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	<-started
+
+	util.MustPutAndError(t, util.ServiceInstanceURI(fixtures.AlternateServiceInstanceName, util.CreateServiceInstanceQuery()), http.StatusTooManyRequests, req, api.ErrorRateLimited)
+
+	// Reads are not mutating so are unaffected.
+	util.MustGet(t, "/v2/catalog", http.StatusOK, nil)
+
+	close(release)
+	<-done
+}
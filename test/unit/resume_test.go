@@ -0,0 +1,244 @@
+// Copyright 2020-2021 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file  except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the  License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unit_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/couchbase/service-broker/pkg/api"
+	"github.com/couchbase/service-broker/pkg/broker"
+	"github.com/couchbase/service-broker/pkg/operation"
+	"github.com/couchbase/service-broker/pkg/provisioners"
+	"github.com/couchbase/service-broker/pkg/registry"
+	"github.com/couchbase/service-broker/test/unit/fixtures"
+	"github.com/couchbase/service-broker/test/unit/util"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// mustStartInterruptedOperation creates a service instance registry entry as the
+// service broker would, and starts an operation, but doesn't run it, as if the
+// service broker had restarted.
+func mustStartInterruptedOperation(t *testing.T, opType operation.Type) *api.CreateServiceInstanceResponse {
+	entry, err := registry.New(registry.ServiceInstance, util.Namespace, fixtures.ServiceInstanceName, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := map[registry.Key]interface{}{
+		registry.Namespace:  util.Namespace,
+		registry.InstanceID: fixtures.ServiceInstanceName,
+		registry.ServiceID:  fixtures.BasicConfigurationOfferingID,
+		registry.PlanID:     fixtures.BasicConfigurationPlanID,
+		registry.Context:    &runtime.RawExtension{},
+		registry.Parameters: &runtime.RawExtension{},
+	}
+
+	for key, value := range values {
+		if err := entry.Set(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := entry.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if opType == operation.TypeProvision {
+		creator, err := provisioners.NewCreator(provisioners.ResourceTypeServiceInstance)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := creator.Prepare(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := operation.Start(entry, opType); err != nil {
+		t.Fatal(err)
+	}
+
+	operationID, _, err := entry.GetString(registry.OperationID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &api.CreateServiceInstanceResponse{
+		Operation: operationID,
+	}
+}
+
+// mustResumeOperations resumes interrupted operations as the service broker does on
+// start up.
+func mustResumeOperations(t *testing.T) {
+	if err := broker.ResumeOperations(configuration); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestResumeProvision tests that an interrupted service instance provision is resumed.
+func TestResumeProvision(t *testing.T) {
+	defer mustReset(t)
+
+	util.MustReplaceBrokerConfig(t, clients, fixtures.BasicConfiguration())
+
+	rsp := mustStartInterruptedOperation(t, operation.TypeProvision)
+
+	mustResumeOperations(t)
+
+	util.MustPollServiceInstanceForCompletion(t, fixtures.ServiceInstanceName, rsp)
+
+	pods := clients.Dynamic().Resource(corev1.SchemeGroupVersion.WithResource("pods")).Namespace(util.Namespace)
+
+	if _, err := pods.Get(context.TODO(), "instance-"+fixtures.ServiceInstanceName, metav1.GetOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestResumeProvisionPartial tests that an interrupted service instance provision is
+// resumed when resources were created before the service broker restarted.
+func TestResumeProvisionPartial(t *testing.T) {
+	defer mustReset(t)
+
+	util.MustReplaceBrokerConfig(t, clients, fixtures.BasicConfiguration())
+
+	rsp := mustStartInterruptedOperation(t, operation.TypeProvision)
+
+	pod := &unstructured.Unstructured{}
+	pod.SetAPIVersion("v1")
+	pod.SetKind("Pod")
+	pod.SetName("instance-" + fixtures.ServiceInstanceName)
+
+	pods := clients.Dynamic().Resource(corev1.SchemeGroupVersion.WithResource("pods")).Namespace(util.Namespace)
+
+	if _, err := pods.Create(context.TODO(), pod, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	mustResumeOperations(t)
+
+	util.MustPollServiceInstanceForCompletion(t, fixtures.ServiceInstanceName, rsp)
+}
+
+// TestResumeProvisionConfigurationChanged tests that an interrupted service instance
+// provision is failed if the templates it would create have changed.
+func TestResumeProvisionConfigurationChanged(t *testing.T) {
+	defer mustReset(t)
+
+	util.MustReplaceBrokerConfig(t, clients, fixtures.BasicConfiguration())
+
+	rsp := mustStartInterruptedOperation(t, operation.TypeProvision)
+
+	configuration := fixtures.BasicConfiguration()
+	configuration.Templates[0].Template = &runtime.RawExtension{Raw: []byte(`{"apiVersion":"v1","kind":"Pod","metadata":{"name":"changed"}}`)}
+	util.MustReplaceBrokerConfig(t, clients, configuration)
+
+	mustResumeOperations(t)
+
+	util.MustPollServiceInstanceForFailure(t, fixtures.ServiceInstanceName, rsp)
+}
+
+// TestResumeProvisionGeneratedValues tests that an interrupted service instance
+// provision is resumed when its templates generate different values each time they
+// are rendered.
+func TestResumeProvisionGeneratedValues(t *testing.T) {
+	defer mustReset(t)
+
+	configuration := fixtures.BasicConfiguration()
+	configuration.Templates[0].Template = &runtime.RawExtension{Raw: []byte(`{"nameservers":["{{ generatePassword 16 nil }}"]}`)}
+	util.MustReplaceBrokerConfig(t, clients, configuration)
+
+	rsp := mustStartInterruptedOperation(t, operation.TypeProvision)
+
+	mustResumeOperations(t)
+
+	util.MustPollServiceInstanceForCompletion(t, fixtures.ServiceInstanceName, rsp)
+}
+
+// TestResumeUpdate tests that an interrupted service instance update is failed.
+func TestResumeUpdate(t *testing.T) {
+	defer mustReset(t)
+
+	util.MustReplaceBrokerConfig(t, clients, fixtures.BasicConfiguration())
+
+	rsp := mustStartInterruptedOperation(t, operation.TypeUpdate)
+
+	mustResumeOperations(t)
+
+	util.MustPollServiceInstanceForFailure(t, fixtures.ServiceInstanceName, rsp)
+}
+
+// TestResumeDeprovision tests that an interrupted service instance deprovision is resumed.
+func TestResumeDeprovision(t *testing.T) {
+	defer mustReset(t)
+
+	util.MustReplaceBrokerConfig(t, clients, fixtures.BasicConfiguration())
+
+	rsp := mustStartInterruptedOperation(t, operation.TypeDeprovision)
+
+	mustResumeOperations(t)
+
+	util.MustPollServiceInstanceForDeletion(t, fixtures.ServiceInstanceName, rsp)
+}
+
+// TestResumeCorruptEntry tests that a registry entry whose operation cannot be resumed
+// is failed, and does not prevent other operations from being resumed.
+func TestResumeCorruptEntry(t *testing.T) {
+	defer mustReset(t)
+
+	util.MustReplaceBrokerConfig(t, clients, fixtures.BasicConfiguration())
+
+	rsp := mustStartInterruptedOperation(t, operation.TypeProvision)
+
+	corrupt, err := registry.New(registry.ServiceInstance, util.Namespace, "corrupt", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := map[registry.Key]interface{}{
+		registry.Operation:          string(operation.TypeProvision),
+		registry.OperationStartTime: "yesterday",
+	}
+
+	for key, value := range values {
+		if err := corrupt.Set(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := corrupt.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	mustResumeOperations(t)
+
+	util.MustPollServiceInstanceForCompletion(t, fixtures.ServiceInstanceName, rsp)
+
+	entry := util.MustGetRegistryEntry(t, clients, registry.ServiceInstance, "corrupt")
+
+	var status string
+
+	if err := json.Unmarshal(entry.Data[string(registry.OperationStatus)], &status); err != nil {
+		t.Fatal(err)
+	}
+
+	util.Assert(t, status != "")
+}
@@ -0,0 +1,184 @@
+// Copyright 2020-2021 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file  except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the  License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unit_test
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/couchbase/service-broker/pkg/api"
+	"github.com/couchbase/service-broker/pkg/errors"
+	"github.com/couchbase/service-broker/pkg/operation"
+	"github.com/couchbase/service-broker/test/unit/fixtures"
+	"github.com/couchbase/service-broker/test/unit/util"
+)
+
+// mustSubmit reserves space for, and submits, an operation.
+func mustSubmit(t *testing.T, scheduler *operation.Scheduler, key string, f func()) {
+	reservation, err := scheduler.Reserve()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reservation.Submit(key, f)
+}
+
+// TestSchedulerBackpressure tests that the scheduler rejects operations when its
+// queue is full, and reports the queue depth.
+func TestSchedulerBackpressure(t *testing.T) {
+	scheduler := operation.NewScheduler(1, 2)
+
+	// Block the only worker.
+	started := make(chan interface{})
+	release := make(chan interface{})
+
+	mustSubmit(t, scheduler, "blocker", func() {
+		close(started)
+		<-release
+	})
+
+	<-started
+
+	var wg sync.WaitGroup
+
+	wg.Add(2)
+
+	mustSubmit(t, scheduler, "a", wg.Done)
+	mustSubmit(t, scheduler, "b", wg.Done)
+
+	util.Assert(t, scheduler.Depth() == 2)
+
+	if _, err := scheduler.Reserve(); !errors.IsBusyError(err) {
+		t.Fatal("expected busy error", err)
+	}
+
+	close(release)
+
+	wg.Wait()
+
+	util.Assert(t, scheduler.Depth() == 0)
+
+	reservation, err := scheduler.Reserve()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reservation.Release()
+}
+
+// TestSchedulerSerialization tests that operations with the same key are never run
+// concurrently, and are run in order.
+func TestSchedulerSerialization(t *testing.T) {
+	scheduler := operation.NewScheduler(4, 16)
+
+	operations := 10
+
+	var running int32
+
+	var order []int
+
+	var wg sync.WaitGroup
+
+	wg.Add(operations)
+
+	for i := 0; i < operations; i++ {
+		i := i
+
+		mustSubmit(t, scheduler, fixtures.ServiceInstanceName, func() {
+			defer wg.Done()
+
+			if atomic.AddInt32(&running, 1) != 1 {
+				t.Error("operations run concurrently")
+			}
+
+			order = append(order, i)
+
+			atomic.AddInt32(&running, -1)
+		})
+	}
+
+	wg.Wait()
+
+	for i := 0; i < operations; i++ {
+		util.Assert(t, order[i] == i)
+	}
+}
+
+// TestSchedulerTryLock tests that a key claimed by the caller cannot be claimed
+// while an operation is queued or running, and that operations submitted while it
+// is claimed wait until it is unlocked.
+func TestSchedulerTryLock(t *testing.T) {
+	scheduler := operation.NewScheduler(1, 2)
+
+	util.Assert(t, scheduler.TryLock(fixtures.ServiceInstanceName))
+	util.Assert(t, !scheduler.TryLock(fixtures.ServiceInstanceName))
+
+	done := make(chan interface{})
+
+	mustSubmit(t, scheduler, fixtures.ServiceInstanceName, func() { close(done) })
+
+	select {
+	case <-done:
+		t.Fatal("operation run while key locked")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	scheduler.Unlock(fixtures.ServiceInstanceName)
+
+	<-done
+
+	util.MustWaitFor(t, func() error {
+		if !scheduler.TryLock(fixtures.ServiceInstanceName) {
+			return fmt.Errorf("key still locked")
+		}
+
+		return nil
+	}, time.Minute)
+}
+
+// TestSchedulerBusy tests that the service broker rejects requests when the
+// operation queue is full.
+func TestSchedulerBusy(t *testing.T) {
+	defer mustReset(t)
+
+	util.MustReplaceBrokerConfig(t, clients, fixtures.BasicConfiguration())
+
+	scheduler := configuration.Scheduler
+	defer func() {
+		configuration.Scheduler = scheduler
+	}()
+
+	configuration.Scheduler = operation.NewScheduler(1, 1)
+
+	reservation, err := configuration.Scheduler.Reserve()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer reservation.Release()
+
+	req := fixtures.BasicServiceInstanceCreateRequest()
+	util.MustPutAndError(t, util.ServiceInstanceURI(fixtures.ServiceInstanceName, util.CreateServiceInstanceQuery()), http.StatusServiceUnavailable, req, api.ErrorBusy)
+
+	// The request should not have been recorded, so can be retried once the
+	// service broker is no longer busy.
+	reservation.Release()
+
+	util.MustCreateServiceInstanceSuccessfully(t, fixtures.ServiceInstanceName, req)
+}
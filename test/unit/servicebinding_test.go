@@ -16,6 +16,7 @@ package unit_test
 
 import (
 	"net/http"
+	"reflect"
 	"testing"
 
 	"github.com/couchbase/service-broker/pkg/api"
@@ -468,3 +469,179 @@ func TestServiceBindingDeleteDeleteAndRecreate(t *testing.T) {
 	util.MustDeleteServiceBinding(t, fixtures.ServiceInstanceName, fixtures.ServiceBindingName, binding)
 	util.MustCreateServiceBinding(t, fixtures.ServiceInstanceName, fixtures.ServiceBindingName, binding)
 }
+
+// TestServiceBindingRead tests that a service binding can be read.
+func TestServiceBindingRead(t *testing.T) {
+	defer mustReset(t)
+
+	util.MustReplaceBrokerConfig(t, clients, fixtures.BasicConfiguration())
+
+	req := fixtures.BasicServiceInstanceCreateRequest()
+	util.MustCreateServiceInstanceSuccessfully(t, fixtures.ServiceInstanceName, req)
+
+	binding := fixtures.BasicServiceBindingCreateRequest()
+	binding.Parameters = &runtime.RawExtension{
+		Raw: []byte(`{"test":1}`),
+	}
+	util.MustCreateServiceBinding(t, fixtures.ServiceInstanceName, fixtures.ServiceBindingName, binding)
+
+	read := &api.GetServiceBindingResponse{}
+	util.MustGet(t, util.ServiceBindingURI(fixtures.ServiceInstanceName, fixtures.ServiceBindingName, util.ReadServiceBindingQuery(binding)), http.StatusOK, read)
+
+	util.Assert(t, read.Credentials != nil)
+	util.Assert(t, read.Metadata != nil)
+	util.Assert(t, reflect.DeepEqual(read.Parameters, binding.Parameters))
+}
+
+// TestServiceBindingReadIllegalBinding tests that a read on an illegal service
+// binding is rejected.
+func TestServiceBindingReadIllegalBinding(t *testing.T) {
+	defer mustReset(t)
+
+	util.MustReplaceBrokerConfig(t, clients, fixtures.BasicConfiguration())
+
+	req := fixtures.BasicServiceInstanceCreateRequest()
+	util.MustCreateServiceInstanceSuccessfully(t, fixtures.ServiceInstanceName, req)
+
+	binding := fixtures.BasicServiceBindingCreateRequest()
+	util.MustGetAndError(t, util.ServiceBindingURI(fixtures.ServiceInstanceName, fixtures.ServiceBindingName, util.ReadServiceBindingQuery(binding)), http.StatusNotFound, api.ErrorResourceNotFound)
+}
+
+// TestServiceBindingReadIllegalInstance tests that a read of a service binding via
+// the wrong service instance is rejected.
+func TestServiceBindingReadIllegalInstance(t *testing.T) {
+	defer mustReset(t)
+
+	util.MustReplaceBrokerConfig(t, clients, fixtures.BasicConfiguration())
+
+	req := fixtures.BasicServiceInstanceCreateRequest()
+	util.MustCreateServiceInstanceSuccessfully(t, fixtures.ServiceInstanceName, req)
+
+	binding := fixtures.BasicServiceBindingCreateRequest()
+	util.MustCreateServiceBinding(t, fixtures.ServiceInstanceName, fixtures.ServiceBindingName, binding)
+
+	util.MustGetAndError(t, util.ServiceBindingURI(fixtures.AlternateServiceInstanceName, fixtures.ServiceBindingName, util.ReadServiceBindingQuery(binding)), http.StatusNotFound, api.ErrorResourceNotFound)
+}
+
+// TestServiceBindingReadPlanIDIllegal tests that a read with an illegal plan ID hint
+// is rejected.
+func TestServiceBindingReadPlanIDIllegal(t *testing.T) {
+	defer mustReset(t)
+
+	util.MustReplaceBrokerConfig(t, clients, fixtures.BasicConfiguration())
+
+	req := fixtures.BasicServiceInstanceCreateRequest()
+	util.MustCreateServiceInstanceSuccessfully(t, fixtures.ServiceInstanceName, req)
+
+	binding := fixtures.BasicServiceBindingCreateRequest()
+	util.MustCreateServiceBinding(t, fixtures.ServiceInstanceName, fixtures.ServiceBindingName, binding)
+
+	query := util.ReadServiceBindingQuery(binding)
+	query.Set(util.QueryPlanID, fixtures.IllegalID)
+	util.MustGetAndError(t, util.ServiceBindingURI(fixtures.ServiceInstanceName, fixtures.ServiceBindingName, query), http.StatusBadRequest, api.ErrorQueryError)
+}
+
+// TestServiceBindingCreateAsync tests that a service binding can be created asynchronously
+// and its credentials read once the operation has completed.
+func TestServiceBindingCreateAsync(t *testing.T) {
+	defer mustReset(t)
+
+	util.MustReplaceBrokerConfig(t, clients, fixtures.BasicConfiguration())
+
+	req := fixtures.BasicServiceInstanceCreateRequest()
+	util.MustCreateServiceInstanceSuccessfully(t, fixtures.ServiceInstanceName, req)
+
+	binding := fixtures.BasicServiceBindingCreateRequest()
+	util.MustCreateServiceBindingAsyncSuccessfully(t, fixtures.ServiceInstanceName, fixtures.ServiceBindingName, binding)
+
+	read := &api.GetServiceBindingResponse{}
+	util.MustGet(t, util.ServiceBindingURI(fixtures.ServiceInstanceName, fixtures.ServiceBindingName, util.ReadServiceBindingQuery(binding)), http.StatusOK, read)
+
+	util.Assert(t, read.Credentials != nil)
+}
+
+// TestServiceBindingDeleteAsync tests that a service binding can be deleted asynchronously.
+func TestServiceBindingDeleteAsync(t *testing.T) {
+	defer mustReset(t)
+
+	util.MustReplaceBrokerConfig(t, clients, fixtures.BasicConfiguration())
+
+	req := fixtures.BasicServiceInstanceCreateRequest()
+	util.MustCreateServiceInstanceSuccessfully(t, fixtures.ServiceInstanceName, req)
+
+	binding := fixtures.BasicServiceBindingCreateRequest()
+	util.MustCreateServiceBindingAsyncSuccessfully(t, fixtures.ServiceInstanceName, fixtures.ServiceBindingName, binding)
+	util.MustDeleteServiceBindingAsyncSuccessfully(t, fixtures.ServiceInstanceName, fixtures.ServiceBindingName, binding)
+	util.MustGetAndError(t, util.ServiceBindingURI(fixtures.ServiceInstanceName, fixtures.ServiceBindingName, util.ReadServiceBindingQuery(binding)), http.StatusNotFound, api.ErrorResourceNotFound)
+}
+
+// TestServiceBindingPollIllegalOperation tests that polling a service binding with
+// the wrong operation ID is rejected.
+func TestServiceBindingPollIllegalOperation(t *testing.T) {
+	defer mustReset(t)
+
+	util.MustReplaceBrokerConfig(t, clients, fixtures.BasicConfiguration())
+
+	req := fixtures.BasicServiceInstanceCreateRequest()
+	util.MustCreateServiceInstanceSuccessfully(t, fixtures.ServiceInstanceName, req)
+
+	binding := fixtures.BasicServiceBindingCreateRequest()
+	util.MustCreateServiceBindingAsync(t, fixtures.ServiceInstanceName, fixtures.ServiceBindingName, binding)
+	util.MustGetAndError(t, util.ServiceBindingPollURI(fixtures.ServiceInstanceName, fixtures.ServiceBindingName, util.PollServiceBindingQuery(binding, "illegal")), http.StatusBadRequest, api.ErrorQueryError)
+}
+
+// TestServiceBindingConcurrentOperation tests that synchronous service binding
+// operations are rejected, rather than wait, while another operation on the service
+// instance is queued or running.
+func TestServiceBindingConcurrentOperation(t *testing.T) {
+	defer mustReset(t)
+
+	util.MustReplaceBrokerConfig(t, clients, fixtures.BasicConfiguration())
+
+	req := fixtures.BasicServiceInstanceCreateRequest()
+	util.MustCreateServiceInstanceSuccessfully(t, fixtures.ServiceInstanceName, req)
+
+	binding := fixtures.BasicServiceBindingCreateRequest()
+
+	util.Assert(t, configuration.Scheduler.TryLock(fixtures.ServiceInstanceName))
+	util.MustPutAndError(t, util.ServiceBindingURI(fixtures.ServiceInstanceName, fixtures.ServiceBindingName, nil), http.StatusUnprocessableEntity, binding, api.ErrorConcurrencyError)
+	configuration.Scheduler.Unlock(fixtures.ServiceInstanceName)
+
+	util.MustCreateServiceBinding(t, fixtures.ServiceInstanceName, fixtures.ServiceBindingName, binding)
+
+	util.Assert(t, configuration.Scheduler.TryLock(fixtures.ServiceInstanceName))
+	util.MustDeleteAndError(t, util.ServiceBindingURI(fixtures.ServiceInstanceName, fixtures.ServiceBindingName, util.DeleteServiceBindingQuery(binding)), http.StatusUnprocessableEntity, api.ErrorConcurrencyError)
+	configuration.Scheduler.Unlock(fixtures.ServiceInstanceName)
+
+	util.MustDeleteServiceBinding(t, fixtures.ServiceInstanceName, fixtures.ServiceBindingName, binding)
+}
+
+// TestServiceBindingPollWithoutOperation tests that a service binding can be polled
+// without an operation, as it is optional.
+func TestServiceBindingPollWithoutOperation(t *testing.T) {
+	defer mustReset(t)
+
+	util.MustReplaceBrokerConfig(t, clients, fixtures.BasicConfiguration())
+
+	req := fixtures.BasicServiceInstanceCreateRequest()
+	util.MustCreateServiceInstanceSuccessfully(t, fixtures.ServiceInstanceName, req)
+
+	binding := fixtures.BasicServiceBindingCreateRequest()
+	util.MustCreateServiceBindingAsync(t, fixtures.ServiceInstanceName, fixtures.ServiceBindingName, binding)
+	util.MustPollServiceBindingForCompletion(t, fixtures.ServiceInstanceName, fixtures.ServiceBindingName, "")
+}
+
+// TestServiceBindingPollIllegalInstance tests that polling a service binding via
+// the wrong service instance is rejected.
+func TestServiceBindingPollIllegalInstance(t *testing.T) {
+	defer mustReset(t)
+
+	util.MustReplaceBrokerConfig(t, clients, fixtures.BasicConfiguration())
+
+	req := fixtures.BasicServiceInstanceCreateRequest()
+	util.MustCreateServiceInstanceSuccessfully(t, fixtures.ServiceInstanceName, req)
+
+	binding := fixtures.BasicServiceBindingCreateRequest()
+	rsp := util.MustCreateServiceBindingAsync(t, fixtures.ServiceInstanceName, fixtures.ServiceBindingName, binding)
+	util.MustGetAndError(t, util.ServiceBindingPollURI("illegal", fixtures.ServiceBindingName, util.PollServiceBindingQuery(binding, rsp.Operation)), http.StatusNotFound, api.ErrorResourceNotFound)
+}
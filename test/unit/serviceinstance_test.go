@@ -15,15 +15,28 @@
 package unit_test
 
 import (
+	"context"
+	"fmt"
 	"net/http"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/couchbase/service-broker/pkg/api"
+	v1 "github.com/couchbase/service-broker/pkg/apis/servicebroker/v1alpha1"
+	"github.com/couchbase/service-broker/pkg/registry"
 	"github.com/couchbase/service-broker/test/unit/fixtures"
 	"github.com/couchbase/service-broker/test/unit/util"
 
+	corev1 "k8s.io/api/core/v1"
+	k8s_errors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	clienttesting "k8s.io/client-go/testing"
 )
 
 // TestServiceInstanceCreate tests that the service broker accepts a minimal
@@ -205,6 +218,92 @@ func TestServiceInstancePollWithReadiness(t *testing.T) {
 	util.MustPollServiceInstanceForCompletion(t, fixtures.ServiceInstanceName, rsp)
 }
 
+// TestServiceInstanceCreateMaximumPollingDuration tests that a service instance
+// creation that takes longer than the maximum polling duration is failed.
+func TestServiceInstanceCreateMaximumPollingDuration(t *testing.T) {
+	defer mustReset(t)
+
+	configuration := fixtures.BasicConfigurationWithReadiness()
+	configuration.Bindings[0].MaximumPollingDuration = &metav1.Duration{Duration: time.Second}
+	util.MustReplaceBrokerConfig(t, clients, configuration)
+
+	req := fixtures.BasicServiceInstanceCreateRequest()
+	rsp := util.MustCreateServiceInstance(t, fixtures.ServiceInstanceName, req)
+
+	poll := &api.PollServiceInstanceResponse{}
+
+	callback := func() error {
+		util.MustGet(t, util.ServiceInstancePollURI(fixtures.ServiceInstanceName, util.PollServiceInstanceQuery(nil, rsp)), http.StatusOK, poll)
+
+		if poll.State != api.PollStateFailed {
+			return fmt.Errorf("poll state %v", poll.State)
+		}
+
+		return nil
+	}
+	util.MustWaitFor(t, callback, time.Minute)
+
+	util.Assert(t, strings.Contains(poll.Description, "maximum polling duration"))
+}
+
+// TestServiceInstanceCreateRollback tests that resources created by a failed service
+// instance creation are removed when rollback on failure is enabled.
+func TestServiceInstanceCreateRollback(t *testing.T) {
+	defer mustReset(t)
+
+	configuration := fixtures.BasicConfigurationWithReadiness()
+	configuration.Bindings[0].RollbackOnFailure = true
+	configuration.Bindings[0].ServiceInstance.ReadinessChecks[0].Timeout = &metav1.Duration{Duration: 100 * time.Millisecond}
+	util.MustReplaceBrokerConfig(t, clients, configuration)
+
+	req := fixtures.BasicServiceInstanceCreateRequest()
+	rsp := util.MustCreateServiceInstance(t, fixtures.ServiceInstanceName, req)
+
+	util.MustPollServiceInstanceForFailure(t, fixtures.ServiceInstanceName, rsp)
+
+	pods := clients.Dynamic().Resource(corev1.SchemeGroupVersion.WithResource("pods")).Namespace(util.Namespace)
+
+	if _, err := pods.Get(context.TODO(), "instance-"+fixtures.ServiceInstanceName, metav1.GetOptions{}); !k8s_errors.IsNotFound(err) {
+		t.Fatal("expected resource to be deleted", err)
+	}
+
+	// Singletons are deleted when no other service instance owns them.
+	if _, err := pods.Get(context.TODO(), "singleton", metav1.GetOptions{}); !k8s_errors.IsNotFound(err) {
+		t.Fatal("expected resource to be deleted", err)
+	}
+}
+
+// TestServiceInstanceCreateRollbackSharedSingleton tests that singletons owned by
+// other service instances are retained when a failed service instance creation is
+// rolled back.
+func TestServiceInstanceCreateRollbackSharedSingleton(t *testing.T) {
+	defer mustReset(t)
+
+	util.MustReplaceBrokerConfig(t, clients, fixtures.BasicConfiguration())
+
+	req := fixtures.BasicServiceInstanceCreateRequest()
+	util.MustCreateServiceInstanceSuccessfully(t, fixtures.ServiceInstanceName, req)
+
+	configuration := fixtures.BasicConfigurationWithReadiness()
+	configuration.Bindings[0].RollbackOnFailure = true
+	configuration.Bindings[0].ServiceInstance.ReadinessChecks[0].Timeout = &metav1.Duration{Duration: 100 * time.Millisecond}
+	util.MustReplaceBrokerConfig(t, clients, configuration)
+
+	rsp := util.MustCreateServiceInstance(t, fixtures.AlternateServiceInstanceName, req)
+
+	util.MustPollServiceInstanceForFailure(t, fixtures.AlternateServiceInstanceName, rsp)
+
+	pods := clients.Dynamic().Resource(corev1.SchemeGroupVersion.WithResource("pods")).Namespace(util.Namespace)
+
+	// The singleton is retained, but is only owned by the other service instance.
+	singleton, err := pods.Get(context.TODO(), "singleton", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	util.Assert(t, len(singleton.GetOwnerReferences()) == 1)
+}
+
 // TestServiceInstancePollServiceIDOptional tests that the service ID supplied to a service
 // instance polling operation is optional.
 func TestServiceInstancePollServiceIDOptional(t *testing.T) {
@@ -531,6 +630,200 @@ func TestServiceInstanceDeleteAndRecreate(t *testing.T) {
 	util.MustCreateServiceInstanceSuccessfully(t, fixtures.ServiceInstanceName, req)
 }
 
+// TestServiceInstanceDeleteResources tests that resources created for a service instance
+// are deleted along with the service instance and registry.
+func TestServiceInstanceDeleteResources(t *testing.T) {
+	defer mustReset(t)
+
+	util.MustReplaceBrokerConfig(t, clients, fixtures.BasicConfiguration())
+
+	req := fixtures.BasicServiceInstanceCreateRequest()
+	util.MustCreateServiceInstanceSuccessfully(t, fixtures.ServiceInstanceName, req)
+
+	rsp := util.MustDeleteServiceInstance(t, fixtures.ServiceInstanceName, req)
+	util.MustPollServiceInstanceForCompletion(t, fixtures.ServiceInstanceName, rsp)
+
+	pods := clients.Dynamic().Resource(corev1.SchemeGroupVersion.WithResource("pods")).Namespace(util.Namespace)
+
+	if _, err := pods.Get(context.TODO(), "instance-"+fixtures.ServiceInstanceName, metav1.GetOptions{}); !k8s_errors.IsNotFound(err) {
+		t.Fatal("expected resource to be deleted", err)
+	}
+
+	name := registry.Name(registry.ServiceInstance, fixtures.ServiceInstanceName)
+
+	if _, err := clients.Kubernetes().CoreV1().Secrets(util.Namespace).Get(context.TODO(), name, metav1.GetOptions{}); !k8s_errors.IsNotFound(err) {
+		t.Fatal("expected registry to be deleted", err)
+	}
+
+	util.MustPollServiceInstanceForDeletion(t, fixtures.ServiceInstanceName, rsp)
+}
+
+// TestServiceInstanceDeleteTeardown tests that teardown templates are created before
+// any resources are deleted, that a failing teardown fails the deprovision, and that
+// deprovisioning can be retried.
+func TestServiceInstanceDeleteTeardown(t *testing.T) {
+	defer mustReset(t)
+
+	timeout := metav1.Duration{Duration: 100 * time.Millisecond}
+
+	configuration := fixtures.BasicConfiguration()
+	configuration.Templates = append(configuration.Templates, v1.ConfigurationTemplate{
+		Name:     "test-teardown",
+		Template: &runtime.RawExtension{Raw: []byte(`{"apiVersion":"v1","kind":"Pod","metadata":{"name":"{{ printf \"teardown-%s\" (registry \"instance-id\") }}"}}`)},
+	})
+	configuration.Bindings[0].ServiceInstance.Teardown = []v1.ServiceBrokerTemplateListStep{
+		{
+			Name:      "backup",
+			Templates: []string{"test-teardown"},
+			ReadinessChecks: []v1.ConfigurationReadinessCheck{
+				{
+					Name:    "backup-complete",
+					Timeout: &timeout,
+					Condition: &v1.ConfigurationReadinessCheckCondition{
+						APIVersion: "v1",
+						Kind:       "Pod",
+						Namespace:  `{{ registry "namespace" }}`,
+						Name:       `{{ printf "teardown-%s" (registry "instance-id") }}`,
+						Type:       "Ready",
+						Status:     "True",
+					},
+				},
+			},
+		},
+	}
+	util.MustReplaceBrokerConfig(t, clients, configuration)
+
+	req := fixtures.BasicServiceInstanceCreateRequest()
+	util.MustCreateServiceInstanceSuccessfully(t, fixtures.ServiceInstanceName, req)
+
+	pods := clients.Dynamic().Resource(corev1.SchemeGroupVersion.WithResource("pods")).Namespace(util.Namespace)
+
+	// The teardown never becomes ready, so nothing should be deleted.
+	rsp := util.MustDeleteServiceInstance(t, fixtures.ServiceInstanceName, req)
+	util.MustPollServiceInstanceForFailure(t, fixtures.ServiceInstanceName, rsp)
+
+	if _, err := pods.Get(context.TODO(), "instance-"+fixtures.ServiceInstanceName, metav1.GetOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	teardown, err := pods.Get(context.TODO(), "teardown-"+fixtures.ServiceInstanceName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Once the teardown has completed, deprovisioning succeeds and removes everything.
+	if err := unstructured.SetNestedField(teardown.Object, fixtures.BasicResourceStatus(t), "status"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := pods.Update(context.TODO(), teardown, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	util.MustDeleteServiceInstanceSuccessfully(t, fixtures.ServiceInstanceName, req)
+
+	for _, name := range []string{"instance-", "teardown-"} {
+		if _, err := pods.Get(context.TODO(), name+fixtures.ServiceInstanceName, metav1.GetOptions{}); !k8s_errors.IsNotFound(err) {
+			t.Fatal("expected resource to be deleted", err)
+		}
+	}
+}
+
+// TestServiceInstanceCreateNilAttributes tests that every attribute of a template is
+// rendered, and those that render as nil are removed, however many there are.
+func TestServiceInstanceCreateNilAttributes(t *testing.T) {
+	defer mustReset(t)
+
+	configuration := fixtures.BasicConfiguration()
+
+	for i := range configuration.Templates {
+		if configuration.Templates[i].Name == "test-template" {
+			configuration.Templates[i].Template.Raw = []byte(strings.Replace(string(configuration.Templates[i].Template.Raw), `"hostname":`, `"subdomain":"{{ parameter \"/subdomain\" }}","hostname":`, 1))
+		}
+	}
+
+	util.MustReplaceBrokerConfig(t, clients, configuration)
+
+	req := fixtures.BasicServiceInstanceCreateRequest()
+	util.MustCreateServiceInstanceSuccessfully(t, fixtures.ServiceInstanceName, req)
+
+	pods := clients.Dynamic().Resource(corev1.SchemeGroupVersion.WithResource("pods")).Namespace(util.Namespace)
+
+	pod, err := pods.Get(context.TODO(), "instance-"+fixtures.ServiceInstanceName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spec, ok := pod.Object["spec"].(map[string]interface{})
+	util.Assert(t, ok)
+
+	for _, attribute := range []string{"hostname", "subdomain"} {
+		if _, ok := spec[attribute]; ok {
+			t.Fatalf("nil attribute %s not removed", attribute)
+		}
+	}
+
+	for attribute, value := range spec {
+		if s, ok := value.(string); ok && strings.Contains(s, "{{") {
+			t.Fatalf("attribute %s not rendered", attribute)
+		}
+	}
+}
+
+// TestServiceInstanceDeleteOrder tests that resources are deleted in the reverse
+// order they were created in, last step first.
+func TestServiceInstanceDeleteOrder(t *testing.T) {
+	defer mustReset(t)
+
+	configuration := fixtures.BasicConfiguration()
+	configuration.Templates = append(configuration.Templates, v1.ConfigurationTemplate{
+		Name:     "test-second",
+		Template: &runtime.RawExtension{Raw: []byte(`{"apiVersion":"v1","kind":"Pod","metadata":{"name":"{{ printf \"second-%s\" (registry \"instance-id\") }}"}}`)},
+	})
+	configuration.Bindings[0].ServiceInstance.Templates = nil
+	configuration.Bindings[0].ServiceInstance.Steps = []v1.ServiceBrokerTemplateListStep{
+		{
+			Name:      "first",
+			Templates: []string{"test-template"},
+		},
+		{
+			Name:      "second",
+			Templates: []string{"test-second"},
+		},
+	}
+	util.MustReplaceBrokerConfig(t, clients, configuration)
+
+	req := fixtures.BasicServiceInstanceCreateRequest()
+	util.MustCreateServiceInstanceSuccessfully(t, fixtures.ServiceInstanceName, req)
+
+	client, ok := clients.Dynamic().(*dynamicfake.FakeDynamicClient)
+	util.Assert(t, ok)
+
+	var lock sync.Mutex
+
+	var deleted []string
+
+	client.PrependReactor("delete", "pods", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		if deletion, ok := action.(clienttesting.DeleteAction); ok {
+			lock.Lock()
+			deleted = append(deleted, deletion.GetName())
+			lock.Unlock()
+		}
+
+		return false, nil, nil
+	})
+
+	util.MustDeleteServiceInstanceSuccessfully(t, fixtures.ServiceInstanceName, req)
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	expected := []string{"second-" + fixtures.ServiceInstanceName, "instance-" + fixtures.ServiceInstanceName}
+	if !reflect.DeepEqual(deleted, expected) {
+		t.Fatalf("expected deletion order %v, got %v", expected, deleted)
+	}
+}
+
 // TestServiceInstanceRead tests that we can read an existing service instance.
 func TestServiceInstanceRead(t *testing.T) {
 	defer mustReset(t)
@@ -880,3 +1173,416 @@ func TestServiceInstanceUpdateUpdatedParametersWithExternalMutations(t *testing.
 	fixtures.AssertFixtureFieldSet(t, clients, optionalParameterValue, "spec", "hostname")
 	fixtures.AssertFixtureFieldSet(t, clients, muatatedValue, "spec", "subdomain")
 }
+
+// TestServiceInstanceCreateMaintenanceInfo tests that a service instance can be created
+// with maintenance info that matches the service plan, and that the version is recorded.
+func TestServiceInstanceCreateMaintenanceInfo(t *testing.T) {
+	defer mustReset(t)
+
+	configuration := fixtures.BasicConfiguration()
+	configuration.Catalog.Services[0].Plans[0].MaintenanceInfo = &v1.MaintenanceInfo{
+		Version: "1.0.0",
+	}
+	util.MustReplaceBrokerConfig(t, clients, configuration)
+
+	req := fixtures.BasicServiceInstanceCreateRequest()
+	req.MaintenanceInfo = &api.MaintenanceInfo{
+		Version: "1.0.0",
+	}
+	util.MustCreateServiceInstanceSuccessfully(t, fixtures.ServiceInstanceName, req)
+
+	entry := util.MustGetRegistryEntry(t, clients, registry.ServiceInstance, fixtures.ServiceInstanceName)
+	util.MustHaveRegistryEntryWithValue(t, entry, registry.MaintenanceInfoVersion, "1.0.0")
+}
+
+// TestServiceInstanceCreateMaintenanceInfoConflict tests that a service instance
+// cannot be created when the maintenance info doesn't match the service plan.
+func TestServiceInstanceCreateMaintenanceInfoConflict(t *testing.T) {
+	defer mustReset(t)
+
+	configuration := fixtures.BasicConfiguration()
+	configuration.Catalog.Services[0].Plans[0].MaintenanceInfo = &v1.MaintenanceInfo{
+		Version: "1.0.0",
+	}
+	util.MustReplaceBrokerConfig(t, clients, configuration)
+
+	req := fixtures.BasicServiceInstanceCreateRequest()
+	req.MaintenanceInfo = &api.MaintenanceInfo{
+		Version: "2.0.0",
+	}
+	util.MustPutAndError(t, util.ServiceInstanceURI(fixtures.ServiceInstanceName, util.CreateServiceInstanceQuery()), http.StatusUnprocessableEntity, req, api.ErrorMaintenanceInfoConflict)
+}
+
+// TestServiceInstanceCreateMaintenanceInfoUnsupported tests that a service instance
+// cannot be created with maintenance info when the service plan doesn't define any.
+func TestServiceInstanceCreateMaintenanceInfoUnsupported(t *testing.T) {
+	defer mustReset(t)
+
+	util.MustReplaceBrokerConfig(t, clients, fixtures.BasicConfiguration())
+
+	req := fixtures.BasicServiceInstanceCreateRequest()
+	req.MaintenanceInfo = &api.MaintenanceInfo{
+		Version: "1.0.0",
+	}
+	util.MustPutAndError(t, util.ServiceInstanceURI(fixtures.ServiceInstanceName, util.CreateServiceInstanceQuery()), http.StatusUnprocessableEntity, req, api.ErrorMaintenanceInfoConflict)
+}
+
+// TestServiceInstanceUpgrade tests that a service instance can be upgraded to a new
+// service plan version, and that existing parameters are retained.
+func TestServiceInstanceUpgrade(t *testing.T) {
+	defer mustReset(t)
+
+	configuration := fixtures.BasicConfiguration()
+	configuration.Catalog.Services[0].Plans[0].MaintenanceInfo = &v1.MaintenanceInfo{
+		Version: "1.0.0",
+	}
+	util.MustReplaceBrokerConfig(t, clients, configuration)
+
+	req := fixtures.BasicServiceInstanceCreateRequest()
+	req.Parameters = &runtime.RawExtension{
+		Raw: []byte(`{"test":1}`),
+	}
+	util.MustCreateServiceInstanceSuccessfully(t, fixtures.ServiceInstanceName, req)
+
+	configuration.Catalog.Services[0].Plans[0].MaintenanceInfo.Version = "2.0.0"
+	util.MustReplaceBrokerConfig(t, clients, configuration)
+
+	update := fixtures.BasicServiceInstanceUpdateRequest()
+	update.MaintenanceInfo = &api.MaintenanceInfo{
+		Version: "2.0.0",
+	}
+	util.MustUpdateServiceInstanceSuccessfully(t, fixtures.ServiceInstanceName, update)
+
+	entry := util.MustGetRegistryEntry(t, clients, registry.ServiceInstance, fixtures.ServiceInstanceName)
+	util.MustHaveRegistryEntryWithValue(t, entry, registry.MaintenanceInfoVersion, "2.0.0")
+
+	read := &api.GetServiceInstanceResponse{}
+	util.MustGet(t, util.ServiceInstanceURI(fixtures.ServiceInstanceName, util.ReadServiceInstanceQuery(req)), http.StatusOK, read)
+	util.Assert(t, reflect.DeepEqual(read.Parameters, req.Parameters))
+	util.Assert(t, read.MaintenanceInfo != nil && read.MaintenanceInfo.Version == "2.0.0")
+}
+
+// TestServiceInstanceUpgradeMaintenanceInfoConflict tests that a service instance
+// cannot be upgraded to a version not advertised by the service plan.
+func TestServiceInstanceUpgradeMaintenanceInfoConflict(t *testing.T) {
+	defer mustReset(t)
+
+	configuration := fixtures.BasicConfiguration()
+	configuration.Catalog.Services[0].Plans[0].MaintenanceInfo = &v1.MaintenanceInfo{
+		Version: "1.0.0",
+	}
+	util.MustReplaceBrokerConfig(t, clients, configuration)
+
+	req := fixtures.BasicServiceInstanceCreateRequest()
+	util.MustCreateServiceInstanceSuccessfully(t, fixtures.ServiceInstanceName, req)
+
+	update := fixtures.BasicServiceInstanceUpdateRequest()
+	update.MaintenanceInfo = &api.MaintenanceInfo{
+		Version: "2.0.0",
+	}
+	util.MustPatchAndError(t, util.ServiceInstanceURI(fixtures.ServiceInstanceName, util.UpdateServiceInstanceQuery()), http.StatusUnprocessableEntity, update, api.ErrorMaintenanceInfoConflict)
+}
+
+// TestServiceInstanceUpdatePlanMigration tests that changing the plan of a service
+// instance creates resources introduced by the new plan, deletes those no longer
+// required, and records the new plan.
+func TestServiceInstanceUpdatePlanMigration(t *testing.T) {
+	defer mustReset(t)
+
+	configuration := fixtures.BasicConfiguration()
+	configuration.Catalog.Services[0].PlanUpdatable = true
+	configuration.Templates = append(configuration.Templates, v1.ConfigurationTemplate{
+		Name:     "test-migrated",
+		Template: &runtime.RawExtension{Raw: []byte(`{"apiVersion":"v1","kind":"Pod","metadata":{"name":"{{ printf \"migrated-%s\" (registry \"instance-id\") }}"}}`)},
+	})
+	configuration.Bindings[1].ServiceInstance.Templates = []string{
+		"test-migrated",
+	}
+	util.MustReplaceBrokerConfig(t, clients, configuration)
+
+	req := fixtures.BasicServiceInstanceCreateRequest()
+	util.MustCreateServiceInstanceSuccessfully(t, fixtures.ServiceInstanceName, req)
+
+	update := fixtures.BasicServiceInstanceUpdateRequest()
+	update.PlanID = fixtures.BasicConfigurationPlanID2
+	util.MustUpdateServiceInstanceSuccessfully(t, fixtures.ServiceInstanceName, update)
+
+	entry := util.MustGetRegistryEntry(t, clients, registry.ServiceInstance, fixtures.ServiceInstanceName)
+	util.MustHaveRegistryEntryWithValue(t, entry, registry.PlanID, fixtures.BasicConfigurationPlanID2)
+
+	pods := clients.Dynamic().Resource(corev1.SchemeGroupVersion.WithResource("pods")).Namespace(util.Namespace)
+
+	if _, err := pods.Get(context.TODO(), "migrated-"+fixtures.ServiceInstanceName, metav1.GetOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := pods.Get(context.TODO(), "instance-"+fixtures.ServiceInstanceName, metav1.GetOptions{}); !k8s_errors.IsNotFound(err) {
+		t.Fatal("expected resource to be deleted", err)
+	}
+
+	// Singletons are shared, so must remain after migration.
+	if _, err := pods.Get(context.TODO(), "singleton", metav1.GetOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestServiceInstanceUpdatePlanMigrationPollPreviousPlan tests that a plan change
+// can be polled to completion with the plan ID from before the update, as required
+// by the OSB specification.
+func TestServiceInstanceUpdatePlanMigrationPollPreviousPlan(t *testing.T) {
+	defer mustReset(t)
+
+	configuration := fixtures.BasicConfiguration()
+	configuration.Catalog.Services[0].PlanUpdatable = true
+	util.MustReplaceBrokerConfig(t, clients, configuration)
+
+	req := fixtures.BasicServiceInstanceCreateRequest()
+	util.MustCreateServiceInstanceSuccessfully(t, fixtures.ServiceInstanceName, req)
+
+	update := fixtures.BasicServiceInstanceUpdateRequest()
+	update.PlanID = fixtures.BasicConfigurationPlanID2
+	rsp := util.MustUpdateServiceInstance(t, fixtures.ServiceInstanceName, update)
+	util.MustPollServiceInstanceForCompletionWithRequest(t, fixtures.ServiceInstanceName, req, rsp)
+
+	entry := util.MustGetRegistryEntry(t, clients, registry.ServiceInstance, fixtures.ServiceInstanceName)
+	util.MustHaveRegistryEntryWithValue(t, entry, registry.PlanID, fixtures.BasicConfigurationPlanID2)
+	util.MustNotHaveRegistryEntry(t, entry, registry.MigratingPlanID)
+}
+
+// TestServiceInstanceUpdatePlanMigrationMaintenanceInfo tests that a plan change
+// records the maintenance info version of the target plan.
+func TestServiceInstanceUpdatePlanMigrationMaintenanceInfo(t *testing.T) {
+	defer mustReset(t)
+
+	configuration := fixtures.BasicConfiguration()
+	configuration.Catalog.Services[0].PlanUpdatable = true
+	configuration.Catalog.Services[0].Plans[0].MaintenanceInfo = &v1.MaintenanceInfo{
+		Version: "1.0.0",
+	}
+	configuration.Catalog.Services[0].Plans[1].MaintenanceInfo = &v1.MaintenanceInfo{
+		Version: "2.0.0",
+	}
+	util.MustReplaceBrokerConfig(t, clients, configuration)
+
+	req := fixtures.BasicServiceInstanceCreateRequest()
+	req.MaintenanceInfo = &api.MaintenanceInfo{
+		Version: "1.0.0",
+	}
+	util.MustCreateServiceInstanceSuccessfully(t, fixtures.ServiceInstanceName, req)
+
+	update := fixtures.BasicServiceInstanceUpdateRequest()
+	update.PlanID = fixtures.BasicConfigurationPlanID2
+	util.MustUpdateServiceInstanceSuccessfully(t, fixtures.ServiceInstanceName, update)
+
+	entry := util.MustGetRegistryEntry(t, clients, registry.ServiceInstance, fixtures.ServiceInstanceName)
+	util.MustHaveRegistryEntryWithValue(t, entry, registry.MaintenanceInfoVersion, "2.0.0")
+}
+
+// TestServiceInstanceUpdatePlanMigrationRegistryScope tests that a plan change is
+// rejected when the target plan stores its registry in a different scope.
+func TestServiceInstanceUpdatePlanMigrationRegistryScope(t *testing.T) {
+	defer mustReset(t)
+
+	configuration := fixtures.BasicConfiguration()
+	configuration.Catalog.Services[0].PlanUpdatable = true
+	util.MustReplaceBrokerConfig(t, clients, configuration)
+
+	req := fixtures.BasicServiceInstanceCreateRequest()
+	util.MustCreateServiceInstanceSuccessfully(t, fixtures.ServiceInstanceName, req)
+
+	update := fixtures.BasicServiceInstanceUpdateRequest()
+	update.PlanID = fixtures.BasicConfigurationPlanID3
+	util.MustPatchAndError(t, util.ServiceInstanceURI(fixtures.ServiceInstanceName, util.UpdateServiceInstanceQuery()), http.StatusBadRequest, update, api.ErrorParameterError)
+
+	entry := util.MustGetRegistryEntry(t, clients, registry.ServiceInstance, fixtures.ServiceInstanceName)
+	util.MustHaveRegistryEntryWithValue(t, entry, registry.PlanID, fixtures.BasicConfigurationPlanID)
+}
+
+// TestServiceInstanceUpdatePlanMigrationRollback tests that resources created by a
+// failed plan change are removed, and the original resources are retained.
+func TestServiceInstanceUpdatePlanMigrationRollback(t *testing.T) {
+	defer mustReset(t)
+
+	configuration := fixtures.BasicConfiguration()
+	configuration.Catalog.Services[0].PlanUpdatable = true
+	configuration.Templates = append(configuration.Templates, v1.ConfigurationTemplate{
+		Name:     "test-migrated",
+		Template: &runtime.RawExtension{Raw: []byte(`{"apiVersion":"v1","kind":"Pod","metadata":{"name":"{{ printf \"migrated-%s\" (registry \"instance-id\") }}"}}`)},
+	})
+	configuration.Bindings[1].ServiceInstance.Templates = []string{
+		"test-migrated",
+	}
+	configuration.Bindings[1].ServiceInstance.ReadinessChecks = []v1.ConfigurationReadinessCheck{
+		{
+			Name: "migrated-ready",
+			Condition: &v1.ConfigurationReadinessCheckCondition{
+				APIVersion: "v1",
+				Kind:       "Pod",
+				Namespace:  `{{ registry "namespace" }}`,
+				Name:       `{{ printf "migrated-%s" (registry "instance-id") }}`,
+				Type:       "Ready",
+				Status:     "True",
+			},
+			Timeout: &metav1.Duration{Duration: 100 * time.Millisecond},
+		},
+	}
+	util.MustReplaceBrokerConfig(t, clients, configuration)
+
+	req := fixtures.BasicServiceInstanceCreateRequest()
+	util.MustCreateServiceInstanceSuccessfully(t, fixtures.ServiceInstanceName, req)
+
+	update := fixtures.BasicServiceInstanceUpdateRequest()
+	update.PlanID = fixtures.BasicConfigurationPlanID2
+	rsp := util.MustUpdateServiceInstance(t, fixtures.ServiceInstanceName, update)
+	util.MustPollServiceInstanceForFailure(t, fixtures.ServiceInstanceName, rsp)
+
+	entry := util.MustGetRegistryEntry(t, clients, registry.ServiceInstance, fixtures.ServiceInstanceName)
+	util.MustHaveRegistryEntryWithValue(t, entry, registry.PlanID, fixtures.BasicConfigurationPlanID)
+
+	pods := clients.Dynamic().Resource(corev1.SchemeGroupVersion.WithResource("pods")).Namespace(util.Namespace)
+
+	if _, err := pods.Get(context.TODO(), "migrated-"+fixtures.ServiceInstanceName, metav1.GetOptions{}); !k8s_errors.IsNotFound(err) {
+		t.Fatal("expected resource to be deleted", err)
+	}
+
+	if _, err := pods.Get(context.TODO(), "instance-"+fixtures.ServiceInstanceName, metav1.GetOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestServiceInstanceUpdateWithSteps tests that updates are applied to resources
+// defined by configuration steps.
+func TestServiceInstanceUpdateWithSteps(t *testing.T) {
+	defer mustReset(t)
+
+	configuration := fixtures.BasicConfiguration()
+	configuration.Bindings[0].ServiceInstance.Steps = []v1.ServiceBrokerTemplateListStep{
+		{
+			Name:      "default",
+			Templates: configuration.Bindings[0].ServiceInstance.Templates,
+		},
+	}
+	configuration.Bindings[0].ServiceInstance.Templates = nil
+	util.MustReplaceBrokerConfig(t, clients, configuration)
+
+	req := fixtures.BasicServiceInstanceCreateRequest()
+	util.MustCreateServiceInstanceSuccessfully(t, fixtures.ServiceInstanceName, req)
+
+	hostname := "twilight"
+
+	update := fixtures.BasicServiceInstanceUpdateRequest()
+	update.Parameters = &runtime.RawExtension{
+		Raw: []byte(`{"` + fixtures.OptionalParameter + `":"` + hostname + `"}`),
+	}
+	util.MustUpdateServiceInstanceSuccessfully(t, fixtures.ServiceInstanceName, update)
+
+	fixtures.AssertFixtureFieldSet(t, clients, hostname, "spec", "hostname")
+}
+
+// TestServiceInstanceUpdatePollWithReadiness tests that an update is reported as in
+// progress until readiness checks pass.
+func TestServiceInstanceUpdatePollWithReadiness(t *testing.T) {
+	defer mustReset(t)
+
+	util.MustReplaceBrokerConfig(t, clients, fixtures.BasicConfigurationWithReadiness())
+
+	req := fixtures.BasicServiceInstanceCreateRequest()
+	rsp := util.MustCreateServiceInstance(t, fixtures.ServiceInstanceName, req)
+
+	fixtures.MustSetFixtureField(t, clients, fixtures.BasicResourceStatus(t), "status")
+
+	util.MustPollServiceInstanceForCompletion(t, fixtures.ServiceInstanceName, rsp)
+
+	// Mark the resource as unready, the update should not complete.
+	unready := map[string]interface{}{
+		"conditions": []interface{}{
+			map[string]interface{}{
+				"type":   "Ready",
+				"status": "False",
+			},
+		},
+	}
+	fixtures.MustSetFixtureField(t, clients, unready, "status")
+
+	update := fixtures.BasicServiceInstanceUpdateRequest()
+	rsp = util.MustUpdateServiceInstance(t, fixtures.ServiceInstanceName, update)
+
+	poll := &api.PollServiceInstanceResponse{}
+	util.MustGet(t, util.ServiceInstancePollURI(fixtures.ServiceInstanceName, util.PollServiceInstanceQuery(nil, rsp)), http.StatusOK, poll)
+	util.Assert(t, poll.State == api.PollStateInProgress)
+
+	fixtures.MustSetFixtureField(t, clients, fixtures.BasicResourceStatus(t), "status")
+
+	util.MustPollServiceInstanceForCompletion(t, fixtures.ServiceInstanceName, rsp)
+}
+
+// TestServiceInstanceUpdateTemplateAdded tests that templates added to a configuration
+// binding are created by a service instance update.
+func TestServiceInstanceUpdateTemplateAdded(t *testing.T) {
+	defer mustReset(t)
+
+	configuration := fixtures.BasicConfiguration()
+	util.MustReplaceBrokerConfig(t, clients, configuration)
+
+	req := fixtures.BasicServiceInstanceCreateRequest()
+	util.MustCreateServiceInstanceSuccessfully(t, fixtures.ServiceInstanceName, req)
+
+	configuration.Templates = append(configuration.Templates, v1.ConfigurationTemplate{
+		Name:     "test-added",
+		Template: &runtime.RawExtension{Raw: []byte(`{"apiVersion":"v1","kind":"Pod","metadata":{"name":"{{ printf \"added-%s\" (registry \"instance-id\") }}"}}`)},
+	})
+	configuration.Bindings[0].ServiceInstance.Templates = append(configuration.Bindings[0].ServiceInstance.Templates, "test-added")
+	util.MustReplaceBrokerConfig(t, clients, configuration)
+
+	update := fixtures.BasicServiceInstanceUpdateRequest()
+	util.MustUpdateServiceInstanceSuccessfully(t, fixtures.ServiceInstanceName, update)
+
+	pods := clients.Dynamic().Resource(corev1.SchemeGroupVersion.WithResource("pods")).Namespace(util.Namespace)
+
+	pod, err := pods.Get(context.TODO(), "added-"+fixtures.ServiceInstanceName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	util.Assert(t, len(pod.GetOwnerReferences()) == 1)
+	util.Assert(t, pod.GetAnnotations()[v1.ResourceAnnotation] != "")
+}
+
+// TestServiceInstanceUpdateTemplateRemoved tests that resources created by the service
+// broker, but removed from the configuration binding, are pruned by a service instance
+// update.
+func TestServiceInstanceUpdateTemplateRemoved(t *testing.T) {
+	defer mustReset(t)
+
+	configuration := fixtures.BasicConfiguration()
+	configuration.Templates = append(configuration.Templates, v1.ConfigurationTemplate{
+		Name:     "test-removed",
+		Template: &runtime.RawExtension{Raw: []byte(`{"apiVersion":"v1","kind":"Pod","metadata":{"name":"{{ printf \"removed-%s\" (registry \"instance-id\") }}"}}`)},
+	})
+	configuration.Bindings[0].ServiceInstance.Templates = append(configuration.Bindings[0].ServiceInstance.Templates, "test-removed")
+	util.MustReplaceBrokerConfig(t, clients, configuration)
+
+	req := fixtures.BasicServiceInstanceCreateRequest()
+	util.MustCreateServiceInstanceSuccessfully(t, fixtures.ServiceInstanceName, req)
+
+	pods := clients.Dynamic().Resource(corev1.SchemeGroupVersion.WithResource("pods")).Namespace(util.Namespace)
+
+	if _, err := pods.Get(context.TODO(), "removed-"+fixtures.ServiceInstanceName, metav1.GetOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	configuration.Bindings[0].ServiceInstance.Templates = fixtures.BasicConfiguration().Bindings[0].ServiceInstance.Templates
+	util.MustReplaceBrokerConfig(t, clients, configuration)
+
+	update := fixtures.BasicServiceInstanceUpdateRequest()
+	util.MustUpdateServiceInstanceSuccessfully(t, fixtures.ServiceInstanceName, update)
+
+	if _, err := pods.Get(context.TODO(), "removed-"+fixtures.ServiceInstanceName, metav1.GetOptions{}); !k8s_errors.IsNotFound(err) {
+		t.Fatal("expected resource to be deleted", err)
+	}
+
+	// Resources still rendered must be retained.
+	if _, err := pods.Get(context.TODO(), "instance-"+fixtures.ServiceInstanceName, metav1.GetOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright 2020-2021 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file  except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the  License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unit_test
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/couchbase/service-broker/pkg/broker"
+	"github.com/couchbase/service-broker/test/unit/fixtures"
+	"github.com/couchbase/service-broker/test/unit/util"
+)
+
+// mustSetKeyPair replaces the TLS key pair, returning a function to restore the
+// original.
+func mustSetKeyPair(keyPair *broker.KeyPair) func() {
+	original := configuration.KeyPair
+
+	configuration.KeyPair = keyPair
+
+	return func() {
+		configuration.KeyPair = original
+	}
+}
+
+// mustWriteFile writes a file.
+func mustWriteFile(t *testing.T, path string, data []byte) {
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// mustEncodeKeyPair PEM encodes a TLS certificate and private key.
+func mustEncodeKeyPair(t *testing.T, certificate tls.Certificate) ([]byte, []byte) {
+	key, err := x509.MarshalPKCS8PrivateKey(certificate.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certificatePEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.Certificate[0]})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key})
+
+	return certificatePEM, keyPEM
+}
+
+// mustReloadKeyPair reloads the key pair files.
+func mustReloadKeyPair(t *testing.T, keyPair *broker.KeyPair) {
+	for _, credential := range keyPair.Credentials() {
+		if err := credential.Reload(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// mustVerifyServerCertificate checks the server presents the expected certificate.
+func mustVerifyServerCertificate(t *testing.T, certificatePEM []byte) {
+	block, _ := pem.Decode(certificatePEM)
+	util.Assert(t, block != nil)
+
+	// Verification is done by comparison, the generated certificate is self-signed.
+	connection, err := tls.Dial("tcp", "localhost:8443", &tls.Config{
+		InsecureSkipVerify: true, //nolint:gosec
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer connection.Close()
+
+	certificates := connection.ConnectionState().PeerCertificates
+	util.Assert(t, len(certificates) > 0)
+	util.Assert(t, bytes.Equal(certificates[0].Raw, block.Bytes))
+}
+
+// TestTLSKeyPairReload tests that the server certificate is reloaded when its
+// files change.
+func TestTLSKeyPairReload(t *testing.T) {
+	defer mustReset(t)
+
+	util.MustReplaceBrokerConfig(t, clients, fixtures.BasicConfiguration())
+
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	certificatePath := filepath.Join(dir, "tls-certificate")
+	keyPath := filepath.Join(dir, "tls-private-key")
+
+	mustWriteFile(t, certificatePath, []byte(util.Cert))
+	mustWriteFile(t, keyPath, []byte(util.Key))
+
+	keyPair, err := broker.NewKeyPair(certificatePath, keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer mustSetKeyPair(keyPair)()
+
+	mustVerifyServerCertificate(t, []byte(util.Cert))
+
+	ca := util.MustNewCertificateAuthority(t)
+
+	certificatePEM, keyPEM := mustEncodeKeyPair(t, ca.MustNewClientCertificate(t, "localhost", "localhost"))
+
+	// Until both halves of the key pair are updated the old one is served.
+	mustWriteFile(t, certificatePath, certificatePEM)
+	mustReloadKeyPair(t, keyPair)
+	mustVerifyServerCertificate(t, []byte(util.Cert))
+
+	mustWriteFile(t, keyPath, keyPEM)
+	mustReloadKeyPair(t, keyPair)
+	mustVerifyServerCertificate(t, certificatePEM)
+}
+
+// TestTLSKeyPairInvalid tests that an invalid key pair is rejected at start up.
+func TestTLSKeyPairInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	certificatePath := filepath.Join(dir, "tls-certificate")
+	keyPath := filepath.Join(dir, "tls-private-key")
+
+	mustWriteFile(t, certificatePath, []byte(util.Cert))
+	mustWriteFile(t, keyPath, []byte(util.Cert))
+
+	_, err = broker.NewKeyPair(certificatePath, keyPath)
+	util.Assert(t, err != nil)
+}
+
+// TestPlaintextListener tests the API is also served without TLS when configured.
+func TestPlaintextListener(t *testing.T) {
+	defer mustReset(t)
+
+	util.MustReplaceBrokerConfig(t, clients, fixtures.BasicConfiguration())
+
+	request := util.MustDefaultRequest(t, http.MethodGet, "/v2/catalog")
+	request.URL.Scheme = "http"
+	request.URL.Host = util.PlaintextListenAddress
+
+	response := util.MustDoRequest(t, http.DefaultClient, request)
+	response.Body.Close()
+
+	if err := util.VerifyStatusCode(response, http.StatusOK); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright 2020-2021 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file  except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the  License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unit_test
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	v1 "github.com/couchbase/service-broker/pkg/apis/servicebroker/v1alpha1"
+	"github.com/couchbase/service-broker/pkg/broker"
+	"github.com/couchbase/service-broker/pkg/config"
+	"github.com/couchbase/service-broker/test/unit/fixtures"
+	"github.com/couchbase/service-broker/test/unit/util"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+// tokenReviewUsers maps from bearer token to the user Kubernetes authenticates it as.
+var tokenReviewUsers = map[string]authenticationv1.UserInfo{
+	"catalog": {
+		Username: "system:serviceaccount:default:service-catalog",
+		Groups:   []string{"system:serviceaccounts", "system:serviceaccounts:default"},
+	},
+	"heman": {
+		Username: "HeMan",
+		Groups:   []string{"masters-of-the-universe"},
+	},
+	"skeletor": {
+		Username: "Skeletor",
+		Groups:   []string{"evil-horde"},
+	},
+}
+
+// mustReactToTokenReviews makes the fake Kubernetes client authenticate tokens
+// in the user table, checking they are reviewed for the expected audiences.
+func mustReactToTokenReviews(t *testing.T, audiences []string) {
+	client, ok := clients.Kubernetes().(*fake.Clientset)
+	util.Assert(t, ok)
+
+	client.PrependReactor("create", "tokenreviews", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		create, ok := action.(clienttesting.CreateAction)
+		util.Assert(t, ok)
+
+		review, ok := create.GetObject().(*authenticationv1.TokenReview)
+		util.Assert(t, ok)
+
+		if !reflect.DeepEqual(review.Spec.Audiences, audiences) {
+			t.Errorf("unexpected audiences %v", review.Spec.Audiences)
+		}
+
+		result := review.DeepCopy()
+
+		user, ok := tokenReviewUsers[review.Spec.Token]
+		if ok {
+			result.Status.Authenticated = true
+			result.Status.User = user
+			result.Status.Audiences = audiences
+		} else {
+			result.Status.Error = "invalid bearer token"
+		}
+
+		return true, result, nil
+	})
+}
+
+// TestAuthenticationTokenReview tests that tokens authenticated by Kubernetes are
+// accepted if the service account or group is allowed by the configuration.
+func TestAuthenticationTokenReview(t *testing.T) {
+	defer mustReset(t)
+
+	configuration := fixtures.BasicConfiguration()
+	configuration.TokenReview = &v1.TokenReviewAuthorization{
+		ServiceAccounts: []v1.ServiceAccountReference{
+			{
+				Namespace: "default",
+				Name:      "service-catalog",
+			},
+		},
+		Groups: []string{
+			"masters-of-the-universe",
+		},
+	}
+
+	util.MustReplaceBrokerConfig(t, clients, configuration)
+
+	audiences := []string{"service-broker"}
+
+	mustReactToTokenReviews(t, audiences)
+
+	defer mustSetAuthenticator(broker.NewTokenReviewAuthenticator(audiences...))()
+
+	mustVerifyAuthorization(t, "Bearer catalog", http.StatusOK)
+	mustVerifyAuthorization(t, "Bearer heman", http.StatusOK)
+	mustVerifyAuthorization(t, "Bearer skeletor", http.StatusUnauthorized)
+	mustVerifyAuthorization(t, "Bearer orko", http.StatusUnauthorized)
+	mustVerifyAuthorization(t, "Basic aGVtYW4=", http.StatusUnauthorized)
+}
+
+// TestAuthenticationTokenReviewUnconfigured tests that configuration without any
+// allowed service accounts or groups is rejected when using token review, and that
+// no tokens are accepted should it be used anyway.
+func TestAuthenticationTokenReviewUnconfigured(t *testing.T) {
+	defer mustReset(t)
+
+	config.TokenReviewRequired = true
+	defer func() {
+		config.TokenReviewRequired = false
+	}()
+
+	util.MustReplaceBrokerConfigWithInvalidCondition(t, clients, fixtures.BasicConfiguration())
+
+	config.TokenReviewRequired = false
+	util.MustReplaceBrokerConfig(t, clients, fixtures.BasicConfiguration())
+
+	mustReactToTokenReviews(t, nil)
+
+	defer mustSetAuthenticator(broker.NewTokenReviewAuthenticator())()
+
+	mustVerifyAuthorization(t, "Bearer catalog", http.StatusUnauthorized)
+	mustVerifyAuthorization(t, "Bearer heman", http.StatusUnauthorized)
+}
+
+// TestAuthenticationTokenReviewCache tests that token review results, both good and
+// bad, are cached rather than reviewed for every request.
+func TestAuthenticationTokenReviewCache(t *testing.T) {
+	defer mustReset(t)
+
+	configuration := fixtures.BasicConfiguration()
+	configuration.TokenReview = &v1.TokenReviewAuthorization{
+		Groups: []string{
+			"masters-of-the-universe",
+		},
+	}
+
+	util.MustReplaceBrokerConfig(t, clients, configuration)
+
+	mustReactToTokenReviews(t, nil)
+
+	client, ok := clients.Kubernetes().(*fake.Clientset)
+	util.Assert(t, ok)
+
+	reviews := 0
+
+	client.PrependReactor("create", "tokenreviews", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		reviews++
+
+		return false, nil, nil
+	})
+
+	defer mustSetAuthenticator(broker.NewTokenReviewAuthenticator())()
+
+	mustVerifyAuthorization(t, "Bearer heman", http.StatusOK)
+	mustVerifyAuthorization(t, "Bearer heman", http.StatusOK)
+	mustVerifyAuthorization(t, "Bearer orko", http.StatusUnauthorized)
+	mustVerifyAuthorization(t, "Bearer orko", http.StatusUnauthorized)
+
+	util.Assert(t, reviews == 2)
+}
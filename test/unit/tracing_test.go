@@ -0,0 +1,237 @@
+// Copyright 2020-2021 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file  except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the  License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unit_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/couchbase/service-broker/pkg/tracing"
+	"github.com/couchbase/service-broker/test/unit/fixtures"
+	"github.com/couchbase/service-broker/test/unit/util"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// traceParent is sent by the platform to include the service broker in its traces.
+	traceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	// traceID is the trace that traceParent belongs to.
+	traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+
+	// parentSpanID is the platform's span that traceParent identifies.
+	parentSpanID = "00f067aa0ba902b7"
+)
+
+// errSpanMissing is returned when an expected span has not been recorded.
+var errSpanMissing = errors.New("span missing")
+
+// spanRecorder is a trace exporter that keeps spans in memory.
+type spanRecorder struct {
+	lock  sync.Mutex
+	spans []*tracing.SpanData
+}
+
+// Export records the spans.
+func (r *spanRecorder) Export(spans []*tracing.SpanData) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.spans = append(r.spans, spans...)
+
+	return nil
+}
+
+// find returns all spans with the name.
+func (r *spanRecorder) find(name string) []*tracing.SpanData {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	var spans []*tracing.SpanData
+
+	for _, span := range r.spans {
+		if span.Name == name {
+			spans = append(spans, span)
+		}
+	}
+
+	return spans
+}
+
+// recordSpans enables tracing, returning where spans are recorded.  Tracing
+// must be disabled when done.
+func recordSpans() *spanRecorder {
+	recorder := &spanRecorder{}
+
+	tracing.SetExporter(recorder)
+
+	return recorder
+}
+
+// mustFindSpan waits for a span to be exported, and returns it.
+func mustFindSpan(t *testing.T, recorder *spanRecorder, name string) *tracing.SpanData {
+	callback := func() error {
+		if err := tracing.Flush(); err != nil {
+			return err
+		}
+
+		if len(recorder.find(name)) == 0 {
+			return fmt.Errorf("%w: %s", errSpanMissing, name)
+		}
+
+		return nil
+	}
+
+	util.MustWaitFor(t, callback, time.Minute)
+
+	return recorder.find(name)[0]
+}
+
+// TestTracingProvision tests that a provisioning operation is recorded in the
+// platform's trace, from the API request through to the background operation.
+func TestTracingProvision(t *testing.T) {
+	defer mustReset(t)
+
+	recorder := recordSpans()
+	defer tracing.SetExporter(nil)
+
+	configuration := fixtures.BasicConfigurationWithReadiness()
+	configuration.Bindings[0].ServiceInstance.ReadinessChecks[0].Timeout = &metav1.Duration{Duration: 100 * time.Millisecond}
+	util.MustReplaceBrokerConfig(t, clients, configuration)
+
+	rsp := mustCreateServiceInstanceWithHeader(t, "traceparent", traceParent, http.StatusAccepted)
+	util.MustPollServiceInstanceForFailure(t, fixtures.ServiceInstanceName, rsp)
+
+	request := mustFindSpan(t, recorder, "PUT /v2/service_instances/:instance_id")
+	prepare := mustFindSpan(t, recorder, "Creator.Prepare")
+	run := mustFindSpan(t, recorder, "Creator.Run")
+	step := mustFindSpan(t, recorder, "Creator.step")
+	create := mustFindSpan(t, recorder, "createResource")
+	ready := mustFindSpan(t, recorder, "conditionReady")
+
+	for _, span := range []*tracing.SpanData{request, prepare, run, step, create, ready} {
+		util.Assert(t, span.SpanContext.TraceID.String() == traceID)
+	}
+
+	util.Assert(t, request.Kind == tracing.SpanKindServer)
+	util.Assert(t, request.ParentSpanID.String() == parentSpanID)
+	util.Assert(t, prepare.ParentSpanID == request.SpanContext.SpanID)
+	util.Assert(t, run.ParentSpanID == request.SpanContext.SpanID)
+	util.Assert(t, step.ParentSpanID == run.SpanContext.SpanID)
+	util.Assert(t, create.ParentSpanID == step.SpanContext.SpanID)
+	util.Assert(t, ready.ParentSpanID == step.SpanContext.SpanID)
+
+	// The readiness check timing out fails the operation, but each poll is not
+	// itself a failure.
+	util.Assert(t, run.Status == tracing.StatusError)
+	util.Assert(t, step.Status == tracing.StatusError)
+	util.Assert(t, ready.Status == tracing.StatusUnset)
+}
+
+// testSpan returns a span to export.
+func testSpan() *tracing.SpanData {
+	spanContext, _ := tracing.ParseTraceParent(traceParent)
+
+	start := time.Unix(0, 1000)
+
+	return &tracing.SpanData{
+		Name:        "test",
+		Kind:        tracing.SpanKindInternal,
+		SpanContext: spanContext,
+		StartTime:   start,
+		EndTime:     start.Add(time.Microsecond),
+		Attributes: []tracing.Attribute{
+			tracing.String("prince", "adam"),
+			tracing.Int("power", 9000),
+		},
+		Status:        tracing.StatusError,
+		StatusMessage: "by the power of greyskull",
+	}
+}
+
+// TestTracingOTLPExporter tests spans are exported in the OTLP/JSON encoding.
+func TestTracingOTLPExporter(t *testing.T) {
+	var body []byte
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = ioutil.ReadAll(r.Body)
+
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+
+	defer server.Close()
+
+	if err := tracing.NewOTLPExporter(server.URL + "/v1/traces").Export([]*tracing.SpanData{testSpan()}); err != nil {
+		t.Fatal(err)
+	}
+
+	request := struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []map[string]interface{} `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}{}
+
+	if err := json.Unmarshal(body, &request); err != nil {
+		t.Fatal(err)
+	}
+
+	util.Assert(t, len(request.ResourceSpans) == 1)
+	util.Assert(t, len(request.ResourceSpans[0].ScopeSpans) == 1)
+	util.Assert(t, len(request.ResourceSpans[0].ScopeSpans[0].Spans) == 1)
+
+	span := request.ResourceSpans[0].ScopeSpans[0].Spans[0]
+	util.Assert(t, span["traceId"] == traceID)
+	util.Assert(t, span["spanId"] == parentSpanID)
+	util.Assert(t, span["startTimeUnixNano"] == "1000")
+	util.Assert(t, span["endTimeUnixNano"] == "2000")
+
+	if err := tracing.NewOTLPExporter(server.URL + "/snake-mountain").Export([]*tracing.SpanData{testSpan()}); !errors.Is(err, tracing.ErrExportFailed) {
+		t.Fatal("expected export failure", err)
+	}
+}
+
+// TestTracingWriterExporter tests spans are written one per line.
+func TestTracingWriterExporter(t *testing.T) {
+	buffer := &bytes.Buffer{}
+
+	if err := tracing.NewWriterExporter(buffer).Export([]*tracing.SpanData{testSpan(), testSpan()}); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := bytes.Split(bytes.TrimSpace(buffer.Bytes()), []byte("\n"))
+	util.Assert(t, len(lines) == 2)
+
+	span := map[string]interface{}{}
+
+	if err := json.Unmarshal(lines[0], &span); err != nil {
+		t.Fatal(err)
+	}
+
+	util.Assert(t, span["name"] == "test")
+	util.Assert(t, span["traceId"] == traceID)
+}
@@ -454,6 +454,17 @@ func ServiceBindingURI(instance, binding string, query *url.Values) string {
 	return uri
 }
 
+// ServiceBindingPollURI generates a URI (path + query) to operate on a service binding polling.
+func ServiceBindingPollURI(instance, binding string, query *url.Values) string {
+	uri := "/v2/service_instances/" + instance + "/service_bindings/" + binding + "/last_operation"
+
+	if query != nil {
+		uri = uri + "?" + query.Encode()
+	}
+
+	return uri
+}
+
 // CreateServiceInstanceQuery creates a query string for use with the service instance creation.
 func CreateServiceInstanceQuery() *url.Values {
 	values := &url.Values{}
@@ -522,6 +533,56 @@ func DeleteServiceBindingQuery(req *api.CreateServiceBindingRequest) *url.Values
 	return values
 }
 
+// CreateServiceBindingAsyncQuery creates a query string for use with asynchronous
+// service binding creation.
+func CreateServiceBindingAsyncQuery() *url.Values {
+	values := &url.Values{}
+
+	values.Add("accepts_incomplete", "true")
+
+	return values
+}
+
+// DeleteServiceBindingAsyncQuery creates a query string for use with the asynchronous
+// service binding deletion API.  It is generated from the original service binding
+// creation request.
+func DeleteServiceBindingAsyncQuery(req *api.CreateServiceBindingRequest) *url.Values {
+	values := DeleteServiceBindingQuery(req)
+
+	values.Add("accepts_incomplete", "true")
+
+	return values
+}
+
+// PollServiceBindingQuery creates a query string for use with the service binding polling
+// API.  It is generated from the original service binding creation request and the operation
+// ID returned by the asynchronous request, which is omitted if empty.
+func PollServiceBindingQuery(req *api.CreateServiceBindingRequest, operation string) *url.Values {
+	values := &url.Values{}
+
+	if req != nil {
+		values.Add(QueryServiceID, req.ServiceID)
+		values.Add(QueryPlanID, req.PlanID)
+	}
+
+	if operation != "" {
+		values.Add(QueryOperation, operation)
+	}
+
+	return values
+}
+
+// ReadServiceBindingQuery creates a query string for use with the service binding get
+// API.  It is generated from the original service binding creation request.
+func ReadServiceBindingQuery(req *api.CreateServiceBindingRequest) *url.Values {
+	values := &url.Values{}
+
+	values.Add(QueryServiceID, req.ServiceID)
+	values.Add(QueryPlanID, req.PlanID)
+
+	return values
+}
+
 // MustCreateServiceInstance wraps up service instance creation.
 func MustCreateServiceInstance(t *testing.T, name string, req *api.CreateServiceInstanceRequest) *api.CreateServiceInstanceResponse {
 	rsp := &api.CreateServiceInstanceResponse{}
@@ -535,10 +596,16 @@ func MustCreateServiceInstance(t *testing.T, name string, req *api.CreateService
 
 // MustPollServiceInstanceForCompletion wraps up service instance poll.
 func MustPollServiceInstanceForCompletion(t *testing.T, name string, rsp *api.CreateServiceInstanceResponse) {
+	MustPollServiceInstanceForCompletionWithRequest(t, name, nil, rsp)
+}
+
+// MustPollServiceInstanceForCompletionWithRequest waits for an asynchronous operation to
+// complete, polling with the service and plan IDs from the request as a platform would.
+func MustPollServiceInstanceForCompletionWithRequest(t *testing.T, name string, req *api.CreateServiceInstanceRequest, rsp *api.CreateServiceInstanceResponse) {
 	callback := func() error {
 		// Polling will usually always return OK with the status embedded in the response.
 		poll := &api.PollServiceInstanceResponse{}
-		MustGet(t, ServiceInstancePollURI(name, PollServiceInstanceQuery(nil, rsp)), http.StatusOK, poll)
+		MustGet(t, ServiceInstancePollURI(name, PollServiceInstanceQuery(req, rsp)), http.StatusOK, poll)
 
 		// A failed is always an error.
 		Assert(t, poll.State != api.PollStateFailed)
@@ -553,6 +620,24 @@ func MustPollServiceInstanceForCompletion(t *testing.T, name string, rsp *api.Cr
 	util.MustWaitFor(t, callback, pollTimeout)
 }
 
+// MustPollServiceInstanceForFailure waits for an asynchronous operation to fail.
+func MustPollServiceInstanceForFailure(t *testing.T, name string, rsp *api.CreateServiceInstanceResponse) {
+	callback := func() error {
+		poll := &api.PollServiceInstanceResponse{}
+		MustGet(t, ServiceInstancePollURI(name, PollServiceInstanceQuery(nil, rsp)), http.StatusOK, poll)
+
+		// A success is always an error.
+		Assert(t, poll.State != api.PollStateSucceeded)
+
+		if poll.State == api.PollStateFailed {
+			return nil
+		}
+
+		return fmt.Errorf("poll state %v", poll.State)
+	}
+	util.MustWaitFor(t, callback, pollTimeout)
+}
+
 // MustDeleteServiceInstance wraps up service instance deletion.
 func MustDeleteServiceInstance(t *testing.T, name string, req *api.CreateServiceInstanceRequest) *api.CreateServiceInstanceResponse {
 	rsp := &api.CreateServiceInstanceResponse{}
@@ -620,3 +705,73 @@ func MustCreateServiceBinding(t *testing.T, instance, binding string, req *api.C
 func MustDeleteServiceBinding(t *testing.T, instance, binding string, req *api.CreateServiceBindingRequest) {
 	MustDelete(t, ServiceBindingURI(instance, binding, DeleteServiceBindingQuery(req)), http.StatusOK, nil)
 }
+
+// MustCreateServiceBindingAsync wraps up asynchronous service binding creation.
+func MustCreateServiceBindingAsync(t *testing.T, instance, binding string, req *api.CreateServiceBindingRequest) *api.CreateServiceBindingResponse {
+	rsp := &api.CreateServiceBindingResponse{}
+	MustPut(t, ServiceBindingURI(instance, binding, CreateServiceBindingAsyncQuery()), http.StatusAccepted, req, rsp)
+
+	// All asynchronous operations must have an operation string.
+	Assert(t, rsp.Operation != "")
+
+	return rsp
+}
+
+// MustPollServiceBindingForCompletion wraps up service binding poll.
+func MustPollServiceBindingForCompletion(t *testing.T, instance, binding string, operation string) {
+	callback := func() error {
+		// Polling will usually always return OK with the status embedded in the response.
+		poll := &api.PollServiceBindingResponse{}
+		MustGet(t, ServiceBindingPollURI(instance, binding, PollServiceBindingQuery(nil, operation)), http.StatusOK, poll)
+
+		// A failed is always an error.
+		Assert(t, poll.State != api.PollStateFailed)
+
+		// Polling completes when the the state is success.
+		if poll.State == api.PollStateSucceeded {
+			return nil
+		}
+
+		return fmt.Errorf("poll state %v", poll.State)
+	}
+	util.MustWaitFor(t, callback, pollTimeout)
+}
+
+// MustDeleteServiceBindingAsync wraps up asynchronous service binding deletion.
+func MustDeleteServiceBindingAsync(t *testing.T, instance, binding string, req *api.CreateServiceBindingRequest) *api.DeleteServiceBindingResponse {
+	rsp := &api.DeleteServiceBindingResponse{}
+	MustDelete(t, ServiceBindingURI(instance, binding, DeleteServiceBindingAsyncQuery(req)), http.StatusAccepted, rsp)
+
+	// All asynchronous operations must have an operation string.
+	Assert(t, rsp.Operation != "")
+
+	return rsp
+}
+
+// MustPollServiceBindingForDeletion wraps up polling for an aysnc binding deletion.
+func MustPollServiceBindingForDeletion(t *testing.T, instance, binding string, operation string) {
+	callback := func() error {
+		var response map[string]interface{}
+
+		if err := Get(ServiceBindingPollURI(instance, binding, PollServiceBindingQuery(nil, operation)), http.StatusGone, &response); err != nil {
+			return err
+		}
+
+		Assert(t, len(response) == 0)
+
+		return nil
+	}
+	util.MustWaitFor(t, callback, pollTimeout)
+}
+
+// MustCreateServiceBindingAsyncSuccessfully wraps up asynchronous service binding creation and polling.
+func MustCreateServiceBindingAsyncSuccessfully(t *testing.T, instance, binding string, req *api.CreateServiceBindingRequest) {
+	rsp := MustCreateServiceBindingAsync(t, instance, binding, req)
+	MustPollServiceBindingForCompletion(t, instance, binding, rsp.Operation)
+}
+
+// MustDeleteServiceBindingAsyncSuccessfully wraps up asynchronous service binding deletion and polling.
+func MustDeleteServiceBindingAsyncSuccessfully(t *testing.T, instance, binding string, req *api.CreateServiceBindingRequest) {
+	rsp := MustDeleteServiceBindingAsync(t, instance, binding, req)
+	MustPollServiceBindingForDeletion(t, instance, binding, rsp.Operation)
+}
@@ -133,7 +133,7 @@ func MustReplaceBrokerConfig(t *testing.T, clients client.Clients, spec *v1.Serv
 		return nil
 	}
 
-	if err := util.WaitFor(callback, configUpdateTimeout); err != nil {
+	if err := util.WaitFor(context.Background(), callback, configUpdateTimeout); err != nil {
 		t.Fatal(err)
 	}
 
@@ -174,7 +174,7 @@ func MustReplaceBrokerConfigWithInvalidCondition(t *testing.T, clients client.Cl
 		return nil
 	}
 
-	if err := util.WaitFor(callback, configUpdateTimeout); err != nil {
+	if err := util.WaitFor(context.Background(), callback, configUpdateTimeout); err != nil {
 		t.Fatal(err)
 	}
 }
@@ -400,6 +400,8 @@ func mustValidateCatalog(t *testing.T) {
 		optional := []string{
 			"tags",
 			"requires",
+			"instances_retrievable",
+			"bindings_retrievable",
 			"metadata",
 			"dashboard_client",
 			"plan_updatable",
@@ -444,6 +446,8 @@ func mustValidateCatalog(t *testing.T) {
 				"free",
 				"bindable",
 				"schemas",
+				"maintenance_info",
+				"maximum_polling_duration",
 			}
 
 			mustValidateObject(t, plan, required, optional)
@@ -97,4 +97,13 @@ KPFH2k0pVW7JOhDaEIOS/GrU
 
 	// Namespace is the default namespace, that isn't default.
 	Namespace = "Skeletor"
+
+	// PlaintextListenAddress is where the API is served without TLS.
+	PlaintextListenAddress = "localhost:8080"
+
+	// OpsListenAddress is where the operations API is served.
+	OpsListenAddress = "localhost:8444"
+
+	// OpsToken is the operations API bearer token.
+	OpsToken = "Orko"
 )
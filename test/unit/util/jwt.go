@@ -0,0 +1,170 @@
+// Copyright 2020-2021 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file  except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the  License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"testing"
+)
+
+const (
+	// rsaKeyBits is the size of RSA keys to generate.
+	rsaKeyBits = 2048
+)
+
+// JWTSigner is used to sign JSON web tokens.
+type JWTSigner struct {
+	// kid is the key ID.
+	kid string
+
+	// key is the private key.
+	key crypto.Signer
+}
+
+// MustNewRSAJWTSigner creates a new RS256 signer.
+func MustNewRSAJWTSigner(t *testing.T, kid string) *JWTSigner {
+	key, err := rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &JWTSigner{
+		kid: kid,
+		key: key,
+	}
+}
+
+// MustNewECDSAJWTSigner creates a new ES256 signer.
+func MustNewECDSAJWTSigner(t *testing.T, kid string) *JWTSigner {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &JWTSigner{
+		kid: kid,
+		key: key,
+	}
+}
+
+// encode base64 URL encodes a big-endian integer.
+func encode(i *big.Int, size int) string {
+	data := i.Bytes()
+
+	if len(data) < size {
+		data = append(make([]byte, size-len(data)), data...)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// JWK returns the signer's public key as a JSON web key.
+func (s *JWTSigner) JWK() map[string]interface{} {
+	switch key := s.key.(type) {
+	case *rsa.PrivateKey:
+		return map[string]interface{}{
+			"kty": "RSA",
+			"kid": s.kid,
+			"use": "sig",
+			"n":   encode(key.N, 0),
+			"e":   encode(big.NewInt(int64(key.E)), 0),
+		}
+	case *ecdsa.PrivateKey:
+		return map[string]interface{}{
+			"kty": "EC",
+			"kid": s.kid,
+			"use": "sig",
+			"crv": "P-256",
+			"x":   encode(key.X, 32),
+			"y":   encode(key.Y, 32),
+		}
+	}
+
+	return nil
+}
+
+// MustNewJWKS returns a JSON web key set containing the signers' public keys.
+func MustNewJWKS(t *testing.T, signers ...*JWTSigner) []byte {
+	keys := make([]interface{}, len(signers))
+
+	for i, signer := range signers {
+		keys[i] = signer.JWK()
+	}
+
+	data, err := json.Marshal(map[string]interface{}{"keys": keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+// mustEncodeSegment encodes a token segment.
+func mustEncodeSegment(t *testing.T, v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// MustSign creates a signed token with the requested claims.
+func (s *JWTSigner) MustSign(t *testing.T, claims map[string]interface{}) string {
+	alg := "RS256"
+
+	if _, ok := s.key.(*ecdsa.PrivateKey); ok {
+		alg = "ES256"
+	}
+
+	header := map[string]interface{}{
+		"alg": alg,
+		"typ": "JWT",
+		"kid": s.kid,
+	}
+
+	signed := mustEncodeSegment(t, header) + "." + mustEncodeSegment(t, claims)
+
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+
+	switch key := s.key.(type) {
+	case *rsa.PrivateKey:
+		sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		signature = sig
+	case *ecdsa.PrivateKey:
+		r, ss, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		signature = append(r.FillBytes(make([]byte, 32)), ss.FillBytes(make([]byte, 32))...)
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
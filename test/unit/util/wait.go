@@ -15,6 +15,7 @@
 package util
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
@@ -65,7 +66,7 @@ func ServerRunning() error {
 
 // WaitFor waits until a condition is nil.
 func WaitFor(f util.WaitFunc, timeout time.Duration) error {
-	return util.WaitFor(f, timeout)
+	return util.WaitFor(context.Background(), f, timeout)
 }
 
 // MustWaitFor waits until a condition is nil.